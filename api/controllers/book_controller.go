@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/zerodot618/go-huang/api/database"
 	"github.com/zerodot618/go-huang/api/models"
+	"github.com/zerodot618/go-huang/apperror"
 )
 
 // BookController is a struct that represents a controller for book-related operations
@@ -16,12 +17,12 @@ func (ctrl *BookController) CreateBook(c *gin.Context) {
 	// Bind the request body to a Book struct
 	var book models.Book
 	if err := c.ShouldBindJSON(&book); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Binding(err))
 		return
 	}
 	// Create a new book record in the database
 	if err := database.GlobalDB.Create(&book).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Internal(err, "Could not create book"))
 		return
 	}
 	// Return the created book to the client
@@ -33,13 +34,13 @@ func (ctrl *BookController) SearchBooks(c *gin.Context) {
 	// Get the search query from the request parameters
 	query := c.Query("query")
 	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter is required"})
+		apperror.Abort(c, apperror.Validation(apperror.FieldError{Field: "query", Code: "required", Message: "query parameter is required"}))
 		return
 	}
 	// Query the database for books that match the search query
 	var books []models.Book
 	if err := database.GlobalDB.Where("title LIKE ? OR author LIKE ? OR publisher LIKE ? OR description LIKE ?", "%"+query+"%", "%"+query+"%", "%"+query+"%", "%"+query+"%").Find(&books).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Internal(err, "Could not search books"))
 		return
 	}
 	// Return the search results to the client
@@ -53,12 +54,12 @@ func (ctrl *BookController) DeleteBook(c *gin.Context) {
 	// Check if the book with the given ID exists
 	var book models.Book
 	if err := database.GlobalDB.Where("id = ?", bookID).First(&book).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+		apperror.Abort(c, apperror.NotFound("book not found"))
 		return
 	}
 	// Delete the book record from the database
 	if err := database.GlobalDB.Delete(&book).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Internal(err, "Could not delete book"))
 		return
 	}
 	// Return a success message to the client
@@ -72,7 +73,7 @@ func (ctrl *BookController) UpdateBook(c *gin.Context) {
 	// Check if the book with the given ID exists
 	var book models.Book
 	if err := database.GlobalDB.Where("id = ?", id).First(&book).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		apperror.Abort(c, apperror.NotFound("book not found"))
 		return
 	}
 	// Bind the request body to a struct
@@ -82,8 +83,8 @@ func (ctrl *BookController) UpdateBook(c *gin.Context) {
 		Publisher   *string `json:"publisher"`
 		Description *string `json:"description"`
 	}
-	if err := c.BindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
 		return
 	}
 	// Update the book fields with the provided values
@@ -101,7 +102,7 @@ func (ctrl *BookController) UpdateBook(c *gin.Context) {
 	}
 	// Save the updated book to the database
 	if err := database.GlobalDB.Save(&book).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Internal(err, "Could not update book"))
 		return
 	}
 	// Return the updated book to the client
@@ -113,7 +114,7 @@ func (ctrl *BookController) GetBooksList(c *gin.Context) {
 	// Query the database for all book records
 	var books []models.Book
 	if err := database.GlobalDB.Find(&books).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Internal(err, "Could not list books"))
 		return
 	}
 	// Return the list of books to the client
@@ -127,7 +128,7 @@ func (ctrl *BookController) GetBookById(c *gin.Context) {
 	// Check if the book with the given ID exists
 	var book models.Book
 	if err := database.GlobalDB.Where("id = ?", bookID).First(&book).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+		apperror.Abort(c, apperror.NotFound("book not found"))
 		return
 	}
 	// Return the book to the client
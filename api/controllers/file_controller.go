@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/zerodot618/go-huang/api/database"
 	"github.com/zerodot618/go-huang/api/models"
+	"github.com/zerodot618/go-huang/apperror"
 )
 
 // FileController is a struct that represents a controller for file-related operations“
@@ -26,7 +27,7 @@ func (f *FileController) UploadFile(c *gin.Context) {
 	// Get the file from the form data
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Binding(err))
 
 		return
 	}
@@ -34,7 +35,7 @@ func (f *FileController) UploadFile(c *gin.Context) {
 	filePath := filepath.Join("uploads", file.Filename)
 	// Save the file to the defined path
 	if err := c.SaveUploadedFile(file, filePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to save file"))
 
 		return
 	}
@@ -46,7 +47,7 @@ func (f *FileController) UploadFile(c *gin.Context) {
 		UUID:     uuid,
 	}
 	if err := database.GlobalDB.Create(&fileMetadata).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file metadata"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to save file metadata"))
 
 		return
 	}
@@ -68,7 +69,7 @@ func (f *FileController) UploadFiles(c *gin.Context) {
 	// Get the files from the form data
 	form, err := c.MultipartForm()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Binding(err))
 
 		return
 	}
@@ -78,7 +79,7 @@ func (f *FileController) UploadFiles(c *gin.Context) {
 	for _, file := range files {
 		filePath := filepath.Join("uploads", file.Filename)
 		if err := c.SaveUploadedFile(file, filePath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+			apperror.Abort(c, apperror.Internal(err, "Failed to save file"))
 
 			return
 		}
@@ -90,7 +91,7 @@ func (f *FileController) UploadFiles(c *gin.Context) {
 	// Save file metadata to database
 	err = database.GlobalDB.Create(&fileModels).Error
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file metadata"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to save file metadata"))
 
 		return
 	}
@@ -117,7 +118,7 @@ func (f *FileController) GetFile(c *gin.Context) {
 	// Retrieve the file metadata from the database
 	err := database.GlobalDB.Where("uuid = ?", uuid).First(&file).Error
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		apperror.Abort(c, apperror.NotFound("File not found"))
 		return
 	}
 	// Define the path of the file tobe retrieved
@@ -125,7 +126,7 @@ func (f *FileController) GetFile(c *gin.Context) {
 	// Open the file
 	fileData, err := os.Open(filePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to open file"))
 		return
 	}
 	defer fileData.Close()
@@ -133,13 +134,14 @@ func (f *FileController) GetFile(c *gin.Context) {
 	fileHeader := make([]byte, 512)
 	_, err = fileData.Read(fileHeader)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to read file"))
+		return
 	}
 	fileContentType := http.DetectContentType(fileHeader)
 	// Get the file info
 	fileInfo, err := fileData.Stat()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file info"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to get file info"))
 		return
 	}
 	// Set the headers for the file transfer and return the file
@@ -168,7 +170,7 @@ func (f *FileController) DeleteFile(c *gin.Context) {
 	// Retrieve the file metadata from the database
 	err := database.GlobalDB.Where("uuid = ?", uuid).First(&file).Error
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		apperror.Abort(c, apperror.NotFound("File not found"))
 		return
 	}
 	// Define the path of the file to be deleted
@@ -176,13 +178,13 @@ func (f *FileController) DeleteFile(c *gin.Context) {
 	// Delete the file from the server
 	err = os.Remove(filePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to delete file"))
 		return
 	}
 	// Delete the file metadata from the database
 	err = database.GlobalDB.Delete(&file).Error
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file from database"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to delete file from database"))
 		return
 	}
 	// Return a success message
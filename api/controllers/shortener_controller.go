@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/zerodot618/go-huang/api/models"
+	"github.com/zerodot618/go-huang/apperror"
 )
 
 // ShortenerController is a struct that represents a controller for shortener-related operations“
@@ -32,8 +33,7 @@ func (ctrl *ShortenerController) RedirectShortURL(c *gin.Context) {
 	shortURL := c.Param("short_url")
 	url, err := models.GetURLByShortURL(shortURL)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
-		c.Abort()
+		apperror.Abort(c, apperror.NotFound("URL not found"))
 		return
 	}
 
@@ -53,7 +53,7 @@ func (ctrl *ShortenerController) GetURLStatistics(c *gin.Context) {
 	shortURL := c.Param("short_url")
 	url, err := models.GetURLByShortURL(shortURL)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "URL not found"})
+		apperror.Abort(c, apperror.NotFound("URL not found"))
 		return
 	}
 
@@ -1,13 +1,13 @@
 package controllers
 
 import (
-	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zerodot618/go-huang/api/auth"
 	"github.com/zerodot618/go-huang/api/database"
 	"github.com/zerodot618/go-huang/api/models"
+	"github.com/zerodot618/go-huang/apperror"
 	"gorm.io/gorm"
 )
 
@@ -17,7 +17,7 @@ type UserController struct{}
 // LoginPayload login body
 // LoginPayload is a struct that contains the fields for a user's login credentials
 type LoginPayload struct {
-	Email    string `json:"email" bingding:"required,email"`
+	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
 
@@ -31,8 +31,8 @@ type LoginResponse struct {
 // swagger input
 type UserDetails struct {
 	Name     string `json:"name" binding:"required"`
-	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,password"`
 }
 
 // Signup is a function that handles user signup
@@ -54,23 +54,17 @@ func (ctrl UserController) Signup(c *gin.Context) {
 	var user models.User
 	err := c.ShouldBindJSON(&user)
 	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid Inputs"})
-		c.Abort()
+		apperror.Abort(c, apperror.Binding(err))
 		return
 	}
 	err = user.HashPassword(user.Password)
 	if err != nil {
-		log.Println(err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": "Error Hashing Password"})
-		c.Abort()
+		apperror.Abort(c, apperror.Internal(err, "Error Hashing Password"))
 		return
 	}
 	err = user.CreateUserRecord()
 	if err != nil {
-		log.Println(err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": "Error Creating User"})
-		c.Abort()
+		apperror.Abort(c, apperror.Internal(err, "Error Creating User"))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"Message": "Sucessfully Register"})
@@ -96,30 +90,26 @@ func (ctrl UserController) Login(c *gin.Context) {
 	var user models.User
 	err := c.ShouldBindJSON(&payload)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid Inputs"})
-		c.Abort()
+		apperror.Abort(c, apperror.Binding(err))
 		return
 	}
 	result := database.GlobalDB.Where("email = ?", payload.Email).First(&user)
 	if result.Error == gorm.ErrRecordNotFound {
-		c.JSON(http.StatusUnauthorized, gin.H{"Error": "Invalid User Credentials"})
-		c.Abort()
+		apperror.Abort(c, apperror.Unauthorized("Invalid User Credentials"))
+		return
+	}
+	if result.Error != nil {
+		apperror.Abort(c, apperror.Internal(result.Error, "Could Not Get User"))
 		return
 	}
 	err = user.CheckPassword(payload.Password)
 	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"Error": "Invalid User Credentials"})
-		c.Abort()
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Invalid User Credentials"))
 		return
 	}
 	signedToken, err := auth.CreateToken(user)
 	if err != nil {
-		log.Println(err)
-		c.JSON(500, gin.H{
-			"Error": "Error Signing Token",
-		})
-		c.Abort()
+		apperror.Abort(c, apperror.Internal(err, "Error Signing Token"))
 		return
 	}
 	tokenResponse := LoginResponse{
@@ -151,14 +141,12 @@ func (ctrl UserController) Profile(c *gin.Context) {
 	result := database.GlobalDB.Where("email = ?", email.(string)).First(&user)
 	// If the user is not found, return a 404 status code
 	if result.Error == gorm.ErrRecordNotFound {
-		c.JSON(http.StatusNotFound, gin.H{"Error": "User Not Found"})
-		c.Abort()
+		apperror.Abort(c, apperror.NotFound("User Not Found"))
 		return
 	}
 	// If an error occurs while retriving the user profile, return a 500 status code
 	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": "Could Not Get User Profile"})
-		c.Abort()
+		apperror.Abort(c, apperror.Internal(result.Error, "Could Not Get User Profile"))
 		return
 	}
 	// Set the user's password to an empty string
@@ -1,10 +1,9 @@
 package middlewares
 
 import (
-	"net/http"
-
 	"github.com/gin-gonic/gin"
 	"github.com/zerodot618/go-huang/api/auth"
+	"github.com/zerodot618/go-huang/apperror"
 )
 
 // Authz is a middleware that validates token and authorizes users
@@ -12,16 +11,10 @@ import (
 // This function is responsible for validating the token sent by the client in the Authorization header
 // and authorizing the user if the token is valid
 func TokenAuthMiddleware() gin.HandlerFunc {
-	errList := make(map[string]string)
 	return func(c *gin.Context) {
 		err := auth.ValidateToken(c.Request)
 		if err != nil {
-			errList["unauthorized"] = "Unauthorized"
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status": http.StatusUnauthorized,
-				"error":  errList,
-			})
-			c.Abort()
+			apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Unauthorized"))
 			return
 		}
 		c.Next()
@@ -1,14 +1,13 @@
 package models
 
 import (
-	"errors"
 	"html"
 	"strings"
 
 	"github.com/zerodot618/go-huang/api/database"
 	"github.com/zerodot618/go-huang/api/security"
+	"github.com/zerodot618/go-huang/validation"
 
-	"github.com/badoux/checkmail"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -19,7 +18,7 @@ type User struct {
 	gorm.Model
 	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email" gorm:"unique"`
-	Password string `json:"password" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
 }
 
 // BeforeSave is a hook that is called before a user is saved to the database
@@ -41,62 +40,17 @@ func (user *User) Prepare() {
 }
 
 // Validate is a function that is used to validate a user before saving it to the database
-// It takes an action as an argument, which is used to determine which validation to perform
-func (user *User) Validate(action string) map[string]string {
-	var errorMessages = make(map[string]string)
-	var err error
+// It takes an action as an argument, which is used to determine which fields are validated
+// The returned validation errors are rendered as field errors by middlewares.ErrorHandler
+func (user *User) Validate(action string) error {
 	switch strings.ToLower(action) {
 	case "update":
-		if user.Email == "" {
-			err = errors.New("required email")
-			errorMessages["Required_email"] = err.Error()
-		}
-		if user.Email != "" {
-			if err = checkmail.ValidateFormat(user.Email); err != nil {
-				err = errors.New("invalid email")
-				errorMessages["Invalid_email"] = err.Error()
-			}
-		}
+		return validation.Partial(user, "Email")
 	case "login":
-		if user.Password == "" {
-			err = errors.New("eequired password")
-			errorMessages["Required_password"] = err.Error()
-		}
-		if user.Email == "" {
-			err = errors.New("required email")
-			errorMessages["Required_email"] = err.Error()
-		}
-		if user.Email != "" {
-			if err = checkmail.ValidateFormat(user.Email); err != nil {
-				err = errors.New("invalid email")
-				errorMessages["Invalid_email"] = err.Error()
-			}
-		}
+		return validation.Partial(user, "Email", "Password")
 	default:
-		if user.Name == "" {
-			err = errors.New("required name")
-			errorMessages["Required_name"] = err.Error()
-		}
-		if user.Password == "" {
-			err = errors.New("required password")
-			errorMessages["Required_password"] = err.Error()
-		}
-		if user.Password != "" && len(user.Password) < 6 {
-			err = errors.New("password should be atleast 6 characters")
-			errorMessages["Invalid_password"] = err.Error()
-		}
-		if user.Email == "" {
-			err = errors.New("required Email")
-			errorMessages["Required_email"] = err.Error()
-		}
-		if user.Email != "" {
-			if err = checkmail.ValidateFormat(user.Email); err != nil {
-				err = errors.New("invalid email")
-				errorMessages["Invalid_email"] = err.Error()
-			}
-		}
+		return validation.Struct(user)
 	}
-	return errorMessages
 }
 
 // SaveUser is a function that is used to save a user to the database
@@ -0,0 +1,172 @@
+// Package apperror defines the typed errors returned by the API.
+// Every error carries a machine-readable code that maps to an HTTP status,
+// and is rendered as the same JSON envelope by middlewares.ErrorHandler.
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// Code is a machine-readable error code
+type Code string
+
+// The error codes returned by the API
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeValidation       Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeConflict         Code = "conflict"
+	CodeTooManyRequests  Code = "too_many_requests"
+	CodeInternal         Code = "internal_error"
+	CodeUnavailable      Code = "service_unavailable"
+)
+
+// statuses maps every error code to its HTTP status
+var statuses = map[Code]int{
+	CodeBadRequest:       http.StatusBadRequest,
+	CodeValidation:       http.StatusUnprocessableEntity,
+	CodeUnauthorized:     http.StatusUnauthorized,
+	CodeForbidden:        http.StatusForbidden,
+	CodeNotFound:         http.StatusNotFound,
+	CodeMethodNotAllowed: http.StatusMethodNotAllowed,
+	CodeConflict:         http.StatusConflict,
+	CodeTooManyRequests:  http.StatusTooManyRequests,
+	CodeInternal:         http.StatusInternalServerError,
+	CodeUnavailable:      http.StatusServiceUnavailable,
+}
+
+// FieldError describes why a single request field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is an API error with a code, a message safe to show to clients
+// and optional field-level details. The wrapped cause is never rendered.
+type Error struct {
+	Code    Code         `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	Err     error        `json:"-"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status matching the error code
+func (e *Error) Status() int {
+	if status, ok := statuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// WithField adds a field-level detail to the error and returns it
+func (e *Error) WithField(field, code, message string) *Error {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+	return e
+}
+
+// New creates an error with the given code and message
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap creates an error with the given code and message that wraps err
+func Wrap(err error, code Code, message string) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// BadRequest creates a bad_request error
+func BadRequest(message string) *Error {
+	return New(CodeBadRequest, message)
+}
+
+// Validation creates a validation_failed error with the given field details
+func Validation(fields ...FieldError) *Error {
+	return &Error{Code: CodeValidation, Message: "Invalid Inputs", Fields: fields}
+}
+
+// Unauthorized creates an unauthorized error
+func Unauthorized(message string) *Error {
+	return New(CodeUnauthorized, message)
+}
+
+// Forbidden creates a forbidden error
+func Forbidden(message string) *Error {
+	return New(CodeForbidden, message)
+}
+
+// NotFound creates a not_found error
+func NotFound(message string) *Error {
+	return New(CodeNotFound, message)
+}
+
+// Conflict creates a conflict error
+func Conflict(message string) *Error {
+	return New(CodeConflict, message)
+}
+
+// Internal creates an internal_error error wrapping err, the cause is hidden from clients
+func Internal(err error, message string) *Error {
+	return Wrap(err, CodeInternal, message)
+}
+
+// From converts any error into an *Error.
+// Validation errors from the gin binding become field errors,
+// unknown errors become internal errors.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{
+				Field:   fe.Field(),
+				Code:    fe.Tag(),
+				Message: fmt.Sprintf("failed on the '%s' rule", fe.Tag()),
+			})
+		}
+		return &Error{Code: CodeValidation, Message: "Invalid Inputs", Fields: fields, Err: err}
+	}
+	return Internal(err, "Internal Server Error")
+}
+
+// Binding converts an error returned by the gin binding into an *Error.
+// Validation errors become field errors, malformed bodies become bad_request errors.
+func Binding(err error) *Error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return From(err)
+	}
+	return Wrap(err, CodeBadRequest, err.Error())
+}
+
+// Abort records err on the gin context and stops the handler chain.
+// The error is rendered by middlewares.ErrorHandler.
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
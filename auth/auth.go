@@ -1,85 +1,200 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
-// JwtWrapper wraps the signing key and the issuer
-// JwtWrapper is a struct that holds the secret key, issuer and expiration time for a JWT token
+// Default token settings
+const (
+	DefaultIssuer   = "AuthService"
+	DefaultAudience = "go-huang"
+	DefaultLeeway   = 30 * time.Second
+)
+
+// JwtWrapper wraps the signing keys and the issuer
+// JwtWrapper is a struct that holds the signing keys, issuer, audience and expiration times of the JWT tokens
 type JwtWrapper struct {
-	SecretKey         string // key used for signing the JWT token
-	Issuer            string // Issuer of the JWT token
-	ExpirationMinutes int64  // Number of minutes the JWT token will be valid fot
-	ExpirationHours   int64  // Expiration time of the JWT token in hours
+	Keys              *KeyRing      // keys used for signing and validating the JWT tokens
+	Issuer            string        // Issuer of the JWT token
+	Audience          string        // Audience of the JWT token, the tokens of other audiences are refused
+	Leeway            time.Duration // Clock skew accepted when validating the times of a JWT token
+	ExpirationMinutes int64         // Number of minutes the access token will be valid for
+	ExpirationHours   int64         // Number of hours the refresh token will be valid for
+}
+
+// Default is the JwtWrapper used to sign and validate the tokens
+// It keeps its keys in memory until main configures it with NewFromEnv
+var Default = New(NewKeyRing(NewMemoryKeyStore(), KeyRingOptions{}))
+
+// New returns a JwtWrapper with the default issuer, audience, leeway and expiration times
+func New(keys *KeyRing) *JwtWrapper {
+	return &JwtWrapper{
+		Keys:              keys,
+		Issuer:            DefaultIssuer,
+		Audience:          DefaultAudience,
+		Leeway:            DefaultLeeway,
+		ExpirationMinutes: 120,
+		ExpirationHours:   12,
+	}
+}
+
+// NewFromEnv returns a JwtWrapper with its keys kept in a store
+// It is configured by JWT_ALGORITHM (RS256, ES256 or EdDSA), JWT_ISSUER, JWT_AUDIENCE,
+// JWT_LEEWAY and JWT_ROTATION_INTERVAL, durations use the time.ParseDuration format
+func NewFromEnv(store KeyStore) (*JwtWrapper, error) {
+	opts := KeyRingOptions{Algorithm: os.Getenv("JWT_ALGORITHM")}
+	if opts.Algorithm != "" {
+		if _, err := signingMethod(opts.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+	interval, err := envDuration("JWT_ROTATION_INTERVAL")
+	if err != nil {
+		return nil, err
+	}
+	opts.RotationInterval = interval
+	j := New(nil)
+	// Keys stay published until every token they signed expired
+	opts.Retention = j.RefreshTokenTTL() + DefaultLeeway
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		j.Issuer = issuer
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		j.Audience = audience
+	}
+	leeway, err := envDuration("JWT_LEEWAY")
+	if err != nil {
+		return nil, err
+	}
+	if leeway > 0 {
+		j.Leeway = leeway
+	}
+	j.Keys = NewKeyRing(store, opts)
+	return j, nil
 }
 
-// JwtClaim adds email as a claim to the token
-// JwtClaim is a struct that holds the Email of the user, as well as the StandardClaims
+// JwtClaim adds the user ID and email as claims to the token
+// JwtClaim is a struct that holds the ID and Email of the user, as well as the StandardClaims
 type JwtClaim struct {
+	ID    uint
 	Email string
+	// SessionID is the login session of the token, revoking the session rejects the token
+	SessionID uint `json:"sid,omitempty"`
+	// ImpersonatorID is the admin acting as the user, for the tokens of an impersonation
+	ImpersonatorID uint `json:"act,omitempty"`
+	// Purpose restricts what a token can be used for, it is empty for access tokens
+	Purpose string `json:",omitempty"`
 	jwt.RegisteredClaims
 }
 
+// PurposeMFA marks the short-lived token issued after the password step of a two-step login
+// It can only be traded for access and refresh tokens with a second factor
+const PurposeMFA = "mfa_pending"
+
+// PurposeRefresh marks the refresh tokens, they can only be traded for access tokens of their session
+const PurposeRefresh = "refresh"
+
 // GenerateToken generates a JWT token
-// GenerateToken takes an email as an argument and returns a signed JWT token and an error
-func (j *JwtWrapper) GenerateToken(email string) (signedToken string, err error) {
-	claims := &JwtClaim{
-		Email: email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Minute * time.Duration(j.ExpirationMinutes))),
-			Issuer:    j.Issuer,
-		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err = token.SignedString([]byte(j.SecretKey))
-	if err != nil {
-		return
-	}
-	return
+// GenerateToken takes a user ID, an email and a session ID as arguments and returns a signed JWT token and an error
+func (j *JwtWrapper) GenerateToken(userID uint, email string, sessionID uint) (signedToken string, err error) {
+	return j.sign(&JwtClaim{ID: userID, Email: email, SessionID: sessionID}, time.Minute*time.Duration(j.ExpirationMinutes))
 }
 
 // RefreshToken generates a refresh jwt token
-// RefreshToken takes an email as an argument and returns a signed JWT token and an error
-func (j *JwtWrapper) RefreshToken(email string) (signedtoken string, err error) {
-	claims := &JwtClaim{
-		Email: email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Minute * time.Duration(j.ExpirationMinutes))),
-			Issuer:    j.Issuer,
-		},
+// RefreshToken takes a user ID, an email and a session ID as arguments and returns a signed JWT token and an error
+func (j *JwtWrapper) RefreshToken(userID uint, email string, sessionID uint) (signedtoken string, err error) {
+	return j.sign(&JwtClaim{ID: userID, Email: email, SessionID: sessionID, Purpose: PurposeRefresh}, j.RefreshTokenTTL())
+}
+
+// RefreshTokenTTL returns the lifetime of the refresh tokens, the longest lived tokens of a session
+func (j *JwtWrapper) RefreshTokenTTL() time.Duration {
+	return time.Hour * time.Duration(j.ExpirationHours)
+}
+
+// GenerateMFAToken generates the short-lived token of a login waiting for its second factor
+// GenerateMFAToken takes a user ID, an email and a lifetime and returns a signed JWT token and an error
+func (j *JwtWrapper) GenerateMFAToken(userID uint, email string, ttl time.Duration) (string, error) {
+	return j.sign(&JwtClaim{ID: userID, Email: email, Purpose: PurposeMFA}, ttl)
+}
+
+// GenerateImpersonationToken generates a token letting an admin act as a user, it has no refresh token
+// GenerateImpersonationToken takes a user ID, an email, a session ID, the admin ID and a lifetime
+// and returns a signed JWT token and an error
+func (j *JwtWrapper) GenerateImpersonationToken(userID uint, email string, sessionID, adminID uint, ttl time.Duration) (string, error) {
+	return j.sign(&JwtClaim{ID: userID, Email: email, SessionID: sessionID, ImpersonatorID: adminID}, ttl)
+}
+
+// sign signs the claims of a token with the current key of the key ring, identified by the kid header
+func (j *JwtWrapper) sign(claims *JwtClaim, ttl time.Duration) (string, error) {
+	key, err := j.Keys.Signer(context.Background())
+	if err != nil {
+		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedtoken, err = token.SignedString([]byte(j.SecretKey))
+	method, err := signingMethod(key.Algorithm)
 	if err != nil {
-		return
+		return "", err
+	}
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    j.Issuer,
+		Audience:  jwt.ClaimStrings{j.Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 	}
-	return
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Signer)
 }
 
 // ValidateToken validates the JWT token
 // ValidateToken takes a signed JWT token as an argument and returns the JwtClaim and an error
+// The token must be signed by a published key with the algorithm of that key,
+// and have our issuer and audience and valid times, give or take the leeway
 func (j *JwtWrapper) ValidateToken(signedToken string) (claims *JwtClaim, err error) {
-	token, err := jwt.ParseWithClaims(
-		signedToken,
-		&JwtClaim{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(j.SecretKey), nil
-		},
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}),
+		jwt.WithIssuer(j.Issuer),
+		jwt.WithAudience(j.Audience),
+		jwt.WithLeeway(j.Leeway),
+		jwt.WithIssuedAt(),
 	)
+	claims = &JwtClaim{}
+	_, err = parser.ParseWithClaims(signedToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := j.Keys.Lookup(context.Background(), kid)
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		// The algorithm is the one of the key, never the one chosen by the token
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.Signer.Public(), nil
+	})
 	if err != nil {
-		return
+		return nil, err
 	}
-	claims, ok := token.Claims.(*JwtClaim)
-	if !ok {
-		err = errors.New("couldn't parse claims")
-		return
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("JWT has no expiry")
 	}
-	if claims.ExpiresAt.Unix() < time.Now().Local().Unix() {
-		err = errors.New("JWT is expired")
-		return
+	return claims, nil
+}
+
+// envDuration reads a duration from the environment, zero if it is not set
+func envDuration(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
 	}
-	return
+	return d, nil
 }
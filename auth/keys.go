@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/zerodot618/go-huang/jwk"
+	"github.com/zerodot618/go-huang/logging"
+)
+
+// Signing algorithms of the keys
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Default settings of a KeyRing
+const (
+	DefaultRotationInterval = 30 * 24 * time.Hour
+	DefaultPublishDelay     = 10 * time.Minute
+	DefaultRetention        = 24 * time.Hour
+	// reloadInterval is how often Run reloads the keys, to see the keys rotated by other instances
+	reloadInterval = time.Minute
+	// minReloadInterval limits the reloads caused by tokens with an unknown kid
+	minReloadInterval = 10 * time.Second
+)
+
+// ErrUnsupportedAlgorithm is returned for algorithms other than RS256, ES256 and EdDSA
+var ErrUnsupportedAlgorithm = errors.New("auth: unsupported signing algorithm")
+
+// Key is a signing key of the tokens
+// A key is published in the JWKS as soon as it is created, signs from ActivatesAt until a newer key
+// activates, and is kept to verify the tokens it signed until RetiresAt
+type Key struct {
+	ID          string
+	Algorithm   string
+	Signer      crypto.Signer
+	ActivatesAt time.Time
+	RetiresAt   time.Time
+}
+
+// GenerateKey generates a key for an algorithm
+func GenerateKey(algorithm string, activatesAt, retiresAt time.Time) (*Key, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:          hex.EncodeToString(id),
+		Algorithm:   algorithm,
+		Signer:      signer,
+		ActivatesAt: activatesAt,
+		RetiresAt:   retiresAt,
+	}, nil
+}
+
+// JWK returns the public JSON Web Key of the key
+func (k *Key) JWK() (jwk.Key, error) {
+	return jwk.New(k.ID, k.Algorithm, k.Signer.Public())
+}
+
+// signingMethod returns the jwt signing method of an algorithm
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmES256:
+		return jwt.SigningMethodES256, nil
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, algorithm)
+}
+
+// KeyStore persists the signing keys, so they are shared by the instances and survive restarts
+type KeyStore interface {
+	LoadKeys(ctx context.Context) ([]*Key, error)
+	SaveKey(ctx context.Context, key *Key) error
+	DeleteKeysRetiredBefore(ctx context.Context, t time.Time) error
+}
+
+// MemoryKeyStore is a KeyStore for a single instance, the keys are lost on restart
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys []*Key
+}
+
+// NewMemoryKeyStore returns an empty MemoryKeyStore
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{}
+}
+
+// LoadKeys returns the stored keys
+func (s *MemoryKeyStore) LoadKeys(ctx context.Context) ([]*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Key(nil), s.keys...), nil
+}
+
+// SaveKey stores a key
+func (s *MemoryKeyStore) SaveKey(ctx context.Context, key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+// DeleteKeysRetiredBefore deletes the keys retired before t
+func (s *MemoryKeyStore) DeleteKeysRetiredBefore(ctx context.Context, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.keys[:0]
+	for _, key := range s.keys {
+		if !key.RetiresAt.Before(t) {
+			kept = append(kept, key)
+		}
+	}
+	s.keys = kept
+	return nil
+}
+
+// KeyRingOptions configures the rotation of a KeyRing
+type KeyRingOptions struct {
+	// Algorithm of the generated keys, RS256, ES256 or EdDSA
+	Algorithm string
+	// RotationInterval is how long a key signs before the next one replaces it
+	RotationInterval time.Duration
+	// PublishDelay is how long a new key is published before it signs,
+	// so the services caching our JWKS know it before they see tokens signed with it
+	PublishDelay time.Duration
+	// Retention is how long a key stays published after it stopped signing,
+	// it must be longer than the lifetime of the tokens
+	Retention time.Duration
+}
+
+// KeyRing holds the signing keys and rotates them
+type KeyRing struct {
+	store KeyStore
+	opts  KeyRingOptions
+
+	mu       sync.RWMutex
+	keys     []*Key
+	loadedAt time.Time
+}
+
+// NewKeyRing returns a KeyRing, the keys are loaded or generated on first use
+func NewKeyRing(store KeyStore, opts KeyRingOptions) *KeyRing {
+	if opts.Algorithm == "" {
+		opts.Algorithm = AlgorithmRS256
+	}
+	if opts.RotationInterval <= 0 {
+		opts.RotationInterval = DefaultRotationInterval
+	}
+	if opts.PublishDelay <= 0 {
+		opts.PublishDelay = DefaultPublishDelay
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	return &KeyRing{store: store, opts: opts}
+}
+
+// Load reloads the keys from the store and rotates them when they are due
+func (r *KeyRing) Load(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load(ctx, time.Now())
+}
+
+// Rotate generates a new key now, it signs once the publish delay is over
+func (r *KeyRing) Rotate(ctx context.Context) (*Key, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generate(ctx, time.Now().Add(r.opts.PublishDelay))
+}
+
+// Run reloads and rotates the keys until the context is cancelled
+func (r *KeyRing) Run(ctx context.Context) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Load(ctx); err != nil {
+				logging.Default.Error("could not rotate signing keys", "error", err)
+			}
+		}
+	}
+}
+
+// Signer returns the key signing the tokens now
+// The newest active key is used, as long as it stays published for the lifetime of a new token
+func (r *KeyRing) Signer(ctx context.Context) (*Key, error) {
+	now := time.Now()
+	r.mu.RLock()
+	key := r.signer(now)
+	r.mu.RUnlock()
+	if key != nil {
+		return key, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(ctx, now); err != nil {
+		return nil, err
+	}
+	if key := r.signer(now); key != nil {
+		return key, nil
+	}
+	// Every key is retiring, the rotation was not running: sign with a new key at once
+	return r.generate(ctx, now)
+}
+
+// Lookup returns the published key identified by kid
+// Unknown kids reload the keys, at most every few seconds, in case another instance rotated them
+func (r *KeyRing) Lookup(ctx context.Context, kid string) (*Key, bool) {
+	now := time.Now()
+	r.mu.RLock()
+	key := r.find(kid, now)
+	loadedAt := r.loadedAt
+	r.mu.RUnlock()
+	if key != nil || now.Sub(loadedAt) < minReloadInterval {
+		return key, key != nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(ctx, now); err != nil {
+		logging.Default.Error("could not reload signing keys", "error", err)
+	}
+	key = r.find(kid, now)
+	return key, key != nil
+}
+
+// JWKS returns the published keys as a JSON Web Key Set
+func (r *KeyRing) JWKS(ctx context.Context) (jwk.Set, error) {
+	r.mu.RLock()
+	empty := len(r.keys) == 0
+	r.mu.RUnlock()
+	if empty {
+		if _, err := r.Signer(ctx); err != nil {
+			return jwk.Set{}, err
+		}
+	}
+	now := time.Now()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := jwk.Set{Keys: []jwk.Key{}}
+	for _, key := range r.keys {
+		if !key.RetiresAt.After(now) {
+			continue
+		}
+		public, err := key.JWK()
+		if err != nil {
+			return jwk.Set{}, err
+		}
+		set.Keys = append(set.Keys, public)
+	}
+	return set, nil
+}
+
+// load reloads the keys, generates the first key and rotates the signing key when it is due, r.mu must be held
+func (r *KeyRing) load(ctx context.Context, now time.Time) error {
+	keys, err := r.store.LoadKeys(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ActivatesAt.Before(keys[j].ActivatesAt) })
+	r.keys = keys
+	r.loadedAt = now
+	if len(keys) == 0 {
+		_, err := r.generate(ctx, now)
+		return err
+	}
+	// The next key is published ahead of the end of the interval of the newest one
+	newest := keys[len(keys)-1]
+	if !newest.ActivatesAt.Add(r.opts.RotationInterval - r.opts.PublishDelay).After(now) {
+		key, err := r.generate(ctx, now.Add(r.opts.PublishDelay))
+		if err != nil {
+			return err
+		}
+		logging.Default.Info("signing key rotated", "kid", key.ID, "activates_at", key.ActivatesAt)
+	}
+	return r.store.DeleteKeysRetiredBefore(ctx, now)
+}
+
+// generate generates, stores and adds a key activating at a time, r.mu must be held
+func (r *KeyRing) generate(ctx context.Context, activatesAt time.Time) (*Key, error) {
+	retiresAt := activatesAt.Add(r.opts.RotationInterval + r.opts.Retention)
+	key, err := GenerateKey(r.opts.Algorithm, activatesAt, retiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.store.SaveKey(ctx, key); err != nil {
+		return nil, err
+	}
+	r.keys = append(r.keys, key)
+	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i].ActivatesAt.Before(r.keys[j].ActivatesAt) })
+	return key, nil
+}
+
+// signer returns the newest active key that stays published for a retention period, r.mu must be held
+func (r *KeyRing) signer(now time.Time) *Key {
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		key := r.keys[i]
+		if key.ActivatesAt.After(now) {
+			continue
+		}
+		if key.RetiresAt.After(now.Add(r.opts.Retention)) {
+			return key
+		}
+		return nil
+	}
+	return nil
+}
+
+// find returns a published key by kid, r.mu must be held
+func (r *KeyRing) find(kid string, now time.Time) *Key {
+	for _, key := range r.keys {
+		if key.ID == kid && key.RetiresAt.After(now) {
+			return key
+		}
+	}
+	return nil
+}
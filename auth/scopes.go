@@ -0,0 +1,26 @@
+package auth
+
+// Scopes of the API keys, an API key can only call the routes requiring one of its scopes
+// User tokens are not scoped, they can call every route of their user
+const (
+	ScopeProfileRead = "profile:read"
+	ScopeTodosRead   = "todos:read"
+	ScopeTodosWrite  = "todos:write"
+)
+
+// Scopes lists the scopes that can be granted to an API key
+var Scopes = []string{
+	ScopeProfileRead,
+	ScopeTodosRead,
+	ScopeTodosWrite,
+}
+
+// IsValidScope reports whether a scope can be granted to an API key
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/zerodot618/go-huang/oidc/mockprovider"
+)
+
+// main runs a mock OpenID Connect provider to try the OIDC logins locally, for example with
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9999
+//	OIDC_MOCK_CLIENT_ID=go-huang
+//	OIDC_MOCK_CLIENT_SECRET=secret
+//
+// and opening http://localhost:8088/api/public/oidc/mock/login
+func main() {
+	addr := flag.String("addr", "localhost:9999", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9999", "issuer URL")
+	clientID := flag.String("client-id", "go-huang", "client ID")
+	clientSecret := flag.String("client-secret", "secret", "client secret")
+	email := flag.String("email", "jane@example.com", "email of the logged in user")
+	name := flag.String("name", "Jane Doe", "name of the logged in user")
+	flag.Parse()
+
+	provider, err := mockprovider.New(*issuer, *clientID, *clientSecret, mockprovider.Identity{
+		Subject:       "mock|" + *email,
+		Email:         *email,
+		Name:          *name,
+		EmailVerified: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("mock OIDC provider %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
@@ -1,16 +1,150 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/zerodot618/go-huang/cmd/scan_port/port"
+	"github.com/zerodot618/go-huang/cmd/scan_port/output"
+	"github.com/zerodot618/go-huang/port"
 )
 
+// progressInterval is how often the progress is printed
+const progressInterval = time.Second
+
+// main scans the ports of the hosts given by -hosts or as arguments, for example
+//
+//	scan_port -ports 22,80,8000-8100 -timeout 1s -workers 200 -format json 10.0.0.0/24 example.com
+//
+// The open ports, and the UDP ports that may be open, are written to the standard output or the -o file
+// in the -format format, -all writes every port. The progress is printed to the standard error.
+// Ctrl-C stops the scan and writes the results so far
 func main() {
-	fmt.Println("Port Scanning")
-	results := port.InitialScan("localhost")
-	fmt.Println(results)
+	hosts := flag.String("hosts", "", "comma separated hosts, IP addresses or CIDR ranges, added to the arguments")
+	ports := flag.String("ports", "1-1024", "ports and ranges, like 22,80,8000-8100")
+	protocol := flag.String("protocol", "tcp", "protocol: tcp, udp or both")
+	timeout := flag.Duration("timeout", port.DefaultTimeout, "timeout of every dial")
+	workers := flag.Int("workers", port.DefaultWorkers, "number of ports probed at once")
+	detect := flag.Bool("detect", true, "grab the banners of the open TCP ports and detect their services")
+	format := flag.String("format", "table", "output format: "+strings.Join(output.Formats, ", "))
+	all := flag.Bool("all", false, "write every port, not only the open ones")
+	outputFile := flag.String("o", "", "file to write the results to, the standard output by default")
+	quiet := flag.Bool("quiet", false, "do not print the progress")
+	flag.Parse()
+
+	targets := flag.Args()
+	if *hosts != "" {
+		targets = append(targets, strings.Split(*hosts, ",")...)
+	}
+	if len(targets) == 0 {
+		targets = []string{"localhost"}
+	}
+	expanded, err := port.ExpandTargets(targets)
+	if err != nil {
+		fail(err)
+	}
+	portList, err := port.ParsePorts(*ports)
+	if err != nil {
+		fail(err)
+	}
+	protocols, err := parseProtocol(*protocol)
+	if err != nil {
+		fail(err)
+	}
+	if *workers < 1 {
+		fail(fmt.Errorf("-workers must be at least 1"))
+	}
+	out := os.Stdout
+	if *outputFile != "" {
+		if out, err = os.Create(*outputFile); err != nil {
+			fail(err)
+		}
+		defer out.Close()
+	}
+	writer, err := output.New(*format, out, output.Options{All: *all, Args: os.Args[1:]})
+	if err != nil {
+		fail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	var progress func(done, total int, result port.ScanResult)
+	if !*quiet {
+		progress = progressPrinter(start)
+	}
+	var writeErr error
+	scanner := port.New(
+		port.WithProtocols(protocols...),
+		port.WithTimeout(*timeout),
+		port.WithWorkers(*workers),
+		port.WithDetection(*detect),
+		port.WithProgress(func(done, total int, result port.ScanResult) {
+			if err := writer.Result(result); err != nil && writeErr == nil {
+				writeErr = err
+			}
+			if progress != nil {
+				progress(done, total, result)
+			}
+		}),
+	)
+	results, scanErr := scanner.Scan(ctx, expanded, portList)
+	if !*quiet {
+		fmt.Fprintln(os.Stderr)
+	}
+
+	summary := port.Summarize(expanded, results, start, time.Now(), scanErr != nil)
+	if err := writer.Close(results, summary); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		fail(writeErr)
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "%d hosts, %d probes, %d open ports in %s\n",
+			summary.Hosts, summary.Probes, summary.States[port.StateOpen], summary.Elapsed().Round(time.Millisecond))
+	}
+	if scanErr != nil {
+		fmt.Fprintln(os.Stderr, "scan interrupted")
+		os.Exit(130)
+	}
+}
+
+// parseProtocol returns the protocols of the -protocol flag
+func parseProtocol(protocol string) ([]string, error) {
+	switch protocol {
+	case "tcp", "udp":
+		return []string{protocol}, nil
+	case "both":
+		return []string{"tcp", "udp"}, nil
+	default:
+		return nil, fmt.Errorf("invalid protocol %q, must be tcp, udp or both", protocol)
+	}
+}
+
+// progressPrinter returns a Scanner.Progress printing the progress to the standard error every progressInterval
+func progressPrinter(start time.Time) func(done, total int, result port.ScanResult) {
+	var last time.Time
+	open := 0
+	return func(done, total int, result port.ScanResult) {
+		if result.State == port.StateOpen {
+			open++
+		}
+		if now := time.Now(); now.Sub(last) >= progressInterval || done == total {
+			last = now
+			fmt.Fprintf(os.Stderr, "\rscanned %d/%d (%.1f%%), %d open, %s elapsed",
+				done, total, 100*float64(done)/float64(total), open, now.Sub(start).Round(time.Second))
+		}
+	}
+}
 
-	widescanresults := port.WideScan("localhost")
-	fmt.Println(widescanresults)
+// fail prints an error and exits
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "scan_port:", err)
+	os.Exit(2)
 }
@@ -0,0 +1,63 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/zerodot618/go-huang/port"
+)
+
+// jsonWriter writes one JSON document with the summary and the results once the scan is done
+type jsonWriter struct {
+	w    io.Writer
+	opts Options
+}
+
+// Result does nothing, the results are written by Close
+func (j *jsonWriter) Result(port.ScanResult) error {
+	return nil
+}
+
+// Close writes the document
+func (j *jsonWriter) Close(results []port.ScanResult, summary port.Summary) error {
+	shown := j.opts.filter(results)
+	records := make([]record, len(shown))
+	for i, result := range shown {
+		records[i] = newRecord(result)
+	}
+	encoder := json.NewEncoder(j.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Summary summaryRecord `json:"summary"`
+		Results []record      `json:"results"`
+	}{newSummaryRecord(summary), records})
+}
+
+// jsonLinesWriter streams a JSON object by result as the probes are done, in no particular order,
+// and a last object with the summary. The type field of the objects is result or summary
+type jsonLinesWriter struct {
+	encoder *json.Encoder
+	opts    Options
+}
+
+// newJSONLinesWriter returns a jsonLinesWriter
+func newJSONLinesWriter(w io.Writer, opts Options) *jsonLinesWriter {
+	return &jsonLinesWriter{encoder: json.NewEncoder(w), opts: opts}
+}
+
+// Result writes a result
+func (j *jsonLinesWriter) Result(result port.ScanResult) error {
+	if !j.opts.shown(result) {
+		return nil
+	}
+	r := newRecord(result)
+	r.Type = "result"
+	return j.encoder.Encode(r)
+}
+
+// Close writes the summary
+func (j *jsonLinesWriter) Close(_ []port.ScanResult, summary port.Summary) error {
+	s := newSummaryRecord(summary)
+	s.Type = "summary"
+	return j.encoder.Encode(s)
+}
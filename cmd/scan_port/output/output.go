@@ -0,0 +1,125 @@
+// Package output writes the results of the port scanner as a table, JSON, JSON Lines, CSV or nmap XML.
+// Every format but the table is meant to be parsed, to compare the scans of a host over time.
+package output
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zerodot618/go-huang/port"
+)
+
+// Formats are the supported output formats
+var Formats = []string{"table", "json", "jsonl", "csv", "xml"}
+
+// Writer writes the results of a scan
+type Writer interface {
+	// Result is called as soon as a probe is done, the streaming formats write the result at once
+	Result(result port.ScanResult) error
+	// Close writes the results sorted by host, protocol and port, and the summary of the run
+	Close(results []port.ScanResult, summary port.Summary) error
+}
+
+// Options are the options of the writers
+type Options struct {
+	// All writes every port, only the open ones and the UDP ports that may be open are written otherwise
+	All bool
+	// Args are the command line arguments of the scan, reported by the XML format
+	Args []string
+}
+
+// New returns the writer of a format
+func New(format string, w io.Writer, opts Options) (Writer, error) {
+	switch format {
+	case "table":
+		return &tableWriter{w: w, opts: opts}, nil
+	case "json":
+		return &jsonWriter{w: w, opts: opts}, nil
+	case "jsonl":
+		return newJSONLinesWriter(w, opts), nil
+	case "csv":
+		return newCSVWriter(w, opts), nil
+	case "xml":
+		return &xmlWriter{w: w, opts: opts}, nil
+	default:
+		return nil, fmt.Errorf("invalid format %q, must be one of %v", format, Formats)
+	}
+}
+
+// shown reports whether a result is written
+func (o Options) shown(result port.ScanResult) bool {
+	return o.All || result.State == port.StateOpen || result.State == port.StateOpenFiltered
+}
+
+// filter returns the results written
+func (o Options) filter(results []port.ScanResult) []port.ScanResult {
+	shown := make([]port.ScanResult, 0, len(results))
+	for _, result := range results {
+		if o.shown(result) {
+			shown = append(shown, result)
+		}
+	}
+	return shown
+}
+
+// record is a result in the JSON, JSON Lines and CSV formats
+type record struct {
+	Type       string    `json:"type,omitempty"`
+	Host       string    `json:"host"`
+	Port       int       `json:"port"`
+	Protocol   string    `json:"protocol"`
+	State      string    `json:"state"`
+	Reason     string    `json:"reason"`
+	Service    string    `json:"service,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Banner     string    `json:"banner,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// newRecord returns the record of a result
+func newRecord(result port.ScanResult) record {
+	return record{
+		Host:       result.Host,
+		Port:       result.PortNumber(),
+		Protocol:   result.Protocol(),
+		State:      result.State,
+		Reason:     result.Reason,
+		Service:    result.Service,
+		Version:    result.Version,
+		Banner:     result.Banner,
+		StartedAt:  result.StartedAt.UTC(),
+		DurationMS: milliseconds(result.Duration),
+	}
+}
+
+// summaryRecord is the summary of a run in the JSON and JSON Lines formats
+type summaryRecord struct {
+	Type        string         `json:"type,omitempty"`
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at"`
+	ElapsedMS   float64        `json:"elapsed_ms"`
+	Hosts       int            `json:"hosts"`
+	Probes      int            `json:"probes"`
+	States      map[string]int `json:"states"`
+	Interrupted bool           `json:"interrupted"`
+}
+
+// newSummaryRecord returns the record of a summary
+func newSummaryRecord(summary port.Summary) summaryRecord {
+	return summaryRecord{
+		StartedAt:   summary.StartedAt.UTC(),
+		FinishedAt:  summary.FinishedAt.UTC(),
+		ElapsedMS:   milliseconds(summary.Elapsed()),
+		Hosts:       summary.Hosts,
+		Probes:      summary.Probes,
+		States:      summary.States,
+		Interrupted: summary.Interrupted,
+	}
+}
+
+// milliseconds returns a duration in milliseconds, with a microsecond precision
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
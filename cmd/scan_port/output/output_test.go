@@ -0,0 +1,97 @@
+package output
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zerodot618/go-huang/port"
+)
+
+// update rewrites the golden files with the output of the writers: go test ./cmd/scan_port/output -update
+var update = flag.Bool("update", false, "update the golden files")
+
+// scanStart is the start of the scan of the golden files
+var scanStart = time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC)
+
+// scanResults are the results of the golden files, sorted by host, protocol and port as the writers get them
+func scanResults() []port.ScanResult {
+	at := func(ms int) time.Time { return scanStart.Add(time.Duration(ms) * time.Millisecond) }
+	return []port.ScanResult{
+		{Host: "10.0.0.1", Port: "22/tcp", State: port.StateOpen, Reason: port.ReasonSynAck, StartedAt: at(10), Duration: 1234 * time.Microsecond,
+			Service: "ssh", Version: "OpenSSH_9.6p1", Banner: `SSH-2.0-OpenSSH_9.6p1 "Ubuntu", <3ubuntu>`},
+		{Host: "10.0.0.1", Port: "80/tcp", State: port.StateClosed, Reason: port.ReasonConnRefused, StartedAt: at(11), Duration: 500 * time.Microsecond,
+			Service: "http"},
+		{Host: "10.0.0.1", Port: "443/tcp", State: port.StateOpen, Reason: port.ReasonSynAck, StartedAt: at(12), Duration: 2 * time.Millisecond,
+			Service: "ssl/https"},
+		{Host: "10.0.0.1", Port: "53/udp", State: port.StateOpenFiltered, Reason: port.ReasonNoResponse, StartedAt: at(13), Duration: time.Second,
+			Service: "domain"},
+		{Host: "10.0.0.2", Port: "22/tcp", State: port.StateFiltered, Reason: port.ReasonTimeout, StartedAt: at(20), Duration: time.Second,
+			Service: "ssh"},
+		{Host: "2001:db8::1", Port: "8080/tcp", State: port.StateOpen, Reason: port.ReasonSynAck, StartedAt: at(30), Duration: 750 * time.Microsecond,
+			Service: "http-proxy", Version: "nginx/1.24.0", Banner: "HTTP/1.1 200 OK"},
+		{Host: "db.internal", Port: "5432/tcp", State: port.StateOpen, Reason: port.ReasonSynAck, StartedAt: at(40), Duration: 3 * time.Millisecond,
+			Service: "postgresql"},
+	}
+}
+
+func TestWritersGolden(t *testing.T) {
+	results := scanResults()
+	hosts := []string{"10.0.0.1", "10.0.0.2", "2001:db8::1", "db.internal"}
+	summary := port.Summarize(hosts, results, scanStart, scanStart.Add(1500*time.Millisecond), false)
+	interrupted := port.Summarize(hosts, results, scanStart, scanStart.Add(1500*time.Millisecond), true)
+	tests := []struct {
+		golden  string
+		format  string
+		opts    Options
+		summary port.Summary
+	}{
+		{"table.golden", "table", Options{}, summary},
+		{"table_all_interrupted.golden", "table", Options{All: true}, interrupted},
+		{"json.golden", "json", Options{}, summary},
+		{"jsonl.golden", "jsonl", Options{}, summary},
+		{"csv.golden", "csv", Options{}, summary},
+		{"csv_all.golden", "csv", Options{All: true}, summary},
+		{"xml.golden", "xml", Options{Args: []string{"-p", "22,80,443", "10.0.0.0/30"}}, summary},
+		{"xml_interrupted.golden", "xml", Options{All: true}, interrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := New(tt.format, &buf, tt.opts)
+			if err != nil {
+				t.Fatalf("New(%q) error = %v", tt.format, err)
+			}
+			for _, result := range results {
+				if err := w.Result(result); err != nil {
+					t.Fatalf("Result() error = %v", err)
+				}
+			}
+			if err := w.Close(results, tt.summary); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			path := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != string(want) {
+				t.Errorf("%s output differs from %s:\n%s\nwant:\n%s", tt.format, path, got, want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidFormat(t *testing.T) {
+	if _, err := New("yaml", &bytes.Buffer{}, Options{}); err == nil {
+		t.Error("New(yaml) error = nil, want an invalid format error")
+	}
+}
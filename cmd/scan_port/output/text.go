@@ -0,0 +1,97 @@
+package output
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zerodot618/go-huang/port"
+)
+
+// csvHeader are the columns of the CSV format
+var csvHeader = []string{"host", "port", "protocol", "state", "reason", "service", "version", "banner", "started_at", "duration_ms"}
+
+// tableWriter writes an aligned table for humans once the scan is done, followed by the summary
+type tableWriter struct {
+	w    io.Writer
+	opts Options
+}
+
+// Result does nothing, the results are written by Close
+func (t *tableWriter) Result(port.ScanResult) error {
+	return nil
+}
+
+// Close writes the table and the summary
+func (t *tableWriter) Close(results []port.ScanResult, summary port.Summary) error {
+	tw := tabwriter.NewWriter(t.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tPORT\tSTATE\tREASON\tSERVICE\tVERSION")
+	for _, result := range t.opts.filter(results) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			result.Host, result.Port, result.State, result.Reason, result.Service, result.Version)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	states := make([]string, 0, len(summary.States))
+	for state, count := range summary.States {
+		states = append(states, fmt.Sprintf("%d %s", count, state))
+	}
+	sort.Strings(states)
+	line := fmt.Sprintf("\n%d hosts, %d probes (%s) in %s",
+		summary.Hosts, summary.Probes, strings.Join(states, ", "), summary.Elapsed().Round(time.Millisecond))
+	if summary.Interrupted {
+		line += ", interrupted"
+	}
+	_, err := fmt.Fprintln(t.w, line)
+	return err
+}
+
+// csvWriter writes a header and a CSV row by result once the scan is done
+// The summary is not written, every row is a result
+type csvWriter struct {
+	w    *csv.Writer
+	opts Options
+}
+
+// newCSVWriter returns a csvWriter
+func newCSVWriter(w io.Writer, opts Options) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), opts: opts}
+}
+
+// Result does nothing, the results are written by Close
+func (c *csvWriter) Result(port.ScanResult) error {
+	return nil
+}
+
+// Close writes the header and the rows
+func (c *csvWriter) Close(results []port.ScanResult, _ port.Summary) error {
+	if err := c.w.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, result := range c.opts.filter(results) {
+		r := newRecord(result)
+		err := c.w.Write([]string{
+			r.Host,
+			strconv.Itoa(r.Port),
+			r.Protocol,
+			r.State,
+			r.Reason,
+			r.Service,
+			r.Version,
+			r.Banner,
+			r.StartedAt.Format(time.RFC3339Nano),
+			strconv.FormatFloat(r.DurationMS, 'f', 3, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
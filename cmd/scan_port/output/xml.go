@@ -0,0 +1,230 @@
+package output
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zerodot618/go-huang/port"
+)
+
+// xmlTimeFormat is the format of the times written by nmap, like Mon Oct 19 09:00:00 2026
+const xmlTimeFormat = "Mon Jan 2 15:04:05 2006"
+
+// xmlWriter writes the subset of the nmap XML format read by the usual tools, like ndiff, once the scan is done
+// Hosts given by name have no address element, the scanner does not resolve them
+type xmlWriter struct {
+	w    io.Writer
+	opts Options
+}
+
+// nmapRun is the root element of the nmap XML format, the following types are its other elements
+type nmapRun struct {
+	XMLName          xml.Name   `xml:"nmaprun"`
+	Scanner          string     `xml:"scanner,attr"`
+	Args             string     `xml:"args,attr"`
+	Start            int64      `xml:"start,attr"`
+	StartStr         string     `xml:"startstr,attr"`
+	Version          string     `xml:"version,attr"`
+	XMLOutputVersion string     `xml:"xmloutputversion,attr"`
+	Hosts            []nmapHost `xml:"host"`
+	RunStats         nmapStats  `xml:"runstats"`
+}
+
+type nmapHost struct {
+	StartTime int64          `xml:"starttime,attr"`
+	EndTime   int64          `xml:"endtime,attr"`
+	Status    nmapStatus     `xml:"status"`
+	Address   *nmapAddress   `xml:"address,omitempty"`
+	Hostnames *nmapHostnames `xml:"hostnames,omitempty"`
+	Ports     nmapPorts      `xml:"ports"`
+}
+
+type nmapStatus struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type nmapHostnames struct {
+	Hostname []nmapHostname `xml:"hostname"`
+}
+
+type nmapHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapPorts struct {
+	ExtraPorts []nmapExtraPorts `xml:"extraports"`
+	Ports      []nmapPort       `xml:"port"`
+}
+
+type nmapExtraPorts struct {
+	State string `xml:"state,attr"`
+	Count int    `xml:"count,attr"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    nmapState    `xml:"state"`
+	Service  *nmapService `xml:"service,omitempty"`
+}
+
+type nmapState struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type nmapService struct {
+	Name      string `xml:"name,attr"`
+	Product   string `xml:"product,attr,omitempty"`
+	ExtraInfo string `xml:"extrainfo,attr,omitempty"`
+	Tunnel    string `xml:"tunnel,attr,omitempty"`
+	Method    string `xml:"method,attr"`
+	Conf      int    `xml:"conf,attr"`
+}
+
+type nmapStats struct {
+	Finished nmapFinished  `xml:"finished"`
+	Hosts    nmapHostStats `xml:"hosts"`
+}
+
+type nmapFinished struct {
+	Time    int64  `xml:"time,attr"`
+	TimeStr string `xml:"timestr,attr"`
+	Elapsed string `xml:"elapsed,attr"`
+	Summary string `xml:"summary,attr"`
+	Exit    string `xml:"exit,attr"`
+}
+
+type nmapHostStats struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+// Result does nothing, the results are written by Close
+func (x *xmlWriter) Result(port.ScanResult) error {
+	return nil
+}
+
+// Close writes the document
+// A host is up if one of its ports answered, the ports not written are counted in extraports
+func (x *xmlWriter) Close(results []port.ScanResult, summary port.Summary) error {
+	run := nmapRun{
+		Scanner:          "scan_port",
+		Args:             strings.Join(append([]string{"scan_port"}, x.opts.Args...), " "),
+		Start:            summary.StartedAt.Unix(),
+		StartStr:         summary.StartedAt.Format(xmlTimeFormat),
+		Version:          "1.0",
+		XMLOutputVersion: "1.05",
+	}
+	index := map[string]int{}
+	extra := map[string]map[string]int{}
+	for _, result := range results {
+		i, ok := index[result.Host]
+		if !ok {
+			i = len(run.Hosts)
+			index[result.Host] = i
+			run.Hosts = append(run.Hosts, newNmapHost(result.Host))
+			run.Hosts[i].StartTime = result.StartedAt.Unix()
+			extra[result.Host] = map[string]int{}
+		}
+		host := &run.Hosts[i]
+		if end := result.StartedAt.Add(result.Duration).Unix(); end > host.EndTime {
+			host.EndTime = end
+		}
+		if result.State == port.StateOpen || result.State == port.StateClosed {
+			host.Status = nmapStatus{State: "up", Reason: result.Reason}
+		}
+		if !x.opts.shown(result) {
+			extra[result.Host][result.State]++
+			continue
+		}
+		host.Ports.Ports = append(host.Ports.Ports, newNmapPort(result))
+	}
+	for name, i := range index {
+		host := &run.Hosts[i]
+		for state, count := range extra[name] {
+			host.Ports.ExtraPorts = append(host.Ports.ExtraPorts, nmapExtraPorts{State: state, Count: count})
+		}
+		sort.Slice(host.Ports.ExtraPorts, func(a, b int) bool {
+			return host.Ports.ExtraPorts[a].State < host.Ports.ExtraPorts[b].State
+		})
+		if host.Status.State == "up" {
+			run.RunStats.Hosts.Up++
+		} else {
+			run.RunStats.Hosts.Down++
+		}
+	}
+	run.RunStats.Hosts.Total = len(run.Hosts)
+	exit := "success"
+	if summary.Interrupted {
+		exit = "error"
+	}
+	run.RunStats.Finished = nmapFinished{
+		Time:    summary.FinishedAt.Unix(),
+		TimeStr: summary.FinishedAt.Format(xmlTimeFormat),
+		Elapsed: strconv.FormatFloat(summary.Elapsed().Seconds(), 'f', 2, 64),
+		Summary: fmt.Sprintf("scan_port done at %s; %d IP addresses (%d hosts up) scanned in %.2f seconds",
+			summary.FinishedAt.Format(xmlTimeFormat), len(run.Hosts), run.RunStats.Hosts.Up, summary.Elapsed().Seconds()),
+		Exit: exit,
+	}
+	if _, err := io.WriteString(x.w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(x.w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(run); err != nil {
+		return err
+	}
+	_, err := io.WriteString(x.w, "\n")
+	return err
+}
+
+// newNmapHost returns a host down until one of its ports answers, with its address if it is an IP address
+func newNmapHost(name string) nmapHost {
+	host := nmapHost{Status: nmapStatus{State: "down", Reason: port.ReasonNoResponse}}
+	if addr, err := netip.ParseAddr(name); err == nil {
+		addrType := "ipv4"
+		if addr.Is6() {
+			addrType = "ipv6"
+		}
+		host.Address = &nmapAddress{Addr: name, AddrType: addrType}
+	} else {
+		host.Hostnames = &nmapHostnames{Hostname: []nmapHostname{{Name: name, Type: "user"}}}
+	}
+	return host
+}
+
+// newNmapPort returns the port of a result, the service is probed if a version or a banner was read
+func newNmapPort(result port.ScanResult) nmapPort {
+	p := nmapPort{
+		Protocol: result.Protocol(),
+		PortID:   result.PortNumber(),
+		State:    nmapState{State: result.State, Reason: result.Reason},
+	}
+	if result.Service != "" {
+		service := &nmapService{Name: result.Service, Method: "table", Conf: 3}
+		if result.Version != "" || result.Banner != "" {
+			service.Method, service.Conf = "probed", 10
+			service.Product = result.Version
+			service.ExtraInfo = result.Banner
+		}
+		if name, ok := strings.CutPrefix(service.Name, "ssl/"); ok {
+			service.Name, service.Tunnel = name, "ssl"
+		}
+		p.Service = service
+	}
+	return p
+}
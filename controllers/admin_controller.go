@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/auth"
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/middlewares"
+	"github.com/zerodot618/go-huang/models"
+	"gorm.io/gorm"
+)
+
+// ImpersonationTTL is the lifetime of an impersonation token, it cannot be refreshed
+const ImpersonationTTL = 30 * time.Minute
+
+// AdminController is a struct that represents a controller for the admin operations on users
+type AdminController struct{}
+
+// ImpersonatePayload is a struct that contains the reason of an impersonation, kept in the audit log
+type ImpersonatePayload struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+// ImpersonateResponse is a struct that contains the token letting an admin act as a user
+type ImpersonateResponse struct {
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// ListUsers is a function that returns a page of the users matching the query parameters
+// q matches the name or the email, status is active, disabled or pending_deletion and role is user or admin
+
+// @Summary List Users
+// @ID AdminListUsers
+// @Tags Admin
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param q query string false "Name or email"
+// @Param status query string false "active, disabled or pending_deletion"
+// @Param role query string false "user or admin"
+// @Param page query int false "Page, from 1"
+// @Param page_size query int false "Page size, up to 100"
+// @Produce json
+// @Success 200 {object} models.Page[models.User] "Success"
+// @Failure 422 {string} string "Error"
+// @Router /admin/users [GET]
+func (ctrl AdminController) ListUsers(c *gin.Context) {
+	var filter models.UserFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	page, err := models.SearchUsers(filter, pagination)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List Users"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// DisableUser is a function that disables a user, who is logged out everywhere and cannot log in
+// nor use their API keys until enabled again. An admin cannot disable their own account
+
+// @Summary Disable User
+// @ID AdminDisableUser
+// @Tags Admin
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "User ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /admin/users/{id}/disable [POST]
+func (ctrl AdminController) DisableUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if user.ID == c.GetUint("user_id") {
+		apperror.Abort(c, apperror.BadRequest("Cannot Disable Your Own Account"))
+		return
+	}
+	if err := user.SetDisabled(true); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Disable User"))
+		return
+	}
+	audit(c, models.AuditUserDisabled, user.ID, "")
+	c.JSON(http.StatusOK, gin.H{"Message": "User Disabled"})
+}
+
+// EnableUser is a function that enables a user disabled by an admin
+
+// @Summary Enable User
+// @ID AdminEnableUser
+// @Tags Admin
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "User ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /admin/users/{id}/enable [POST]
+func (ctrl AdminController) EnableUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if err := user.SetDisabled(false); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Enable User"))
+		return
+	}
+	audit(c, models.AuditUserEnabled, user.ID, "")
+	c.JSON(http.StatusOK, gin.H{"Message": "User Enabled"})
+}
+
+// Impersonate is a function that returns a short-lived token letting an admin act as a user, to help them
+// The impersonation is recorded in the audit log with its reason before the token is issued,
+// and shows in the sessions of the user. The token cannot be used on the account routes
+// nor on the admin routes. Admins and disabled users cannot be impersonated
+
+// @Summary Impersonate User
+// @ID AdminImpersonate
+// @Tags Admin
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "User ID"
+// @Param EnterDetails body ImpersonatePayload true "Reason"
+// @Accept json
+// @Produce json
+// @Success 200 {object} ImpersonateResponse "Success"
+// @Failure 403 {string} string "Error"
+// @Router /admin/users/{id}/impersonate [POST]
+func (ctrl AdminController) Impersonate(c *gin.Context) {
+	var payload ImpersonatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	adminID := c.GetUint("user_id")
+	if user.ID == adminID || user.IsAdmin() {
+		apperror.Abort(c, apperror.Forbidden("Cannot Impersonate An Admin"))
+		return
+	}
+	if user.IsDisabled() {
+		apperror.Abort(c, apperror.Forbidden("Account Disabled"))
+		return
+	}
+	// No token is issued unless the impersonation is in the audit log
+	if !audit(c, models.AuditUserImpersonated, user.ID, payload.Reason) {
+		apperror.Abort(c, apperror.New(apperror.CodeUnavailable, "Could Not Record Impersonation"))
+		return
+	}
+	session, err := models.CreateImpersonationSession(user.ID, adminID, c.Request.UserAgent(), c.ClientIP(), ImpersonationTTL)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Create Session"))
+		return
+	}
+	token, err := auth.Default.GenerateImpersonationToken(user.ID, user.Email, session.ID, adminID, ImpersonationTTL)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Error Signing Token"))
+		return
+	}
+	logging.FromGin(c).Warn("user impersonated", "user_id", user.ID, "admin_id", adminID)
+	c.JSON(http.StatusOK, ImpersonateResponse{Token: token, ExpiresIn: int(ImpersonationTTL.Seconds())})
+}
+
+// ListAuditLogs is a function that returns a page of the audit log, newest first
+// It can be filtered by actor_id, target_user_id and action
+
+// @Summary List Audit Logs
+// @ID AdminListAuditLogs
+// @Tags Admin
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param actor_id query int false "Admin ID"
+// @Param target_user_id query int false "User ID"
+// @Param action query string false "Action"
+// @Param page query int false "Page, from 1"
+// @Param page_size query int false "Page size, up to 100"
+// @Produce json
+// @Success 200 {object} models.Page[models.AuditLog] "Success"
+// @Router /admin/audit-logs [GET]
+func (ctrl AdminController) ListAuditLogs(c *gin.Context) {
+	var filter models.AuditLogFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	page, err := models.ListAuditLogs(filter, pagination)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List Audit Logs"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ResetTOTP is a function that disables 2FA for a user who lost their authenticator and recovery codes
+// The user can log in with their password only and enroll again
+// If the user is not found, it returns a 404 status code
+
+// @Summary Reset User 2FA
+// @ID AdminResetTOTP
+// @Tags Admin
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "User ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /admin/users/{id}/2fa/reset [POST]
+func (ctrl AdminController) ResetTOTP(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if err := user.DisableTOTP(); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Reset 2FA"))
+		return
+	}
+	audit(c, models.AuditTOTPReset, user.ID, "")
+	logging.FromGin(c).Warn("2fa reset by admin", "user_id", user.ID, "admin_id", c.GetUint("user_id"))
+	c.JSON(http.StatusOK, gin.H{"Message": "2FA Reset"})
+}
+
+// TerminateSessions is a function that revokes every session of a user, logging them out on all devices
+// It returns the number of revoked sessions
+
+// @Summary Terminate User Sessions
+// @ID AdminTerminateSessions
+// @Tags Admin
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "User ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /admin/users/{id}/sessions/terminate [POST]
+func (ctrl AdminController) TerminateSessions(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	revoked, err := models.RevokeUserSessions(user.ID)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Terminate Sessions"))
+		return
+	}
+	audit(c, models.AuditSessionsTerminated, user.ID, "")
+	logging.FromGin(c).Warn("sessions terminated by admin", "user_id", user.ID, "admin_id", c.GetUint("user_id"), "sessions", revoked)
+	c.JSON(http.StatusOK, gin.H{"Message": "Sessions Terminated", "revoked": revoked})
+}
+
+// adminTargetUser loads the user of the id path parameter
+// It aborts the request and returns false if the user cannot be loaded
+func adminTargetUser(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid User ID"))
+		return nil, false
+	}
+	var user models.User
+	err = database.GlobalDB.First(&user, uint(id)).Error
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("User Not Found"))
+		return nil, false
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+		return nil, false
+	}
+	return &user, true
+}
+
+// audit records an admin action on a user in the audit log
+// It returns false if the entry could not be recorded, the error is logged
+func audit(c *gin.Context, action string, targetUserID uint, reason string) bool {
+	return recordAudit(c, &models.AuditLog{Action: action, TargetUserID: &targetUserID, Reason: reason})
+}
+
+// recordAudit records an admin action in the audit log with the admin and the request
+// It returns false if the entry could not be recorded, the error is logged
+func recordAudit(c *gin.Context, entry *models.AuditLog) bool {
+	entry.ActorID = c.GetUint("user_id")
+	entry.IP = c.ClientIP()
+	entry.UserAgent = c.Request.UserAgent()
+	entry.RequestID = c.GetString(middlewares.RequestIDKey)
+	if err := models.RecordAudit(entry); err != nil {
+		logging.FromGin(c).Error("could not record audit log", "action", entry.Action, "error", err.Error())
+		return false
+	}
+	return true
+}
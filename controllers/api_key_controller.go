@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/models"
+	"gorm.io/gorm"
+)
+
+// APIKeyController is a struct that represents a controller for the personal API keys of the users
+type APIKeyController struct{}
+
+// APIKeyPayload is a struct that contains the fields of a new API key
+type APIKeyPayload struct {
+	Name      string     `json:"name" binding:"required,max=64"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,scope"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse is the response of a new API key, the key is only shown once
+type APIKeyResponse struct {
+	Key    string         `json:"key"`
+	APIKey *models.APIKey `json:"api_key"`
+}
+
+// CreateAPIKey is a function that creates an API key for the current user
+// The key is sent in the X-API-Key header and can only call the routes of its scopes
+// It returns the key once, only its hash is stored
+
+// @Summary Create API Key
+// @ID CreateAPIKey
+// @Tags APIKey
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body APIKeyPayload true "Name, scopes and optional expiry"
+// @Accept json
+// @Produce json
+// @Success 201 {object} APIKeyResponse "Success"
+// @Failure 422 {string} string "Error"
+// @Router /protected/api-keys [POST]
+func (ctrl APIKeyController) CreateAPIKey(c *gin.Context) {
+	var payload APIKeyPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		apperror.Abort(c, apperror.Validation(apperror.FieldError{
+			Field:   "expires_at",
+			Code:    "future",
+			Message: "expires_at must be in the future",
+		}))
+		return
+	}
+	key := models.APIKey{
+		UserID:    c.GetUint("user_id"),
+		Name:      payload.Name,
+		Scopes:    payload.Scopes,
+		ExpiresAt: payload.ExpiresAt,
+	}
+	plain, err := models.CreateAPIKey(&key)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Create API Key"))
+		return
+	}
+	logging.FromGin(c).Info("api key created", "user_id", key.UserID, "api_key_id", key.ID, "prefix", key.Prefix)
+	c.JSON(http.StatusCreated, APIKeyResponse{Key: plain, APIKey: &key})
+}
+
+// ListAPIKeys is a function that lists the API keys of the current user, with their last use
+
+// @Summary List API Keys
+// @ID ListAPIKeys
+// @Tags APIKey
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Produce json
+// @Success 200 {object} string "Success"
+// @Router /protected/api-keys [GET]
+func (ctrl APIKeyController) ListAPIKeys(c *gin.Context) {
+	keys, err := models.ListAPIKeys(c.GetUint("user_id"))
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get API Keys"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// RevokeAPIKey is a function that revokes an API key of the current user
+// If the key is not found or already revoked, it returns a 404 status code
+
+// @Summary Revoke API Key
+// @ID RevokeAPIKey
+// @Tags APIKey
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "API key ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /protected/api-keys/{id} [DELETE]
+func (ctrl APIKeyController) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid API Key ID"))
+		return
+	}
+	err = models.RevokeAPIKey(c.GetUint("user_id"), uint(id))
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("API Key Not Found"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Revoke API Key"))
+		return
+	}
+	logging.FromGin(c).Info("api key revoked", "user_id", c.GetUint("user_id"), "api_key_id", id)
+	c.JSON(http.StatusOK, gin.H{"Message": "API Key Revoked"})
+}
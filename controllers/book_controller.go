@@ -4,8 +4,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
 	"github.com/zerodot618/go-huang/database"
 	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/utils"
 )
 
 // BookController is a struct that represents a controller for book-related operations
@@ -16,12 +18,12 @@ func (ctrl *BookController) CreateBook(c *gin.Context) {
 	// Bind the request body to a Book struct
 	var book models.Book
 	if err := c.ShouldBindJSON(&book); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Binding(err))
 		return
 	}
 	// Create a new book record in the database
 	if err := database.GlobalDB.Create(&book).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Could not create book"))
 		return
 	}
 	// Return the created book to the client
@@ -33,13 +35,13 @@ func (ctrl *BookController) SearchBooks(c *gin.Context) {
 	// Get the search query from the request parameters
 	query := c.Query("query")
 	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter is required"})
+		apperror.Abort(c, apperror.Validation(apperror.FieldError{Field: "query", Code: "required", Message: "query parameter is required"}))
 		return
 	}
 	// Query the database for books that match the search query
 	var books []models.Book
 	if err := database.GlobalDB.Where("title LIKE ? OR author LIKE ? OR publisher LIKE ? OR description LIKE ?", "%"+query+"%", "%"+query+"%", "%"+query+"%", "%"+query+"%").Find(&books).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Internal(err, "Could not search books"))
 		return
 	}
 	// Return the search results to the client
@@ -53,12 +55,12 @@ func (ctrl *BookController) DeleteBook(c *gin.Context) {
 	// Check if the book with the given ID exists
 	var book models.Book
 	if err := database.GlobalDB.Where("id = ?", bookID).First(&book).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+		apperror.Abort(c, apperror.NotFound("book not found"))
 		return
 	}
 	// Delete the book record from the database
 	if err := database.GlobalDB.Delete(&book).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Could not delete book"))
 		return
 	}
 	// Return a success message to the client
@@ -72,18 +74,19 @@ func (ctrl *BookController) UpdateBook(c *gin.Context) {
 	// Check if the book with the given ID exists
 	var book models.Book
 	if err := database.GlobalDB.Where("id = ?", id).First(&book).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		apperror.Abort(c, apperror.NotFound("book not found"))
 		return
 	}
 	// Bind the request body to a struct
 	var updateData struct {
-		Title       *string `json:"title"`
-		Author      *string `json:"author"`
-		Publisher   *string `json:"publisher"`
-		Description *string `json:"description"`
-	}
-	if err := c.BindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		Title       *string `json:"title" binding:"omitempty,min=1,max=191"`
+		Author      *string `json:"author" binding:"omitempty,min=1,max=191"`
+		Publisher   *string `json:"publisher" binding:"omitempty,min=1,max=191"`
+		Description *string `json:"description" binding:"omitempty,max=191"`
+		ISBN        *string `json:"isbn" binding:"omitempty,isbn"`
+	}
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
 		return
 	}
 	// Update the book fields with the provided values
@@ -99,9 +102,12 @@ func (ctrl *BookController) UpdateBook(c *gin.Context) {
 	if updateData.Description != nil {
 		book.Description = *updateData.Description
 	}
+	if updateData.ISBN != nil {
+		book.ISBN = *updateData.ISBN
+	}
 	// Save the updated book to the database
 	if err := database.GlobalDB.Save(&book).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Could not update book"))
 		return
 	}
 	// Return the updated book to the client
@@ -113,7 +119,7 @@ func (ctrl *BookController) GetBooksList(c *gin.Context) {
 	// Query the database for all book records
 	var books []models.Book
 	if err := database.GlobalDB.Find(&books).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Internal(err, "Could not list books"))
 		return
 	}
 	// Return the list of books to the client
@@ -127,7 +133,7 @@ func (ctrl *BookController) GetBookById(c *gin.Context) {
 	// Check if the book with the given ID exists
 	var book models.Book
 	if err := database.GlobalDB.Where("id = ?", bookID).First(&book).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+		apperror.Abort(c, apperror.NotFound("book not found"))
 		return
 	}
 	// Return the book to the client
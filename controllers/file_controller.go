@@ -8,10 +8,16 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/zerodot618/go-huang/apperror"
 	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/metrics"
 	"github.com/zerodot618/go-huang/models"
 )
 
+// UploadDir is the directory the uploaded files are stored in
+const UploadDir = "uploads"
+
 // FileController is a struct that represents a controller for file-related operations“
 type FileController struct{}
 
@@ -26,15 +32,15 @@ func (f *FileController) UploadFile(c *gin.Context) {
 	// Get the file from the form data
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Binding(err))
 
 		return
 	}
 	// Define the path where the file will be saved
-	filePath := filepath.Join("uploads", file.Filename)
+	filePath := filepath.Join(UploadDir, file.Filename)
 	// Save the file to the defined path
 	if err := c.SaveUploadedFile(file, filePath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to save file"))
 
 		return
 	}
@@ -46,10 +52,13 @@ func (f *FileController) UploadFile(c *gin.Context) {
 		UUID:     uuid,
 	}
 	if err := database.GlobalDB.Create(&fileMetadata).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file metadata"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to save file metadata"))
 
 		return
 	}
+	logging.FromGin(c).Info("file uploaded", "uuid", fileMetadata.UUID, "bytes", file.Size)
+	metrics.Uploads.Inc()
+	metrics.UploadBytes.Add(float64(file.Size))
 	// Return success message and file metadata
 	c.JSON(http.StatusOK, gin.H{
 		"message": "File uploaded successfully",
@@ -68,20 +77,22 @@ func (f *FileController) UploadFiles(c *gin.Context) {
 	// Get the files from the form data
 	form, err := c.MultipartForm()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apperror.Abort(c, apperror.Binding(err))
 
 		return
 	}
 	files := form.File["files"]
 	var fileModels []models.File
+	var totalBytes int64
 	// Save each file to the defined path and generate a unique identifier for each file
 	for _, file := range files {
-		filePath := filepath.Join("uploads", file.Filename)
+		filePath := filepath.Join(UploadDir, file.Filename)
 		if err := c.SaveUploadedFile(file, filePath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+			apperror.Abort(c, apperror.Internal(err, "Failed to save file"))
 
 			return
 		}
+		totalBytes += file.Size
 		fileModels = append(fileModels, models.File{
 			UUID:     uuid.New().String(),
 			Filename: file.Filename,
@@ -90,10 +101,13 @@ func (f *FileController) UploadFiles(c *gin.Context) {
 	// Save file metadata to database
 	err = database.GlobalDB.Create(&fileModels).Error
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file metadata"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to save file metadata"))
 
 		return
 	}
+	logging.FromGin(c).Info("files uploaded", "count", len(fileModels), "bytes", totalBytes)
+	metrics.Uploads.Add(float64(len(fileModels)))
+	metrics.UploadBytes.Add(float64(totalBytes))
 	// Return a success message and the file metadata
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Files uploaded successfully",
@@ -117,15 +131,15 @@ func (f *FileController) GetFile(c *gin.Context) {
 	// Retrieve the file metadata from the database
 	err := database.GlobalDB.Where("uuid = ?", uuid).First(&file).Error
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		apperror.Abort(c, apperror.NotFound("File not found"))
 		return
 	}
 	// Define the path of the file tobe retrieved
-	filePath := filepath.Join("uploads", file.Filename)
+	filePath := filepath.Join(UploadDir, file.Filename)
 	// Open the file
 	fileData, err := os.Open(filePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to open file"))
 		return
 	}
 	defer fileData.Close()
@@ -133,13 +147,14 @@ func (f *FileController) GetFile(c *gin.Context) {
 	fileHeader := make([]byte, 512)
 	_, err = fileData.Read(fileHeader)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to read file"))
+		return
 	}
 	fileContentType := http.DetectContentType(fileHeader)
 	// Get the file info
 	fileInfo, err := fileData.Stat()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file info"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to get file info"))
 		return
 	}
 	// Set the headers for the file transfer and return the file
@@ -168,21 +183,21 @@ func (f *FileController) DeleteFile(c *gin.Context) {
 	// Retrieve the file metadata from the database
 	err := database.GlobalDB.Where("uuid = ?", uuid).First(&file).Error
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		apperror.Abort(c, apperror.NotFound("File not found"))
 		return
 	}
 	// Define the path of the file to be deleted
-	filePath := filepath.Join("uploads", file.Filename)
+	filePath := filepath.Join(UploadDir, file.Filename)
 	// Delete the file from the server
 	err = os.Remove(filePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to delete file"))
 		return
 	}
 	// Delete the file metadata from the database
 	err = database.GlobalDB.Delete(&file).Error
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file from database"})
+		apperror.Abort(c, apperror.Internal(err, "Failed to delete file from database"))
 		return
 	}
 	// Return a success message
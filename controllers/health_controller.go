@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/lifecycle"
+)
+
+// readinessTimeout bounds the time spent by each readiness check
+const readinessTimeout = 2 * time.Second
+
+// HealthController is a struct that represents a controller for liveness and readiness probes
+type HealthController struct{}
+
+// Liveness reports that the process is running
+// It does not check any dependency, so a failing database does not get the process restarted
+func (ctrl *HealthController) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readiness reports whether the service can handle traffic
+// It pings the database and checks that the upload directory is writable,
+// and fails while the server is shutting down so load balancers stop sending requests
+func (ctrl *HealthController) Readiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := gin.H{}
+	ready := true
+	if lifecycle.ShuttingDown() {
+		checks["shutdown"] = "shutting down"
+		ready = false
+	}
+	if err := database.Ping(ctx); err != nil {
+		checks["database"] = err.Error()
+		ready = false
+	} else {
+		checks["database"] = "ok"
+	}
+	if err := checkWritable(UploadDir); err != nil {
+		checks["storage"] = err.Error()
+		ready = false
+	} else {
+		checks["storage"] = "ok"
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
+
+// checkWritable creates and removes a temporary file in dir
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/auth"
+)
+
+// jwksMaxAge is how long clients may cache the JWKS, shorter than the publish delay of new keys
+const jwksMaxAge = "public, max-age=300"
+
+// JWKSController is a struct that represents a controller for the public signing keys of the tokens
+type JWKSController struct{}
+
+// JWKS is a function that returns the public keys verifying our tokens as a JSON Web Key Set
+// Other services use it to verify the tokens, the key of a token is identified by its kid header
+
+// @Summary JSON Web Key Set
+// @ID JWKS
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} jwk.Set "Success"
+// @Router /.well-known/jwks.json [GET]
+func (ctrl JWKSController) JWKS(c *gin.Context) {
+	set, err := auth.Default.Keys.JWKS(c.Request.Context())
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get Signing Keys"))
+		return
+	}
+	c.Header("Cache-Control", jwksMaxAge)
+	c.JSON(http.StatusOK, set)
+}
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/zerodot618/go-huang/database"
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// memDB is an in-memory database for the statements GORM runs on single tables:
+// inserts, and selects, updates and deletes filtered by column = ?, column < ? and column IS NULL,
+// updates set columns to a value or increment them, selects read every column or a list of columns
+type memDB struct {
+	mu     sync.Mutex
+	nextID int64
+	tables map[string][]map[string]driver.Value
+}
+
+var (
+	insertPattern = regexp.MustCompile("^INSERT INTO `(\\w+)` \\((.+?)\\) VALUES \\((.+)\\)$")
+	selectPattern = regexp.MustCompile("^SELECT (\\*|[`\\w.,]+) FROM `(\\w+)` WHERE (.+?)(?: ORDER BY [^?]+)?(?: LIMIT (?:\\?|\\d+))?$")
+	updatePattern = regexp.MustCompile("^UPDATE `(\\w+)` SET (.+?) WHERE (.+)$")
+	deletePattern = regexp.MustCompile("^DELETE FROM `(\\w+)` WHERE (.+)$")
+	setPattern    = regexp.MustCompile("^`?(\\w+)`?=(?:`?(\\w+)`? \\+ )?\\?$")
+	condPattern   = regexp.MustCompile("^(?:`\\w+`\\.)?`?(\\w+)`? (=|<|IS NULL)(?: \\?)?$")
+)
+
+// openMemDB sets database.GlobalDB to a new in-memory database for the test
+func openMemDB(t *testing.T) *memDB {
+	t.Helper()
+	db := &memDB{tables: map[string][]map[string]driver.Value{}}
+	gdb, err := gorm.Open(gormmysql.New(gormmysql.Config{Conn: sql.OpenDB(db), SkipInitializeWithVersion: true}),
+		&gorm.Config{SkipDefaultTransaction: true, DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	previous := database.GlobalDB
+	database.GlobalDB = gdb
+	t.Cleanup(func() { database.GlobalDB = previous })
+	return db
+}
+
+// rows returns a copy of the rows of a table
+func (db *memDB) rows(table string) []map[string]driver.Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	rows := make([]map[string]driver.Value, len(db.tables[table]))
+	for i, row := range db.tables[table] {
+		rows[i] = map[string]driver.Value{}
+		for k, v := range row {
+			rows[i][k] = v
+		}
+	}
+	return rows
+}
+
+// set changes a column of every row of a table
+func (db *memDB) set(table, column string, value driver.Value) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, row := range db.tables[table] {
+		row[column] = value
+	}
+}
+
+func (db *memDB) Connect(context.Context) (driver.Conn, error) { return memConn{db}, nil }
+func (db *memDB) Driver() driver.Driver                        { return nil }
+
+type memConn struct{ db *memDB }
+
+func (c memConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c memConn) Close() error                        { return nil }
+func (c memConn) Begin() (driver.Tx, error)           { return memTx{}, nil }
+
+type memTx struct{}
+
+func (memTx) Commit() error   { return nil }
+func (memTx) Rollback() error { return nil }
+
+func (c memConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if m := insertPattern.FindStringSubmatch(query); m != nil {
+		row := map[string]driver.Value{}
+		for i, column := range strings.Split(m[2], ",") {
+			row[strings.Trim(column, "` ")] = args[i].Value
+		}
+		if m[1] == "external_identities" {
+			for _, other := range db.tables[m[1]] {
+				if other["provider"] == row["provider"] && other["subject"] == row["subject"] {
+					return nil, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry for key 'external_identities.idx_identity_subject'"}
+				}
+			}
+		}
+		db.nextID++
+		row["id"] = db.nextID
+		db.tables[m[1]] = append(db.tables[m[1]], row)
+		return memResult{id: db.nextID, affected: 1}, nil
+	}
+	if m := updatePattern.FindStringSubmatch(query); m != nil {
+		// The arguments of the SET clause come first, then the ones of the WHERE clause
+		columns := strings.Split(m[2], ",")
+		if len(args) < len(columns) {
+			return nil, fmt.Errorf("memdb: missing arguments of %q", m[2])
+		}
+		match, err := where(m[3], args[len(columns):])
+		if err != nil {
+			return nil, err
+		}
+		var affected int64
+		for _, row := range db.tables[m[1]] {
+			if !match(row) {
+				continue
+			}
+			for i, column := range columns {
+				set := setPattern.FindStringSubmatch(strings.TrimSpace(column))
+				if set == nil {
+					return nil, fmt.Errorf("memdb: unsupported assignment %q", column)
+				}
+				if set[2] == "" {
+					row[set[1]] = args[i].Value
+					continue
+				}
+				// column = column + ?, on integers
+				current, _ := row[set[2]].(int64)
+				row[set[1]] = current + args[i].Value.(int64)
+			}
+			affected++
+		}
+		return memResult{affected: affected}, nil
+	}
+	if m := deletePattern.FindStringSubmatch(query); m != nil {
+		match, err := where(m[2], args)
+		if err != nil {
+			return nil, err
+		}
+		var kept []map[string]driver.Value
+		for _, row := range db.tables[m[1]] {
+			if !match(row) {
+				kept = append(kept, row)
+			}
+		}
+		affected := int64(len(db.tables[m[1]]) - len(kept))
+		db.tables[m[1]] = kept
+		return memResult{affected: affected}, nil
+	}
+	return nil, fmt.Errorf("memdb: unsupported statement %q", query)
+}
+
+func (c memConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m := selectPattern.FindStringSubmatch(query)
+	if m == nil {
+		return nil, fmt.Errorf("memdb: unsupported query %q", query)
+	}
+	match, err := where(m[3], args)
+	if err != nil {
+		return nil, err
+	}
+	result := &memRows{}
+	if m[1] != "*" {
+		for _, column := range strings.Split(m[1], ",") {
+			column = column[strings.LastIndex(column, ".")+1:]
+			result.columns = append(result.columns, strings.Trim(column, "`"))
+		}
+	}
+	for _, row := range db.tables[m[2]] {
+		if !match(row) {
+			continue
+		}
+		if result.columns == nil {
+			for column := range row {
+				result.columns = append(result.columns, column)
+			}
+		}
+		values := make([]driver.Value, len(result.columns))
+		for i, column := range result.columns {
+			values[i] = row[column]
+		}
+		result.values = append(result.values, values)
+	}
+	return result, nil
+}
+
+// where returns the filter of the conditions of a WHERE clause joined by AND, the arguments are used in order
+func where(clause string, args []driver.NamedValue) (func(map[string]driver.Value) bool, error) {
+	var filters []func(map[string]driver.Value) bool
+	for _, cond := range strings.Split(strings.NewReplacer("(", "", ")", "").Replace(clause), " AND ") {
+		m := condPattern.FindStringSubmatch(strings.TrimSpace(cond))
+		if m == nil {
+			return nil, fmt.Errorf("memdb: unsupported condition %q", cond)
+		}
+		column, op := m[1], m[2]
+		if op == "IS NULL" {
+			filters = append(filters, func(row map[string]driver.Value) bool { return row[column] == nil })
+			continue
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("memdb: missing argument of %q", cond)
+		}
+		arg := args[0].Value
+		args = args[1:]
+		filters = append(filters, func(row map[string]driver.Value) bool {
+			if op == "<" {
+				if t, ok := arg.(time.Time); ok {
+					value, ok := row[column].(time.Time)
+					return ok && value.Before(t)
+				}
+				value, ok := row[column].(int64)
+				return ok && value < arg.(int64)
+			}
+			return fmt.Sprint(row[column]) == fmt.Sprint(arg)
+		})
+	}
+	return func(row map[string]driver.Value) bool {
+		for _, f := range filters {
+			if !f(row) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+type memResult struct{ id, affected int64 }
+
+func (r memResult) LastInsertId() (int64, error) { return r.id, nil }
+func (r memResult) RowsAffected() (int64, error) { return r.affected, nil }
+
+type memRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *memRows) Columns() []string { return r.columns }
+func (r *memRows) Close() error      { return nil }
+func (r *memRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/metrics"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/oidc"
+	"github.com/zerodot618/go-huang/utils"
+	"gorm.io/gorm"
+)
+
+// oidcStateCookie binds an authorization request to the browser that started it, against login CSRF
+const oidcStateCookie = "oidc_state"
+
+// oidcTimeout bounds the time spent talking to a provider during a request
+const oidcTimeout = 15 * time.Second
+
+// OIDCController is a struct that represents a controller for the logins with OpenID Connect providers
+type OIDCController struct{}
+
+// Providers is a function that lists the names of the configured OpenID Connect providers
+
+// @Summary List OIDC Providers
+// @ID ListOIDCProviders
+// @Tags OIDC
+// @Produce json
+// @Success 200 {object} string "Success"
+// @Router /public/oidc/providers [GET]
+func (ctrl OIDCController) Providers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": oidc.Default.Names()})
+}
+
+// Login is a function that starts a login with an OpenID Connect provider
+// It stores the state, the nonce and the PKCE verifier and redirects the user to the provider
+
+// @Summary Login With OIDC
+// @ID LoginOIDC
+// @Tags OIDC
+// @Param provider path string true "Provider name"
+// @Success 302 {string} string "Redirect to the provider"
+// @Failure 404 {string} string "Error"
+// @Router /public/oidc/{provider}/login [GET]
+func (ctrl OIDCController) Login(c *gin.Context) {
+	authURL, ok := startOIDCAuth(c, nil)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Link is a function that starts linking an OpenID Connect identity to the current user
+// It returns the URL of the provider to open in the browser, the callback then links the identity
+
+// @Summary Link OIDC Identity
+// @ID LinkOIDC
+// @Tags OIDC
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param provider path string true "Provider name"
+// @Produce json
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /protected/oidc/{provider}/link [POST]
+func (ctrl OIDCController) Link(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	authURL, ok := startOIDCAuth(c, &userID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// Callback is a function that handles the redirect of a provider after the user logged in
+// It exchanges the code with the PKCE verifier and validates the ID token.
+// The identity is then linked to the user who started a link, or used to log in:
+// a known identity logs its user in, otherwise the user is found by email if the provider verified it,
+// or created. The login gets the same tokens as the password login.
+
+// @Summary OIDC Callback
+// @ID OIDCCallback
+// @Tags OIDC
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Produce json
+// @Success 200 {object} LoginResponse "Success"
+// @Failure 400 {string} string "Error"
+// @Router /public/oidc/{provider}/callback [GET]
+func (ctrl OIDCController) Callback(c *gin.Context) {
+	provider, ok := oidc.Default.Get(c.Param("provider"))
+	if !ok {
+		apperror.Abort(c, apperror.NotFound("Unknown Provider"))
+		return
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		apperror.Abort(c, apperror.BadRequest("Provider Error: "+errCode+" "+c.Query("error_description")))
+		return
+	}
+	state, code := c.Query("state"), c.Query("code")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	clearOIDCStateCookie(c)
+	if state == "" || code == "" || cookie != state {
+		apperror.Abort(c, apperror.BadRequest("Invalid Or Expired State"))
+		return
+	}
+	request, err := models.ConsumeOIDCAuthRequest(state, provider.Name)
+	if err == models.ErrInvalidToken {
+		apperror.Abort(c, apperror.BadRequest("Invalid Or Expired State"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Complete Login"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), oidcTimeout)
+	defer cancel()
+	tokens, err := provider.Exchange(ctx, code, request.CodeVerifier)
+	if err != nil {
+		logging.FromGin(c).Warn("oidc code exchange failed", "provider", provider.Name, "error", err.Error())
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Could Not Exchange Code"))
+		return
+	}
+	claims, err := provider.VerifyIDToken(ctx, tokens.IDToken, request.Nonce)
+	if err != nil {
+		logging.FromGin(c).Warn("oidc id token rejected", "provider", provider.Name, "error", err.Error())
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Invalid ID Token"))
+		return
+	}
+	if request.LinkUserID != nil {
+		linkIdentity(c, *request.LinkUserID, provider.Name, claims)
+		return
+	}
+	user, ok := oidcLoginUser(c, provider.Name, claims)
+	if !ok {
+		metrics.Logins.WithLabelValues("failure").Inc()
+		return
+	}
+	logging.FromGin(c).Info("oidc login", "provider", provider.Name, "user_id", user.ID)
+	completeLogin(c, user)
+}
+
+// Identities is a function that lists the identities linked to the current user
+
+// @Summary List Linked Identities
+// @ID ListIdentities
+// @Tags OIDC
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Produce json
+// @Success 200 {object} string "Success"
+// @Router /protected/identities [GET]
+func (ctrl OIDCController) Identities(c *gin.Context) {
+	identities, err := models.ListExternalIdentities(c.GetUint("user_id"))
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get Identities"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// Unlink is a function that removes an identity linked to the current user
+
+// @Summary Unlink Identity
+// @ID UnlinkIdentity
+// @Tags OIDC
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Identity ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /protected/identities/{id} [DELETE]
+func (ctrl OIDCController) Unlink(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid Identity ID"))
+		return
+	}
+	err = models.UnlinkExternalIdentity(c.GetUint("user_id"), uint(id))
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("Identity Not Found"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Unlink Identity"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "Identity Unlinked"})
+}
+
+// startOIDCAuth stores a new authorization request and returns the URL of the provider
+// It aborts the request and returns false on failure
+func startOIDCAuth(c *gin.Context, linkUserID *uint) (string, bool) {
+	provider, ok := oidc.Default.Get(c.Param("provider"))
+	if !ok {
+		apperror.Abort(c, apperror.NotFound("Unknown Provider"))
+		return "", false
+	}
+	state, errState := oidc.RandomString()
+	nonce, errNonce := oidc.RandomString()
+	verifier, errVerifier := oidc.RandomString()
+	if err := errors.Join(errState, errNonce, errVerifier); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Start Login"))
+		return "", false
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), oidcTimeout)
+	defer cancel()
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		logging.FromGin(c).Error("oidc discovery failed", "provider", provider.Name, "error", err.Error())
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnavailable, "Provider Unavailable"))
+		return "", false
+	}
+	request := models.OIDCAuthRequest{
+		Provider:     provider.Name,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		LinkUserID:   linkUserID,
+	}
+	if err := models.CreateOIDCAuthRequest(state, &request); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Start Login"))
+		return "", false
+	}
+	// Lax, so the cookie is sent with the top-level redirect back from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(models.OIDCAuthRequestTTL.Seconds()), "/api/public/oidc", "", secureCookies(c), true)
+	return authURL, true
+}
+
+// clearOIDCStateCookie deletes the state cookie once the callback is received
+func clearOIDCStateCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/api/public/oidc", "", secureCookies(c), true)
+}
+
+// secureCookies reports whether cookies must only be sent over HTTPS
+func secureCookies(c *gin.Context) bool {
+	return c.Request.TLS != nil || strings.HasPrefix(os.Getenv("APP_URL"), "https://")
+}
+
+// linkIdentity links a verified identity to the user who started the link
+func linkIdentity(c *gin.Context, userID uint, provider string, claims *oidc.IDTokenClaims) {
+	identity, err := models.LinkExternalIdentity(userID, provider, claims.Subject, claims.Email)
+	if err != nil {
+		if utils.ClassifyError(err).Kind == utils.KindDuplicateKey {
+			apperror.Abort(c, apperror.Conflict("Identity Already Linked"))
+			return
+		}
+		apperror.Abort(c, apperror.Internal(err, "Could Not Link Identity"))
+		return
+	}
+	logging.FromGin(c).Info("identity linked", "provider", provider, "user_id", userID)
+	c.JSON(http.StatusOK, gin.H{"Message": "Identity Linked", "identity": identity})
+}
+
+// oidcLoginUser returns the user of an identity, linking it by email or creating the user the first time
+// Emails are only trusted when the provider verified them, and only matched to accounts with a verified
+// email, so an identity cannot take over an account someone registered without owning the address
+func oidcLoginUser(c *gin.Context, provider string, claims *oidc.IDTokenClaims) (*models.User, bool) {
+	var user models.User
+	identity, err := models.FindExternalIdentity(provider, claims.Subject)
+	if err == nil {
+		err = database.GlobalDB.First(&user, identity.UserID).Error
+		if err == nil {
+			return &user, true
+		}
+		if err != gorm.ErrRecordNotFound {
+			apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+			return nil, false
+		}
+		// The user of the identity was deleted, the identity is unlinked and the login is a new one
+		if err := models.UnlinkExternalIdentity(identity.UserID, identity.ID); err != nil && err != gorm.ErrRecordNotFound {
+			apperror.Abort(c, apperror.Internal(err, "Could Not Unlink Identity"))
+			return nil, false
+		}
+		logging.FromGin(c).Info("identity of a deleted user unlinked", "provider", provider, "user_id", identity.UserID)
+	}
+	if err != gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get Identity"))
+		return nil, false
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		apperror.Abort(c, apperror.Forbidden("Email Not Verified By Provider, Log In And Link The Identity"))
+		return nil, false
+	}
+	err = database.GlobalDB.Where("email = ?", claims.Email).First(&user).Error
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		if !createOIDCUser(c, &user, claims) {
+			return nil, false
+		}
+	case err != nil:
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+		return nil, false
+	case !user.IsEmailVerified():
+		apperror.Abort(c, apperror.Conflict("Account Exists, Log In And Link The Identity"))
+		return nil, false
+	}
+	if _, err := models.LinkExternalIdentity(user.ID, provider, claims.Subject, claims.Email); err != nil {
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Could Not Link Identity"))
+		return nil, false
+	}
+	logging.FromGin(c).Info("identity linked", "provider", provider, "user_id", user.ID)
+	return &user, true
+}
+
+// createOIDCUser creates a verified user for a new identity, with a random password
+// The user can set a password later with the password reset
+func createOIDCUser(c *gin.Context, user *models.User, claims *oidc.IDTokenClaims) bool {
+	password, err := oidc.RandomString()
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Create User"))
+		return false
+	}
+	now := time.Now()
+	*user = models.User{Name: claims.Name, Email: claims.Email, Role: models.RoleUser, EmailVerifiedAt: &now}
+	if user.Name == "" {
+		user.Name = claims.Email
+	}
+	if err := user.HashPassword(password); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Error Hashing Password"))
+		return false
+	}
+	if err := user.CreateUserRecord(); err != nil {
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Error Creating User"))
+		return false
+	}
+	logging.FromGin(c).Info("user registered", "user_id", user.ID, "via", "oidc")
+	return true
+}
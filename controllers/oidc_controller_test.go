@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/zerodot618/go-huang/middlewares"
+	"github.com/zerodot618/go-huang/oidc"
+	"github.com/zerodot618/go-huang/oidc/mockprovider"
+)
+
+// The OIDC flow is run against the mock provider on an httptest server, with the controllers on a gin router
+// and the database in memory: memDB understands the few statements of the authorization requests and identities.
+
+const (
+	testProvider    = "mock"
+	testClientID    = "go-huang"
+	testSecret      = "s3cret"
+	testRedirectURL = "http://app.test/api/public/oidc/mock/callback"
+	testUserID      = 7
+	// authRequestsTable is the table of models.OIDCAuthRequest
+	authRequestsTable = "oidc_auth_requests"
+)
+
+// oidcTest is the mock provider and the router of the controllers for a test
+type oidcTest struct {
+	db       *memDB
+	router   *gin.Engine
+	provider *httptest.Server
+	// resign makes the token endpoint sign the ID tokens with a key missing from the JWKS
+	resign bool
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ot := &oidcTest{db: openMemDB(t)}
+	rogue, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	var mock *mockprovider.Provider
+	ot.provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" || !ot.resign {
+			mock.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		mock.ServeHTTP(rec, r)
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["id_token"] == nil {
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+			return
+		}
+		claims := jwt.MapClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(body["id_token"].(string), claims); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "rogue-1"
+		body["id_token"], _ = token.SignedString(rogue)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(ot.provider.Close)
+	mock, err = mockprovider.New(ot.provider.URL, testClientID, testSecret, mockprovider.Identity{
+		Subject: "mock-sub-1", Email: "ada@example.com", Name: "Ada", EmailVerified: true,
+	})
+	if err != nil {
+		t.Fatalf("mock provider: %v", err)
+	}
+
+	previous := oidc.Default
+	oidc.Default = oidc.NewRegistry(oidc.NewProvider(oidc.Config{
+		Name:         testProvider,
+		Issuer:       ot.provider.URL,
+		ClientID:     testClientID,
+		ClientSecret: testSecret,
+		RedirectURL:  testRedirectURL,
+	}))
+	t.Cleanup(func() { oidc.Default = previous })
+
+	var ctrl OIDCController
+	ot.router = gin.New()
+	ot.router.Use(middlewares.ErrorHandler())
+	ot.router.POST("/api/protected/oidc/:provider/link", func(c *gin.Context) { c.Set("user_id", uint(testUserID)) }, ctrl.Link)
+	ot.router.GET("/api/public/oidc/:provider/callback", ctrl.Callback)
+	return ot
+}
+
+// startLink starts linking an identity and returns the authorization URL and the state cookie
+func (ot *oidcTest) startLink(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	ot.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/protected/oidc/mock/link", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link: status %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly {
+		t.Fatalf("link: state cookie = %+v", cookie)
+	}
+	return body.AuthorizationURL, cookie
+}
+
+// authorize follows the authorization URL at the provider and returns the callback URL it redirects to
+func (ot *oidcTest) authorize(t *testing.T, authURL string) *url.URL {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	return callback
+}
+
+// callback sends the redirect of the provider to the callback route
+func (ot *oidcTest) callback(callback *url.URL, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	ot.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorMessage returns the message of an error response
+func errorMessage(rec *httptest.ResponseRecorder) string {
+	var body middlewares.ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Error.Message
+}
+
+func TestOIDCLinkFlow(t *testing.T) {
+	ot := newOIDCTest(t)
+	authURL, cookie := ot.startLink(t)
+
+	// The authorization URL comes from the discovery document, with PKCE
+	parsed, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, ot.provider.URL+"/authorize?") {
+		t.Fatalf("authorization URL = %q", authURL)
+	}
+	q := parsed.Query()
+	requests := ot.db.rows(authRequestsTable)
+	if len(requests) != 1 {
+		t.Fatalf("stored %d authorization requests, want 1", len(requests))
+	}
+	stored := requests[0]
+	checks := map[string]string{
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"response_type":         "code",
+		"state":                 cookie.Value,
+		"nonce":                 fmt.Sprint(stored["nonce"]),
+		"code_challenge":        oidc.CodeChallenge(fmt.Sprint(stored["code_verifier"])),
+		"code_challenge_method": "S256",
+	}
+	for name, want := range checks {
+		if q.Get(name) != want {
+			t.Errorf("authorization URL %s = %q, want %q", name, q.Get(name), want)
+		}
+	}
+	if fmt.Sprint(stored["link_user_id"]) != fmt.Sprint(testUserID) {
+		t.Errorf("authorization request link_user_id = %v, want %d", stored["link_user_id"], testUserID)
+	}
+
+	callback := ot.authorize(t, authURL)
+	if callback.Query().Get("state") != cookie.Value || callback.Query().Get("code") == "" {
+		t.Fatalf("callback URL = %s", callback)
+	}
+	rec := ot.callback(callback, cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rec.Code, rec.Body)
+	}
+	identities := ot.db.rows("external_identities")
+	if len(identities) != 1 {
+		t.Fatalf("linked %d identities, want 1", len(identities))
+	}
+	identity := identities[0]
+	if fmt.Sprint(identity["user_id"]) != fmt.Sprint(testUserID) || identity["provider"] != testProvider ||
+		identity["subject"] != "mock-sub-1" || identity["email"] != "ada@example.com" {
+		t.Errorf("linked identity = %v", identity)
+	}
+	if n := len(ot.db.rows(authRequestsTable)); n != 0 {
+		t.Errorf("%d authorization requests left, the request is not consumed", n)
+	}
+
+	// The callback cannot be replayed, its authorization request is consumed
+	if rec := ot.callback(callback, cookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback: status %d, want 400", rec.Code)
+	}
+
+	// The same subject cannot be linked twice
+	authURL, cookie = ot.startLink(t)
+	if rec := ot.callback(ot.authorize(t, authURL), cookie); rec.Code != http.StatusConflict {
+		t.Errorf("second link: status %d, want 409: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare changes the flow before the callback, it returns the cookie sent with it
+		prepare     func(ot *oidcTest, cookie *http.Cookie) *http.Cookie
+		wantStatus  int
+		wantMessage string
+	}{
+		{
+			name:        "missing state cookie",
+			prepare:     func(ot *oidcTest, cookie *http.Cookie) *http.Cookie { return nil },
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Invalid Or Expired State",
+		},
+		{
+			name: "state not matching the cookie",
+			prepare: func(ot *oidcTest, cookie *http.Cookie) *http.Cookie {
+				return &http.Cookie{Name: oidcStateCookie, Value: "forged-state"}
+			},
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Invalid Or Expired State",
+		},
+		{
+			name: "expired state",
+			prepare: func(ot *oidcTest, cookie *http.Cookie) *http.Cookie {
+				ot.db.set(authRequestsTable, "expires_at", time.Now().Add(-time.Minute))
+				return cookie
+			},
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Invalid Or Expired State",
+		},
+		{
+			name: "wrong PKCE verifier",
+			prepare: func(ot *oidcTest, cookie *http.Cookie) *http.Cookie {
+				ot.db.set(authRequestsTable, "code_verifier", "not-the-verifier")
+				return cookie
+			},
+			wantStatus:  http.StatusUnauthorized,
+			wantMessage: "Could Not Exchange Code",
+		},
+		{
+			name: "nonce mismatch",
+			prepare: func(ot *oidcTest, cookie *http.Cookie) *http.Cookie {
+				ot.db.set(authRequestsTable, "nonce", "another-nonce")
+				return cookie
+			},
+			wantStatus:  http.StatusUnauthorized,
+			wantMessage: "Invalid ID Token",
+		},
+		{
+			name: "ID token signed with an unknown kid",
+			prepare: func(ot *oidcTest, cookie *http.Cookie) *http.Cookie {
+				ot.resign = true
+				return cookie
+			},
+			wantStatus:  http.StatusUnauthorized,
+			wantMessage: "Invalid ID Token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ot := newOIDCTest(t)
+			authURL, cookie := ot.startLink(t)
+			callback := ot.authorize(t, authURL)
+			rec := ot.callback(callback, tt.prepare(ot, cookie))
+			if rec.Code != tt.wantStatus || errorMessage(rec) != tt.wantMessage {
+				t.Errorf("callback: status %d %q, want %d %q", rec.Code, errorMessage(rec), tt.wantStatus, tt.wantMessage)
+			}
+			if n := len(ot.db.rows("external_identities")); n != 0 {
+				t.Errorf("linked %d identities, want none", n)
+			}
+		})
+	}
+}
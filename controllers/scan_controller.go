@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/lifecycle"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/scans"
+	"gorm.io/gorm"
+)
+
+// Settings of the event streams
+const (
+	// sseWriteTimeout bounds every write of an event stream, which outlives the write timeout of the server
+	sseWriteTimeout = 30 * time.Second
+	// ssePingInterval is the interval between the comments keeping an idle stream open through the proxies
+	ssePingInterval = 15 * time.Second
+	// scanPollInterval is the interval between the reads of a job running on another instance
+	scanPollInterval = 2 * time.Second
+)
+
+// ScanController is a struct that represents a controller for the port scans run by the admins
+type ScanController struct{}
+
+// ScanPayload is a struct that contains the targets and the ports of a scan
+// The targets are host names, IP addresses and CIDR ranges, they must be in SCAN_ALLOWED_TARGETS
+type ScanPayload struct {
+	Targets   []string `json:"targets" binding:"required,min=1,max=64,dive,required,max=255"`
+	Ports     string   `json:"ports" binding:"required,max=1024"`
+	Protocols []string `json:"protocols" binding:"omitempty,max=2,dive,oneof=tcp udp"`
+	Detect    bool     `json:"detect"`
+}
+
+// ScanJobResponse is a struct that contains a scan job and the open ports found so far
+type ScanJobResponse struct {
+	models.ScanJob
+	Results []models.ScanJobResult `json:"results"`
+}
+
+// StartScan is a function that starts a port scan in the background
+// It returns the queued job, its results are read with GetScan or streamed by ScanEvents.
+// Every target must be allowed by SCAN_ALLOWED_TARGETS, the scans are disabled if it is empty
+
+// @Summary Start Scan
+// @ID StartScan
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body ScanPayload true "Targets and ports"
+// @Accept json
+// @Produce json
+// @Success 202 {object} models.ScanJob "Success"
+// @Failure 400 {string} string "Error"
+// @Failure 403 {string} string "Error"
+// @Router /scans [POST]
+func (ctrl ScanController) StartScan(c *gin.Context) {
+	var payload ScanPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	plan, err := scans.Default.Prepare(scans.Request{
+		Targets:   payload.Targets,
+		Ports:     payload.Ports,
+		Protocols: payload.Protocols,
+		Detect:    payload.Detect,
+	})
+	if err != nil {
+		if !abortScanPrepare(c, err) {
+			apperror.Abort(c, apperror.Internal(err, "Could Not Validate Scan"))
+		}
+		return
+	}
+	job, err := scans.Default.Start(c.GetUint("user_id"), plan)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Start Scan"))
+		return
+	}
+	auditScan(c, models.AuditScanStarted, job)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ListScans is a function that returns a page of the scan jobs, newest first, filtered by status and profile
+
+// @Summary List Scans
+// @ID ListScans
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param status query string false "queued, running, done, failed or cancelled"
+// @Param profile_id query int false "Profile ID"
+// @Param page query int false "Page, from 1"
+// @Param page_size query int false "Page size, up to 100"
+// @Produce json
+// @Success 200 {object} models.Page[models.ScanJob] "Success"
+// @Router /scans [GET]
+func (ctrl ScanController) ListScans(c *gin.Context) {
+	var filter models.ScanJobFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	page, err := models.ListScanJobs(filter, pagination)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List Scans"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// GetScan is a function that returns a scan job with the open ports found so far
+// If the job is not found, it returns a 404 status code
+
+// @Summary Get Scan
+// @ID GetScan
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Scan ID"
+// @Produce json
+// @Success 200 {object} ScanJobResponse "Success"
+// @Failure 404 {string} string "Error"
+// @Router /scans/{id} [GET]
+func (ctrl ScanController) GetScan(c *gin.Context) {
+	job, ok := scanJob(c)
+	if !ok {
+		return
+	}
+	results, err := models.ListScanJobResults(job.ID)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get Scan Results"))
+		return
+	}
+	c.JSON(http.StatusOK, ScanJobResponse{ScanJob: *job, Results: results})
+}
+
+// ScanChanges is a function that returns the changes found by a scan job of a profile since the previous done job
+// of the profile: the ports opened and closed, and the service, version and banner changes of the ports still open
+
+// @Summary Get Scan Changes
+// @ID ScanChanges
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Scan ID"
+// @Produce json
+// @Success 200 {object} []models.ScanChange "Success"
+// @Failure 404 {string} string "Error"
+// @Router /scans/{id}/changes [GET]
+func (ctrl ScanController) ScanChanges(c *gin.Context) {
+	job, ok := scanJob(c)
+	if !ok {
+		return
+	}
+	changes, err := models.ListScanChanges(job.ID)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get Scan Changes"))
+		return
+	}
+	c.JSON(http.StatusOK, changes)
+}
+
+// CancelScan is a function that cancels a queued or running scan job, the open ports found so far are kept
+// It returns a 409 status code if the job is over
+
+// @Summary Cancel Scan
+// @ID CancelScan
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Scan ID"
+// @Success 202 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Failure 409 {string} string "Error"
+// @Router /scans/{id}/cancel [POST]
+func (ctrl ScanController) CancelScan(c *gin.Context) {
+	job, ok := scanJob(c)
+	if !ok {
+		return
+	}
+	if job.Finished() {
+		apperror.Abort(c, apperror.Conflict("Scan Is Over"))
+		return
+	}
+	if !scans.Default.Cancel(job.ID) {
+		apperror.Abort(c, apperror.Conflict("Scan Runs On Another Instance"))
+		return
+	}
+	auditScan(c, models.AuditScanCancelled, job)
+	c.JSON(http.StatusAccepted, gin.H{"Message": "Scan Cancelled"})
+}
+
+// ScanEvents is a function that streams the events of a scan job as server-sent events
+// The stream starts with a result event by open port found so far, then sends a result event by open port found,
+// a progress event every second and a done event with the job once it is over, and ends.
+// The events of a job running on another instance are read from the database every few seconds, without progress
+
+// @Summary Stream Scan Events
+// @ID ScanEvents
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Scan ID"
+// @Produce text/event-stream
+// @Success 200 {string} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /scans/{id}/events [GET]
+func (ctrl ScanController) ScanEvents(c *gin.Context) {
+	job, ok := scanJob(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	rc := http.NewResponseController(c.Writer)
+	send := func(event string, data interface{}) bool {
+		// The deadline is extended by write, the server one would end the stream
+		if err := rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout)); err != nil {
+			return false
+		}
+		if event == "" {
+			_, err := c.Writer.WriteString(": ping\n\n")
+			return err == nil && rc.Flush() == nil
+		}
+		c.SSEvent(event, data)
+		return rc.Flush() == nil
+	}
+
+	// The headers are sent at once, the first event of a job running elsewhere can take a while
+	if !send("", nil) {
+		return
+	}
+	ping := time.NewTicker(ssePingInterval)
+	defer ping.Stop()
+	results, progress, events, unsubscribe, live := scans.Default.Subscribe(job.ID)
+	if live {
+		defer unsubscribe()
+		for _, result := range results {
+			if !send(scans.EventResult, result) {
+				return
+			}
+		}
+		if !send(scans.EventProgress, progress) {
+			return
+		}
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					// The job is over, or the stream did not keep up and the client reconnects
+					return
+				}
+				if !send(event.Type, event.Data) {
+					return
+				}
+			case <-ping.C:
+				if !send("", nil) {
+					return
+				}
+			case <-c.Request.Context().Done():
+				return
+			case <-lifecycle.Done():
+				return
+			}
+		}
+	}
+
+	// The job is over, or runs on another instance
+	poll := time.NewTicker(scanPollInterval)
+	defer poll.Stop()
+	var lastID uint
+	for {
+		// The job is read before its results, none is missed once it is over
+		current, err := models.GetScanJob(job.ID)
+		if err != nil {
+			logging.FromGin(c).Error("could not get scan job", "job_id", job.ID, "error", err.Error())
+			return
+		}
+		results, err := models.ListScanJobResultsAfter(job.ID, lastID)
+		if err != nil {
+			logging.FromGin(c).Error("could not get scan results", "job_id", job.ID, "error", err.Error())
+			return
+		}
+		for _, result := range results {
+			if !send(scans.EventResult, result) {
+				return
+			}
+			lastID = result.ID
+		}
+		if current.Finished() {
+			send(scans.EventDone, current)
+			return
+		}
+		select {
+		case <-poll.C:
+		case <-ping.C:
+			if !send("", nil) {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		case <-lifecycle.Done():
+			return
+		}
+	}
+}
+
+// scanJob loads the scan job of the id path parameter
+// It aborts the request and returns false if the job cannot be loaded
+func scanJob(c *gin.Context) (*models.ScanJob, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid Scan ID"))
+		return nil, false
+	}
+	job, err := models.GetScanJob(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apperror.Abort(c, apperror.NotFound("Scan Not Found"))
+		return nil, false
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get Scan"))
+		return nil, false
+	}
+	return job, true
+}
+
+// auditScan records an action on a scan job in the audit log, with its targets and ports as the reason
+func auditScan(c *gin.Context, action string, job *models.ScanJob) {
+	reason := fmt.Sprintf("scan %d of %s, ports %s/%s", job.ID, job.Targets, job.Ports, strings.ReplaceAll(job.Protocols, ",", "+"))
+	if len(reason) > 255 {
+		reason = reason[:252] + "..."
+	}
+	recordAudit(c, &models.AuditLog{Action: action, Reason: reason})
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/scans"
+	"github.com/zerodot618/go-huang/utils"
+	"gorm.io/gorm"
+)
+
+// ScanProfileController is a struct that represents a controller for the named scans run on a schedule
+type ScanProfileController struct{}
+
+// ScanProfilePayload is a struct that contains the settings of a scan profile
+// The schedule is a cron schedule in UTC like 0 */6 * * *, the profile only runs on demand without one
+type ScanProfilePayload struct {
+	Name      string   `json:"name" binding:"required,max=64"`
+	Targets   []string `json:"targets" binding:"required,min=1,max=64,dive,required,max=255"`
+	Ports     string   `json:"ports" binding:"required,max=1024"`
+	Protocols []string `json:"protocols" binding:"omitempty,max=2,dive,oneof=tcp udp"`
+	Detect    bool     `json:"detect"`
+	TimeoutMS int      `json:"timeout_ms" binding:"omitempty,min=1,max=30000"`
+	Schedule  string   `json:"schedule" binding:"max=64"`
+	Enabled   *bool    `json:"enabled"`
+}
+
+// ListScanProfiles is a function that returns a page of the scan profiles, sorted by name
+
+// @Summary List Scan Profiles
+// @ID ListScanProfiles
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param page query int false "Page, from 1"
+// @Param page_size query int false "Page size, up to 100"
+// @Produce json
+// @Success 200 {object} models.Page[models.ScanProfile] "Success"
+// @Router /scan-profiles [GET]
+func (ctrl ScanProfileController) ListScanProfiles(c *gin.Context) {
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	page, err := models.ListScanProfiles(pagination)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List Scan Profiles"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// CreateScanProfile is a function that creates a scan profile
+// Its targets must be allowed by SCAN_ALLOWED_TARGETS, as for StartScan
+
+// @Summary Create Scan Profile
+// @ID CreateScanProfile
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body ScanProfilePayload true "Profile"
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.ScanProfile "Success"
+// @Failure 400 {string} string "Error"
+// @Failure 409 {string} string "Error"
+// @Router /scan-profiles [POST]
+func (ctrl ScanProfileController) CreateScanProfile(c *gin.Context) {
+	profile := models.ScanProfile{Source: models.ScanProfileSourceAPI, CreatedByID: c.GetUint("user_id")}
+	if !bindScanProfile(c, &profile) {
+		return
+	}
+	if err := models.CreateScanProfile(&profile); err != nil {
+		if utils.ClassifyError(err).Kind == utils.KindDuplicateKey {
+			apperror.Abort(c, apperror.Conflict("Scan Profile Name Taken"))
+			return
+		}
+		apperror.Abort(c, apperror.Internal(err, "Could Not Create Scan Profile"))
+		return
+	}
+	auditScanProfile(c, models.AuditScanProfileCreated, &profile)
+	c.JSON(http.StatusCreated, profile)
+}
+
+// GetScanProfile is a function that returns a scan profile
+// If the profile is not found, it returns a 404 status code
+
+// @Summary Get Scan Profile
+// @ID GetScanProfile
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Profile ID"
+// @Produce json
+// @Success 200 {object} models.ScanProfile "Success"
+// @Failure 404 {string} string "Error"
+// @Router /scan-profiles/{id} [GET]
+func (ctrl ScanProfileController) GetScanProfile(c *gin.Context) {
+	profile, ok := scanProfile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateScanProfile is a function that replaces the settings of a scan profile, its history is kept
+// The profiles of SCAN_PROFILES_FILE are changed in the file, it returns a 409 status code for them
+
+// @Summary Update Scan Profile
+// @ID UpdateScanProfile
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Profile ID"
+// @Param EnterDetails body ScanProfilePayload true "Profile"
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.ScanProfile "Success"
+// @Failure 404 {string} string "Error"
+// @Failure 409 {string} string "Error"
+// @Router /scan-profiles/{id} [PUT]
+func (ctrl ScanProfileController) UpdateScanProfile(c *gin.Context) {
+	profile, ok := editableScanProfile(c)
+	if !ok {
+		return
+	}
+	if !bindScanProfile(c, profile) {
+		return
+	}
+	if err := models.UpdateScanProfile(profile); err != nil {
+		if utils.ClassifyError(err).Kind == utils.KindDuplicateKey {
+			apperror.Abort(c, apperror.Conflict("Scan Profile Name Taken"))
+			return
+		}
+		apperror.Abort(c, apperror.Internal(err, "Could Not Update Scan Profile"))
+		return
+	}
+	auditScanProfile(c, models.AuditScanProfileUpdated, profile)
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteScanProfile is a function that deletes a scan profile, its jobs and their changes are kept
+// The profiles of SCAN_PROFILES_FILE are removed from the file, it returns a 409 status code for them
+
+// @Summary Delete Scan Profile
+// @ID DeleteScanProfile
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Profile ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Failure 409 {string} string "Error"
+// @Router /scan-profiles/{id} [DELETE]
+func (ctrl ScanProfileController) DeleteScanProfile(c *gin.Context) {
+	profile, ok := editableScanProfile(c)
+	if !ok {
+		return
+	}
+	if err := models.DeleteScanProfile(profile.ID); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Delete Scan Profile"))
+		return
+	}
+	auditScanProfile(c, models.AuditScanProfileDeleted, profile)
+	c.JSON(http.StatusOK, gin.H{"Message": "Scan Profile Deleted"})
+}
+
+// RunScanProfile is a function that starts a scan of a profile now, its schedule is unchanged
+// It returns a 409 status code if the previous scan of the profile is not over
+
+// @Summary Run Scan Profile
+// @ID RunScanProfile
+// @Tags Scans
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Profile ID"
+// @Produce json
+// @Success 202 {object} models.ScanJob "Success"
+// @Failure 403 {string} string "Error"
+// @Failure 404 {string} string "Error"
+// @Failure 409 {string} string "Error"
+// @Router /scan-profiles/{id}/run [POST]
+func (ctrl ScanProfileController) RunScanProfile(c *gin.Context) {
+	profile, ok := scanProfile(c)
+	if !ok {
+		return
+	}
+	job, err := scans.Default.RunProfile(c.GetUint("user_id"), profile)
+	if errors.Is(err, scans.ErrProfileBusy) {
+		apperror.Abort(c, apperror.Conflict("Previous Scan Not Over"))
+		return
+	}
+	if err != nil {
+		if !abortScanPrepare(c, err) {
+			apperror.Abort(c, apperror.Internal(err, "Could Not Start Scan"))
+		}
+		return
+	}
+	auditScan(c, models.AuditScanStarted, job)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// bindScanProfile binds the payload of a profile and validates its targets and schedule
+// It aborts the request and returns false if the profile is invalid
+func bindScanProfile(c *gin.Context, profile *models.ScanProfile) bool {
+	var payload ScanProfilePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return false
+	}
+	profile.Name = payload.Name
+	profile.Targets = strings.Join(payload.Targets, ",")
+	profile.Ports = payload.Ports
+	profile.Protocols = strings.Join(payload.Protocols, ",")
+	profile.Detect = payload.Detect
+	profile.TimeoutMS = payload.TimeoutMS
+	profile.Schedule = strings.TrimSpace(payload.Schedule)
+	profile.Enabled = payload.Enabled == nil || *payload.Enabled
+	if _, err := scans.Default.Prepare(scans.ProfileRequest(profile)); err != nil {
+		if !abortScanPrepare(c, err) {
+			apperror.Abort(c, apperror.Internal(err, "Could Not Validate Scan Profile"))
+		}
+		return false
+	}
+	next, err := scans.NextRun(profile.Schedule, time.Now())
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid Schedule").WithField("schedule", "invalid", err.Error()))
+		return false
+	}
+	profile.NextRunAt = nil
+	if profile.Enabled {
+		profile.NextRunAt = next
+	}
+	return true
+}
+
+// abortScanPrepare aborts the request with the error of scans.Manager.Prepare
+// It returns false if the error is not one of Prepare
+func abortScanPrepare(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, scans.ErrDisabled):
+		apperror.Abort(c, apperror.Forbidden("Scans Are Disabled"))
+	case errors.Is(err, scans.ErrNotAllowed):
+		apperror.Abort(c, apperror.Forbidden("Target Not Allowed").WithField("targets", "not_allowed", err.Error()))
+	case errors.Is(err, scans.ErrInvalid):
+		apperror.Abort(c, apperror.BadRequest("Invalid Scan").WithField("scan", "invalid", err.Error()))
+	default:
+		return false
+	}
+	return true
+}
+
+// scanProfile loads the scan profile of the id path parameter
+// It aborts the request and returns false if the profile cannot be loaded
+func scanProfile(c *gin.Context) (*models.ScanProfile, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid Scan Profile ID"))
+		return nil, false
+	}
+	profile, err := models.GetScanProfile(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apperror.Abort(c, apperror.NotFound("Scan Profile Not Found"))
+		return nil, false
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get Scan Profile"))
+		return nil, false
+	}
+	return profile, true
+}
+
+// editableScanProfile loads the scan profile of the id path parameter if it is not managed by a file
+func editableScanProfile(c *gin.Context) (*models.ScanProfile, bool) {
+	profile, ok := scanProfile(c)
+	if !ok {
+		return nil, false
+	}
+	if profile.Source == models.ScanProfileSourceFile {
+		apperror.Abort(c, apperror.Conflict("Scan Profile Managed By File"))
+		return nil, false
+	}
+	return profile, true
+}
+
+// auditScanProfile records a change of a scan profile in the audit log
+func auditScanProfile(c *gin.Context, action string, profile *models.ScanProfile) {
+	recordAudit(c, &models.AuditLog{Action: action, Reason: fmt.Sprintf("profile %d %s", profile.ID, profile.Name)})
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/models"
+	"gorm.io/gorm"
+)
+
+// SessionController is a struct that represents a controller for the login sessions of the users
+type SessionController struct{}
+
+// SessionResponse is a session of the current user, Current marks the session of the request
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// ListSessions is a function that lists the active sessions of the current user
+// with their device, user agent, IP address, creation and last activity
+
+// @Summary List Sessions
+// @ID ListSessions
+// @Tags Session
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Produce json
+// @Success 200 {object} string "Success"
+// @Router /protected/sessions [GET]
+func (ctrl SessionController) ListSessions(c *gin.Context) {
+	sessions, err := models.ListActiveSessions(c.GetUint("user_id"))
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get Sessions"))
+		return
+	}
+	current := c.GetUint("session_id")
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{Session: session, Current: session.ID == current}
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// RevokeSession is a function that revokes a session of the current user, its tokens are rejected at once
+// If the session is not found or already revoked, it returns a 404 status code
+
+// @Summary Revoke Session
+// @ID RevokeSession
+// @Tags Session
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "Session ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /protected/sessions/{id} [DELETE]
+func (ctrl SessionController) RevokeSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid Session ID"))
+		return
+	}
+	err = models.RevokeSession(c.GetUint("user_id"), uint(id))
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("Session Not Found"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Revoke Session"))
+		return
+	}
+	logging.FromGin(c).Info("session revoked", "user_id", c.GetUint("user_id"), "session_id", id)
+	c.JSON(http.StatusOK, gin.H{"Message": "Session Revoked"})
+}
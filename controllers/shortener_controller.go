@@ -2,49 +2,140 @@ package controllers
 
 import (
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/metrics"
 	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/utils"
 )
 
 // ShortenerController is a struct that represents a controller for shortener-related operations``
 type ShortenerController struct{}
 
+// UpdateShortURLPayload is a struct that contains the fields that can be changed on a short URL
+type UpdateShortURLPayload struct {
+	LongURL      *string                  `json:"long_url" binding:"omitempty,url"`
+	RedirectType *int                     `json:"redirect_type" binding:"omitempty,oneof=301 302 307 308"`
+	ForwardQuery *bool                    `json:"forward_query"`
+	Destinations *[]models.URLDestination `json:"destinations" binding:"omitempty,dive"`
+}
+
 // CreateShortURl creates a short URL from a long URL and stores it in the database
 // c *gin.Context is a pointer to the Gin context
 func (ctrl *ShortenerController) CreateShortURL(c *gin.Context) {
 	// Create a URL struct
 	var url models.URL
 	// Bind the JSON body to the URL struct
-	c.BindJSON(&url)
+	if err := c.ShouldBindJSON(&url); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	// Fill the defaults and validate the redirect type and destinations
+	if err := url.Prepare(); err != nil {
+		apperror.Abort(c, apperror.BadRequest(err.Error()))
+		return
+	}
+	// The short URL belongs to the user who created it
+	url.CreatedBy = c.GetUint("user_id")
 	// Generate a short URL
 	url.GenerateShortURL()
 	// Create the URL in the database
-	models.CreateURL(&url)
+	if err := models.CreateURL(&url); err != nil {
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Could not save URL"))
+		return
+	}
 	// Return the short URL in the response
 	c.JSON(http.StatusOK, gin.H{"short_url": url.ShortURL})
 }
 
+// UpdateShortURL retargets a short URL or changes its redirect settings
+// Only the user who created the short URL can update it, it is not found for the others
+// c *gin.Context is a pointer to the Gin context
+func (ctrl *ShortenerController) UpdateShortURL(c *gin.Context) {
+	shortURL := c.Param("short_url")
+	url, err := models.GetURLByShortURL(shortURL)
+	if err != nil || url.CreatedBy != c.GetUint("user_id") {
+		apperror.Abort(c, apperror.NotFound("URL not found"))
+		return
+	}
+	var payload UpdateShortURLPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	// Update the URL fields with the provided values
+	if payload.LongURL != nil {
+		url.LongURL = *payload.LongURL
+	}
+	if payload.RedirectType != nil {
+		url.RedirectType = *payload.RedirectType
+	}
+	if payload.ForwardQuery != nil {
+		url.ForwardQuery = *payload.ForwardQuery
+	}
+	if payload.Destinations != nil {
+		url.Destinations = *payload.Destinations
+	}
+	if err := url.Prepare(); err != nil {
+		apperror.Abort(c, apperror.BadRequest(err.Error()))
+		return
+	}
+	if err := models.UpdateURL(&url); err != nil {
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Could not save URL"))
+		return
+	}
+	if payload.Destinations != nil {
+		if err := models.ReplaceDestinations(&url, url.Destinations); err != nil {
+			apperror.Abort(c, utils.ClassifyError(err).AppError("Could not save URL"))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": url})
+}
+
 // RedirectShortURL redirects the user to the long URL associated with the short URL
 // c *gin.Context is a pointer to the Gin context
 func (ctrl *ShortenerController) RedirectShortURL(c *gin.Context) {
 	shortURL := c.Param("short_url")
 	url, err := models.GetURLByShortURL(shortURL)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
-		c.Abort()
+		apperror.Abort(c, apperror.NotFound("URL not found"))
 		return
 	}
 
+	// Pick the destination, weighted destinations take precedence over the long URL
+	target := url.LongURL
+	destination := url.PickDestination()
+	if destination != nil {
+		target = destination.LongURL
+	}
+	// Pass the query parameters of the request (e.g. UTM tags) through to the long URL
+	if url.ForwardQuery {
+		target, err = models.MergeQuery(target, c.Request.URL.Query())
+		if err != nil {
+			apperror.Abort(c, apperror.Internal(err, "Invalid long URL"))
+			return
+		}
+	}
+
 	// Update statistics
-	url.AccessCount++
-	now := time.Now()
-	url.LastAccessed = &now
-	url.AccessPlace = c.ClientIP()
-	models.UpdateURL(&url)
+	models.RecordVisit(&url, c.ClientIP())
+	if destination != nil {
+		models.IncrementDestinationCount(destination)
+	}
 
-	c.Redirect(http.StatusMovedPermanently, url.LongURL)
+	redirectType := url.RedirectType
+	if !models.IsValidRedirectType(redirectType) {
+		redirectType = models.DefaultRedirectType
+	}
+	// Keep temporary redirects out of caches so every visit reaches us and is counted
+	if redirectType == http.StatusFound || redirectType == http.StatusTemporaryRedirect {
+		c.Header("Cache-Control", "no-store")
+	}
+	metrics.Redirects.WithLabelValues(strconv.Itoa(redirectType)).Inc()
+	c.Redirect(redirectType, target)
 }
 
 // GetURLStatistics returns the statistics for a specific short URL
@@ -53,13 +144,16 @@ func (ctrl *ShortenerController) GetURLStatistics(c *gin.Context) {
 	shortURL := c.Param("short_url")
 	url, err := models.GetURLByShortURL(shortURL)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "URL not found"})
+		apperror.Abort(c, apperror.NotFound("URL not found"))
 		return
 	}
 
 	c.JSON(200, gin.H{
 		"short_url":     url.ShortURL,
 		"long_url":      url.LongURL,
+		"redirect_type": url.RedirectType,
+		"forward_query": url.ForwardQuery,
+		"destinations":  url.Destinations,
 		"access_count":  url.AccessCount,
 		"last_accessed": url.LastAccessed,
 		"access_place":  url.AccessPlace,
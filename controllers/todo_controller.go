@@ -0,0 +1,523 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/recurrence"
+	"github.com/zerodot618/go-huang/utils"
+	"gorm.io/gorm"
+)
+
+// ToDoController is a struct that represents a controller for the to-do items of the users
+// Every user sees and changes their own to-do items and those of their lists, as their role allows.
+// Every access is checked by authorizeToDo, authorizeList or the scopes of the models
+type ToDoController struct{}
+
+// ToDoPayload is a struct that contains the fields of a new to-do item
+type ToDoPayload struct {
+	Title    string     `json:"title" binding:"required,max=100"`
+	Content  string     `json:"content" binding:"required"`
+	Status   string     `json:"status" binding:"omitempty,oneof=open in_progress done"`
+	Priority int        `json:"priority" binding:"min=0,max=3"`
+	DueAt    *time.Time `json:"due_at"`
+	Tags     []string   `json:"tags" binding:"max=20,dive,min=1,max=32"`
+	// ListID adds the to-do item to a list the user can edit, it is personal otherwise
+	ListID *uint `json:"list_id"`
+	// Recurrence or RRule makes the to-do item recurring, completing it creates the next occurrence
+	Recurrence *RecurrencePayload `json:"recurrence"`
+	RRule      string             `json:"rrule" binding:"omitempty,max=255"`
+	// RemindBeforeMinutes sends a reminder this many minutes before the due time
+	RemindBeforeMinutes *int `json:"remind_before_minutes" binding:"omitempty,min=0,max=43200"`
+}
+
+// RecurrencePayload is a struct that contains a recurrence rule, the fields of an RRULE
+// Weekdays are only used by weekly rules and MonthDay by monthly rules, negative from the end of the month
+type RecurrencePayload struct {
+	Frequency string     `json:"frequency" binding:"required,oneof=daily weekly monthly"`
+	Interval  int        `json:"interval" binding:"omitempty,min=1,max=1000"`
+	Weekdays  []string   `json:"weekdays" binding:"omitempty,max=7,dive,oneof=MO TU WE TH FR SA SU"`
+	MonthDay  int        `json:"month_day" binding:"omitempty,min=-31,max=31"`
+	Until     *time.Time `json:"until"`
+}
+
+// UpdateToDoPayload is a struct that contains the fields of a to-do item to update, the others are kept
+// A null due_at keeps the due time, clear_due_at removes it
+type UpdateToDoPayload struct {
+	Title      *string    `json:"title" binding:"omitempty,min=1,max=100"`
+	Content    *string    `json:"content" binding:"omitempty,min=1"`
+	Status     *string    `json:"status" binding:"omitempty,oneof=open in_progress done"`
+	Priority   *int       `json:"priority" binding:"omitempty,min=0,max=3"`
+	DueAt      *time.Time `json:"due_at"`
+	ClearDueAt bool       `json:"clear_due_at"`
+	Tags       *[]string  `json:"tags" binding:"omitempty,max=20,dive,min=1,max=32"`
+	// A null recurrence keeps the recurrence rule, clear_recurrence removes it
+	Recurrence      *RecurrencePayload `json:"recurrence"`
+	RRule           string             `json:"rrule" binding:"omitempty,max=255"`
+	ClearRecurrence bool               `json:"clear_recurrence"`
+	// A null remind_before_minutes keeps the reminder, clear_reminder removes it
+	RemindBeforeMinutes *int `json:"remind_before_minutes" binding:"omitempty,min=0,max=43200"`
+	ClearReminder       bool `json:"clear_reminder"`
+}
+
+// AssignToDoPayload is a struct that contains the member of the list to assign a to-do item to, null unassigns it
+type AssignToDoPayload struct {
+	AssigneeID *uint `json:"assignee_id"`
+}
+
+// BulkToDoPayload is a struct that contains an operation on several to-do items
+type BulkToDoPayload struct {
+	Action string `json:"action" binding:"required,oneof=complete delete"`
+	IDs    []uint `json:"ids" binding:"required,min=1,max=100"`
+}
+
+// CreateToDo is a function that creates a to-do item for the current user, personal or in a list
+// It can recur and send a reminder before its due time.
+// If the title is already used, it returns a 409 status code, and a 422 status code for an invalid recurrence rule
+
+// @Summary Create ToDo
+// @ID CreateToDo
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body ToDoPayload true "Title and content"
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.ToDo "Success"
+// @Failure 409 {string} string "Error"
+// @Failure 422 {string} string "Error"
+// @Router /todos [POST]
+func (ctrl ToDoController) CreateToDo(c *gin.Context) {
+	var payload ToDoPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	userID := c.GetUint("user_id")
+	rule, ok := recurrenceRule(c, payload.Recurrence, payload.RRule)
+	if !ok {
+		return
+	}
+	if payload.ListID != nil {
+		if _, ok := authorizeList(c, *payload.ListID, models.PermissionEdit); !ok {
+			return
+		}
+	}
+	todo := models.ToDo{
+		Title:        payload.Title,
+		Content:      payload.Content,
+		Status:       payload.Status,
+		Priority:     payload.Priority,
+		DueAt:        payload.DueAt,
+		AuthorID:     userID,
+		ListID:       payload.ListID,
+		Recurrence:   rule,
+		RemindBefore: payload.RemindBeforeMinutes,
+	}
+	if err := todo.SaveToDo(payload.Tags); err != nil {
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Could Not Create ToDo"))
+		return
+	}
+	recordToDoActivity(c, &todo, models.ActivityToDoCreated)
+	recordNextOccurrence(c, &todo)
+	c.JSON(http.StatusCreated, todo)
+}
+
+// ListToDos is a function that returns a page of the to-do items the current user can see, newest first
+// They can be filtered by text, status, priority, tag, due time, list and assignee, and sorted by the sort parameter
+
+// @Summary List ToDos
+// @ID ListToDos
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param q query string false "Title or content"
+// @Param status query string false "open, in_progress or done"
+// @Param priority query int false "Priority, from 0 to 3"
+// @Param tag query string false "Tag"
+// @Param due_before query string false "RFC 3339 time"
+// @Param due_after query string false "RFC 3339 time"
+// @Param list_id query int false "List ID"
+// @Param assignee_id query int false "Assignee ID"
+// @Param sort query string false "created_at, due_at, priority or title, prefixed with - for descending order"
+// @Param page query int false "Page, from 1"
+// @Param page_size query int false "Page size, up to 100"
+// @Produce json
+// @Success 200 {object} models.Page[models.ToDo] "Success"
+// @Router /todos [GET]
+func (ctrl ToDoController) ListToDos(c *gin.Context) {
+	var filter models.ToDoFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	page, err := models.FindUserToDos(c.GetUint("user_id"), filter, pagination)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List ToDos"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ListOverdueToDos is a function that returns a page of the to-do items the current user can see
+// past their due time and not done, the most overdue first
+
+// @Summary List Overdue ToDos
+// @ID ListOverdueToDos
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param page query int false "Page, from 1"
+// @Param page_size query int false "Page size, up to 100"
+// @Produce json
+// @Success 200 {object} models.Page[models.ToDo] "Success"
+// @Router /todos/overdue [GET]
+func (ctrl ToDoController) ListOverdueToDos(c *gin.Context) {
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	page, err := models.FindOverdueToDos(c.GetUint("user_id"), time.Now(), pagination)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List ToDos"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// ListTags is a function that returns the tags of the current user
+
+// @Summary List ToDo Tags
+// @ID ListToDoTags
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Produce json
+// @Success 200 {object} string "Success"
+// @Router /todos/tags [GET]
+func (ctrl ToDoController) ListTags(c *gin.Context) {
+	tags, err := models.ListUserTags(c.GetUint("user_id"))
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List Tags"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// BulkToDos is a function that completes or deletes several to-do items at once
+// The to-do items the current user cannot change are ignored. It returns how many to-do items were changed
+
+// @Summary Complete Or Delete ToDos
+// @ID BulkToDos
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body BulkToDoPayload true "Action and IDs"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 422 {string} string "Error"
+// @Router /todos/bulk [POST]
+func (ctrl ToDoController) BulkToDos(c *gin.Context) {
+	var payload BulkToDoPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	userID := c.GetUint("user_id")
+	var todos []models.ToDo
+	var err error
+	activity := models.ActivityToDoCompleted
+	switch payload.Action {
+	case "complete":
+		todos, err = models.CompleteUserToDos(userID, payload.IDs)
+	case "delete":
+		todos, err = models.DeleteUserToDos(userID, payload.IDs)
+		activity = models.ActivityToDoDeleted
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Update ToDos"))
+		return
+	}
+	for i := range todos {
+		recordToDoActivity(c, &todos[i], activity)
+		recordNextOccurrence(c, &todos[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"action": payload.Action, "affected": len(todos)})
+}
+
+// GetToDo is a function that returns a to-do item the current user can see
+// If the to-do item is not found, it returns a 404 status code
+
+// @Summary Get ToDo
+// @ID GetToDo
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "ToDo ID"
+// @Produce json
+// @Success 200 {object} models.ToDo "Success"
+// @Failure 404 {string} string "Error"
+// @Router /todos/{id} [GET]
+func (ctrl ToDoController) GetToDo(c *gin.Context) {
+	todo, ok := authorizeToDo(c, models.PermissionView)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, todo)
+}
+
+// UpdateToDo is a function that updates the fields of a to-do item the current user can change, the others are kept
+// Setting the status to done records the completion time, and creates the next occurrence of a recurring to-do item
+// If the to-do item is not found, it returns a 404 status code, and a 403 status code for the viewers of its list
+
+// @Summary Update ToDo
+// @ID UpdateToDo
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "ToDo ID"
+// @Param EnterDetails body UpdateToDoPayload true "Title and content"
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.ToDo "Success"
+// @Failure 403 {string} string "Error"
+// @Failure 404 {string} string "Error"
+// @Failure 422 {string} string "Error"
+// @Router /todos/{id} [PUT]
+func (ctrl ToDoController) UpdateToDo(c *gin.Context) {
+	var payload UpdateToDoPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	rule, ok := recurrenceRule(c, payload.Recurrence, payload.RRule)
+	if !ok {
+		return
+	}
+	todo, ok := authorizeToDo(c, models.PermissionEdit)
+	if !ok {
+		return
+	}
+	wasDone := todo.Status == models.ToDoStatusDone
+	if payload.Title != nil {
+		todo.Title = *payload.Title
+	}
+	if payload.Content != nil {
+		todo.Content = *payload.Content
+	}
+	if payload.Status != nil {
+		todo.SetStatus(*payload.Status)
+	}
+	if payload.Priority != nil {
+		todo.Priority = *payload.Priority
+	}
+	if payload.DueAt != nil {
+		todo.DueAt = payload.DueAt
+	}
+	if payload.ClearDueAt {
+		todo.DueAt = nil
+	}
+	if rule != "" {
+		todo.Recurrence = rule
+	}
+	if payload.ClearRecurrence {
+		todo.Recurrence = ""
+	}
+	if payload.RemindBeforeMinutes != nil {
+		todo.RemindBefore = payload.RemindBeforeMinutes
+	}
+	if payload.ClearReminder {
+		todo.RemindBefore = nil
+	}
+	var tags []string
+	if payload.Tags != nil {
+		// An empty list removes every tag, unlike a missing one
+		tags = append([]string{}, *payload.Tags...)
+	}
+	err := todo.UpdateAToDo(tags)
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("ToDo Not Found"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Could Not Update ToDo"))
+		return
+	}
+	activity := models.ActivityToDoUpdated
+	if !wasDone && todo.Status == models.ToDoStatusDone {
+		activity = models.ActivityToDoCompleted
+	}
+	recordToDoActivity(c, todo, activity)
+	recordNextOccurrence(c, todo)
+	c.JSON(http.StatusOK, todo)
+}
+
+// AssignToDo is a function that assigns a to-do item of a list to one of its members, or unassigns it
+// If the assignee is not a member of the list, or the to-do item is personal, it returns a 422 status code
+
+// @Summary Assign ToDo
+// @ID AssignToDo
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "ToDo ID"
+// @Param EnterDetails body AssignToDoPayload true "Assignee ID"
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.ToDo "Success"
+// @Failure 422 {string} string "Error"
+// @Router /todos/{id}/assignee [PUT]
+func (ctrl ToDoController) AssignToDo(c *gin.Context) {
+	var payload AssignToDoPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	todo, ok := authorizeToDo(c, models.PermissionEdit)
+	if !ok {
+		return
+	}
+	err := todo.AssignToDo(payload.AssigneeID)
+	if err == models.ErrNotMember {
+		apperror.Abort(c, apperror.Validation(apperror.FieldError{
+			Field:   "assignee_id",
+			Code:    "member",
+			Message: "assignee_id must be a member of the list of the todo",
+		}))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Assign ToDo"))
+		return
+	}
+	recordToDoActivity(c, todo, models.ActivityToDoAssigned)
+	c.JSON(http.StatusOK, todo)
+}
+
+// DeleteToDo is a function that deletes a to-do item the current user can change
+// If the to-do item is not found, it returns a 404 status code, and a 403 status code for the viewers of its list
+
+// @Summary Delete ToDo
+// @ID DeleteToDo
+// @Tags ToDo
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "ToDo ID"
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /todos/{id} [DELETE]
+func (ctrl ToDoController) DeleteToDo(c *gin.Context) {
+	todo, ok := authorizeToDo(c, models.PermissionEdit)
+	if !ok {
+		return
+	}
+	err := todo.DeleteAToDo()
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("ToDo Not Found"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Delete ToDo"))
+		return
+	}
+	recordToDoActivity(c, todo, models.ActivityToDoDeleted)
+	c.JSON(http.StatusOK, gin.H{"Message": "ToDo Deleted"})
+}
+
+// authorizeToDo loads the to-do item of the id path parameter and checks that the current user has a permission on it
+// It aborts the request and returns false if the to-do item cannot be loaded or the permission is missing
+func authorizeToDo(c *gin.Context, permission models.Permission) (*models.ToDo, bool) {
+	id, ok := toDoID(c)
+	if !ok {
+		return nil, false
+	}
+	todo, err := models.FindToDo(c.GetUint("user_id"), id, permission)
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("ToDo Not Found"))
+		return nil, false
+	}
+	if err == models.ErrForbidden {
+		apperror.Abort(c, apperror.Forbidden("Insufficient List Role"))
+		return nil, false
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get ToDo"))
+		return nil, false
+	}
+	return todo, true
+}
+
+// toDoID parses the id path parameter
+func toDoID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid ToDo ID"))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// recordToDoActivity adds a change of a to-do item to the activity feed of its list
+// A failure is logged, the change itself is done
+func recordToDoActivity(c *gin.Context, todo *models.ToDo, action string) {
+	if err := models.RecordToDoActivity(todo, c.GetUint("user_id"), action); err != nil {
+		logging.FromGin(c).Error("could not record todo activity", "todo_id", todo.ID, "action", action, "error", err.Error())
+	}
+}
+
+// recordNextOccurrence adds the next occurrence created by completing a recurring to-do item to the activity feed
+func recordNextOccurrence(c *gin.Context, todo *models.ToDo) {
+	if todo.NextOccurrence != nil {
+		recordToDoActivity(c, todo.NextOccurrence, models.ActivityToDoCreated)
+	}
+}
+
+// recurrenceRule returns the canonical form of the recurrence rule of a payload, given by its fields or as an RRULE
+// It returns an empty rule if there is none, and aborts the request with a 422 status code if it is invalid
+func recurrenceRule(c *gin.Context, payload *RecurrencePayload, rrule string) (string, bool) {
+	if payload != nil && rrule != "" {
+		apperror.Abort(c, apperror.Validation(apperror.FieldError{
+			Field:   "rrule",
+			Code:    "excluded_with",
+			Message: "rrule cannot be given with recurrence",
+		}))
+		return "", false
+	}
+	var rule recurrence.Rule
+	var err error
+	field := "recurrence"
+	switch {
+	case rrule != "":
+		field = "rrule"
+		rule, err = recurrence.Parse(rrule)
+	case payload != nil:
+		rule = recurrence.Rule{
+			Frequency: recurrence.Frequency(strings.ToUpper(payload.Frequency)),
+			Interval:  payload.Interval,
+			MonthDay:  payload.MonthDay,
+			Until:     payload.Until,
+		}
+		if rule.Interval == 0 {
+			rule.Interval = 1
+		}
+		for _, code := range payload.Weekdays {
+			day, _ := recurrence.ParseWeekday(code)
+			rule.Weekdays = append(rule.Weekdays, day)
+		}
+		err = rule.Validate()
+	default:
+		return "", true
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Validation(apperror.FieldError{
+			Field:   field,
+			Code:    "rrule",
+			Message: err.Error(),
+		}))
+		return "", false
+	}
+	return rule.String(), true
+}
@@ -0,0 +1,432 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/mail"
+	"github.com/zerodot618/go-huang/models"
+	"gorm.io/gorm"
+)
+
+// TodoListController is a struct that represents a controller for the shared to-do lists
+type TodoListController struct{}
+
+// TodoListPayload is a struct that contains the fields of a to-do list
+type TodoListPayload struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description" binding:"max=255"`
+}
+
+// InviteMemberPayload is a struct that contains the email to invite to a list and the role of the invitee
+type InviteMemberPayload struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=viewer editor owner"`
+}
+
+// AcceptInvitationPayload is a struct that contains the token of an invitation to a list
+type AcceptInvitationPayload struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// MemberRolePayload is a struct that contains the new role of a member
+type MemberRolePayload struct {
+	Role string `json:"role" binding:"required,oneof=viewer editor owner"`
+}
+
+// CreateList is a function that creates a to-do list, the current user becomes its owner
+
+// @Summary Create ToDo List
+// @ID CreateTodoList
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body TodoListPayload true "Name and description"
+// @Accept json
+// @Produce json
+// @Success 201 {object} models.TodoList "Success"
+// @Failure 422 {string} string "Error"
+// @Router /todo-lists [POST]
+func (ctrl TodoListController) CreateList(c *gin.Context) {
+	var payload TodoListPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	list := models.TodoList{
+		Name:        payload.Name,
+		Description: payload.Description,
+		CreatedByID: c.GetUint("user_id"),
+	}
+	if err := models.CreateTodoList(&list); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Create List"))
+		return
+	}
+	c.JSON(http.StatusCreated, list)
+}
+
+// ListLists is a function that returns a page of the to-do lists of the current user, with their role
+
+// @Summary List ToDo Lists
+// @ID ListTodoLists
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param page query int false "Page, from 1"
+// @Param page_size query int false "Page size, up to 100"
+// @Produce json
+// @Success 200 {object} models.Page[models.TodoList] "Success"
+// @Router /todo-lists [GET]
+func (ctrl TodoListController) ListLists(c *gin.Context) {
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	page, err := models.FindUserTodoLists(c.GetUint("user_id"), pagination)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List Lists"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// GetList is a function that returns a to-do list of the current user
+// If the user is not a member of the list, it returns a 404 status code
+
+// @Summary Get ToDo List
+// @ID GetTodoList
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "List ID"
+// @Produce json
+// @Success 200 {object} models.TodoList "Success"
+// @Failure 404 {string} string "Error"
+// @Router /todo-lists/{id} [GET]
+func (ctrl TodoListController) GetList(c *gin.Context) {
+	list, ok := authorizeListParam(c, models.PermissionView)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// UpdateList is a function that renames a to-do list, only its owners can
+
+// @Summary Update ToDo List
+// @ID UpdateTodoList
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "List ID"
+// @Param EnterDetails body TodoListPayload true "Name and description"
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.TodoList "Success"
+// @Failure 403 {string} string "Error"
+// @Router /todo-lists/{id} [PUT]
+func (ctrl TodoListController) UpdateList(c *gin.Context) {
+	var payload TodoListPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	list, ok := authorizeListParam(c, models.PermissionManage)
+	if !ok {
+		return
+	}
+	list.Name = payload.Name
+	list.Description = payload.Description
+	if err := list.UpdateTodoList(c.GetUint("user_id")); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Update List"))
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// DeleteList is a function that deletes a to-do list with its to-do items, only its owners can
+
+// @Summary Delete ToDo List
+// @ID DeleteTodoList
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "List ID"
+// @Success 200 {object} string "Success"
+// @Failure 403 {string} string "Error"
+// @Router /todo-lists/{id} [DELETE]
+func (ctrl TodoListController) DeleteList(c *gin.Context) {
+	list, ok := authorizeListParam(c, models.PermissionManage)
+	if !ok {
+		return
+	}
+	if err := list.DeleteTodoList(); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Delete List"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "List Deleted"})
+}
+
+// ListMembers is a function that returns the members of a to-do list, owners first
+
+// @Summary List ToDo List Members
+// @ID ListTodoListMembers
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "List ID"
+// @Produce json
+// @Success 200 {object} string "Success"
+// @Failure 404 {string} string "Error"
+// @Router /todo-lists/{id}/members [GET]
+func (ctrl TodoListController) ListMembers(c *gin.Context) {
+	list, ok := authorizeListParam(c, models.PermissionView)
+	if !ok {
+		return
+	}
+	members, err := list.ListMembers()
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List Members"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// InviteMember is a function that invites an email to a to-do list with a role, only its owners can
+// The invitee is emailed a link and becomes a member once they accept it, see AcceptInvitation.
+// The invitation and the response are the same whether a user has the email or not, and the email is sent
+// in the background, so invitations do not tell which emails are registered.
+// If a member already has the email, it returns a 409 status code
+
+// @Summary Invite ToDo List Member
+// @ID InviteTodoListMember
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "List ID"
+// @Param EnterDetails body InviteMemberPayload true "Email and role"
+// @Accept json
+// @Produce json
+// @Success 202 {object} string "Success"
+// @Failure 409 {string} string "Error"
+// @Router /todo-lists/{id}/members [POST]
+func (ctrl TodoListController) InviteMember(c *gin.Context) {
+	var payload InviteMemberPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	list, ok := authorizeListParam(c, models.PermissionManage)
+	if !ok {
+		return
+	}
+	var inviter models.User
+	inviterID := c.GetUint("user_id")
+	if err := database.GlobalDB.Select("id", "name").First(&inviter, inviterID).Error; err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+		return
+	}
+	token, err := list.InviteMember(payload.Email, payload.Role, inviterID)
+	if err == models.ErrAlreadyMember {
+		apperror.Abort(c, apperror.Conflict("Already A Member"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Invite Member"))
+		return
+	}
+	link := appURL("/api/todo-lists/invitations/accept", token)
+	msg := mail.TodoListInvitation(payload.Email, inviter.Name, list.Name, payload.Role, link, models.TodoListInvitationTTL)
+	sendMailInBackground(c, msg, "list invitation", "list_id", list.ID)
+	c.JSON(http.StatusAccepted, gin.H{"Message": "Invitation Sent"})
+}
+
+// AcceptInvitation is a function that makes the current user a member of the list of an invitation
+// The invitation must have been sent to the email of the user. It is read from the body or the token query parameter,
+// so the link of the invitation email works. If the invitation is invalid or expired, it returns a 400 status code,
+// and if the user is already a member, a 409 status code
+
+// @Summary Accept ToDo List Invitation
+// @ID AcceptTodoListInvitation
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body AcceptInvitationPayload true "Invitation token"
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.TodoListMember "Success"
+// @Failure 400 {string} string "Error"
+// @Failure 409 {string} string "Error"
+// @Router /todo-lists/invitations/accept [POST]
+func (ctrl TodoListController) AcceptInvitation(c *gin.Context) {
+	var payload AcceptInvitationPayload
+	if err := c.ShouldBind(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	member, err := models.AcceptTodoListInvitation(payload.Token, user)
+	switch {
+	case err == models.ErrInvalidToken:
+		apperror.Abort(c, apperror.BadRequest("Invalid Or Expired Invitation"))
+		return
+	case err == models.ErrAlreadyMember:
+		apperror.Abort(c, apperror.Conflict("Already A Member"))
+		return
+	case err != nil:
+		apperror.Abort(c, apperror.Internal(err, "Could Not Accept Invitation"))
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// ChangeMemberRole is a function that changes the role of a member of a to-do list, only its owners can
+// If the last owner would be demoted, it returns a 409 status code
+
+// @Summary Change ToDo List Member Role
+// @ID ChangeTodoListMemberRole
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "List ID"
+// @Param user_id path int true "User ID"
+// @Param EnterDetails body MemberRolePayload true "Role"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 409 {string} string "Error"
+// @Router /todo-lists/{id}/members/{user_id} [PUT]
+func (ctrl TodoListController) ChangeMemberRole(c *gin.Context) {
+	var payload MemberRolePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	list, ok := authorizeListParam(c, models.PermissionManage)
+	if !ok {
+		return
+	}
+	userID, ok := memberUserID(c)
+	if !ok {
+		return
+	}
+	if !memberChanged(c, list.ChangeMemberRole(userID, payload.Role, c.GetUint("user_id")), "Could Not Change Role") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "Role Changed"})
+}
+
+// RemoveMember is a function that removes a member from a to-do list
+// Owners can remove anyone and every member can leave. Their to-do items in the list are unassigned
+// If the last owner would be removed, it returns a 409 status code
+
+// @Summary Remove ToDo List Member
+// @ID RemoveTodoListMember
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "List ID"
+// @Param user_id path int true "User ID"
+// @Success 200 {object} string "Success"
+// @Failure 409 {string} string "Error"
+// @Router /todo-lists/{id}/members/{user_id} [DELETE]
+func (ctrl TodoListController) RemoveMember(c *gin.Context) {
+	userID, ok := memberUserID(c)
+	if !ok {
+		return
+	}
+	currentUserID := c.GetUint("user_id")
+	// Leaving a list only needs to be a member of it
+	permission := models.PermissionManage
+	if userID == currentUserID {
+		permission = models.PermissionView
+	}
+	list, ok := authorizeListParam(c, permission)
+	if !ok {
+		return
+	}
+	if !memberChanged(c, list.RemoveMember(userID, currentUserID), "Could Not Remove Member") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "Member Removed"})
+}
+
+// ListActivity is a function that returns a page of the activity feed of a to-do list, newest first
+
+// @Summary List ToDo List Activity
+// @ID ListTodoListActivity
+// @Tags TodoList
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param id path int true "List ID"
+// @Param page query int false "Page, from 1"
+// @Param page_size query int false "Page size, up to 100"
+// @Produce json
+// @Success 200 {object} models.Page[models.TodoActivity] "Success"
+// @Router /todo-lists/{id}/activity [GET]
+func (ctrl TodoListController) ListActivity(c *gin.Context) {
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	list, ok := authorizeListParam(c, models.PermissionView)
+	if !ok {
+		return
+	}
+	page, err := list.ListActivity(pagination)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not List Activity"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// authorizeListParam checks that the current user has a permission on the list of the id path parameter
+func authorizeListParam(c *gin.Context, permission models.Permission) (*models.TodoList, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid List ID"))
+		return nil, false
+	}
+	return authorizeList(c, uint(id), permission)
+}
+
+// authorizeList loads a to-do list and checks that the current user has a permission on it
+// It aborts the request and returns false if the list cannot be loaded or the permission is missing
+func authorizeList(c *gin.Context, listID uint, permission models.Permission) (*models.TodoList, bool) {
+	list, err := models.AuthorizeList(c.GetUint("user_id"), listID, permission)
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("List Not Found"))
+		return nil, false
+	}
+	if err == models.ErrForbidden {
+		apperror.Abort(c, apperror.Forbidden("Insufficient List Role"))
+		return nil, false
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get List"))
+		return nil, false
+	}
+	return list, true
+}
+
+// memberUserID parses the user_id path parameter
+func memberUserID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		apperror.Abort(c, apperror.BadRequest("Invalid User ID"))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// memberChanged aborts the request if a change of a membership failed
+func memberChanged(c *gin.Context, err error, message string) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == gorm.ErrRecordNotFound:
+		apperror.Abort(c, apperror.NotFound("Member Not Found"))
+	case err == models.ErrLastOwner:
+		apperror.Abort(c, apperror.Conflict("A List Needs An Owner"))
+	default:
+		apperror.Abort(c, apperror.Internal(err, message))
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/mail"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/utils"
+)
+
+// UpdateProfilePayload is a struct that contains the fields of the profile a user can update
+type UpdateProfilePayload struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+// ChangePasswordPayload is a struct that contains the fields to change the password
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,password"`
+}
+
+// ChangeEmailPayload is a struct that contains the fields to change the email address
+type ChangeEmailPayload struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// PasswordPayload is a struct that contains the password confirming a sensitive action
+type PasswordPayload struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// UpdateProfile is a function that updates the profile of the current user
+// The email is changed with ChangeEmail, since the new address must be verified first
+
+// @Summary Update User Profile
+// @ID UpdateProfile
+// @Tags User
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body UpdateProfilePayload true "Profile"
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.User "Success"
+// @Failure 422 {string} string "Error"
+// @Router /protected/profile [PATCH]
+func (ctrl UserController) UpdateProfile(c *gin.Context) {
+	var payload UpdateProfilePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if err := user.UpdateName(payload.Name); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Update Profile"))
+		return
+	}
+	user.Password = ""
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword is a function that changes the password of the current user
+// It requires the current password and logs the user out of every other session
+// Wrong passwords count as failed logins and lock the account like the login does.
+// Users created by an OpenID Connect login have a random password, they set one with the password reset first
+
+// @Summary Change Password
+// @ID ChangePassword
+// @Tags User
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body ChangePasswordPayload true "Current and new password"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 401 {string} string "Error"
+// @Router /protected/password [POST]
+func (ctrl UserController) ChangePassword(c *gin.Context) {
+	var payload ChangePasswordPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if refuseLocked(c, user) {
+		return
+	}
+	if err := user.CheckPassword(payload.CurrentPassword); err != nil {
+		recordFailedCheck(c, user, "wrong password")
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Invalid Current Password"))
+		return
+	}
+	if err := user.UpdatePassword(payload.NewPassword); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Change Password"))
+		return
+	}
+	revoked, err := models.RevokeOtherSessions(user.ID, c.GetUint("session_id"))
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Revoke Sessions"))
+		return
+	}
+	logging.FromGin(c).Info("password changed", "user_id", user.ID, "revoked_sessions", revoked)
+	c.JSON(http.StatusOK, gin.H{"Message": "Password Changed", "revoked_sessions": revoked})
+}
+
+// ChangeEmail is a function that starts changing the email address of the current user
+// It requires the password and emails a confirmation link to the new address,
+// the account keeps its current address until the link is opened
+// If the address is used by another account, it returns a 409 status code
+// Wrong passwords count as failed logins, users created by an OpenID Connect login set a password first
+
+// @Summary Change Email
+// @ID ChangeEmail
+// @Tags User
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body ChangeEmailPayload true "New email and password"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 409 {string} string "Error"
+// @Router /protected/email [POST]
+func (ctrl UserController) ChangeEmail(c *gin.Context) {
+	var payload ChangeEmailPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if refuseLocked(c, user) {
+		return
+	}
+	if err := user.CheckPassword(payload.Password); err != nil {
+		recordFailedCheck(c, user, "wrong password")
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Invalid User Credentials"))
+		return
+	}
+	if payload.Email == user.Email {
+		apperror.Abort(c, apperror.BadRequest("Email Unchanged"))
+		return
+	}
+	var taken int64
+	if err := database.GlobalDB.Model(&models.User{}).Where("email = ?", payload.Email).Count(&taken).Error; err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Change Email"))
+		return
+	}
+	if taken > 0 {
+		apperror.Abort(c, apperror.Conflict("Email Already In Use"))
+		return
+	}
+	if err := user.StartEmailChange(payload.Email); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Change Email"))
+		return
+	}
+	token, err := models.CreateUserToken(user.ID, models.TokenPurposeEmailChange, models.EmailChangeTokenTTL)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Change Email"))
+		return
+	}
+	link := appURL("/api/public/email/change/confirm", token)
+	if err := sendMail(c, mail.EmailChangeEmail(payload.Email, user.Name, link, models.EmailChangeTokenTTL)); err != nil {
+		logging.FromGin(c).Error("could not send email change email", "user_id", user.ID, "error", err.Error())
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "Check Your New Email To Confirm The Change"})
+}
+
+// ConfirmEmailChange is a function that completes an email change with the token sent to the new address
+// The previous address is told about the change
+// If the token is unknown, expired or already used, it returns a 400 status code
+
+// @Summary Confirm Email Change
+// @ID ConfirmEmailChange
+// @Tags User
+// @Param token query string true "Email change token"
+// @Success 200 {object} string "Success"
+// @Failure 400 {string} string "Error"
+// @Router /public/email/change/confirm [GET]
+func (ctrl UserController) ConfirmEmailChange(c *gin.Context) {
+	var payload TokenPayload
+	if err := c.ShouldBind(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	token, err := models.ConsumeUserToken(payload.Token, models.TokenPurposeEmailChange)
+	if err == models.ErrInvalidToken {
+		apperror.Abort(c, apperror.BadRequest("Invalid Or Expired Token"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Change Email"))
+		return
+	}
+	var user models.User
+	if err := database.GlobalDB.First(&user, token.UserID).Error; err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Change Email"))
+		return
+	}
+	previous := user.Email
+	err = user.ConfirmEmailChange()
+	if err == models.ErrNoPendingEmail {
+		apperror.Abort(c, apperror.BadRequest("Invalid Or Expired Token"))
+		return
+	}
+	if err != nil {
+		if utils.ClassifyError(err).Kind == utils.KindDuplicateKey {
+			apperror.Abort(c, apperror.Conflict("Email Already In Use"))
+			return
+		}
+		apperror.Abort(c, apperror.Internal(err, "Could Not Change Email"))
+		return
+	}
+	if err := sendMail(c, mail.EmailChangedNotice(previous, user.Name, user.Email)); err != nil {
+		logging.FromGin(c).Error("could not send email changed notice", "user_id", user.ID, "error", err.Error())
+	}
+	logging.FromGin(c).Info("email changed", "user_id", user.ID)
+	c.JSON(http.StatusOK, gin.H{"Message": "Email Changed"})
+}
+
+// DeleteAccount is a function that schedules the deletion of the account of the current user
+// It requires the password and logs the user out everywhere.
+// The account is purged after a grace period, logging in again before that cancels the deletion
+// Wrong passwords count as failed logins, users created by an OpenID Connect login set a password first
+
+// @Summary Delete Account
+// @ID DeleteAccount
+// @Tags User
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body PasswordPayload true "Password"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 401 {string} string "Error"
+// @Router /protected/account [DELETE]
+func (ctrl UserController) DeleteAccount(c *gin.Context) {
+	var payload PasswordPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if refuseLocked(c, user) {
+		return
+	}
+	if err := user.CheckPassword(payload.Password); err != nil {
+		recordFailedCheck(c, user, "wrong password")
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Invalid User Credentials"))
+		return
+	}
+	if err := user.RequestDeletion(); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Delete Account"))
+		return
+	}
+	logging.FromGin(c).Warn("account deletion requested", "user_id", user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"Message":     "Account Scheduled For Deletion, Log In Again To Cancel",
+		"deletion_at": user.DeletionScheduledAt(),
+	})
+}
@@ -1,13 +1,20 @@
 package controllers
 
 import (
-	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
 	"github.com/zerodot618/go-huang/auth"
 	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/mail"
+	"github.com/zerodot618/go-huang/metrics"
 	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/utils"
 	"gorm.io/gorm"
 )
 
@@ -17,7 +24,7 @@ type UserController struct{}
 // LoginPayload login body
 // LoginPayload is a struct that contains the fields for a user's login credentials
 type LoginPayload struct {
-	Email    string `json:"email" bingding:"required,email"`
+	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
 
@@ -28,16 +35,30 @@ type LoginResponse struct {
 	RefreshToken string `json:"refreshToken"`
 }
 
+// RefreshPayload is the body of a token refresh, the refresh token returned by a login
+type RefreshPayload struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// MFARequiredResponse is the response of the password step of a login when the user enabled 2FA
+type MFARequiredResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
 // swagger input
 type UserDetails struct {
 	Name     string `json:"name" binding:"required"`
-	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,password"`
 }
 
 // Signup is a function that handles user signup
 // It takes in a gin context as an argument and binds the user data from the request body to a user struct
-// It then hashes the user's password and creates a user record in the database
+// It then hashes the user's password, creates an unverified user record in the database
+// and emails a verification link to the user
+// A taken email gets the same response and its owner is told by email, so signups do not tell which emails are registered
 // If successful, it returns a 200 status code with a success message
 // If unsuccessful, it returns a 400 or 500 status code with an error message
 
@@ -51,35 +72,55 @@ type UserDetails struct {
 // @Failure 400 {string} string "Error"
 // @Router /public/signup [POST]
 func (ctrl UserController) Signup(c *gin.Context) {
-	var user models.User
-	err := c.ShouldBindJSON(&user)
+	var payload UserDetails
+	err := c.ShouldBindJSON(&payload)
 	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid Inputs"})
-		c.Abort()
+		apperror.Abort(c, apperror.Binding(err))
 		return
 	}
-	err = user.HashPassword(user.Password)
+	// Only the signup fields are taken from the request, the role and 2FA keep their defaults
+	user := models.User{Name: payload.Name, Email: payload.Email, Role: models.RoleUser}
+	err = user.HashPassword(payload.Password)
 	if err != nil {
-		log.Println(err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": "Error Hashing Password"})
-		c.Abort()
+		apperror.Abort(c, apperror.Internal(err, "Error Hashing Password"))
 		return
 	}
 	err = user.CreateUserRecord()
+	if dbErr := utils.ClassifyError(err); dbErr != nil && dbErr.Kind == utils.KindDuplicateKey && dbErr.Field == "email" {
+		// A taken address gets the same response, its owner is told by email
+		signupTaken(c, payload.Email)
+		c.JSON(http.StatusOK, gin.H{"Message": signupMessage})
+		return
+	}
 	if err != nil {
-		log.Println(err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": "Error Creating User"})
-		c.Abort()
+		apperror.Abort(c, utils.ClassifyError(err).AppError("Error Creating User"))
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"Message": "Sucessfully Register"})
+	logging.FromGin(c).Info("user registered", "user_id", user.ID)
+	// The account stays unverified until the link of the verification email is opened
+	sendVerificationEmail(c, &user)
+	c.JSON(http.StatusOK, gin.H{"Message": signupMessage})
+}
+
+// signupMessage is the response of every signup, whether the email was taken or not
+const signupMessage = "Sucessfully Register, Check Your Email To Verify Your Account"
+
+// signupTaken emails the owner of an account that someone signed up with its address
+func signupTaken(c *gin.Context, email string) {
+	var owner models.User
+	if err := database.GlobalDB.Select("id", "name", "email").Where("email = ?", email).First(&owner).Error; err != nil {
+		logging.FromGin(c).Error("could not get the user of a taken email", "error", err.Error())
+		return
+	}
+	logging.FromGin(c).Info("signup with a taken email", "user_id", owner.ID)
+	sendMailInBackground(c, mail.AccountExistsEmail(owner.Email, owner.Name), "account exists email", "user_id", owner.ID)
 }
 
 // Login is a function that handles user login
 // It takes in a gin context as an argument and binds the user data from the request body to a LoginPayload struct
 // It then checks if the user exists in the database and if the password is correct
 // If successful, it generates a token and a refresh token and returns a 200 status code with the token and refresh token
+// If the user enabled 2FA, it returns a short-lived MFA token instead, to send to LoginMFA with a code
 // If unsuccessful, it returns a 401 or 500 status code with an error message
 
 // @Summary Login User
@@ -96,47 +137,109 @@ func (ctrl UserController) Login(c *gin.Context) {
 	var user models.User
 	err := c.ShouldBindJSON(&payload)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid Inputs"})
-		c.Abort()
+		apperror.Abort(c, apperror.Binding(err))
 		return
 	}
 	result := database.GlobalDB.Where("email = ?", payload.Email).First(&user)
 	if result.Error == gorm.ErrRecordNotFound {
-		c.JSON(http.StatusUnauthorized, gin.H{"Error": "Invalid User Credentials"})
-		c.Abort()
+		// Spend the time of a password check, the response time does not tell whether the email is registered
+		models.CheckDummyPassword(payload.Password)
+		logging.FromGin(c).Warn("login failed", "reason", "unknown email")
+		metrics.Logins.WithLabelValues("failure").Inc()
+		apperror.Abort(c, apperror.Unauthorized("Invalid User Credentials"))
+		return
+	}
+	if result.Error != nil {
+		apperror.Abort(c, apperror.Internal(result.Error, "Could Not Get User"))
+		return
+	}
+	// Refuse logins to accounts locked after too many failures, without checking the password
+	if lockedFor := user.LockedFor(time.Now()); lockedFor > 0 {
+		logging.FromGin(c).Warn("login failed", "reason", "account locked", "user_id", user.ID)
+		metrics.Logins.WithLabelValues("failure").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		apperror.Abort(c, apperror.New(apperror.CodeTooManyRequests, "Account Temporarily Locked"))
 		return
 	}
 	err = user.CheckPassword(payload.Password)
 	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"Error": "Invalid User Credentials"})
-		c.Abort()
+		logging.FromGin(c).Warn("login failed", "reason", "wrong password", "user_id", user.ID)
+		metrics.Logins.WithLabelValues("failure").Inc()
+		if err := user.RecordFailedLogin(); err != nil {
+			logging.FromGin(c).Error("could not record failed login", "user_id", user.ID, "error", err.Error())
+		}
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Invalid User Credentials"))
 		return
 	}
-	jwtWrapper := auth.JwtWrapper{
-		SecretKey:         "verysecretkey",
-		Issuer:            "AuthService",
-		ExpirationMinutes: 120,
-		ExpirationHours:   12,
+	if err := user.ResetFailedLogins(); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Update User"))
+		return
 	}
-	signedToken, err := jwtWrapper.GenerateToken(user.Email)
-	if err != nil {
-		log.Println(err)
-		c.JSON(500, gin.H{
-			"Error": "Error Signing Token",
+	if !user.IsEmailVerified() {
+		metrics.Logins.WithLabelValues("failure").Inc()
+		apperror.Abort(c, apperror.Forbidden("Email Not Verified"))
+		return
+	}
+	completeLogin(c, &user)
+}
+
+// completeLogin is called once the first factor of a login is checked
+// With 2FA enabled, it only returns a token to trade for real tokens with a code, see LoginMFA
+// A user disabled by an admin cannot log in
+func completeLogin(c *gin.Context, user *models.User) {
+	if user.IsDisabled() {
+		logging.FromGin(c).Warn("login failed", "reason", "account disabled", "user_id", user.ID)
+		metrics.Logins.WithLabelValues("failure").Inc()
+		apperror.Abort(c, apperror.Forbidden("Account Disabled"))
+		return
+	}
+	if user.TOTPEnabled {
+		mfaToken, err := auth.Default.GenerateMFAToken(user.ID, user.Email, MFATokenTTL)
+		if err != nil {
+			apperror.Abort(c, apperror.Internal(err, "Error Signing Token"))
+			return
+		}
+		metrics.TokensIssued.WithLabelValues("mfa").Inc()
+		c.JSON(http.StatusOK, MFARequiredResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int(MFATokenTTL.Seconds()),
 		})
-		c.Abort()
 		return
 	}
-	signedtoken, err := jwtWrapper.RefreshToken(user.Email)
+	issueTokens(c, user)
+}
+
+// issueTokens completes a login: it records a session and generates a token and a refresh token
+// for the user, and returns them with a 200 status code
+// Logging in cancels the scheduled deletion of the account
+func issueTokens(c *gin.Context, user *models.User) {
+	if user.DeletionRequestedAt != nil {
+		if err := user.CancelDeletion(); err != nil {
+			apperror.Abort(c, apperror.Internal(err, "Could Not Update User"))
+			return
+		}
+		logging.FromGin(c).Info("account deletion cancelled", "user_id", user.ID)
+	}
+	// Record the login as a session, its tokens are rejected once it is revoked
+	session, err := models.CreateSession(user.ID, c.Request.UserAgent(), c.ClientIP(), auth.Default.RefreshTokenTTL())
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Create Session"))
+		return
+	}
+	signedToken, err := auth.Default.GenerateToken(user.ID, user.Email, session.ID)
 	if err != nil {
-		log.Println(err)
-		c.JSON(500, gin.H{
-			"Error": "Error Signing Token",
-		})
-		c.Abort()
+		apperror.Abort(c, apperror.Internal(err, "Error Signing Token"))
 		return
 	}
+	signedtoken, err := auth.Default.RefreshToken(user.ID, user.Email, session.ID)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Error Signing Token"))
+		return
+	}
+	metrics.Logins.WithLabelValues("success").Inc()
+	metrics.TokensIssued.WithLabelValues("access").Inc()
+	metrics.TokensIssued.WithLabelValues("refresh").Inc()
 	tokenResponse := LoginResponse{
 		Token:        signedToken,
 		RefreshToken: signedtoken,
@@ -144,8 +247,66 @@ func (ctrl UserController) Login(c *gin.Context) {
 	c.JSON(200, tokenResponse)
 }
 
+// Refresh is a controller function that trades a refresh token for a new access token of the same session
+// Only refresh tokens are accepted, and only while their session is active and the user is not disabled
+// It returns the new token with the refresh token, or a 401 status code
+
+// @Summary Refresh Token
+// @Description Trade a refresh token for a new access token
+// @Tags User
+// @ID RefreshToken
+// @Param EnterDetails body RefreshPayload true "Refresh token"
+// @Accept json
+// @Success 200 {object} LoginResponse "Success"
+// @Failure 401 {string} string "Error"
+// @Router /public/token/refresh [POST]
+func (ctrl UserController) Refresh(c *gin.Context) {
+	var payload RefreshPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	claims, err := auth.Default.ValidateToken(payload.RefreshToken)
+	if err != nil || claims.Purpose != auth.PurposeRefresh {
+		apperror.Abort(c, apperror.Unauthorized("Invalid Or Expired Refresh Token"))
+		return
+	}
+	active, err := models.TouchSession(claims.SessionID, claims.ID, c.ClientIP())
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Check Session"))
+		return
+	}
+	if !active {
+		apperror.Abort(c, apperror.Unauthorized("Session Revoked Or Expired"))
+		return
+	}
+	var user models.User
+	if err := database.GlobalDB.First(&user, claims.ID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apperror.Abort(c, apperror.Unauthorized("Invalid Or Expired Refresh Token"))
+			return
+		}
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+		return
+	}
+	if user.IsDisabled() {
+		apperror.Abort(c, apperror.Forbidden("Account Disabled"))
+		return
+	}
+	signedToken, err := auth.Default.GenerateToken(user.ID, user.Email, claims.SessionID)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Error Signing Token"))
+		return
+	}
+	metrics.TokensIssued.WithLabelValues("access").Inc()
+	c.JSON(http.StatusOK, LoginResponse{
+		Token:        signedToken,
+		RefreshToken: payload.RefreshToken,
+	})
+}
+
 // Profile is a controller function that retrieves the user profile from the database
-// based on the email provided in the authorization middleware.
+// based on the user ID provided in the authorization middleware.
 // It returns a 404 status code if the user is not found,
 // and a 500 status code if an error occurs while retrieving the user profile.
 
@@ -161,20 +322,16 @@ func (ctrl UserController) Login(c *gin.Context) {
 func (ctrl UserController) Profile(c *gin.Context) {
 	// Initialize a user model
 	var user models.User
-	// Get the eamil from the authorization middleware
-	email, _ := c.Get("email")
-	// Query the database for the user
-	result := database.GlobalDB.Where("email = ?", email.(string)).First(&user)
+	// Query the database for the user of the token, its email may have changed since it was issued
+	result := database.GlobalDB.First(&user, c.GetUint("user_id"))
 	// If the user is not found, return a 404 status code
 	if result.Error == gorm.ErrRecordNotFound {
-		c.JSON(http.StatusNotFound, gin.H{"Error": "User Not Found"})
-		c.Abort()
+		apperror.Abort(c, apperror.NotFound("User Not Found"))
 		return
 	}
 	// If an error occurs while retriving the user profile, return a 500 status code
 	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error": "Could Not Get User Profile"})
-		c.Abort()
+		apperror.Abort(c, apperror.Internal(result.Error, "Could Not Get User Profile"))
 		return
 	}
 	// Set the user's password to an empty string
@@ -0,0 +1,362 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/auth"
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/metrics"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/totp"
+	"gorm.io/gorm"
+)
+
+// MFATokenTTL is the lifetime of the token returned by the password step of a two-step login
+const MFATokenTTL = 5 * time.Minute
+
+// defaultTOTPIssuer is the issuer shown by authenticator apps when TOTP_ISSUER is not set
+const defaultTOTPIssuer = "go-huang"
+
+// MFALoginPayload is a struct that contains the fields of the second step of a login
+type MFALoginPayload struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// CodePayload is a struct that contains a TOTP code or a recovery code
+type CodePayload struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTOTPPayload is a struct that contains the fields to disable 2FA
+type DisableTOTPPayload struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// TOTPEnrollmentResponse is the response of a TOTP enrollment
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// RecoveryCodesResponse contains recovery codes, they are only shown once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginMFA is a function that handles the second step of a login with 2FA
+// It takes the MFA token returned by Login and a TOTP code or a recovery code
+// If successful, it returns a 200 status code with the token and refresh token
+// Wrong codes count as failed logins, so the account gets locked like for wrong passwords
+
+// @Summary Login User With 2FA
+// @Description Trade the MFA token of the password step and a code for tokens
+// @Tags User
+// @ID LoginMFA
+// @Param EnterDetails body MFALoginPayload true "MFA token and code"
+// @Accept json
+// @Success 200 {object} LoginResponse "Success"
+// @Failure 401 {string} string "Error"
+// @Router /public/login/mfa [POST]
+func (ctrl UserController) LoginMFA(c *gin.Context) {
+	var payload MFALoginPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	claims, err := auth.Default.ValidateToken(payload.MFAToken)
+	if err != nil || claims.Purpose != auth.PurposeMFA {
+		metrics.Logins.WithLabelValues("failure").Inc()
+		apperror.Abort(c, apperror.Unauthorized("Invalid Or Expired MFA Token"))
+		return
+	}
+	var user models.User
+	if err := database.GlobalDB.First(&user, claims.ID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apperror.Abort(c, apperror.Unauthorized("Invalid Or Expired MFA Token"))
+			return
+		}
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+		return
+	}
+	if user.IsDisabled() {
+		metrics.Logins.WithLabelValues("failure").Inc()
+		apperror.Abort(c, apperror.Forbidden("Account Disabled"))
+		return
+	}
+	if lockedFor := user.LockedFor(time.Now()); lockedFor > 0 {
+		logging.FromGin(c).Warn("login failed", "reason", "account locked", "user_id", user.ID)
+		metrics.Logins.WithLabelValues("failure").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+		apperror.Abort(c, apperror.New(apperror.CodeTooManyRequests, "Account Temporarily Locked"))
+		return
+	}
+	factor, err := user.VerifySecondFactor(payload.Code)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Verify Code"))
+		return
+	}
+	if factor == "" {
+		logging.FromGin(c).Warn("login failed", "reason", "wrong second factor", "user_id", user.ID)
+		metrics.Logins.WithLabelValues("failure").Inc()
+		if err := user.RecordFailedLogin(); err != nil {
+			logging.FromGin(c).Error("could not record failed login", "user_id", user.ID, "error", err.Error())
+		}
+		apperror.Abort(c, apperror.Unauthorized("Invalid Code"))
+		return
+	}
+	if err := user.ResetFailedLogins(); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Update User"))
+		return
+	}
+	if factor == models.SecondFactorRecovery {
+		logging.FromGin(c).Info("recovery code used", "user_id", user.ID)
+	}
+	issueTokens(c, &user)
+}
+
+// EnrollTOTP is a function that starts the TOTP enrollment of the current user
+// It generates a new secret and returns it with its otpauth URI, to show as a QR code
+// 2FA is only enabled once ConfirmTOTP receives a valid code
+// If 2FA is already enabled, it returns a 409 status code
+
+// @Summary Enroll TOTP
+// @ID EnrollTOTP
+// @Tags User
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Produce json
+// @Success 200 {object} TOTPEnrollmentResponse "Success"
+// @Failure 409 {string} string "Error"
+// @Router /protected/2fa/enroll [POST]
+func (ctrl UserController) EnrollTOTP(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		apperror.Abort(c, apperror.Conflict("2FA Already Enabled"))
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Generate Secret"))
+		return
+	}
+	if err := user.StartTOTPEnrollment(secret); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Update User"))
+		return
+	}
+	c.JSON(http.StatusOK, TOTPEnrollmentResponse{
+		Secret:     secret,
+		OtpauthURI: totp.URI(totpIssuer(), user.Email, secret),
+	})
+}
+
+// ConfirmTOTP is a function that completes the TOTP enrollment of the current user
+// It checks a code of the new secret, enables 2FA and returns the recovery codes
+// If the code is wrong, it returns a 400 status code
+
+// @Summary Confirm TOTP
+// @ID ConfirmTOTP
+// @Tags User
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body CodePayload true "TOTP code"
+// @Accept json
+// @Produce json
+// @Success 200 {object} RecoveryCodesResponse "Success"
+// @Failure 400 {string} string "Error"
+// @Router /protected/2fa/confirm [POST]
+func (ctrl UserController) ConfirmTOTP(c *gin.Context) {
+	var payload CodePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		apperror.Abort(c, apperror.Conflict("2FA Already Enabled"))
+		return
+	}
+	if user.TOTPSecret == "" {
+		apperror.Abort(c, apperror.BadRequest("2FA Enrollment Not Started"))
+		return
+	}
+	valid, err := user.CheckTOTP(payload.Code)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Verify Code"))
+		return
+	}
+	if !valid {
+		apperror.Abort(c, apperror.BadRequest("Invalid Code"))
+		return
+	}
+	codes, err := user.EnableTOTP()
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Enable 2FA"))
+		return
+	}
+	logging.FromGin(c).Info("2fa enabled", "user_id", user.ID)
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP is a function that disables 2FA for the current user
+// It requires the password and a TOTP code or a recovery code
+
+// @Summary Disable TOTP
+// @ID DisableTOTP
+// @Tags User
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body DisableTOTPPayload true "Password and code"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 401 {string} string "Error"
+// @Router /protected/2fa/disable [POST]
+func (ctrl UserController) DisableTOTP(c *gin.Context) {
+	var payload DisableTOTPPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		apperror.Abort(c, apperror.BadRequest("2FA Not Enabled"))
+		return
+	}
+	if refuseLocked(c, user) {
+		return
+	}
+	if err := user.CheckPassword(payload.Password); err != nil {
+		recordFailedCheck(c, user, "wrong password")
+		apperror.Abort(c, apperror.Wrap(err, apperror.CodeUnauthorized, "Invalid User Credentials"))
+		return
+	}
+	if !verifySecondFactor(c, user, payload.Code) {
+		return
+	}
+	if err := user.DisableTOTP(); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Disable 2FA"))
+		return
+	}
+	logging.FromGin(c).Info("2fa disabled", "user_id", user.ID)
+	c.JSON(http.StatusOK, gin.H{"Message": "2FA Disabled"})
+}
+
+// RegenerateRecoveryCodes is a function that replaces the recovery codes of the current user
+// It requires a TOTP code or a recovery code, the previous recovery codes stop working
+
+// @Summary Regenerate Recovery Codes
+// @ID RegenerateRecoveryCodes
+// @Tags User
+// @Param Authorization header string true "Authorization header using the Bearer scheme"
+// @Param EnterDetails body CodePayload true "TOTP code"
+// @Accept json
+// @Produce json
+// @Success 200 {object} RecoveryCodesResponse "Success"
+// @Failure 401 {string} string "Error"
+// @Router /protected/2fa/recovery-codes [POST]
+func (ctrl UserController) RegenerateRecoveryCodes(c *gin.Context) {
+	var payload CodePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		apperror.Abort(c, apperror.BadRequest("2FA Not Enabled"))
+		return
+	}
+	if !verifySecondFactor(c, user, payload.Code) {
+		return
+	}
+	codes, err := user.RegenerateRecoveryCodes()
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Generate Recovery Codes"))
+		return
+	}
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifySecondFactor checks a code of the current user and aborts with a 401 status code if it is wrong
+// Wrong codes count as failed logins, so guessing codes locks the account like guessing passwords
+func verifySecondFactor(c *gin.Context, user *models.User, code string) bool {
+	if refuseLocked(c, user) {
+		return false
+	}
+	factor, err := user.VerifySecondFactor(code)
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Verify Code"))
+		return false
+	}
+	if factor == "" {
+		recordFailedCheck(c, user, "wrong second factor")
+		apperror.Abort(c, apperror.Unauthorized("Invalid Code"))
+		return false
+	}
+	if err := user.ResetFailedLogins(); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Update User"))
+		return false
+	}
+	return true
+}
+
+// refuseLocked aborts with a 429 status code and returns true if the account is locked after too many failures
+func refuseLocked(c *gin.Context, user *models.User) bool {
+	lockedFor := user.LockedFor(time.Now())
+	if lockedFor <= 0 {
+		return false
+	}
+	logging.FromGin(c).Warn("credential check refused", "reason", "account locked", "user_id", user.ID)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockedFor.Seconds()))))
+	apperror.Abort(c, apperror.New(apperror.CodeTooManyRequests, "Account Temporarily Locked"))
+	return true
+}
+
+// recordFailedCheck counts a wrong password or code of a logged in user as a failed login
+func recordFailedCheck(c *gin.Context, user *models.User, reason string) {
+	logging.FromGin(c).Warn("credential check failed", "reason", reason, "user_id", user.ID)
+	if err := user.RecordFailedLogin(); err != nil {
+		logging.FromGin(c).Error("could not record failed login", "user_id", user.ID, "error", err.Error())
+	}
+}
+
+// currentUser loads the user authenticated by the authorization middleware
+// It aborts the request and returns false if the user cannot be loaded
+func currentUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	userID := c.GetUint("user_id")
+	err := database.GlobalDB.First(&user, userID).Error
+	if err == gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.NotFound("User Not Found"))
+		return nil, false
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+		return nil, false
+	}
+	return &user, true
+}
+
+// totpIssuer returns the issuer shown by authenticator apps, from TOTP_ISSUER
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return defaultTOTPIssuer
+}
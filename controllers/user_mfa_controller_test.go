@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/totp"
+)
+
+// mfaUser adds a user with TOTP enabled to the in-memory database
+func mfaUser(t *testing.T, db *memDB) *models.User {
+	t.Helper()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	db.mu.Lock()
+	db.tables["users"] = append(db.tables["users"], map[string]driver.Value{
+		"id": int64(testUserID), "totp_secret": secret, "totp_enabled": true, "totp_last_step": int64(0),
+		"failed_logins": int64(0), "locked_until": nil, "deleted_at": nil,
+	})
+	db.mu.Unlock()
+	return &models.User{ID: testUserID, TOTPSecret: secret, TOTPEnabled: true}
+}
+
+// checkCode runs verifySecondFactor on a new request and returns its result and the status of its error
+func checkCode(user *models.User, code string) (bool, int) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/protected/2fa/disable", nil)
+	ok := verifySecondFactor(c, user, code)
+	var appErr *apperror.Error
+	if err := c.Errors.Last(); err != nil && errors.As(err.Err, &appErr) {
+		return ok, appErr.Status()
+	}
+	return ok, 0
+}
+
+func TestVerifySecondFactorRejectsReplayedCode(t *testing.T) {
+	db := openMemDB(t)
+	user := mfaUser(t, db)
+	step := totp.Step(time.Now())
+	code, err := totp.Code(user.TOTPSecret, step)
+	if err != nil {
+		t.Fatalf("Code() error = %v", err)
+	}
+
+	if ok, status := checkCode(user, code); !ok || status != 0 {
+		t.Fatalf("first use = %v (%d), want the code accepted", ok, status)
+	}
+	if got := db.rows("users")[0]["totp_last_step"]; got != step {
+		t.Errorf("totp_last_step = %v, want %d", got, step)
+	}
+
+	// The user is loaded again, as the next login would do
+	again := &models.User{ID: user.ID, TOTPSecret: user.TOTPSecret, TOTPEnabled: true}
+	if ok, status := checkCode(again, code); ok || status != http.StatusUnauthorized {
+		t.Errorf("replayed code = %v (%d), want it rejected with %d", ok, status, http.StatusUnauthorized)
+	}
+	// A code of an earlier step of the window is refused too
+	previous, _ := totp.Code(user.TOTPSecret, step-1)
+	if ok, status := checkCode(again, previous); ok || status != http.StatusUnauthorized {
+		t.Errorf("earlier code = %v (%d), want it rejected with %d", ok, status, http.StatusUnauthorized)
+	}
+	if got := db.rows("users")[0]["failed_logins"]; got != int64(2) {
+		t.Errorf("failed_logins = %v after two wrong codes, want 2", got)
+	}
+	// The next step is still accepted, and clears the failures
+	next, _ := totp.Code(user.TOTPSecret, step+1)
+	if ok, status := checkCode(again, next); !ok || status != 0 {
+		t.Errorf("next code = %v (%d), want it accepted", ok, status)
+	}
+	if got := db.rows("users")[0]["failed_logins"]; got != int64(0) {
+		t.Errorf("failed_logins = %v after a right code, want 0", got)
+	}
+}
+
+func TestVerifySecondFactorLockedAccount(t *testing.T) {
+	db := openMemDB(t)
+	user := mfaUser(t, db)
+	lockedUntil := time.Now().Add(time.Minute)
+	user.LockedUntil = &lockedUntil
+	code, _ := totp.Code(user.TOTPSecret, totp.Step(time.Now()))
+	if ok, status := checkCode(user, code); ok || status != http.StatusTooManyRequests {
+		t.Errorf("code of a locked account = %v (%d), want it refused with %d", ok, status, http.StatusTooManyRequests)
+	}
+	if got := db.rows("users")[0]["totp_last_step"]; got != int64(0) {
+		t.Errorf("totp_last_step = %v, a locked account must not use up its code", got)
+	}
+}
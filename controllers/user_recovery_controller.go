@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/mail"
+	"github.com/zerodot618/go-huang/models"
+	"gorm.io/gorm"
+)
+
+// mailTimeout bounds the time spent sending an email
+const mailTimeout = 10 * time.Second
+
+// EmailPayload is a struct that contains the email of a user asking for an email
+type EmailPayload struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// TokenPayload is a struct that contains a one-time token received by email
+type TokenPayload struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// ResetPasswordPayload is a struct that contains the fields to reset a forgotten password
+type ResetPasswordPayload struct {
+	Token    string `json:"token" form:"token" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required,password"`
+}
+
+// VerifyEmail is a function that verifies the email address of a user
+// It consumes the one-time token sent by email at signup, the token is read from the query or the JSON body
+// If the token is unknown, expired or already used, it returns a 400 status code
+
+// @Summary Verify Email
+// @Description Verify the email address with the token received by email
+// @ID VerifyEmail
+// @Tags User
+// @Param token query string true "Verification token"
+// @Success 200 {object} string "Success"
+// @Failure 400 {string} string "Error"
+// @Router /public/email/verify [GET]
+func (ctrl UserController) VerifyEmail(c *gin.Context) {
+	var payload TokenPayload
+	if err := c.ShouldBind(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	token, err := models.ConsumeUserToken(payload.Token, models.TokenPurposeEmailVerification)
+	if err == models.ErrInvalidToken {
+		apperror.Abort(c, apperror.BadRequest("Invalid Or Expired Token"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Verify Email"))
+		return
+	}
+	var user models.User
+	if err := database.GlobalDB.First(&user, token.UserID).Error; err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Verify Email"))
+		return
+	}
+	if err := user.MarkEmailVerified(); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Verify Email"))
+		return
+	}
+	logging.FromGin(c).Info("email verified", "user_id", user.ID)
+	c.JSON(http.StatusOK, gin.H{"Message": "Email Verified"})
+}
+
+// ResendVerification is a function that sends a new verification email
+// It always returns a 200 status code and sends the email in the background,
+// so neither the response nor its time tell which emails have an account
+
+// @Summary Resend Verification Email
+// @ID ResendVerification
+// @Tags User
+// @Param EnterDetails body EmailPayload true "Email"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 400 {string} string "Error"
+// @Router /public/email/resend [POST]
+func (ctrl UserController) ResendVerification(c *gin.Context) {
+	var payload EmailPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	var user models.User
+	err := database.GlobalDB.Where("email = ?", payload.Email).First(&user).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+		return
+	}
+	if err == nil && !user.IsEmailVerified() {
+		sendVerificationEmail(c, &user)
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "If the account exists and is not verified, a verification email was sent"})
+}
+
+// ForgotPassword is a function that sends a password reset link to a user
+// It always returns a 200 status code and sends the email in the background,
+// so neither the response nor its time tell which emails have an account
+
+// @Summary Forgot Password
+// @ID ForgotPassword
+// @Tags User
+// @Param EnterDetails body EmailPayload true "Email"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 400 {string} string "Error"
+// @Router /public/password/forgot [POST]
+func (ctrl UserController) ForgotPassword(c *gin.Context) {
+	var payload EmailPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	var user models.User
+	err := database.GlobalDB.Where("email = ?", payload.Email).First(&user).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Get User"))
+		return
+	}
+	if err == nil {
+		sendPasswordResetEmail(c, &user)
+	}
+	c.JSON(http.StatusOK, gin.H{"Message": "If the account exists, a password reset email was sent"})
+}
+
+// ResetPassword is a function that sets a new password with the token of a password reset email
+// It reads a JSON body or the form of ResetPasswordForm
+// The token can only be used once, the account is unlocked and its email is considered verified
+// Every session of the user is revoked
+
+// @Summary Reset Password
+// @ID ResetPassword
+// @Tags User
+// @Param EnterDetails body ResetPasswordPayload true "Token and new password"
+// @Accept json
+// @Success 200 {object} string "Success"
+// @Failure 400 {string} string "Error"
+// @Router /public/password/reset [POST]
+func (ctrl UserController) ResetPassword(c *gin.Context) {
+	var payload ResetPasswordPayload
+	if err := c.ShouldBind(&payload); err != nil {
+		apperror.Abort(c, apperror.Binding(err))
+		return
+	}
+	token, err := models.ConsumeUserToken(payload.Token, models.TokenPurposePasswordReset)
+	if err == models.ErrInvalidToken {
+		apperror.Abort(c, apperror.BadRequest("Invalid Or Expired Token"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Reset Password"))
+		return
+	}
+	var user models.User
+	if err := database.GlobalDB.First(&user, token.UserID).Error; err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Reset Password"))
+		return
+	}
+	if err := user.UpdatePassword(payload.Password); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Reset Password"))
+		return
+	}
+	// Receiving the reset email proves the user owns the address
+	if !user.IsEmailVerified() {
+		if err := user.MarkEmailVerified(); err != nil {
+			apperror.Abort(c, apperror.Internal(err, "Could Not Reset Password"))
+			return
+		}
+	}
+	// Whoever knew the old password is logged out
+	if _, err := models.RevokeUserSessions(user.ID); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Reset Password"))
+		return
+	}
+	logging.FromGin(c).Info("password reset", "user_id", user.ID)
+	c.JSON(http.StatusOK, gin.H{"Message": "Password Reset"})
+}
+
+// ResetPasswordForm is a function that serves the page of the password reset link
+// It is a form posting the token of the link and the new password to ResetPassword.
+// PASSWORD_RESET_URL replaces it with the page of a front end
+
+// @Summary Password Reset Form
+// @ID ResetPasswordForm
+// @Tags User
+// @Param token query string true "Password reset token"
+// @Produce html
+// @Success 200 {string} string "Success"
+// @Router /public/password/reset [GET]
+func (ctrl UserController) ResetPasswordForm(c *gin.Context) {
+	var page strings.Builder
+	if err := resetPasswordPage.Execute(&page, c.Query("token")); err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Render Page"))
+		return
+	}
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page.String()))
+}
+
+// resetPasswordPage is the page of ResetPasswordForm, the token is its data
+var resetPasswordPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset your password</title></head>
+<body>
+<h1>Reset your password</h1>
+<form method="post" action="/api/public/password/reset">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+</body>
+</html>
+`))
+
+// sendVerificationEmail creates a verification token for a user and emails the verification link, in the background
+func sendVerificationEmail(c *gin.Context, user *models.User) {
+	runInBackground(c, "verification email", func(ctx context.Context) error {
+		token, err := models.CreateUserToken(user.ID, models.TokenPurposeEmailVerification, models.EmailVerificationTokenTTL)
+		if err != nil {
+			return err
+		}
+		link := appURL("/api/public/email/verify", token)
+		return mail.Default.Send(ctx, mail.VerificationEmail(user.Email, user.Name, link, models.EmailVerificationTokenTTL))
+	}, "user_id", user.ID)
+}
+
+// sendPasswordResetEmail creates a password reset token for a user and emails the reset link, in the background
+// The link opens ResetPasswordForm, or the page of PASSWORD_RESET_URL
+func sendPasswordResetEmail(c *gin.Context, user *models.User) {
+	runInBackground(c, "password reset email", func(ctx context.Context) error {
+		token, err := models.CreateUserToken(user.ID, models.TokenPurposePasswordReset, models.PasswordResetTokenTTL)
+		if err != nil {
+			return err
+		}
+		link := os.Getenv("PASSWORD_RESET_URL")
+		if link == "" {
+			link = appURL("/api/public/password/reset", token)
+		} else {
+			link += "?token=" + url.QueryEscape(token)
+		}
+		return mail.Default.Send(ctx, mail.PasswordResetEmail(user.Email, user.Name, link, models.PasswordResetTokenTTL))
+	}, "user_id", user.ID)
+}
+
+// sendMail sends an email with the default mailer within mailTimeout
+func sendMail(c *gin.Context, msg mail.Message) error {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), mailTimeout)
+	defer cancel()
+	return mail.Default.Send(ctx, msg)
+}
+
+// sendMailInBackground sends an email with the default mailer, without holding the request
+// The response time does not depend on the mailer, so it does not tell whether an email was sent
+func sendMailInBackground(c *gin.Context, msg mail.Message, what string, args ...any) {
+	runInBackground(c, what, func(ctx context.Context) error {
+		return mail.Default.Send(ctx, msg)
+	}, args...)
+}
+
+// runInBackground runs the sending of an email after the request, within mailTimeout
+// A failure is logged with the attributes
+func runInBackground(c *gin.Context, what string, send func(ctx context.Context) error, args ...any) {
+	logger := logging.FromGin(c)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := send(ctx); err != nil {
+			logger.Error("could not send "+what, append(args, "error", err.Error())...)
+		}
+	}()
+}
+
+// appURL builds an absolute link to the service from APP_URL, a path and a token
+func appURL(path, token string) string {
+	base := strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8088"
+	}
+	return base + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,217 @@
+// Package cron implements the standard five-field cron schedules used by the scheduled scans:
+// minute, hour, day of the month, month and day of the week, with lists, ranges, steps and names,
+// and the @hourly, @daily, @weekly, @monthly and @yearly shortcuts.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxYears bounds the search of the next time, e.g. February 30 never comes
+const maxYears = 5
+
+// ErrInvalidSchedule is returned for a malformed schedule
+var ErrInvalidSchedule = errors.New("invalid cron schedule")
+
+// allHours is the set of the hour field of a schedule running every hour
+const allHours = 1<<24 - 1
+
+// shortcuts are the schedules named by a shortcut
+var shortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field is the range of a field of a schedule and its names
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+	// 7 is Sunday too
+	{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+}
+
+// Schedule is a parsed cron schedule, every field is the set of its allowed values
+// As in cron, a time matches if its day matches the day of the month or the day of the week
+// when both are restricted, and the one restricted otherwise
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	spec                          string
+}
+
+// Parse parses a schedule like */15 9-17 * * MON-FRI or a shortcut like @daily
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	expanded := spec
+	if strings.HasPrefix(spec, "@") {
+		var ok bool
+		if expanded, ok = shortcuts[strings.ToLower(spec)]; !ok {
+			return nil, fmt.Errorf("%w: unknown shortcut %q", ErrInvalidSchedule, spec)
+		}
+	}
+	parts := strings.Fields(expanded)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%w: %q must have %d fields", ErrInvalidSchedule, spec, len(fields))
+	}
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	// Sunday is 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &Schedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: parts[2] == "*" || parts[2] == "?",
+		dowStar: parts[4] == "*" || parts[4] == "?",
+		spec:    spec,
+	}, nil
+}
+
+// String returns the schedule as given to Parse
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time matching the schedule strictly after a time, to the minute, in the location of the time
+// It returns false if no time matches in the next years
+func (s *Schedule) Next(after time.Time) (time.Time, bool) {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxYears, 0, 0)
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case !has(s.month, int(t.Month())):
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !has(s.hour, t.Hour()):
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !has(s.minute, t.Minute()), s.repeated(t, after):
+			next = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+		// A daylight saving change can move a wall clock time back, the search always moves forward
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}, false
+}
+
+// repeated reports whether the wall clock time of t is not after the one of after, as when daylight saving ends
+// and an hour is repeated. As in cron, the schedules at a fixed hour run once in it, those of every hour run twice
+func (s *Schedule) repeated(t, after time.Time) bool {
+	if s.hour == allHours {
+		return false
+	}
+	wall := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	}
+	return !wall(t).After(wall(after))
+}
+
+// dayMatches reports whether the day of a time matches the schedule
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// parseField parses a comma-separated list of values, ranges and steps like 1,5-10,*/15 to a set of values
+func parseField(s string, f field) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		invalid := fmt.Errorf("%w: invalid %s %q", ErrInvalidSchedule, f.name, part)
+		spec, stepValue, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepValue); err != nil || step < 1 {
+				return 0, invalid
+			}
+		}
+		var low, high int
+		switch {
+		case spec == "*" || spec == "?":
+			low, high = f.min, f.max
+		case strings.Contains(spec, "-"):
+			from, to, _ := strings.Cut(spec, "-")
+			var okFrom, okTo bool
+			low, okFrom = f.value(from)
+			high, okTo = f.value(to)
+			if !okFrom || !okTo || low > high {
+				return 0, invalid
+			}
+		default:
+			var ok bool
+			if low, ok = f.value(spec); !ok {
+				return 0, invalid
+			}
+			high = low
+			// 5/10 is every 10 from 5, as in cron
+			if stepped {
+				high = f.max
+			}
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// value parses a value of a field, a number or a name
+func (f field) value(s string) (int, bool) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, true
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, false
+	}
+	return v, true
+}
+
+// has reports whether a set holds a value
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
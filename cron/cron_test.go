@@ -0,0 +1,240 @@
+package cron
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// runs returns the n times of a schedule following start, formatted with their weekday and zone
+func runs(t *testing.T, spec string, start time.Time, n int) []string {
+	t.Helper()
+	s, err := Parse(spec)
+	if err != nil {
+		t.Fatalf("Parse(%q) error = %v", spec, err)
+	}
+	var got []string
+	prev := start
+	for i := 0; i < n; i++ {
+		next, ok := s.Next(prev)
+		if !ok {
+			break
+		}
+		if !next.After(prev) {
+			t.Fatalf("Next(%s) = %s, not after it", prev, next)
+		}
+		got = append(got, next.Format("2006-01-02 Mon 15:04 MST"))
+		prev = next
+	}
+	return got
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/15 9-17 * * MON-FRI", false},
+		{"0,30 0-6/2 1,15 jan-jun sun", false},
+		{"5/20 * * * *", false},
+		{"0 0 ? * 7", false},
+		{"@Daily", false},
+		{"  @hourly  ", false},
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * 32 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"*/0 * * * *", true},
+		{"*/x * * * *", true},
+		{"10-5 * * * *", true},
+		{"1- * * * *", true},
+		{"* * * FOO *", true},
+		{"1,,2 * * * *", true},
+		{"@every 5m", true},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr && !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalidSchedule", tt.spec, err)
+		}
+		if !tt.wantErr && s.String() == "" {
+			t.Errorf("Parse(%q).String() is empty", tt.spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	// The first three runs, fewer for the schedules that never run
+	tests := []struct {
+		name  string
+		spec  string
+		start time.Time
+		want  []string
+	}{
+		{
+			name:  "every minute, seconds are dropped",
+			spec:  "* * * * *",
+			start: time.Date(2026, time.October, 19, 10, 0, 30, 0, time.UTC),
+			want:  []string{"2026-10-19 Mon 10:01 UTC", "2026-10-19 Mon 10:02 UTC", "2026-10-19 Mon 10:03 UTC"},
+		},
+		{
+			name:  "office hours over a weekend",
+			spec:  "*/15 9-17 * * MON-FRI",
+			start: at(2026, time.October, 23, 17, 40),
+			want:  []string{"2026-10-23 Fri 17:45 UTC", "2026-10-26 Mon 09:00 UTC", "2026-10-26 Mon 09:15 UTC"},
+		},
+		{
+			name:  "step from a value",
+			spec:  "5/20 * * * *",
+			start: at(2026, time.October, 19, 10, 5),
+			want:  []string{"2026-10-19 Mon 10:25 UTC", "2026-10-19 Mon 10:45 UTC", "2026-10-19 Mon 11:05 UTC"},
+		},
+		{
+			name:  "step over a range",
+			spec:  "0 0-6/3 * * *",
+			start: at(2026, time.October, 19, 0, 0),
+			want:  []string{"2026-10-19 Mon 03:00 UTC", "2026-10-19 Mon 06:00 UTC", "2026-10-20 Tue 00:00 UTC"},
+		},
+		{
+			name:  "month names",
+			spec:  "0 0 1 jan,jul *",
+			start: at(2026, time.October, 19, 0, 0),
+			want:  []string{"2027-01-01 Fri 00:00 UTC", "2027-07-01 Thu 00:00 UTC", "2028-01-01 Sat 00:00 UTC"},
+		},
+		{
+			name:  "day of month or day of week when both are set",
+			spec:  "0 12 1 * MON",
+			start: at(2026, time.October, 27, 0, 0),
+			want:  []string{"2026-11-01 Sun 12:00 UTC", "2026-11-02 Mon 12:00 UTC", "2026-11-09 Mon 12:00 UTC"},
+		},
+		{
+			name:  "day of month only",
+			spec:  "0 0 31 * *",
+			start: at(2026, time.October, 31, 0, 0),
+			want:  []string{"2026-12-31 Thu 00:00 UTC", "2027-01-31 Sun 00:00 UTC", "2027-03-31 Wed 00:00 UTC"},
+		},
+		{
+			name:  "day of week only, with ? for the day of month",
+			spec:  "30 8 ? * SAT",
+			start: at(2026, time.October, 19, 0, 0),
+			want:  []string{"2026-10-24 Sat 08:30 UTC", "2026-10-31 Sat 08:30 UTC", "2026-11-07 Sat 08:30 UTC"},
+		},
+		{
+			name:  "sunday as 7",
+			spec:  "0 0 * * 7",
+			start: at(2026, time.October, 19, 0, 0),
+			want:  []string{"2026-10-25 Sun 00:00 UTC", "2026-11-01 Sun 00:00 UTC", "2026-11-08 Sun 00:00 UTC"},
+		},
+		{
+			name:  "leap day",
+			spec:  "0 0 29 2 *",
+			start: at(2026, time.October, 19, 0, 0),
+			want:  []string{"2028-02-29 Tue 00:00 UTC", "2032-02-29 Sun 00:00 UTC", "2036-02-29 Fri 00:00 UTC"},
+		},
+		{
+			name:  "a day that never comes",
+			spec:  "0 0 30 2 *",
+			start: at(2026, time.October, 19, 0, 0),
+			want:  nil,
+		},
+		{
+			name:  "weekly shortcut",
+			spec:  "@weekly",
+			start: at(2026, time.October, 19, 0, 0),
+			want:  []string{"2026-10-25 Sun 00:00 UTC", "2026-11-01 Sun 00:00 UTC", "2026-11-08 Sun 00:00 UTC"},
+		},
+		{
+			name:  "yearly shortcut",
+			spec:  "@yearly",
+			start: at(2026, time.December, 31, 23, 59),
+			want:  []string{"2027-01-01 Fri 00:00 UTC", "2028-01-01 Sat 00:00 UTC", "2029-01-01 Mon 00:00 UTC"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runs(t, tt.spec, tt.start, 3); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, newYork)
+	}
+	tests := []struct {
+		name  string
+		spec  string
+		start time.Time
+		want  []string
+	}{
+		{
+			name:  "daily time kept over the spring change",
+			spec:  "0 9 * * *",
+			start: at(time.March, 7, 12, 0),
+			want:  []string{"2026-03-08 Sun 09:00 EDT", "2026-03-09 Mon 09:00 EDT"},
+		},
+		{
+			name:  "time skipped by the spring change",
+			spec:  "30 2 * * *",
+			start: at(time.March, 7, 12, 0),
+			want:  []string{"2026-03-09 Mon 02:30 EDT", "2026-03-10 Tue 02:30 EDT"},
+		},
+		{
+			name:  "hourly over the spring change",
+			spec:  "0 * * * *",
+			start: at(time.March, 8, 0, 30),
+			want:  []string{"2026-03-08 Sun 01:00 EST", "2026-03-08 Sun 03:00 EDT", "2026-03-08 Sun 04:00 EDT"},
+		},
+		{
+			name:  "hourly over the autumn change",
+			spec:  "0 * * * *",
+			start: at(time.November, 1, 0, 30),
+			want:  []string{"2026-11-01 Sun 01:00 EDT", "2026-11-01 Sun 01:00 EST", "2026-11-01 Sun 02:00 EST"},
+		},
+		{
+			name:  "daily time in the hour repeated by the autumn change",
+			spec:  "30 1 * * *",
+			start: at(time.November, 1, 0, 0),
+			want:  []string{"2026-11-01 Sun 01:30 EDT", "2026-11-02 Mon 01:30 EST"},
+		},
+		{
+			name:  "fixed hour with steps in the repeated hour",
+			spec:  "*/20 1 * * *",
+			start: at(time.November, 1, 0, 30),
+			want:  []string{"2026-11-01 Sun 01:00 EDT", "2026-11-01 Sun 01:20 EDT", "2026-11-01 Sun 01:40 EDT", "2026-11-02 Mon 01:00 EST"},
+		},
+		{
+			name:  "daily time kept over the autumn change",
+			spec:  "0 9 * * *",
+			start: at(time.October, 31, 12, 0),
+			want:  []string{"2026-11-01 Sun 09:00 EST", "2026-11-02 Mon 09:00 EST"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runs(t, tt.spec, tt.start, len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
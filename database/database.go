@@ -1,10 +1,11 @@
 package database
 
 import (
+	"context"
 	"fmt"
-	"log"
 
 	"github.com/joho/godotenv"
+	"github.com/zerodot618/go-huang/metrics"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -14,12 +15,12 @@ var GlobalDB *gorm.DB
 
 // InitDatabase creates a mysql db connecntion and stores it in the GlobalDB variable
 // It reads the environment variables from the .env file and uses them to create the connection
-// It returns an error if the connection fails
+// It returns an error if the .env file cannot be read or the connection fails
 func InitDatabase() (err error) {
 	// Read the environment variables from the .env file
 	config, err := godotenv.Read()
 	if err != nil {
-		log.Fatal("Error reading .env file")
+		return fmt.Errorf("reading .env file: %w", err)
 	}
 	// Get database connection details from environment variables
 	dbHost := config["DB_HOST"]
@@ -40,7 +41,34 @@ func InitDatabase() (err error) {
 	// Create the connection and store it in the GlobalDB variable
 	GlobalDB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
 	if err != nil {
-		return
+		return fmt.Errorf("connecting to mysql at %s:%s: %w", dbHost, dbPort, err)
 	}
+	// Observe the query durations and the connection pool stats
+	err = metrics.RegisterGorm(GlobalDB)
 	return
 }
+
+// Ping checks that the database is reachable
+func Ping(ctx context.Context) error {
+	if GlobalDB == nil {
+		return fmt.Errorf("database is not initialized")
+	}
+	sqlDB, err := GlobalDB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close closes the connection pool of the database
+// It has the signature of a lifecycle hook and ignores the context
+func Close(ctx context.Context) error {
+	if GlobalDB == nil {
+		return nil
+	}
+	sqlDB, err := GlobalDB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,72 @@
+// Package lifecycle coordinates the graceful shutdown of the service.
+// Components that own background work or connections register a hook,
+// and the hooks are run in reverse registration order once the HTTP server is drained.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Hook is a function releasing a component on shutdown
+type Hook func(ctx context.Context) error
+
+type namedHook struct {
+	name string
+	fn   Hook
+}
+
+var (
+	mu           sync.Mutex
+	hooks        []namedHook
+	shuttingDown atomic.Bool
+	// done is closed by BeginShutdown
+	done     = make(chan struct{})
+	doneOnce sync.Once
+)
+
+// OnShutdown registers a hook run by Shutdown
+// Hooks run in reverse order, so a component registered after the database is stopped before it
+func OnShutdown(name string, fn Hook) {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks = append(hooks, namedHook{name: name, fn: fn})
+}
+
+// BeginShutdown marks the service as shutting down, readiness checks fail from then on
+func BeginShutdown() {
+	shuttingDown.Store(true)
+	doneOnce.Do(func() { close(done) })
+}
+
+// Done returns a channel closed once the service is shutting down
+// The long-lived requests, like the event streams, end on it so the server can drain them
+func Done() <-chan struct{} {
+	return done
+}
+
+// ShuttingDown reports whether the service is shutting down
+func ShuttingDown() bool {
+	return shuttingDown.Load()
+}
+
+// Shutdown runs the registered hooks in reverse order
+// Every hook is run even if a previous one failed, the errors are joined
+func Shutdown(ctx context.Context) error {
+	BeginShutdown()
+	mu.Lock()
+	pending := hooks
+	hooks = nil
+	mu.Unlock()
+
+	var errs []error
+	for i := len(pending) - 1; i >= 0; i-- {
+		if err := pending[i].fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", pending[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+// Package logging provides the structured JSON logger of the service
+// and the request-scoped loggers handed to controllers.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loggerKey is the key of the request-scoped logger in gin and request contexts
+const loggerKey = "logger"
+
+type contextKey struct{}
+
+// Default is the logger used outside of requests
+var Default = New(os.Getenv("LOG_LEVEL"))
+
+// New creates a JSON logger writing to stdout at the given level (debug, info, warn or error)
+func New(level string) *slog.Logger {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "warn", "warning":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		lvl = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lvl}))
+}
+
+// Set stores a request-scoped logger in the gin context and in the request context
+func Set(c *gin.Context, logger *slog.Logger) {
+	c.Set(loggerKey, logger)
+	c.Request = c.Request.WithContext(WithContext(c.Request.Context(), logger))
+}
+
+// FromGin returns the request-scoped logger of a gin context, or Default if there is none
+func FromGin(c *gin.Context) *slog.Logger {
+	if v, ok := c.Get(loggerKey); ok {
+		if logger, ok := v.(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return FromContext(c.Request.Context())
+}
+
+// WithContext returns a copy of ctx carrying logger
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or Default if there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return Default
+}
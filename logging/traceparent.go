@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceparentHeader is the W3C Trace Context header, see https://www.w3.org/TR/trace-context/
+const TraceparentHeader = "traceparent"
+
+// TraceContext is the W3C trace context of a request
+type TraceContext struct {
+	TraceID  string // 32 hex characters shared by every span of the trace
+	ParentID string // 16 hex characters of the caller span, empty for a new trace
+	SpanID   string // 16 hex characters of the span handling this request
+	Flags    string // 2 hex characters, "01" when the trace is sampled
+}
+
+// ParseTraceparent reads an incoming traceparent header and starts a new span in its trace
+// A new trace is started when the header is missing or invalid
+func ParseTraceparent(header string) TraceContext {
+	trace := TraceContext{SpanID: randomHex(8), Flags: "01"}
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) >= 4 && len(parts[0]) == 2 && parts[0] != "ff" &&
+		isHex(parts[1], 32) && isHex(parts[2], 16) && isHex(parts[3], 2) {
+		trace.TraceID = parts[1]
+		trace.ParentID = parts[2]
+		trace.Flags = parts[3]
+		return trace
+	}
+	trace.TraceID = randomHex(16)
+	return trace
+}
+
+// String formats the trace context as the traceparent of the current span
+func (t TraceContext) String() string {
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceID, t.SpanID, t.Flags)
+}
+
+// isHex reports whether s is n lower case hex characters and not all zeros
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,63 @@
+// Package mail sends the emails of the service through a Mailer.
+// SMTPMailer delivers them to an SMTP server, LogMailer only logs them for development.
+package mail
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/zerodot618/go-huang/logging"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default is the mailer used by the controllers, set by main from the environment
+var Default Mailer = LogMailer{}
+
+// NewFromEnv returns an SMTPMailer if SMTP_HOST is set, or a LogMailer otherwise
+// The SMTP server is configured by SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM
+func NewFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogMailer{}
+	}
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil || port == 0 {
+		port = 587
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// LogMailer is a Mailer that writes the emails to the log instead of sending them
+type LogMailer struct{}
+
+// Send logs the email
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	logging.FromContext(ctx).Info("email not sent, logged only",
+		"to", msg.To,
+		"subject", msg.Subject,
+		"body", msg.Body,
+	)
+	return nil
+}
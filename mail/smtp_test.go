@@ -0,0 +1,246 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// envelope is an email received by smtpServer
+type envelope struct {
+	From string
+	To   []string
+	Auth string
+	Data string
+}
+
+// smtpServer is a minimal SMTP responder recording the emails it receives
+type smtpServer struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	received []envelope
+}
+
+// newSMTPServer starts an SMTP responder on a free loopback port, offering PLAIN authentication
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpServer{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// mailer returns an SMTPMailer delivering to the responder
+func (s *smtpServer) mailer() *SMTPMailer {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return &SMTPMailer{Host: "127.0.0.1", Port: addr.Port, Username: "user", Password: "secret", From: "no-reply@example.com"}
+}
+
+// emails returns the emails received so far
+func (s *smtpServer) emails() []envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]envelope(nil), s.received...)
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) { tp.PrintfLine(format, args...) }
+	reply("220 test ESMTP ready")
+	var env envelope
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250-test greets you")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if mechanism != "PLAIN" || err != nil {
+				reply("535 authentication failed")
+				continue
+			}
+			env.Auth = string(decoded)
+			reply("235 authenticated")
+		case "MAIL":
+			env.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			env.To = append(env.To, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 end with <CRLF>.<CRLF>")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			env.Data = string(data)
+			s.mu.Lock()
+			s.received = append(s.received, env)
+			s.mu.Unlock()
+			env = envelope{}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	ttl := 24 * time.Hour
+	tests := []struct {
+		name        string
+		msg         Message
+		wantSubject string
+		wantBody    []string
+	}{
+		{
+			name:        "verification",
+			msg:         VerificationEmail("ada@example.com", "Ada", "https://app.example.com/verify?token=abc", ttl),
+			wantSubject: "Verify your email address",
+			wantBody:    []string{"Hello Ada,", "https://app.example.com/verify?token=abc", "The link expires in 24 hours."},
+		},
+		{
+			name:        "password reset",
+			msg:         PasswordResetEmail("ada@example.com", "Ada", "https://app.example.com/reset?token=xyz", time.Hour),
+			wantSubject: "Reset your password",
+			wantBody:    []string{"Hello Ada,", "https://app.example.com/reset?token=xyz", "The link expires in 1 hour and can only be used once."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPServer(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := server.mailer().Send(ctx, tt.msg); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			emails := server.emails()
+			if len(emails) != 1 {
+				t.Fatalf("received %d emails, want 1", len(emails))
+			}
+			env := emails[0]
+			if env.From != "no-reply@example.com" {
+				t.Errorf("MAIL FROM = %q, want no-reply@example.com", env.From)
+			}
+			if len(env.To) != 1 || env.To[0] != "ada@example.com" {
+				t.Errorf("RCPT TO = %v, want ada@example.com", env.To)
+			}
+			if env.Auth != "\x00user\x00secret" {
+				t.Errorf("AUTH PLAIN = %q, want the username and password", env.Auth)
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(env.Data))
+			if err != nil {
+				t.Fatalf("ReadMessage() error = %v", err)
+			}
+			headers := map[string]string{
+				"From":                      "no-reply@example.com",
+				"To":                        "ada@example.com",
+				"Subject":                   tt.wantSubject,
+				"Mime-Version":              "1.0",
+				"Content-Type":              "text/plain; charset=UTF-8",
+				"Content-Transfer-Encoding": "8bit",
+			}
+			for name, want := range headers {
+				if got := msg.Header.Get(name); got != want {
+					t.Errorf("header %s = %q, want %q", name, got, want)
+				}
+			}
+			if _, err := msg.Header.Date(); err != nil {
+				t.Errorf("header Date: %v", err)
+			}
+			body, _ := io.ReadAll(msg.Body)
+			for _, want := range tt.wantBody {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("body = %q, want it to contain %q", body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSMTPMailerHeaderInjection(t *testing.T) {
+	server := newSMTPServer(t)
+	msg := Message{To: "ada@example.com", Subject: "Hi\r\nBcc: eve@example.com", Body: "Hello"}
+	if err := server.mailer().Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	emails := server.emails()
+	if len(emails) != 1 {
+		t.Fatalf("received %d emails, want 1", len(emails))
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(emails[0].Data))
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if bcc := parsed.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("header Bcc = %q, the subject injected a header", bcc)
+	}
+}
+
+func TestSMTPMailerRejectedRecipient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 test\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				conn.Write([]byte("250 test\r\n"))
+			case strings.HasPrefix(line, "RCPT"):
+				conn.Write([]byte("550 no such user\r\n"))
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+	m := &SMTPMailer{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, From: "no-reply@example.com"}
+	err = m.Send(context.Background(), Message{To: "nobody@example.com", Subject: "Hi", Body: "Hello"})
+	if err == nil || !strings.Contains(err.Error(), "rcpt") {
+		t.Errorf("Send() error = %v, want a rcpt error", err)
+	}
+}
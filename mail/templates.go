@@ -0,0 +1,129 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// VerificationEmail builds the email asking a new user to verify their address
+func VerificationEmail(to, name, link string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(`Hello %s,
+
+Please verify your email address by opening the link below:
+
+%s
+
+The link expires in %s. If you did not sign up, you can ignore this email.
+`, name, link, humanize(ttl)),
+	}
+}
+
+// PasswordResetEmail builds the email with the link to reset a forgotten password
+func PasswordResetEmail(to, name, link string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(`Hello %s,
+
+Someone asked to reset the password of your account. Open the link below to choose a new one:
+
+%s
+
+The link expires in %s and can only be used once. If you did not ask for it, you can ignore this email.
+`, name, link, humanize(ttl)),
+	}
+}
+
+// AccountExistsEmail builds the email telling the owner of an account that someone signed up with its address
+// Signup answers the same for a taken address, the owner learns about it by email
+func AccountExistsEmail(to, name string) Message {
+	return Message{
+		To:      to,
+		Subject: "You already have an account",
+		Body: fmt.Sprintf(`Hello %s,
+
+Someone tried to sign up with this email address, which already has an account.
+
+If it was you, log in instead, or ask for a password reset if you forgot your password.
+If it was not you, you can ignore this email.
+`, name),
+	}
+}
+
+// EmailChangeEmail builds the email asking a user to confirm the new address of their account
+func EmailChangeEmail(to, name, link string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf(`Hello %s,
+
+You asked to use this address for your account. Please confirm it by opening the link below:
+
+%s
+
+The link expires in %s. Until then, your account keeps its current address.
+If you did not ask for it, you can ignore this email.
+`, name, link, humanize(ttl)),
+	}
+}
+
+// EmailChangedNotice builds the email telling the previous address of an account that it was replaced
+func EmailChangedNotice(to, name, newEmail string) Message {
+	return Message{
+		To:      to,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf(`Hello %s,
+
+The email address of your account was changed to %s.
+
+If you did not make this change, reset your password and contact us at once.
+`, name, newEmail),
+	}
+}
+
+// TodoListInvitation builds the email inviting an address to a to-do list
+// It is the same whether the address has an account or not, the invitee signs up first if needed
+func TodoListInvitation(to, inviter, list, role, link string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf("%s invited you to the list %q", inviter, list),
+		Body: fmt.Sprintf(`Hello,
+
+%s invited you to the to-do list %q as %s.
+
+To accept, log in with this email address, signing up first if you have no account yet, and open the link below:
+
+%s
+
+The invitation expires in %s. If you do not want to join the list, you can ignore this email.
+`, inviter, list, role, link, humanize(ttl)),
+	}
+}
+
+// ReminderEmail builds the email reminding a user of a to-do item due soon
+func ReminderEmail(to, name, title string, dueAt time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf("Reminder: %q is due soon", title),
+		Body: fmt.Sprintf(`Hello %s,
+
+The to-do item %q is due on %s.
+
+Mark it as done once it is finished to stop the reminders.
+`, name, title, dueAt.UTC().Format("Monday, January 2 2006 at 15:04 MST")),
+	}
+}
+
+// humanize formats a duration in whole hours or minutes
+func humanize(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	return fmt.Sprintf("%d minutes", d/time.Minute)
+}
@@ -1,17 +1,42 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/joho/godotenv"
+	"github.com/zerodot618/go-huang/auth"
 	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/lifecycle"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/mail"
 	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/oidc"
+	"github.com/zerodot618/go-huang/reminder"
 	"github.com/zerodot618/go-huang/routes"
+	"github.com/zerodot618/go-huang/scans"
 
 	_ "github.com/zerodot618/go-huang/docs"
 )
 
+// Server settings
+const (
+	serverAddr        = ":8088"
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 60 * time.Second
+	idleTimeout       = 120 * time.Second
+	shutdownTimeout   = 30 * time.Second
+)
+
 // main is the entry point of the program.
 // It initializes the database, sets up the router and starts the server.
+// On SIGINT or SIGTERM it drains the in-flight requests and runs the shutdown hooks.
 
 // @title Swagger JWT API
 // @version 1.0
@@ -31,20 +56,163 @@ import (
 // @in header
 // @name Authorization
 func main() {
+	// Export the .env file to the environment, a missing file is not an error
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.Default.Error("could not load .env file", "error", err)
+	}
+	logging.Default = logging.New(os.Getenv("LOG_LEVEL"))
+	mail.Default = mail.NewFromEnv()
+	oidc.Default = oidc.NewRegistryFromEnv()
+
 	// Initialize the database
 	err := database.InitDatabase()
 	if err != nil {
 		// Log the error and exit
-		log.Fatalln("could not create database")
+		logging.Default.Error("could not initialize database", "error", err)
+		os.Exit(1)
 	}
+	// The database is closed last, after every other component is stopped
+	lifecycle.OnShutdown("database", database.Close)
 	// Automigrate the User model
 	// AutoMigrate() automatically migrates our schema, to keep our schema upto date.
-	database.GlobalDB.AutoMigrate(&models.User{})
+	if err := models.MigrateUsers(); err != nil {
+		logging.Default.Error("could not migrate users", "error", err)
+		os.Exit(1)
+	}
+	database.GlobalDB.AutoMigrate(&models.UserToken{})
+	database.GlobalDB.AutoMigrate(&models.RecoveryCode{})
+	database.GlobalDB.AutoMigrate(&models.ExternalIdentity{})
+	database.GlobalDB.AutoMigrate(&models.OIDCAuthRequest{})
+	database.GlobalDB.AutoMigrate(&models.SigningKey{})
+	database.GlobalDB.AutoMigrate(&models.APIKey{})
+	database.GlobalDB.AutoMigrate(&models.Session{})
+	database.GlobalDB.AutoMigrate(&models.AuditLog{})
+	// Purge the accounts whose deletion grace period is over
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	go models.RunAccountPurge(purgeCtx)
+	lifecycle.OnShutdown("account purge", func(ctx context.Context) error {
+		stopPurge()
+		return nil
+	})
+	// Sign the tokens with the keys of the database, shared by the instances and rotated in the background
+	jwtWrapper, err := auth.NewFromEnv(models.SigningKeyStore{})
+	if err != nil {
+		logging.Default.Error("invalid JWT settings", "error", err)
+		os.Exit(1)
+	}
+	if err := jwtWrapper.Keys.Load(context.Background()); err != nil {
+		logging.Default.Error("could not load signing keys", "error", err)
+		os.Exit(1)
+	}
+	auth.Default = jwtWrapper
+	keysCtx, stopKeys := context.WithCancel(context.Background())
+	go auth.Default.Keys.Run(keysCtx)
+	lifecycle.OnShutdown("signing keys", func(ctx context.Context) error {
+		stopKeys()
+		return nil
+	})
 	database.GlobalDB.AutoMigrate(&models.Book{})
+	if err := models.MigrateToDos(); err != nil {
+		logging.Default.Error("could not migrate the to-do items", "error", err)
+		os.Exit(1)
+	}
+	// Deliver the reminders of the to-do items
+	scheduler, err := reminder.NewSchedulerFromEnv()
+	if err != nil {
+		logging.Default.Error("invalid reminder settings", "error", err)
+		os.Exit(1)
+	}
+	remindersCtx, stopReminders := context.WithCancel(context.Background())
+	remindersDone := make(chan struct{})
+	go func() {
+		defer close(remindersDone)
+		scheduler.Run(remindersCtx)
+	}()
+	lifecycle.OnShutdown("reminders", func(ctx context.Context) error {
+		// The reminder being delivered is finished, the claimed ones are delivered after a restart
+		stopReminders()
+		select {
+		case <-remindersDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
 	database.GlobalDB.AutoMigrate(&models.URL{})
+	database.GlobalDB.AutoMigrate(&models.URLDestination{})
 	database.GlobalDB.AutoMigrate(&models.File{})
+	database.GlobalDB.AutoMigrate(&models.ScanJob{})
+	database.GlobalDB.AutoMigrate(&models.ScanJobResult{})
+	database.GlobalDB.AutoMigrate(&models.ScanProfile{})
+	database.GlobalDB.AutoMigrate(&models.ScanChange{})
+	// Run the port scans of the admins, on the targets allowed by SCAN_ALLOWED_TARGETS
+	scans.Default, err = scans.NewManagerFromEnv()
+	if err != nil {
+		logging.Default.Error("invalid scan settings", "error", err)
+		os.Exit(1)
+	}
+	if scans.Default.Alerter, err = scans.NewAlerterFromEnv(); err != nil {
+		logging.Default.Error("invalid scan alert settings", "error", err)
+		os.Exit(1)
+	}
+	if err := scans.FailStale(); err != nil {
+		logging.Default.Error("could not fail interrupted scan jobs", "error", err)
+	}
+	// The running jobs are recorded as cancelled, their open ports are kept
+	lifecycle.OnShutdown("scans", scans.Default.Shutdown)
+	// Run the scan profiles on their schedule, the profiles of SCAN_PROFILES_FILE are loaded first
+	if err := scans.SyncProfilesFromEnv(); err != nil {
+		logging.Default.Error("could not load scan profiles", "error", err)
+		os.Exit(1)
+	}
+	scanScheduler, err := scans.NewSchedulerFromEnv(scans.Default)
+	if err != nil {
+		logging.Default.Error("invalid scan schedule settings", "error", err)
+		os.Exit(1)
+	}
+	scheduleCtx, stopSchedule := context.WithCancel(context.Background())
+	go scanScheduler.Run(scheduleCtx)
+	lifecycle.OnShutdown("scan schedules", func(ctx context.Context) error {
+		stopSchedule()
+		return nil
+	})
 	// Set up the router
 	r := routes.SetupRouter()
 	// Start the server
-	r.Run(":8088")
+	srv := &http.Server{
+		Addr:              serverAddr,
+		Handler:           r,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		logging.Default.Info("server listening", "addr", serverAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Default.Error("server failed", "error", err)
+			stop()
+		}
+	}()
+
+	// Wait for a termination signal
+	<-ctx.Done()
+	stop()
+	logging.Default.Info("shutting down")
+	lifecycle.BeginShutdown()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// Stop accepting connections and wait for the in-flight requests
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logging.Default.Error("could not drain requests", "error", err)
+	}
+	// Flush the background work and close the database
+	if err := lifecycle.Shutdown(shutdownCtx); err != nil {
+		logging.Default.Error("could not stop cleanly", "error", err)
+		os.Exit(1)
+	}
+	logging.Default.Info("server stopped")
 }
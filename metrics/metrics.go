@@ -0,0 +1,127 @@
+// Package metrics holds the Prometheus metrics of the service
+// and serves them in the Prometheus text format on /metrics.
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the name of every metric of the service
+const namespace = "go_huang"
+
+// Registry is the registry every metric of the service is registered on
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts the handled HTTP requests by method, route template and status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes the latency of HTTP requests by method, route template and status
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// DBQueryDuration observes the duration of GORM operations by operation and table
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of database queries, by operation and table.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	// DBQueryErrors counts the GORM operations that failed, record not found is not counted
+	DBQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "Number of failed database queries, by operation and table.",
+	}, []string{"operation", "table"})
+
+	// Uploads counts the uploaded files
+	Uploads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Number of uploaded files.",
+	})
+
+	// UploadBytes counts the bytes of the uploaded files
+	UploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Size of the uploaded files in bytes.",
+	})
+
+	// Redirects counts the short URL redirects served by redirect status
+	Redirects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Number of short URL redirects served, by redirect status.",
+	}, []string{"status"})
+
+	// Logins counts the login attempts by result (success or failure)
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Number of login attempts, by result.",
+	}, []string{"result"})
+
+	// TokensIssued counts the issued JWT tokens by type (access or refresh)
+	TokensIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_issued_total",
+		Help:      "Number of issued tokens, by type.",
+	}, []string{"type"})
+
+	// Reminders counts the processed to-do reminders by result (sent, failed or cancelled)
+	Reminders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_total",
+		Help:      "Number of processed to-do reminders, by result.",
+	}, []string{"result"})
+	// ScanJobs counts the finished port scan jobs by status (done, failed or cancelled)
+	ScanJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scan_jobs_total",
+		Help:      "Number of finished port scan jobs, by status.",
+	}, []string{"status"})
+	// ScanAlerts counts the alerts about the exposure changes found by the scan profiles, by result (sent or failed)
+	ScanAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scan_alerts_total",
+		Help:      "Number of alerts about the exposure changes found by the scan profiles, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		DBQueryDuration,
+		DBQueryErrors,
+		Uploads,
+		UploadBytes,
+		Redirects,
+		Logins,
+		TokensIssued,
+		Reminders,
+		ScanJobs,
+		ScanAlerts,
+	)
+}
+
+// Handler returns a gin handler serving the metrics in the Prometheus text format
+func Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	return gin.WrapH(h)
+}
@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/models"
+)
+
+// RequireAdmin is a middleware that only lets admins through
+// It must run after Authz, the role is read from the database so a demoted admin loses access at once
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// An admin impersonating someone acts as that user, even if the user is an admin
+		if _, ok := c.Get("impersonator_id"); ok {
+			apperror.Abort(c, apperror.Forbidden("Admin Access Required"))
+			return
+		}
+		var user models.User
+		err := database.GlobalDB.Select("id", "role").First(&user, c.GetUint("user_id")).Error
+		if err != nil {
+			apperror.Abort(c, apperror.Forbidden("Admin Access Required"))
+			return
+		}
+		if !user.IsAdmin() {
+			apperror.Abort(c, apperror.Forbidden("Admin Access Required"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,25 +1,40 @@
 package middlewares
 
 import (
-	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
 	"github.com/zerodot618/go-huang/auth"
+	"github.com/zerodot618/go-huang/models"
+)
+
+// APIKeyHeader is the header carrying a personal API key
+const APIKeyHeader = "X-API-Key"
+
+// Authentication methods, set in the context under AuthMethodKey
+const (
+	AuthMethodKey    = "auth_method"
+	AuthMethodToken  = "token"
+	AuthMethodAPIKey = "api_key"
 )
 
 // Authz is a middleware that validates token and authorizes users
-// It takes a gin.Context as an argument and returns a gin.HandlerFunc
-// This function is responsible for validating the token sent by the client in the Authorization header
-// and authorizing the user if the token is valid
-func Authz() gin.HandlerFunc {
+// It takes the scopes an API key needs on the route and returns a gin.HandlerFunc
+// This function is responsible for validating the token sent by the client in the Authorization header,
+// or the API key sent in the X-API-Key header, and authorizing the user if it is valid.
+// API keys are refused on the routes without scopes, like the account and admin routes.
+func Authz(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := c.Request.Header.Get(APIKeyHeader); apiKey != "" {
+			authorizeAPIKey(c, apiKey, scopes)
+			return
+		}
 		// Get the Authorization header from the request
 		clientToken := c.Request.Header.Get("Authorization")
 		if clientToken == "" {
 			// If the Authorization header is not present, return a 403 status code
-			c.JSON(http.StatusForbidden, "No Authorization header provided")
-			c.Abort()
+			apperror.Abort(c, apperror.Forbidden("No Authorization header provided"))
 			return
 		}
 		// Split the Authorization header to get the token
@@ -29,26 +44,93 @@ func Authz() gin.HandlerFunc {
 			clientToken = strings.TrimSpace(extractedToken[1])
 		} else {
 			// If the token is not in the correct format, return a 400 status code
-			c.JSON(http.StatusBadRequest, "Incorrect Format of Authorization Token")
-			c.Abort()
+			apperror.Abort(c, apperror.BadRequest("Incorrect Format of Authorization Token"))
 			return
 		}
-		// Create a JwtWrapper with the secret key and issuer
-		jwtWrapper := auth.JwtWrapper{
-			SecretKey: "verysecretkey",
-			Issuer:    "AuthService",
-		}
-		// Validate the token
-		claims, err := jwtWrapper.ValidateToken(clientToken)
+		// Validate the token with the published signing keys
+		claims, err := auth.Default.ValidateToken(clientToken)
 		if err != nil {
 			// If token is not valid, return a 401 status code
-			c.JSON(http.StatusUnauthorized, err.Error())
-			c.Abort()
+			apperror.Abort(c, apperror.Unauthorized(err.Error()))
+			return
+		}
+		// Tokens issued for a single purpose, like a pending two-step login, are not access tokens
+		if claims.Purpose != "" {
+			apperror.Abort(c, apperror.Unauthorized("Token Cannot Be Used For This Request"))
+			return
+		}
+		// Tokens of revoked or expired sessions are rejected before they expire
+		active, err := models.TouchSession(claims.SessionID, claims.ID, c.ClientIP())
+		if err != nil {
+			apperror.Abort(c, apperror.Internal(err, "Could Not Check Session"))
+			return
+		}
+		if !active {
+			apperror.Abort(c, apperror.Unauthorized("Session Revoked Or Expired"))
 			return
 		}
 		// Set the claims in the context
 		c.Set("email", claims.Email)
+		c.Set("user_id", claims.ID)
+		c.Set("session_id", claims.SessionID)
+		if claims.ImpersonatorID != 0 {
+			c.Set("impersonator_id", claims.ImpersonatorID)
+		}
+		c.Set(AuthMethodKey, AuthMethodToken)
 		// Continue to the next handler
 		c.Next()
 	}
 }
+
+// OptionalAuthz is a middleware that authorizes the user like Authz when the request has credentials
+// It lets anonymous requests through without a user in the context, for the routes open to everyone
+// that remember who made the request when they can
+func OptionalAuthz(scopes ...string) gin.HandlerFunc {
+	authz := Authz(scopes...)
+	return func(c *gin.Context) {
+		if c.Request.Header.Get(APIKeyHeader) == "" && c.Request.Header.Get("Authorization") == "" {
+			c.Next()
+			return
+		}
+		authz(c)
+	}
+}
+
+// authorizeAPIKey authorizes the user of an API key granted every scope of the route
+func authorizeAPIKey(c *gin.Context, plain string, scopes []string) {
+	if len(scopes) == 0 {
+		apperror.Abort(c, apperror.Forbidden("API Keys Cannot Be Used For This Request"))
+		return
+	}
+	key, err := models.AuthenticateAPIKey(plain, c.ClientIP())
+	if err == models.ErrInvalidAPIKey {
+		apperror.Abort(c, apperror.Unauthorized("Invalid API Key"))
+		return
+	}
+	if err != nil {
+		apperror.Abort(c, apperror.Internal(err, "Could Not Check API Key"))
+		return
+	}
+	if !key.HasScopes(scopes...) {
+		apperror.Abort(c, apperror.Forbidden("API Key Missing Scope: "+strings.Join(scopes, " ")))
+		return
+	}
+	// Set the same user context as the tokens
+	c.Set("email", key.User.Email)
+	c.Set("user_id", key.UserID)
+	c.Set(AuthMethodKey, AuthMethodAPIKey)
+	c.Set("api_key_id", key.ID)
+	c.Next()
+}
+
+// NoImpersonation is a middleware that refuses the requests of an admin impersonating a user
+// It protects the account routes, like the password, the email, 2FA and the API keys, and must run after Authz
+func NoImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("impersonator_id"); ok {
+			apperror.Abort(c, apperror.Forbidden("Not Allowed While Impersonating"))
+			return
+		}
+		c.Next()
+	}
+}
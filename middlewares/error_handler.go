@@ -0,0 +1,78 @@
+package middlewares
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/validation"
+)
+
+// ErrorResponse is the JSON envelope of every error returned by the API
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody is the content of the error envelope
+type ErrorBody struct {
+	Code      apperror.Code         `json:"code"`
+	Message   string                `json:"message"`
+	Fields    []apperror.FieldError `json:"fields,omitempty"`
+	RequestID string                `json:"request_id,omitempty"`
+}
+
+// ErrorHandler is a middleware that renders the errors recorded by the handlers
+// It converts the last error of the gin context into an apperror.Error and writes
+// it as an ErrorResponse, so every error of the API has the same shape
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		RenderError(c, c.Errors.Last().Err)
+	}
+}
+
+// RenderError writes err as an ErrorResponse with the matching HTTP status
+func RenderError(c *gin.Context, err error) {
+	// Validation errors are translated to the language of the client
+	appErr := validation.AppError(err, c.GetHeader("Accept-Language"))
+	if appErr == nil {
+		appErr = apperror.From(err)
+	}
+	if appErr.Code == apperror.CodeInternal {
+		// Only the log sees the cause of internal errors
+		logging.FromGin(c).Error("internal error", "error", err.Error())
+	}
+	c.AbortWithStatusJSON(appErr.Status(), ErrorResponse{
+		Error: ErrorBody{
+			Code:      appErr.Code,
+			Message:   appErr.Message,
+			Fields:    appErr.Fields,
+			RequestID: c.GetString(RequestIDKey),
+		},
+	})
+}
+
+// Recovery is a middleware that recovers from panics and renders them as internal errors
+func Recovery() gin.HandlerFunc {
+	return gin.RecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		logging.FromGin(c).Error("panic recovered", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+		RenderError(c, apperror.New(apperror.CodeInternal, "Internal Server Error"))
+	})
+}
+
+// NoRoute is a handler that renders unknown routes as not_found errors
+func NoRoute(c *gin.Context) {
+	RenderError(c, apperror.NotFound(http.StatusText(http.StatusNotFound)))
+}
+
+// NoMethod is a handler that renders unsupported methods as method_not_allowed errors
+func NoMethod(c *gin.Context) {
+	RenderError(c, apperror.New(apperror.CodeMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+}
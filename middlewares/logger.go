@@ -0,0 +1,64 @@
+package middlewares
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/logging"
+)
+
+// Logger is a middleware that writes one structured log line per request
+// It continues the W3C trace of the caller from the traceparent header, or starts a new one,
+// and hands a logger carrying the request ID and trace IDs to the handlers.
+// RequestID must run before it.
+func Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		trace := logging.ParseTraceparent(c.GetHeader(logging.TraceparentHeader))
+		c.Header(logging.TraceparentHeader, trace.String())
+
+		// Create the request-scoped logger used by the controllers
+		logger := logging.Default.With(
+			slog.String("request_id", c.GetString(RequestIDKey)),
+			slog.String("trace_id", trace.TraceID),
+			slog.String("span_id", trace.SpanID),
+		)
+		if trace.ParentID != "" {
+			logger = logger.With(slog.String("parent_span_id", trace.ParentID))
+		}
+		logging.Set(c, logger)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if userID, ok := c.Get("user_id"); ok {
+			attrs = append(attrs, slog.Any("user_id", userID))
+		}
+		// Requests of an admin impersonating a user are attributed to the admin too
+		if impersonatorID, ok := c.Get("impersonator_id"); ok {
+			attrs = append(attrs, slog.Any("impersonator_id", impersonatorID))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		logging.FromGin(c).LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
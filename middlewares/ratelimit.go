@@ -0,0 +1,105 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/apperror"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/ratelimit"
+)
+
+// maxKeyBodySize bounds the part of the request body read to find the account of a request
+const maxKeyBodySize = 1 << 20
+
+// KeyFunc returns the rate limit key of a request, an empty key skips the limit
+type KeyFunc func(c *gin.Context) string
+
+// RateLimit is a middleware that limits requests with a token bucket per key
+// It sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers and
+// answers 429 with a Retry-After header once the bucket is empty.
+// If the store is unavailable the request is let through.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k := key(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+		res, err := store.Take(c.Request.Context(), name+":"+k, limit)
+		if err != nil {
+			logging.FromGin(c).Warn("rate limit store unavailable", "limiter", name, "error", err.Error())
+			c.Next()
+			return
+		}
+		setRateLimitHeaders(c, res)
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+			apperror.Abort(c, apperror.New(apperror.CodeTooManyRequests, "Too Many Requests"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// ByIP is a KeyFunc limiting requests per client IP
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser is a KeyFunc limiting requests per authenticated user, it must run after Authz
+func ByUser(c *gin.Context) string {
+	userID := c.GetUint("user_id")
+	if userID == 0 {
+		return ""
+	}
+	return "user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// ByJSONField returns a KeyFunc limiting requests per value of a field of the JSON body,
+// e.g. the email of a login request. The body is restored for the handlers.
+func ByJSONField(field string) KeyFunc {
+	return func(c *gin.Context) string {
+		if c.Request.Body == nil {
+			return ""
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxKeyBodySize))
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		if err != nil {
+			return ""
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return ""
+		}
+		value, _ := payload[field].(string)
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			return ""
+		}
+		return field + ":" + value
+	}
+}
+
+// setRateLimitHeaders sets the RateLimit headers, keeping those of the most restrictive limiter
+func setRateLimitHeaders(c *gin.Context, res ratelimit.Result) {
+	if current := c.Writer.Header().Get("RateLimit-Remaining"); current != "" {
+		if remaining, err := strconv.Atoi(current); err == nil && remaining < res.Remaining {
+			return
+		}
+	}
+	c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+}
+
+// seconds rounds a duration up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
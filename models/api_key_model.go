@@ -0,0 +1,127 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to recognize and scan for
+const APIKeyPrefix = "ghk_"
+
+// apiKeyUsageResolution limits the writes of the last use of a key to one per minute
+const apiKeyUsageResolution = time.Minute
+
+// ErrInvalidAPIKey is returned when an API key is unknown, expired or revoked
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey is a personal API key of a user, for scripts and CI jobs
+// Only the SHA-256 hash of the key is stored, its Prefix is kept to recognize it in the list of keys
+type APIKey struct {
+	gorm.Model
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	User       User       `json:"-"`
+	Name       string     `json:"name" gorm:"size:64;not null"`
+	Prefix     string     `json:"prefix" gorm:"size:16;not null"`
+	KeyHash    string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json;type:text"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip" gorm:"size:45"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// CreateAPIKey creates an API key for a user and returns its plain value, which is never stored
+// The key looks like ghk_<prefix>_<secret>, the prefix identifies it in the list of keys
+func CreateAPIKey(key *APIKey) (string, error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	key.Prefix = APIKeyPrefix + hex.EncodeToString(id)
+	plain := key.Prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	key.KeyHash = hashToken(plain)
+	if err := database.GlobalDB.Create(key).Error; err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// AuthenticateAPIKey returns the API key of a plain key, with its user
+// It returns ErrInvalidAPIKey if the key is unknown, expired or revoked, or its user cannot log in,
+// and records its use
+func AuthenticateAPIKey(plain, ip string) (*APIKey, error) {
+	if !strings.HasPrefix(plain, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	var key APIKey
+	err := database.GlobalDB.Preload("User").Where("key_hash = ?", hashToken(plain)).First(&key).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !key.ExpiresAt.After(now)) || key.User.ID == 0 {
+		return nil, ErrInvalidAPIKey
+	}
+	// The keys of disabled accounts and of accounts being deleted stop working
+	if key.User.IsDisabled() || key.User.DeletionRequestedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	// The last use is only written when the previous one is old enough, not on every request
+	err = database.GlobalDB.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-apiKeyUsageResolution)).
+		UpdateColumns(map[string]interface{}{"last_used_at": now, "last_used_ip": ip}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// HasScopes reports whether the key was granted every scope
+func (key *APIKey) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		granted := false
+		for _, s := range key.Scopes {
+			granted = granted || s == scope
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
+}
+
+// ListAPIKeys returns the API keys of a user, newest first
+func ListAPIKeys(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := database.GlobalDB.Where("user_id = ?", userID).Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes an API key of a user, the key is kept so its use stays visible
+// It returns gorm.ErrRecordNotFound if the user has no such key or it is already revoked
+func RevokeAPIKey(userID, keyID uint) error {
+	result := database.GlobalDB.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
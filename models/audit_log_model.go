@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditUserDisabled       = "user.disabled"
+	AuditUserEnabled        = "user.enabled"
+	AuditUserImpersonated   = "user.impersonated"
+	AuditTOTPReset          = "user.2fa_reset"
+	AuditSessionsTerminated = "user.sessions_terminated"
+	AuditScanStarted        = "scan.started"
+	AuditScanCancelled      = "scan.cancelled"
+	AuditScanProfileCreated = "scan_profile.created"
+	AuditScanProfileUpdated = "scan_profile.updated"
+	AuditScanProfileDeleted = "scan_profile.deleted"
+)
+
+// AuditLog records an admin action, it is never updated nor deleted by the API
+type AuditLog struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+	ActorID      uint      `json:"actor_id" gorm:"index;not null"`
+	Action       string    `json:"action" gorm:"size:64;index;not null"`
+	TargetUserID *uint     `json:"target_user_id" gorm:"index"`
+	Reason       string    `json:"reason,omitempty" gorm:"size:255"`
+	IP           string    `json:"ip" gorm:"size:45"`
+	UserAgent    string    `json:"user_agent" gorm:"size:512"`
+	RequestID    string    `json:"request_id" gorm:"size:64"`
+}
+
+// AuditLogFilter filters the audit log
+type AuditLogFilter struct {
+	ActorID      uint   `form:"actor_id"`
+	TargetUserID uint   `form:"target_user_id"`
+	Action       string `form:"action"`
+}
+
+// RecordAudit adds an entry to the audit log
+func RecordAudit(entry *AuditLog) error {
+	if len(entry.UserAgent) > 512 {
+		entry.UserAgent = entry.UserAgent[:512]
+	}
+	return database.GlobalDB.Create(entry).Error
+}
+
+// ListAuditLogs returns a page of the audit log, newest first
+func ListAuditLogs(filter AuditLogFilter, pagination Pagination) (*Page[AuditLog], error) {
+	query := database.GlobalDB.Model(&AuditLog{}).Order("id DESC")
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetUserID != 0 {
+		query = query.Where("target_user_id = ?", filter.TargetUserID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	return paginate[AuditLog](query, pagination)
+}
@@ -5,8 +5,9 @@ import "gorm.io/gorm"
 // Book is a struct that represents a book in the database
 type Book struct {
 	gorm.Model         // gorm.Model provides the fields ID, CreatedAt, UpdatedAt, and DeletedAt
-	Title       string `gorm:"size:191;not null;unique" json:"title"`
-	Author      string `gorm:"size:191;not null" json:"author"`
-	Publisher   string `gorm:"size:191;not null" json:"publisher"`
-	Description string `gorm:"size:191;not null" json:"description"`
+	Title       string `gorm:"size:191;not null;unique" json:"title" binding:"required,max=191"`
+	Author      string `gorm:"size:191;not null" json:"author" binding:"required,max=191"`
+	Publisher   string `gorm:"size:191;not null" json:"publisher" binding:"required,max=191"`
+	Description string `gorm:"size:191;not null" json:"description" binding:"max=191"`
+	ISBN        string `gorm:"size:17" json:"isbn" binding:"omitempty,isbn"`
 }
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"gorm.io/gorm"
+)
+
+// OIDCAuthRequestTTL is how long a user has to log in at the provider
+const OIDCAuthRequestTTL = 10 * time.Minute
+
+// ExternalIdentity links an account of an OpenID Connect provider to a user
+// The account is identified by the issuer subject, which unlike the email never changes
+type ExternalIdentity struct {
+	gorm.Model
+	UserID   uint   `json:"user_id" gorm:"index;not null"`
+	Provider string `json:"provider" gorm:"size:64;not null;uniqueIndex:idx_identity_subject"`
+	Subject  string `json:"subject" gorm:"size:255;not null;uniqueIndex:idx_identity_subject"`
+	Email    string `json:"email"`
+}
+
+// OIDCAuthRequest is an authorization request waiting for the callback of the provider
+// It keeps the PKCE verifier and the nonce on the server, only the hash of the state is stored
+type OIDCAuthRequest struct {
+	gorm.Model
+	StateHash    string `gorm:"size:64;uniqueIndex;not null"`
+	Provider     string `gorm:"size:64;not null"`
+	CodeVerifier string `gorm:"size:128;not null"`
+	Nonce        string `gorm:"size:128;not null"`
+	// LinkUserID is set when a logged in user links a new identity instead of logging in
+	LinkUserID *uint
+	ExpiresAt  time.Time `gorm:"not null"`
+}
+
+// TableName returns the table of the authorization requests, which gorm would name o_id_c_auth_requests
+func (OIDCAuthRequest) TableName() string {
+	return "oidc_auth_requests"
+}
+
+// CreateOIDCAuthRequest stores an authorization request for its state
+func CreateOIDCAuthRequest(state string, request *OIDCAuthRequest) error {
+	request.StateHash = hashToken(state)
+	request.ExpiresAt = time.Now().Add(OIDCAuthRequestTTL)
+	// Expired requests of abandoned logins are cleaned up on the way
+	err := database.GlobalDB.Unscoped().Where("expires_at < ?", time.Now()).Delete(&OIDCAuthRequest{}).Error
+	if err != nil {
+		return err
+	}
+	return database.GlobalDB.Create(request).Error
+}
+
+// ConsumeOIDCAuthRequest deletes the authorization request of a state and returns it
+// It returns ErrInvalidToken if the state is unknown, expired or was issued for another provider.
+// The request is deleted with a single conditional delete, so a callback cannot be replayed.
+func ConsumeOIDCAuthRequest(state, provider string) (*OIDCAuthRequest, error) {
+	var request OIDCAuthRequest
+	err := database.GlobalDB.Where("state_hash = ? AND provider = ?", hashToken(state), provider).First(&request).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := database.GlobalDB.Unscoped().Delete(&OIDCAuthRequest{}, request.ID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 || request.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+	return &request, nil
+}
+
+// FindExternalIdentity returns the identity of a provider subject
+func FindExternalIdentity(provider, subject string) (*ExternalIdentity, error) {
+	var identity ExternalIdentity
+	err := database.GlobalDB.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// LinkExternalIdentity links a provider subject to a user
+// It fails with a duplicate key error if the subject is already linked
+func LinkExternalIdentity(userID uint, provider, subject, email string) (*ExternalIdentity, error) {
+	identity := ExternalIdentity{UserID: userID, Provider: provider, Subject: subject, Email: email}
+	if err := database.GlobalDB.Create(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// ListExternalIdentities returns the identities linked to a user
+func ListExternalIdentities(userID uint) ([]ExternalIdentity, error) {
+	var identities []ExternalIdentity
+	err := database.GlobalDB.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+// UnlinkExternalIdentity removes an identity of a user
+// It returns gorm.ErrRecordNotFound if the user has no such identity
+func UnlinkExternalIdentity(userID, identityID uint) error {
+	result := database.GlobalDB.Unscoped().Where("user_id = ?", userID).Delete(&ExternalIdentity{}, identityID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import "gorm.io/gorm"
+
+// Page sizes of the paginated lists
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Pagination is a page of a list, bound from the page and page_size query parameters
+type Pagination struct {
+	Page     int `form:"page" json:"page" binding:"omitempty,min=1"`
+	PageSize int `form:"page_size" json:"page_size" binding:"omitempty,min=1,max=100"`
+}
+
+// Normalize fills the default page and page size
+func (p *Pagination) Normalize() {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.PageSize < 1 {
+		p.PageSize = DefaultPageSize
+	}
+	if p.PageSize > MaxPageSize {
+		p.PageSize = MaxPageSize
+	}
+}
+
+// Scope limits a query to the page
+func (p Pagination) Scope(db *gorm.DB) *gorm.DB {
+	p.Normalize()
+	return db.Offset((p.Page - 1) * p.PageSize).Limit(p.PageSize)
+}
+
+// Page is a page of a list with the total number of items
+type Page[T any] struct {
+	Items    []T   `json:"items"`
+	Page     int   `json:"page"`
+	PageSize int   `json:"page_size"`
+	Total    int64 `json:"total"`
+}
+
+// paginate counts the items of a query and loads a page of them, with their preloaded associations
+func paginate[T any](query *gorm.DB, pagination Pagination, preloads ...string) (*Page[T], error) {
+	pagination.Normalize()
+	page := Page[T]{Items: []T{}, Page: pagination.Page, PageSize: pagination.PageSize}
+	if err := query.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	// The associations are only preloaded for the page, not for the count
+	for _, preload := range preloads {
+		query = query.Preload(preload)
+	}
+	if err := query.Scopes(pagination.Scope).Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"gorm.io/gorm"
+)
+
+// Statuses of the reminders
+const (
+	ReminderPending   = "pending"
+	ReminderSent      = "sent"
+	ReminderFailed    = "failed"
+	ReminderCancelled = "cancelled"
+)
+
+// ReminderMaxAttempts is the number of deliveries of a reminder before it is marked as failed
+const ReminderMaxAttempts = 5
+
+// ErrReminderLeaseLost is returned when a reminder is no longer claimed by the owner updating it,
+// its lease expired and another owner may have claimed it
+var ErrReminderLeaseLost = errors.New("reminder lease lost")
+
+// Reminder is a persisted job delivering a reminder of a to-do item before its due time
+// A scheduler claims the due reminders for a lease, so several instances never deliver the same one at once,
+// and a reminder claimed by a stopped instance is delivered by another once its lease is over
+type Reminder struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ToDoID    uint      `gorm:"not null;index" json:"todo_id"`
+	// FireAt is when the reminder is due, NextAttemptAt when it is delivered next, later after a failure
+	FireAt        time.Time  `gorm:"not null" json:"fire_at"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_reminder_due,priority:2" json:"next_attempt_at"`
+	Status        string     `gorm:"size:16;not null;index:idx_reminder_due,priority:1" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	LastError     string     `gorm:"size:255" json:"last_error,omitempty"`
+	LockedBy      string     `gorm:"size:64" json:"-"`
+	LockedUntil   *time.Time `json:"-"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+// ReminderTarget is what a reminder is about and who receives it
+type ReminderTarget struct {
+	ToDo      *ToDo
+	Recipient *UserSummary
+}
+
+// scheduleReminder replaces the pending reminder of a to-do item after a change
+// A reminder is scheduled if the to-do item is not done and has a due time in the future and a reminder offset.
+// A reminder already delivered for the same time is not scheduled again
+func scheduleReminder(tx *gorm.DB, todo *ToDo) error {
+	if err := cancelReminders(tx, []uint{todo.ID}); err != nil {
+		return err
+	}
+	now := time.Now()
+	if todo.Status == ToDoStatusDone || todo.DueAt == nil || todo.RemindBefore == nil || !todo.DueAt.After(now) {
+		return nil
+	}
+	// Whole seconds compare equal once stored, whatever the precision of the due time
+	fireAt := todo.DueAt.Add(-time.Duration(*todo.RemindBefore) * time.Minute).Truncate(time.Second)
+	var delivered int64
+	err := tx.Model(&Reminder{}).
+		Where("to_do_id = ? AND fire_at = ? AND status IN ?", todo.ID, fireAt, []string{ReminderSent, ReminderFailed}).
+		Count(&delivered).Error
+	if err != nil || delivered > 0 {
+		return err
+	}
+	// A reminder whose time has passed, the offset being longer than the time left, is delivered at once
+	nextAttemptAt := fireAt
+	if nextAttemptAt.Before(now) {
+		nextAttemptAt = now
+	}
+	return tx.Create(&Reminder{ToDoID: todo.ID, FireAt: fireAt, NextAttemptAt: nextAttemptAt, Status: ReminderPending}).Error
+}
+
+// cancelReminders cancels the pending reminders of to-do items
+func cancelReminders(tx *gorm.DB, todoIDs []uint) error {
+	if len(todoIDs) == 0 {
+		return nil
+	}
+	return tx.Model(&Reminder{}).
+		Where("to_do_id IN ? AND status = ?", todoIDs, ReminderPending).
+		Updates(map[string]interface{}{"status": ReminderCancelled, "locked_by": "", "locked_until": nil}).Error
+}
+
+// ClaimDueReminders claims up to limit pending reminders due at a time for an owner, for the length of a lease
+// A reminder is only claimed by one owner at a time, the claim of another owner is taken over once its lease is over
+func ClaimDueReminders(owner string, now time.Time, lease time.Duration, limit int) ([]Reminder, error) {
+	var ids []uint
+	err := database.GlobalDB.Model(&Reminder{}).
+		Where("status = ? AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)", ReminderPending, now, now).
+		Order("next_attempt_at").Limit(limit).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	// The condition is checked again, only one owner updates a reminder claimed concurrently
+	lockedUntil := now.Add(lease).Truncate(time.Millisecond)
+	err = database.GlobalDB.Model(&Reminder{}).
+		Where("id IN ? AND status = ? AND (locked_until IS NULL OR locked_until < ?)", ids, ReminderPending, now).
+		Updates(map[string]interface{}{"locked_by": owner, "locked_until": lockedUntil}).Error
+	if err != nil {
+		return nil, err
+	}
+	var reminders []Reminder
+	err = database.GlobalDB.
+		Where("id IN ? AND status = ? AND locked_by = ? AND locked_until = ?", ids, ReminderPending, owner, lockedUntil).
+		Order("next_attempt_at").Find(&reminders).Error
+	return reminders, err
+}
+
+// Target loads the to-do item of the reminder and its recipient, the assignee or else the author
+// It returns gorm.ErrRecordNotFound if the to-do item was deleted
+func (r *Reminder) Target() (*ReminderTarget, error) {
+	var todo ToDo
+	if err := database.GlobalDB.First(&todo, r.ToDoID).Error; err != nil {
+		return nil, err
+	}
+	recipientID := todo.AuthorID
+	if todo.AssigneeID != nil {
+		recipientID = *todo.AssigneeID
+	}
+	var recipient UserSummary
+	if err := database.GlobalDB.Where("id = ?", recipientID).Take(&recipient).Error; err != nil {
+		return nil, err
+	}
+	return &ReminderTarget{ToDo: &todo, Recipient: &recipient}, nil
+}
+
+// ExtendLease renews the claim of an owner on a reminder for the length of a lease
+// It returns ErrReminderLeaseLost if the claim expired or was taken over, the reminder must then not be delivered
+func (r *Reminder) ExtendLease(owner string, now time.Time, lease time.Duration) error {
+	result := database.GlobalDB.Model(&Reminder{}).
+		Where("id = ? AND status = ? AND locked_by = ? AND locked_until >= ?", r.ID, ReminderPending, owner, now).
+		UpdateColumn("locked_until", now.Add(lease).Truncate(time.Millisecond))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReminderLeaseLost
+	}
+	return nil
+}
+
+// MarkSent records the delivery of a reminder claimed by an owner
+func (r *Reminder) MarkSent(owner string, now time.Time) error {
+	return r.finish(owner, map[string]interface{}{
+		"status":       ReminderSent,
+		"attempts":     gorm.Expr("attempts + 1"),
+		"sent_at":      now,
+		"locked_by":    "",
+		"locked_until": nil,
+	})
+}
+
+// MarkCancelled cancels a reminder claimed by an owner, whose to-do item was deleted or done in the meantime
+func (r *Reminder) MarkCancelled(owner string) error {
+	return r.finish(owner, map[string]interface{}{"status": ReminderCancelled, "locked_by": "", "locked_until": nil})
+}
+
+// MarkFailed records a failed delivery of a reminder claimed by an owner
+// The reminder is retried after a backoff growing with the attempts, until ReminderMaxAttempts
+func (r *Reminder) MarkFailed(owner string, now time.Time, cause error) error {
+	message := cause.Error()
+	if len(message) > 255 {
+		message = message[:255]
+	}
+	updates := map[string]interface{}{
+		"attempts":     gorm.Expr("attempts + 1"),
+		"last_error":   message,
+		"locked_by":    "",
+		"locked_until": nil,
+	}
+	if r.Attempts+1 >= ReminderMaxAttempts {
+		updates["status"] = ReminderFailed
+	} else {
+		updates["next_attempt_at"] = now.Add(time.Minute << uint(r.Attempts))
+	}
+	return r.finish(owner, updates)
+}
+
+// finish updates a reminder if it is still claimed by an owner, or returns ErrReminderLeaseLost
+func (r *Reminder) finish(owner string, updates map[string]interface{}) error {
+	result := database.GlobalDB.Model(&Reminder{}).
+		Where("id = ? AND status = ? AND locked_by = ?", r.ID, ReminderPending, owner).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReminderLeaseLost
+	}
+	return nil
+}
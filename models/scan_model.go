@@ -0,0 +1,151 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+)
+
+// Statuses of the scan jobs
+const (
+	ScanQueued    = "queued"
+	ScanRunning   = "running"
+	ScanDone      = "done"
+	ScanFailed    = "failed"
+	ScanCancelled = "cancelled"
+)
+
+// ScanJob is a port scan started by an admin or by the schedule of a profile, and run in the background by an instance
+// The instance running a job touches it every few seconds with its progress,
+// a queued or running job left untouched belonged to a stopped instance, see FailStaleScanJobs
+type ScanJob struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `gorm:"index" json:"updated_at"`
+	// CreatedByID is the admin who started the job, 0 for the scheduled jobs of a profile
+	CreatedByID uint  `gorm:"not null;index" json:"created_by_id"`
+	ProfileID   *uint `gorm:"index" json:"profile_id,omitempty"`
+	// Targets are the host names, IP addresses and CIDR ranges, Ports the port ranges, as given
+	Targets   string `gorm:"type:text;not null" json:"targets"`
+	Ports     string `gorm:"size:1024;not null" json:"ports"`
+	Protocols string `gorm:"size:16;not null" json:"protocols"`
+	Detect    bool   `gorm:"not null;default:false" json:"detect"`
+	TimeoutMS int    `gorm:"not null;default:0" json:"timeout_ms"`
+	Status    string `gorm:"size:16;not null;index" json:"status"`
+	Error     string `gorm:"size:255" json:"error,omitempty"`
+	// Hosts is the number of hosts, Probes the number of ports to probe and Done the number probed so far
+	Hosts  int `gorm:"not null;default:0" json:"hosts"`
+	Probes int `gorm:"not null;default:0" json:"probes"`
+	Done   int `gorm:"not null;default:0" json:"done"`
+	Open   int `gorm:"not null;default:0" json:"open"`
+	// Changes is the number of changes since the previous done job of the profile, see ScanChange
+	Changes    int        `gorm:"not null;default:0" json:"changes"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ScanJobResult is a port found open, or maybe open for UDP, by a scan job
+// The closed and filtered ports are only counted, a job can probe millions of them
+type ScanJobResult struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	JobID      uint      `gorm:"not null;index:idx_scan_result_job_port,priority:1" json:"job_id"`
+	Host       string    `gorm:"size:255;not null;index:idx_scan_result_job_port,priority:2" json:"host"`
+	Port       int       `gorm:"not null;index:idx_scan_result_job_port,priority:3" json:"port"`
+	Protocol   string    `gorm:"size:8;not null" json:"protocol"`
+	State      string    `gorm:"size:16;not null" json:"state"`
+	Reason     string    `gorm:"size:32" json:"reason"`
+	Service    string    `gorm:"size:64" json:"service,omitempty"`
+	Version    string    `gorm:"size:255" json:"version,omitempty"`
+	Banner     string    `gorm:"size:255" json:"banner,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// ScanJobFilter filters the scan jobs
+type ScanJobFilter struct {
+	Status    string `form:"status"`
+	ProfileID uint   `form:"profile_id"`
+}
+
+// Finished reports whether a job is over
+func (j *ScanJob) Finished() bool {
+	return j.Status == ScanDone || j.Status == ScanFailed || j.Status == ScanCancelled
+}
+
+// CreateScanJob creates a queued scan job
+func CreateScanJob(job *ScanJob) error {
+	job.Status = ScanQueued
+	return database.GlobalDB.Create(job).Error
+}
+
+// GetScanJob returns a scan job
+func GetScanJob(id uint) (*ScanJob, error) {
+	var job ScanJob
+	if err := database.GlobalDB.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListScanJobs returns a page of the scan jobs, newest first
+func ListScanJobs(filter ScanJobFilter, pagination Pagination) (*Page[ScanJob], error) {
+	query := database.GlobalDB.Model(&ScanJob{}).Order("id DESC")
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.ProfileID != 0 {
+		query = query.Where("profile_id = ?", filter.ProfileID)
+	}
+	return paginate[ScanJob](query, pagination)
+}
+
+// ListScanJobResults returns the results of a scan job, sorted by host and port
+func ListScanJobResults(jobID uint) ([]ScanJobResult, error) {
+	results := []ScanJobResult{}
+	err := database.GlobalDB.Where("job_id = ?", jobID).Order("host, port, protocol").Find(&results).Error
+	return results, err
+}
+
+// ListScanJobResultsAfter returns the results of a scan job recorded after a result, in the order they were recorded
+func ListScanJobResultsAfter(jobID, afterID uint) ([]ScanJobResult, error) {
+	var results []ScanJobResult
+	err := database.GlobalDB.Where("job_id = ? AND id > ?", jobID, afterID).Order("id").Find(&results).Error
+	return results, err
+}
+
+// StartScanJob marks a queued job as running on hosts and probes
+func StartScanJob(id uint, hosts, probes int, now time.Time) error {
+	return database.GlobalDB.Model(&ScanJob{}).
+		Where("id = ? AND status = ?", id, ScanQueued).
+		Updates(map[string]interface{}{"status": ScanRunning, "hosts": hosts, "probes": probes, "started_at": now}).Error
+}
+
+// AddScanJobResult records a result of a running job
+func AddScanJobResult(result *ScanJobResult) error {
+	return database.GlobalDB.Create(result).Error
+}
+
+// TouchScanJob records the progress of a job of the instance, so it is not taken as stale
+func TouchScanJob(id uint, done int) error {
+	return database.GlobalDB.Model(&ScanJob{}).
+		Where("id = ? AND status IN ?", id, []string{ScanQueued, ScanRunning}).
+		Updates(map[string]interface{}{"done": done, "updated_at": time.Now()}).Error
+}
+
+// FinishScanJob records the end of a job with its status, the number of probed and open ports and the error if it failed
+func FinishScanJob(id uint, status string, done, open int, cause string, now time.Time) error {
+	if len(cause) > 255 {
+		cause = cause[:255]
+	}
+	return database.GlobalDB.Model(&ScanJob{}).
+		Where("id = ? AND status IN ?", id, []string{ScanQueued, ScanRunning}).
+		Updates(map[string]interface{}{"status": status, "done": done, "open": open, "error": cause, "finished_at": now}).Error
+}
+
+// FailStaleScanJobs fails the queued and running jobs not touched since a time, whose instance stopped
+func FailStaleScanJobs(before time.Time) (int64, error) {
+	result := database.GlobalDB.Model(&ScanJob{}).
+		Where("status IN ? AND updated_at < ?", []string{ScanQueued, ScanRunning}, before).
+		Updates(map[string]interface{}{"status": ScanFailed, "error": "interrupted", "finished_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
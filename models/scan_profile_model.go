@@ -0,0 +1,203 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"gorm.io/gorm"
+)
+
+// Sources of the scan profiles
+const (
+	ScanProfileSourceAPI  = "api"
+	ScanProfileSourceFile = "file"
+)
+
+// Kinds of the changes between two scans
+const (
+	ScanChangeOpened  = "opened"
+	ScanChangeClosed  = "closed"
+	ScanChangeChanged = "changed"
+)
+
+// ScanProfile is a named scan run on a cron schedule, or on demand if it has none
+// The profiles of the file of SCAN_PROFILES_FILE are loaded at startup and cannot be changed by the API
+type ScanProfile struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string    `gorm:"size:64;not null;uniqueIndex" json:"name"`
+	Source      string    `gorm:"size:8;not null;default:'api'" json:"source"`
+	CreatedByID uint      `gorm:"not null;default:0" json:"created_by_id"`
+	Targets     string    `gorm:"type:text;not null" json:"targets"`
+	Ports       string    `gorm:"size:1024;not null" json:"ports"`
+	Protocols   string    `gorm:"size:16;not null" json:"protocols"`
+	Detect      bool      `gorm:"not null;default:false" json:"detect"`
+	// TimeoutMS is the timeout of every dial, the default of the server if zero
+	TimeoutMS int `gorm:"not null;default:0" json:"timeout_ms"`
+	// Schedule is a cron schedule evaluated in UTC, NextRunAt is its next time if the profile is enabled
+	Schedule  string     `gorm:"size:64;not null;default:''" json:"schedule"`
+	Enabled   bool       `gorm:"not null;default:true" json:"enabled"`
+	NextRunAt *time.Time `gorm:"index" json:"next_run_at,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastJobID *uint      `json:"last_job_id,omitempty"`
+	LastError string     `gorm:"size:255" json:"last_error,omitempty"`
+}
+
+// ScanChange is a difference between the open ports found by a job of a profile and by its previous done job
+// A port opened or closed has one change, a port still open has a change by field whose value changed:
+// service, version or banner
+type ScanChange struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	JobID         uint      `gorm:"not null;index" json:"job_id"`
+	PreviousJobID uint      `gorm:"not null" json:"previous_job_id"`
+	ProfileID     uint      `gorm:"not null;index" json:"profile_id"`
+	Host          string    `gorm:"size:255;not null" json:"host"`
+	Port          int       `gorm:"not null" json:"port"`
+	Protocol      string    `gorm:"size:8;not null" json:"protocol"`
+	Change        string    `gorm:"size:16;not null" json:"change"`
+	Field         string    `gorm:"size:16" json:"field,omitempty"`
+	Before        string    `gorm:"size:255" json:"before,omitempty"`
+	After         string    `gorm:"size:255" json:"after,omitempty"`
+}
+
+// CreateScanProfile creates a profile
+func CreateScanProfile(profile *ScanProfile) error {
+	return database.GlobalDB.Create(profile).Error
+}
+
+// GetScanProfile returns a profile
+func GetScanProfile(id uint) (*ScanProfile, error) {
+	var profile ScanProfile
+	if err := database.GlobalDB.First(&profile, id).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// ListScanProfiles returns a page of the profiles, sorted by name
+func ListScanProfiles(pagination Pagination) (*Page[ScanProfile], error) {
+	return paginate[ScanProfile](database.GlobalDB.Model(&ScanProfile{}).Order("name"), pagination)
+}
+
+// UpdateScanProfile saves the settings of a profile, its run history is kept
+func UpdateScanProfile(profile *ScanProfile) error {
+	return database.GlobalDB.Model(profile).Select(
+		"name", "targets", "ports", "protocols", "detect", "timeout_ms", "schedule", "enabled", "next_run_at",
+	).Updates(profile).Error
+}
+
+// DeleteScanProfile deletes a profile, its jobs and their changes are kept
+func DeleteScanProfile(id uint) error {
+	return database.GlobalDB.Delete(&ScanProfile{}, id).Error
+}
+
+// SyncFileScanProfiles saves the profiles of the file, matched by name, and disables the file profiles not in it anymore
+// The schedule state of a profile whose schedule did not change is kept
+func SyncFileScanProfiles(profiles []ScanProfile) error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		names := make([]string, 0, len(profiles))
+		for i := range profiles {
+			profile := &profiles[i]
+			profile.Source = ScanProfileSourceFile
+			names = append(names, profile.Name)
+			var existing ScanProfile
+			err := tx.Where("name = ?", profile.Name).Take(&existing).Error
+			if err == gorm.ErrRecordNotFound {
+				if err := tx.Create(profile).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			profile.ID = existing.ID
+			if existing.Schedule == profile.Schedule && existing.Enabled == profile.Enabled {
+				profile.NextRunAt = existing.NextRunAt
+			}
+			err = tx.Model(profile).Select(
+				"source", "targets", "ports", "protocols", "detect", "timeout_ms", "schedule", "enabled", "next_run_at",
+			).Updates(profile).Error
+			if err != nil {
+				return err
+			}
+		}
+		query := tx.Model(&ScanProfile{}).Where("source = ?", ScanProfileSourceFile)
+		if len(names) > 0 {
+			query = query.Where("name NOT IN ?", names)
+		}
+		return query.Updates(map[string]interface{}{"enabled": false, "next_run_at": nil}).Error
+	})
+}
+
+// DueScanProfiles returns up to limit enabled profiles due at a time
+func DueScanProfiles(now time.Time, limit int) ([]ScanProfile, error) {
+	var profiles []ScanProfile
+	err := database.GlobalDB.Where("enabled = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at").Limit(limit).Find(&profiles).Error
+	return profiles, err
+}
+
+// ClaimScanProfile moves the next run of a due profile to its next time
+// It returns false if another instance claimed the run first
+func ClaimScanProfile(profile *ScanProfile, next *time.Time) (bool, error) {
+	result := database.GlobalDB.Model(&ScanProfile{}).
+		Where("id = ? AND enabled = ? AND next_run_at = ?", profile.ID, true, profile.NextRunAt).
+		Update("next_run_at", next)
+	return result.RowsAffected == 1, result.Error
+}
+
+// RecordScanProfileRun records a run of a profile, the job started or the error that prevented it
+func RecordScanProfileRun(id uint, jobID *uint, cause string, now time.Time) error {
+	if len(cause) > 255 {
+		cause = cause[:255]
+	}
+	updates := map[string]interface{}{"last_run_at": now, "last_error": cause}
+	if jobID != nil {
+		updates["last_job_id"] = *jobID
+	}
+	return database.GlobalDB.Model(&ScanProfile{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// ScanProfileActive reports whether a job of a profile is queued or running
+func ScanProfileActive(id uint) (bool, error) {
+	var count int64
+	err := database.GlobalDB.Model(&ScanJob{}).
+		Where("profile_id = ? AND status IN ?", id, []string{ScanQueued, ScanRunning}).Count(&count).Error
+	return count > 0, err
+}
+
+// PreviousScanJob returns the last done job of a profile before a job, or nil if there is none
+func PreviousScanJob(profileID, jobID uint) (*ScanJob, error) {
+	var job ScanJob
+	err := database.GlobalDB.Where("profile_id = ? AND id < ? AND status = ?", profileID, jobID, ScanDone).
+		Order("id DESC").Take(&job).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RecordScanChanges records the changes found by a job
+func RecordScanChanges(jobID uint, changes []ScanChange) error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		if len(changes) > 0 {
+			if err := tx.CreateInBatches(changes, 100).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&ScanJob{}).Where("id = ?", jobID).Update("changes", len(changes)).Error
+	})
+}
+
+// ListScanChanges returns the changes found by a job, sorted by host and port
+func ListScanChanges(jobID uint) ([]ScanChange, error) {
+	changes := []ScanChange{}
+	err := database.GlobalDB.Where("job_id = ?", jobID).Order("host, port, protocol, id").Find(&changes).Error
+	return changes, err
+}
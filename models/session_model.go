@@ -0,0 +1,125 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/utils"
+	"gorm.io/gorm"
+)
+
+// sessionSeenResolution limits the writes of the last activity of a session to one per minute
+const sessionSeenResolution = time.Minute
+
+// Session is a login of a user on a device, the tokens of the login carry its ID
+// Revoking a session rejects its tokens before they expire
+type Session struct {
+	gorm.Model
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	Device     string     `json:"device" gorm:"size:64"`
+	UserAgent  string     `json:"user_agent" gorm:"size:512"`
+	IP         string     `json:"ip" gorm:"size:45"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// ImpersonatorID is the admin using the session, for the sessions created by an impersonation
+	ImpersonatorID *uint `json:"impersonator_id,omitempty"`
+}
+
+// CreateSession records a login of a user, lasting as long as its longest token
+func CreateSession(userID uint, userAgent, ip string, ttl time.Duration) (*Session, error) {
+	return createSession(userID, nil, userAgent, ip, ttl)
+}
+
+// CreateImpersonationSession records an admin logging in as a user
+func CreateImpersonationSession(userID, adminID uint, userAgent, ip string, ttl time.Duration) (*Session, error) {
+	return createSession(userID, &adminID, userAgent, ip, ttl)
+}
+
+func createSession(userID uint, impersonatorID *uint, userAgent, ip string, ttl time.Duration) (*Session, error) {
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	now := time.Now()
+	session := Session{
+		UserID:     userID,
+		Device:     utils.DeviceName(userAgent),
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+		// The impersonations are listed with the sessions of the user, who can revoke them
+		ImpersonatorID: impersonatorID,
+	}
+	if err := database.GlobalDB.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// TouchSession checks that a session of a user is active and records its activity
+// It returns false if the session is unknown, expired or revoked
+func TouchSession(sessionID, userID uint, ip string) (bool, error) {
+	var session Session
+	err := database.GlobalDB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if !session.active(now) {
+		return false, nil
+	}
+	if now.Sub(session.LastSeenAt) < sessionSeenResolution {
+		return true, nil
+	}
+	err = database.GlobalDB.Model(&session).UpdateColumns(map[string]interface{}{"last_seen_at": now, "ip": ip}).Error
+	return err == nil, err
+}
+
+// ListActiveSessions returns the active sessions of a user, most recently seen first
+func ListActiveSessions(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := database.GlobalDB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession revokes a session of a user
+// It returns gorm.ErrRecordNotFound if the user has no such active session
+func RevokeSession(userID, sessionID uint) error {
+	result := database.GlobalDB.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes every active session of a user but one, the session of the request
+func RevokeOtherSessions(userID, keepID uint) (int64, error) {
+	result := database.GlobalDB.Model(&Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL AND expires_at > ?", userID, keepID, time.Now()).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// RevokeUserSessions revokes every active session of a user and returns how many were revoked
+func RevokeUserSessions(userID uint) (int64, error) {
+	result := database.GlobalDB.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// active reports whether the session can still be used
+func (session *Session) active(now time.Time) bool {
+	return session.RevokedAt == nil && session.ExpiresAt.After(now)
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/zerodot618/go-huang/auth"
+	"github.com/zerodot618/go-huang/database"
+)
+
+// SigningKey is a stored signing key of the JWT tokens
+// The private key is stored as a PKCS #8 PEM block, like the other secrets of the database it must be protected
+type SigningKey struct {
+	ID          uint      `gorm:"primaryKey"`
+	KID         string    `gorm:"size:32;uniqueIndex;not null"`
+	Algorithm   string    `gorm:"size:16;not null"`
+	PrivateKey  string    `gorm:"type:text;not null"`
+	ActivatesAt time.Time `gorm:"not null"`
+	RetiresAt   time.Time `gorm:"index;not null"`
+	CreatedAt   time.Time
+}
+
+// SigningKeyStore is the auth.KeyStore of the database, it shares the keys between the instances
+type SigningKeyStore struct{}
+
+// LoadKeys returns the stored keys
+func (SigningKeyStore) LoadKeys(ctx context.Context) ([]*auth.Key, error) {
+	var records []SigningKey
+	if err := database.GlobalDB.WithContext(ctx).Order("activates_at").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	keys := make([]*auth.Key, 0, len(records))
+	for _, record := range records {
+		block, _ := pem.Decode([]byte(record.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("signing key %s: invalid PEM", record.KID)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", record.KID, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s: not a signing key", record.KID)
+		}
+		keys = append(keys, &auth.Key{
+			ID:          record.KID,
+			Algorithm:   record.Algorithm,
+			Signer:      signer,
+			ActivatesAt: record.ActivatesAt,
+			RetiresAt:   record.RetiresAt,
+		})
+	}
+	return keys, nil
+}
+
+// SaveKey stores a key
+func (SigningKeyStore) SaveKey(ctx context.Context, key *auth.Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Signer)
+	if err != nil {
+		return err
+	}
+	record := SigningKey{
+		KID:         key.ID,
+		Algorithm:   key.Algorithm,
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ActivatesAt: key.ActivatesAt,
+		RetiresAt:   key.RetiresAt,
+	}
+	return database.GlobalDB.WithContext(ctx).Create(&record).Error
+}
+
+// DeleteKeysRetiredBefore deletes the keys retired before t
+func (SigningKeyStore) DeleteKeysRetiredBefore(ctx context.Context, t time.Time) error {
+	return database.GlobalDB.WithContext(ctx).Where("retires_at < ?", t).Delete(&SigningKey{}).Error
+}
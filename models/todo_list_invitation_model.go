@@ -0,0 +1,116 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TodoListInvitationTTL is the lifetime of an invitation to a to-do list
+const TodoListInvitationTTL = 7 * 24 * time.Hour
+
+// ErrAlreadyMember is returned when a member of a to-do list already has the invited email
+var ErrAlreadyMember = errors.New("user is already a member of the list")
+
+// TodoListInvitation is a pending invitation of an email address to a to-do list
+// It is created the same way whether a user has the email or not, and the invitee only becomes
+// a member once they accept it, logged in with that address. Only the SHA-256 hash of its token is stored
+type TodoListInvitation struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	ListID      uint      `gorm:"not null;uniqueIndex:idx_list_invitation,priority:1" json:"list_id"`
+	Email       string    `gorm:"size:255;not null;uniqueIndex:idx_list_invitation,priority:2" json:"email"`
+	Role        string    `gorm:"size:16;not null" json:"role"`
+	InvitedByID uint      `gorm:"not null" json:"invited_by_id"`
+	TokenHash   string    `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt   time.Time `gorm:"not null" json:"expires_at"`
+}
+
+// InviteMember invites an email to a to-do list with a role and returns the plain token of the invitation
+// A pending invitation of the same email replaces the previous one.
+// It returns ErrAlreadyMember if a member of the list has the email
+func (list *TodoList) InviteMember(email, role string, invitedByID uint) (string, error) {
+	plain, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	invitation := TodoListInvitation{
+		ListID:      list.ID,
+		Email:       email,
+		Role:        role,
+		InvitedByID: invitedByID,
+		TokenHash:   hashToken(plain),
+		ExpiresAt:   time.Now().Add(TodoListInvitationTTL),
+	}
+	err = database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		var members int64
+		err := tx.Model(&TodoListMember{}).
+			Joins("JOIN users ON users.id = todo_list_members.user_id").
+			Where("todo_list_members.list_id = ? AND LOWER(users.email) = ?", list.ID, email).
+			Count(&members).Error
+		if err != nil {
+			return err
+		}
+		if members > 0 {
+			return ErrAlreadyMember
+		}
+		if err := tx.Where("list_id = ? AND email = ?", list.ID, email).Delete(&TodoListInvitation{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// AcceptTodoListInvitation makes a user a member of the list of an invitation sent to their email
+// The invitation is used up. It returns ErrInvalidToken if the invitation is unknown, expired,
+// sent to another email or for a deleted list, and ErrAlreadyMember if the user is already a member
+func AcceptTodoListInvitation(plain string, user *User) (*TodoListMember, error) {
+	var member TodoListMember
+	err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		var invitation TodoListInvitation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND expires_at > ?", hashToken(plain), time.Now()).
+			Take(&invitation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !strings.EqualFold(invitation.Email, user.Email)) {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+		var list TodoList
+		if err := tx.Select("id").Take(&list, invitation.ListID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+		if err := tx.Delete(&invitation).Error; err != nil {
+			return err
+		}
+		var members int64
+		if err := tx.Model(&TodoListMember{}).Where("list_id = ? AND user_id = ?", list.ID, user.ID).Count(&members).Error; err != nil {
+			return err
+		}
+		if members > 0 {
+			return ErrAlreadyMember
+		}
+		member = TodoListMember{ListID: list.ID, UserID: user.ID, Role: invitation.Role, InvitedByID: &invitation.InvitedByID}
+		if err := tx.Create(&member).Error; err != nil {
+			return err
+		}
+		return recordActivity(tx, list.ID, nil, user.ID, ActivityMemberAdded, memberDetails(user.ID, invitation.Role))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
@@ -0,0 +1,423 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Roles of the members of a to-do list
+// Viewers see the list, editors also change its to-do items and owners also manage the list and its members
+const (
+	ListRoleViewer = "viewer"
+	ListRoleEditor = "editor"
+	ListRoleOwner  = "owner"
+)
+
+// Permission is what a user wants to do with a to-do item or a list
+type Permission int
+
+// Permissions checked by AuthorizeToDo and AuthorizeList
+const (
+	PermissionView Permission = iota + 1
+	PermissionEdit
+	PermissionManage
+)
+
+// Actions recorded in the activity feed of a to-do list
+const (
+	ActivityListCreated       = "list.created"
+	ActivityListUpdated       = "list.updated"
+	ActivityMemberAdded       = "member.added"
+	ActivityMemberRoleChanged = "member.role_changed"
+	ActivityMemberRemoved     = "member.removed"
+	ActivityToDoCreated       = "todo.created"
+	ActivityToDoUpdated       = "todo.updated"
+	ActivityToDoCompleted     = "todo.completed"
+	ActivityToDoAssigned      = "todo.assigned"
+	ActivityToDoDeleted       = "todo.deleted"
+)
+
+// ErrForbidden is returned when a user can see a to-do item or a list but not do what they asked
+var ErrForbidden = errors.New("permission denied")
+
+// ErrLastOwner is returned when the last owner of a list would be removed or demoted
+var ErrLastOwner = errors.New("a list needs an owner")
+
+// ErrNotMember is returned when a to-do item is assigned to a user who is not a member of its list
+var ErrNotMember = errors.New("user is not a member of the list")
+
+// TodoList is a shared list of to-do items, its members see and change them according to their role
+type TodoList struct {
+	gorm.Model
+	Name        string `gorm:"size:100;not null" json:"name"`
+	Description string `gorm:"size:255" json:"description"`
+	CreatedByID uint   `gorm:"not null" json:"created_by_id"`
+	// Role is the role of the current user, it is not stored
+	Role string `gorm:"-" json:"role,omitempty"`
+}
+
+// TodoListMember is the membership of a user in a to-do list
+type TodoListMember struct {
+	ID          uint         `gorm:"primaryKey" json:"-"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ListID      uint         `gorm:"not null;uniqueIndex:idx_list_member,priority:1" json:"list_id"`
+	UserID      uint         `gorm:"not null;uniqueIndex:idx_list_member,priority:2;index" json:"user_id"`
+	User        *UserSummary `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role        string       `gorm:"size:16;not null" json:"role"`
+	InvitedByID *uint        `json:"invited_by_id,omitempty"`
+}
+
+// TodoActivity is an entry of the activity feed of a to-do list, it is never updated
+type TodoActivity struct {
+	ID        uint         `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time    `gorm:"index" json:"created_at"`
+	ListID    uint         `gorm:"not null;index" json:"list_id"`
+	ToDoID    *uint        `json:"todo_id,omitempty"`
+	ActorID   uint         `gorm:"not null" json:"actor_id"`
+	Actor     *UserSummary `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+	Action    string       `gorm:"size:32;not null" json:"action"`
+	Details   string       `gorm:"size:255" json:"details,omitempty"`
+}
+
+// roleGrants reports whether a role of a list member grants a permission
+func roleGrants(role string, permission Permission) bool {
+	switch role {
+	case ListRoleOwner:
+		return true
+	case ListRoleEditor:
+		return permission <= PermissionEdit
+	case ListRoleViewer:
+		return permission == PermissionView
+	}
+	return false
+}
+
+// ListRole returns the role of a user in a to-do list, or an empty string if they are not a member
+func ListRole(userID, listID uint) (string, error) {
+	var member TodoListMember
+	err := database.GlobalDB.Where("list_id = ? AND user_id = ?", listID, userID).Take(&member).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", nil
+	}
+	return member.Role, err
+}
+
+// AuthorizeToDo checks that a user has a permission on a to-do item
+// A personal to-do item is only reachable by its author, the to-do items of a list by its members
+// It returns gorm.ErrRecordNotFound if the user cannot see the to-do item, so its existence is not revealed,
+// and ErrForbidden if they can see it but lack the permission
+func AuthorizeToDo(userID uint, todo *ToDo, permission Permission) error {
+	if todo.ListID == nil {
+		if todo.AuthorID != userID {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	}
+	role, err := ListRole(userID, *todo.ListID)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		return gorm.ErrRecordNotFound
+	}
+	if !roleGrants(role, permission) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// AuthorizeList loads a to-do list and checks that a user has a permission on it
+// The returned list carries the role of the user
+// It returns gorm.ErrRecordNotFound if the user is not a member, and ErrForbidden if their role lacks the permission
+func AuthorizeList(userID, listID uint, permission Permission) (*TodoList, error) {
+	role, err := ListRole(userID, listID)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if !roleGrants(role, permission) {
+		return nil, ErrForbidden
+	}
+	var list TodoList
+	if err := database.GlobalDB.First(&list, listID).Error; err != nil {
+		return nil, err
+	}
+	list.Role = role
+	return &list, nil
+}
+
+// visibleToDos limits a query to the to-do items a user can see
+func visibleToDos(userID uint) func(*gorm.DB) *gorm.DB {
+	return toDosWithRoles(userID, ListRoleViewer, ListRoleEditor, ListRoleOwner)
+}
+
+// editableToDos limits a query to the to-do items a user can change
+func editableToDos(userID uint) func(*gorm.DB) *gorm.DB {
+	return toDosWithRoles(userID, ListRoleEditor, ListRoleOwner)
+}
+
+// toDosWithRoles limits a query to the personal to-do items of a user
+// and to those of the lists where they have one of the roles
+func toDosWithRoles(userID uint, roles ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		lists := database.GlobalDB.Model(&TodoListMember{}).Select("list_id").Where("user_id = ? AND role IN ?", userID, roles)
+		return db.Where("(list_id IS NULL AND author_id = ?) OR list_id IN (?)", userID, lists)
+	}
+}
+
+// CreateTodoList creates a to-do list owned by its creator
+func CreateTodoList(list *TodoList) error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(list).Error; err != nil {
+			return err
+		}
+		owner := TodoListMember{ListID: list.ID, UserID: list.CreatedByID, Role: ListRoleOwner}
+		if err := tx.Create(&owner).Error; err != nil {
+			return err
+		}
+		list.Role = ListRoleOwner
+		return recordActivity(tx, list.ID, nil, list.CreatedByID, ActivityListCreated, list.Name)
+	})
+}
+
+// UpdateTodoList saves the name and the description of a to-do list
+func (list *TodoList) UpdateTodoList(actorID uint) error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(list).Updates(map[string]interface{}{"name": list.Name, "description": list.Description}).Error
+		if err != nil {
+			return err
+		}
+		return recordActivity(tx, list.ID, nil, actorID, ActivityListUpdated, list.Name)
+	})
+}
+
+// DeleteTodoList deletes a to-do list with its members and invitations, and its to-do items for good with their reminders
+func (list *TodoList) DeleteTodoList() error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		if err := tx.Model(&ToDo{}).Where("list_id = ?", list.ID).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			if _, err := deleteToDos(tx, ids); err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("list_id = ?", list.ID).Delete(&TodoListMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("list_id = ?", list.ID).Delete(&TodoListInvitation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(list).Error
+	})
+}
+
+// FindUserTodoLists returns a page of the to-do lists a user is a member of, by name, with their role
+func FindUserTodoLists(userID uint, pagination Pagination) (*Page[TodoList], error) {
+	memberships := database.GlobalDB.Model(&TodoListMember{}).Select("list_id").Where("user_id = ?", userID)
+	query := database.GlobalDB.Model(&TodoList{}).Where("id IN (?)", memberships).Order("name, id")
+	page, err := paginate[TodoList](query, pagination)
+	if err != nil {
+		return nil, err
+	}
+	// The role is not a column of TodoList, it is read from the memberships
+	roles := map[uint]string{}
+	var members []TodoListMember
+	if err := database.GlobalDB.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		roles[member.ListID] = member.Role
+	}
+	for i := range page.Items {
+		page.Items[i].Role = roles[page.Items[i].ID]
+	}
+	return page, nil
+}
+
+// ListMembers returns the members of a to-do list, owners first
+func (list *TodoList) ListMembers() ([]TodoListMember, error) {
+	members := []TodoListMember{}
+	err := database.GlobalDB.Preload("User").Where("list_id = ?", list.ID).
+		Order("CASE role WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END, id").
+		Find(&members).Error
+	return members, err
+}
+
+// ChangeMemberRole changes the role of a member of a to-do list
+// It returns gorm.ErrRecordNotFound if the user is not a member, and ErrLastOwner for the last owner
+func (list *TodoList) ChangeMemberRole(userID uint, role string, actorID uint) error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		member, err := lockMember(tx, list.ID, userID)
+		if err != nil {
+			return err
+		}
+		if member.Role == ListRoleOwner && role != ListRoleOwner {
+			if err := checkOtherOwner(tx, list.ID, userID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(member).Update("role", role).Error; err != nil {
+			return err
+		}
+		return recordActivity(tx, list.ID, nil, actorID, ActivityMemberRoleChanged, memberDetails(userID, role))
+	})
+}
+
+// RemoveMember removes a member from a to-do list, the to-do items assigned to them are unassigned
+// It returns gorm.ErrRecordNotFound if the user is not a member, and ErrLastOwner for the last owner
+func (list *TodoList) RemoveMember(userID, actorID uint) error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		member, err := lockMember(tx, list.ID, userID)
+		if err != nil {
+			return err
+		}
+		if member.Role == ListRoleOwner {
+			if err := checkOtherOwner(tx, list.ID, userID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+		err = tx.Model(&ToDo{}).Where("list_id = ? AND assignee_id = ?", list.ID, userID).
+			Update("assignee_id", nil).Error
+		if err != nil {
+			return err
+		}
+		return recordActivity(tx, list.ID, nil, actorID, ActivityMemberRemoved, memberDetails(userID, member.Role))
+	})
+}
+
+// ListActivity returns a page of the activity feed of a to-do list, newest first
+func (list *TodoList) ListActivity(pagination Pagination) (*Page[TodoActivity], error) {
+	query := database.GlobalDB.Model(&TodoActivity{}).Where("list_id = ?", list.ID).Order("id DESC")
+	return paginate[TodoActivity](query, pagination, "Actor")
+}
+
+// RecordToDoActivity adds a change of a to-do item to the activity feed of its list
+// Personal to-do items have no activity feed, nothing is recorded for them
+func RecordToDoActivity(todo *ToDo, actorID uint, action string) error {
+	if todo.ListID == nil {
+		return nil
+	}
+	return recordActivity(database.GlobalDB, *todo.ListID, &todo.ID, actorID, action, todo.Title)
+}
+
+// recordActivity adds an entry to the activity feed of a to-do list
+func recordActivity(tx *gorm.DB, listID uint, todoID *uint, actorID uint, action, details string) error {
+	if len(details) > 255 {
+		details = details[:255]
+	}
+	return tx.Create(&TodoActivity{ListID: listID, ToDoID: todoID, ActorID: actorID, Action: action, Details: details}).Error
+}
+
+// lockMember loads a membership for update
+func lockMember(tx *gorm.DB, listID, userID uint) (*TodoListMember, error) {
+	var member TodoListMember
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("list_id = ? AND user_id = ?", listID, userID).Take(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// checkOtherOwner returns ErrLastOwner unless the list has an owner other than a user
+func checkOtherOwner(tx *gorm.DB, listID, userID uint) error {
+	var owners int64
+	err := tx.Model(&TodoListMember{}).
+		Where("list_id = ? AND user_id <> ? AND role = ?", listID, userID, ListRoleOwner).
+		Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// memberDetails describes a membership in the activity feed
+func memberDetails(userID uint, role string) string {
+	return "user " + strconv.FormatUint(uint64(userID), 10) + " as " + role
+}
+
+// purgeUserTodoLists removes a user from the to-do lists and deletes the lists left without members
+// A list left without an owner is handed to its oldest remaining member, see promoteOldestMember
+func purgeUserTodoLists(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&ToDo{}).Where("assignee_id = ?", userID).Update("assignee_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("actor_id = ?", userID).Delete(&TodoActivity{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("invited_by_id = ?", userID).Delete(&TodoListInvitation{}).Error; err != nil {
+		return err
+	}
+	var owned []uint
+	if err := tx.Model(&TodoListMember{}).Where("user_id = ? AND role = ?", userID, ListRoleOwner).Pluck("list_id", &owned).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&TodoListMember{}).Error; err != nil {
+		return err
+	}
+	for _, listID := range owned {
+		if err := promoteOldestMember(tx, listID); err != nil {
+			return err
+		}
+	}
+	var orphans []uint
+	err := tx.Unscoped().Model(&TodoList{}).
+		Where("id NOT IN (?)", tx.Model(&TodoListMember{}).Select("list_id")).
+		Pluck("id", &orphans).Error
+	if err != nil || len(orphans) == 0 {
+		return err
+	}
+	listed := tx.Unscoped().Model(&ToDo{}).Select("id").Where("list_id IN ?", orphans)
+	if err := tx.Exec("DELETE FROM todo_tags WHERE to_do_id IN (?)", listed).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM reminders WHERE to_do_id IN (?)", listed).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("list_id IN ?", orphans).Delete(&ToDo{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("list_id IN ?", orphans).Delete(&TodoActivity{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("list_id IN ?", orphans).Delete(&TodoListInvitation{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN ?", orphans).Delete(&TodoList{}).Error
+}
+
+// promoteOldestMember makes the oldest member of a list its owner if it has no owner left
+// The change is recorded in the activity feed as made by the new owner, a list without members is left as is
+func promoteOldestMember(tx *gorm.DB, listID uint) error {
+	var owners int64
+	if err := tx.Model(&TodoListMember{}).Where("list_id = ? AND role = ?", listID, ListRoleOwner).Count(&owners).Error; err != nil || owners > 0 {
+		return err
+	}
+	var member TodoListMember
+	err := tx.Where("list_id = ?", listID).Order("created_at, id").Take(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&member).Update("role", ListRoleOwner).Error; err != nil {
+		return err
+	}
+	return recordActivity(tx, listID, nil, member.UserID, ActivityMemberRoleChanged, memberDetails(member.UserID, ListRoleOwner))
+}
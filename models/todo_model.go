@@ -0,0 +1,519 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/recurrence"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of the to-do items
+const (
+	ToDoStatusOpen       = "open"
+	ToDoStatusInProgress = "in_progress"
+	ToDoStatusDone       = "done"
+)
+
+// Priorities of the to-do items, sorted from the lowest
+const (
+	PriorityNone   = 0
+	PriorityLow    = 1
+	PriorityMedium = 2
+	PriorityHigh   = 3
+)
+
+// maxCatchUpOccurrences bounds the occurrences skipped to reach a due time in the future,
+// when a recurring to-do item is completed long after it was due
+const maxCatchUpOccurrences = 1000
+
+// toDoSorts are the orders the to-do items can be listed in, by the sort query parameter
+var toDoSorts = map[string]string{
+	"created_at":  "created_at ASC, id ASC",
+	"-created_at": "created_at DESC, id DESC",
+	"due_at":      "due_at IS NULL, due_at ASC, id ASC",
+	"-due_at":     "due_at IS NULL, due_at DESC, id DESC",
+	"priority":    "priority ASC, id ASC",
+	"-priority":   "priority DESC, id DESC",
+	"title":       "title ASC, id ASC",
+	"-title":      "title DESC, id DESC",
+}
+
+// ToDo is a struct contains information about a to-do item
+// A personal to-do item belongs to its author, the only user who can see and change it,
+// the to-do items of a list are shared with its members, see AuthorizeToDo
+// Completing a recurring to-do item creates its next occurrence, with the same title and the next due time
+type ToDo struct {
+	gorm.Model
+	// Title is unique among the to-do items of an author, except for the occurrences of a recurring one.
+	// The to-do items are deleted for good, see deleteToDos, a soft deleted one would keep its title taken
+	Title       string       `gorm:"size:100;not null;uniqueIndex:idx_todo_author_title_occurrence,priority:2" json:"title"`
+	Content     string       `gorm:"type:text;not null" json:"content"`
+	Status      string       `gorm:"size:16;not null;default:open;index" json:"status"`
+	Priority    int          `gorm:"not null;default:0" json:"priority"`
+	DueAt       *time.Time   `gorm:"index" json:"due_at"`
+	CompletedAt *time.Time   `json:"completed_at"`
+	Tags        []Tag        `gorm:"many2many:todo_tags" json:"tags"`
+	Author      *UserSummary `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	AuthorID    uint         `gorm:"not null;uniqueIndex:idx_todo_author_title_occurrence,priority:1" json:"author_id"`
+	ListID      *uint        `gorm:"index" json:"list_id"`
+	// Assignee is the member of the list in charge of the to-do item
+	Assignee   *UserSummary `gorm:"foreignKey:AssigneeID" json:"assignee,omitempty"`
+	AssigneeID *uint        `gorm:"index" json:"assignee_id"`
+	// Recurrence is the recurrence rule in its canonical form, see the recurrence package
+	Recurrence string `gorm:"size:255;not null;default:''" json:"recurrence,omitempty"`
+	// Occurrence counts the occurrences of a recurring to-do item before this one
+	Occurrence       int   `gorm:"not null;default:0;uniqueIndex:idx_todo_author_title_occurrence,priority:3" json:"occurrence"`
+	NextOccurrenceID *uint `json:"next_occurrence_id,omitempty"`
+	// NextOccurrence is the occurrence created when the to-do item was completed by the last change
+	NextOccurrence *ToDo `gorm:"-" json:"next_occurrence,omitempty"`
+	// RemindBefore is how many minutes before the due time a reminder is sent, nil for none
+	RemindBefore *int `json:"remind_before_minutes"`
+}
+
+// Tag is a label of to-do items, every user has their own tags
+type Tag struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"not null;uniqueIndex:idx_tag_user_name,priority:1" json:"-"`
+	Name   string `gorm:"size:32;not null;uniqueIndex:idx_tag_user_name,priority:2" json:"name"`
+}
+
+// ToDoFilter filters and sorts the to-do items of a user, bound from the query parameters
+type ToDoFilter struct {
+	Query      string     `form:"q" binding:"omitempty,max=100"`
+	Status     string     `form:"status" binding:"omitempty,oneof=open in_progress done"`
+	Priority   *int       `form:"priority" binding:"omitempty,min=0,max=3"`
+	Tag        string     `form:"tag" binding:"omitempty,max=32"`
+	DueBefore  *time.Time `form:"due_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	DueAfter   *time.Time `form:"due_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	ListID     *uint      `form:"list_id"`
+	AssigneeID *uint      `form:"assignee_id"`
+	Sort       string     `form:"sort" binding:"omitempty,oneof=created_at -created_at due_at -due_at priority -priority title -title"`
+}
+
+// MigrateToDos migrates the to-do items, their tags and their reminders
+// The titles were unique across all the users, then per author, they are now unique per author and occurrence
+func MigrateToDos() error {
+	migrator := database.GlobalDB.Migrator()
+	for _, index := range []string{"title", "idx_todo_author_title"} {
+		if migrator.HasTable(&ToDo{}) && migrator.HasIndex(&ToDo{}, index) {
+			if err := migrator.DropIndex(&ToDo{}, index); err != nil {
+				return err
+			}
+		}
+	}
+	if err := database.GlobalDB.AutoMigrate(&Tag{}, &TodoList{}, &TodoListMember{}, &TodoListInvitation{}, &TodoActivity{}, &ToDo{}, &Reminder{}); err != nil {
+		return err
+	}
+	// The to-do items were soft deleted, their titles stayed taken: they are now deleted for good
+	var deleted []uint
+	if err := database.GlobalDB.Unscoped().Model(&ToDo{}).Where("deleted_at IS NOT NULL").Pluck("id", &deleted).Error; err != nil || len(deleted) == 0 {
+		return err
+	}
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		_, err := deleteToDos(tx, deleted)
+		return err
+	})
+}
+
+// Prepare is a method that prepares the ToDo struct for saving
+// It trims the title and content strings, drops the loaded associations
+// and sets the completion time from the status
+func (t *ToDo) Prepare() {
+	t.Title = strings.TrimSpace(t.Title)
+	t.Content = strings.TrimSpace(t.Content)
+	if t.Status == "" {
+		t.Status = ToDoStatusOpen
+	}
+	t.Author = nil
+	t.Assignee = nil
+	t.Tags = nil
+	t.NextOccurrence = nil
+	t.SetStatus(t.Status)
+}
+
+// SetStatus changes the status of the to-do item, the completion time is set when it is done
+func (t *ToDo) SetStatus(status string) {
+	t.Status = status
+	if status != ToDoStatusDone {
+		t.CompletedAt = nil
+		return
+	}
+	if t.CompletedAt == nil {
+		now := time.Now()
+		t.CompletedAt = &now
+	}
+}
+
+// IsOverdue reports whether the to-do item is past its due time and not done
+func (t *ToDo) IsOverdue(now time.Time) bool {
+	return t.Status != ToDoStatusDone && t.DueAt != nil && t.DueAt.Before(now)
+}
+
+// nextDueAt returns the due time of the occurrence following the to-do item, the first one after now
+// The occurrences follow the due time, or the completion time of a to-do item without one.
+// It returns false if the recurrence is over
+func (t *ToDo) nextDueAt(now time.Time) (time.Time, bool) {
+	rule, err := recurrence.Parse(t.Recurrence)
+	if err != nil {
+		return time.Time{}, false
+	}
+	next := now
+	if t.DueAt != nil {
+		next = *t.DueAt
+	} else if t.CompletedAt != nil {
+		next = *t.CompletedAt
+	}
+	for i := 0; i < maxCatchUpOccurrences; i++ {
+		var ok bool
+		if next, ok = rule.Next(next); !ok {
+			return time.Time{}, false
+		}
+		if next.After(now) {
+			break
+		}
+	}
+	return next, true
+}
+
+// spawnNextOccurrence creates the next occurrence of a recurring to-do item once it is done
+// The to-do item is locked and linked to the next occurrence, so it is only created once
+func (t *ToDo) spawnNextOccurrence(tx *gorm.DB) (*ToDo, error) {
+	if t.Recurrence == "" || t.Status != ToDoStatusDone {
+		return nil, nil
+	}
+	var current ToDo
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Tags").First(&current, t.ID).Error
+	if err != nil {
+		return nil, err
+	}
+	if current.NextOccurrenceID != nil {
+		return nil, nil
+	}
+	dueAt, ok := current.nextDueAt(time.Now())
+	if !ok {
+		return nil, nil
+	}
+	next := ToDo{
+		Title:        current.Title,
+		Content:      current.Content,
+		Status:       ToDoStatusOpen,
+		Priority:     current.Priority,
+		DueAt:        &dueAt,
+		AuthorID:     current.AuthorID,
+		ListID:       current.ListID,
+		AssigneeID:   current.AssigneeID,
+		Recurrence:   current.Recurrence,
+		RemindBefore: current.RemindBefore,
+		Occurrence:   current.Occurrence + 1,
+	}
+	if err := tx.Omit(clause.Associations).Create(&next).Error; err != nil {
+		return nil, err
+	}
+	if len(current.Tags) > 0 {
+		if err := tx.Model(&next).Association("Tags").Replace(current.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Model(&current).UpdateColumn("next_occurrence_id", next.ID).Error; err != nil {
+		return nil, err
+	}
+	t.NextOccurrenceID = &next.ID
+	return &next, scheduleReminder(tx, &next)
+}
+
+// afterChange creates the next occurrence of the to-do item if it was completed, and schedules its reminder
+func (t *ToDo) afterChange(tx *gorm.DB) error {
+	next, err := t.spawnNextOccurrence(tx)
+	if err != nil {
+		return err
+	}
+	t.NextOccurrence = next
+	return scheduleReminder(tx, t)
+}
+
+// SaveToDo is a method that saves a new ToDo struct with its tags to the database and loads its author
+func (t *ToDo) SaveToDo(tags []string) error {
+	t.Prepare()
+	err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(t).Error; err != nil {
+			return err
+		}
+		if err := t.replaceTags(tx, tags); err != nil {
+			return err
+		}
+		return t.afterChange(tx)
+	})
+	if err != nil {
+		return err
+	}
+	return t.loadAssociations()
+}
+
+// UpdateAToDo is a method that saves the fields of a ToDo struct to the database
+// The tags are replaced, unless they are nil. The caller checks the permission with AuthorizeToDo
+// It returns gorm.ErrRecordNotFound if the to-do item was deleted
+func (t *ToDo) UpdateAToDo(tags []string) error {
+	t.Prepare()
+	err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&ToDo{}).
+			Where("id = ?", t.ID).
+			Updates(map[string]interface{}{
+				"title":         t.Title,
+				"content":       t.Content,
+				"status":        t.Status,
+				"priority":      t.Priority,
+				"due_at":        t.DueAt,
+				"completed_at":  t.CompletedAt,
+				"recurrence":    t.Recurrence,
+				"remind_before": t.RemindBefore,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if tags != nil {
+			if err := t.replaceTags(tx, tags); err != nil {
+				return err
+			}
+		}
+		return t.afterChange(tx)
+	})
+	if err != nil {
+		return err
+	}
+	return t.loadAssociations()
+}
+
+// AssignToDo is a method that assigns the to-do item of a list to one of its members, nil unassigns it
+// It returns ErrNotMember if the user is not a member of the list, or the to-do item is personal
+func (t *ToDo) AssignToDo(assigneeID *uint) error {
+	if assigneeID != nil {
+		if t.ListID == nil {
+			return ErrNotMember
+		}
+		role, err := ListRole(*assigneeID, *t.ListID)
+		if err != nil {
+			return err
+		}
+		if role == "" {
+			return ErrNotMember
+		}
+	}
+	if err := database.GlobalDB.Model(t).UpdateColumn("assignee_id", assigneeID).Error; err != nil {
+		return err
+	}
+	t.AssigneeID = assigneeID
+	return t.loadAssociations()
+}
+
+// DeleteAToDo is a method that deletes a ToDo struct from the database, see deleteToDos
+// The caller checks the permission with AuthorizeToDo
+// It returns gorm.ErrRecordNotFound if the to-do item was already deleted
+func (t *ToDo) DeleteAToDo() error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		deleted, err := deleteToDos(tx, []uint{t.ID})
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// FindToDo loads a to-do item with its author, assignee and tags, and checks that a user has a permission on it
+// It returns gorm.ErrRecordNotFound if the user cannot see the to-do item, and ErrForbidden if they lack the permission
+func FindToDo(userID, id uint, permission Permission) (*ToDo, error) {
+	var todo ToDo
+	err := database.GlobalDB.Preload("Author").Preload("Assignee").Preload("Tags").First(&todo, id).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := AuthorizeToDo(userID, &todo, permission); err != nil {
+		return nil, err
+	}
+	return &todo, nil
+}
+
+// FindUserToDos returns a page of the to-do items a user can see matching a filter, newest first by default
+// The associations are preloaded in one query each
+func FindUserToDos(userID uint, filter ToDoFilter, pagination Pagination) (*Page[ToDo], error) {
+	query := database.GlobalDB.Model(&ToDo{}).Scopes(visibleToDos(userID))
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		query = query.Where("title LIKE ? OR content LIKE ?", pattern, pattern)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Priority != nil {
+		query = query.Where("priority = ?", *filter.Priority)
+	}
+	if tag := normalizeTag(filter.Tag); tag != "" {
+		tagged := database.GlobalDB.Table("todo_tags").Select("todo_tags.to_do_id").
+			Joins("JOIN tags ON tags.id = todo_tags.tag_id").
+			Where("tags.name = ?", tag)
+		query = query.Where("id IN (?)", tagged)
+	}
+	if filter.DueBefore != nil {
+		query = query.Where("due_at < ?", *filter.DueBefore)
+	}
+	if filter.DueAfter != nil {
+		query = query.Where("due_at >= ?", *filter.DueAfter)
+	}
+	if filter.ListID != nil {
+		query = query.Where("list_id = ?", *filter.ListID)
+	}
+	if filter.AssigneeID != nil {
+		query = query.Where("assignee_id = ?", *filter.AssigneeID)
+	}
+	order, ok := toDoSorts[filter.Sort]
+	if !ok {
+		order = toDoSorts["-created_at"]
+	}
+	return paginate[ToDo](query.Order(order), pagination, "Author", "Assignee", "Tags")
+}
+
+// FindOverdueToDos returns a page of the to-do items a user can see past their due time and not done,
+// the most overdue first
+func FindOverdueToDos(userID uint, now time.Time, pagination Pagination) (*Page[ToDo], error) {
+	query := database.GlobalDB.Model(&ToDo{}).Scopes(visibleToDos(userID)).
+		Where("status <> ? AND due_at < ?", ToDoStatusDone, now).
+		Order("due_at ASC, id ASC")
+	return paginate[ToDo](query, pagination, "Author", "Assignee", "Tags")
+}
+
+// CompleteUserToDos marks to-do items a user can change as done and returns those that were not done yet,
+// with the next occurrences of the recurring ones. The others are ignored
+func CompleteUserToDos(userID uint, ids []uint) ([]ToDo, error) {
+	var todos []ToDo
+	err := database.GlobalDB.Scopes(editableToDos(userID)).
+		Where("id IN ? AND status <> ?", ids, ToDoStatusDone).Find(&todos).Error
+	if err != nil || len(todos) == 0 {
+		return todos, err
+	}
+	now := time.Now()
+	err = database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&ToDo{}).Where("id IN ?", toDoIDs(todos)).
+			Updates(map[string]interface{}{"status": ToDoStatusDone, "completed_at": now}).Error
+		if err != nil {
+			return err
+		}
+		for i := range todos {
+			todos[i].Status = ToDoStatusDone
+			todos[i].CompletedAt = &now
+			if err := todos[i].afterChange(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return todos, err
+}
+
+// DeleteUserToDos deletes to-do items a user can change and returns them, the others are ignored
+func DeleteUserToDos(userID uint, ids []uint) ([]ToDo, error) {
+	var todos []ToDo
+	err := database.GlobalDB.Scopes(editableToDos(userID)).Where("id IN ?", ids).Find(&todos).Error
+	if err != nil || len(todos) == 0 {
+		return todos, err
+	}
+	err = database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		_, err := deleteToDos(tx, toDoIDs(todos))
+		return err
+	})
+	return todos, err
+}
+
+// deleteToDos deletes for good to-do items with their tags and reminders, and returns how many were deleted
+// They are not soft deleted, the titles of the deleted to-do items can be used again by their authors
+func deleteToDos(tx *gorm.DB, ids []uint) (int64, error) {
+	if err := tx.Exec("DELETE FROM todo_tags WHERE to_do_id IN ?", ids).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("to_do_id IN ?", ids).Delete(&Reminder{}).Error; err != nil {
+		return 0, err
+	}
+	result := tx.Unscoped().Where("id IN ?", ids).Delete(&ToDo{})
+	return result.RowsAffected, result.Error
+}
+
+// ListUserTags returns the tags of a user, by name
+func ListUserTags(userID uint) ([]Tag, error) {
+	tags := []Tag{}
+	err := database.GlobalDB.Where("user_id = ?", userID).Order("name").Find(&tags).Error
+	return tags, err
+}
+
+// purgeUserToDos deletes for good the to-do items and the tags of a user
+func purgeUserToDos(tx *gorm.DB, userID uint) error {
+	owned := tx.Unscoped().Model(&ToDo{}).Select("id").Where("author_id = ?", userID)
+	if err := tx.Exec("DELETE FROM todo_tags WHERE to_do_id IN (?)", owned).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM reminders WHERE to_do_id IN (?)", owned).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("author_id = ?", userID).Delete(&ToDo{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", userID).Delete(&Tag{}).Error
+}
+
+// replaceTags replaces the tags of the to-do item, creating the tags of the author it does not have yet
+func (t *ToDo) replaceTags(tx *gorm.DB, names []string) error {
+	tags := []Tag{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = normalizeTag(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		tag := Tag{UserID: t.AuthorID, Name: name}
+		if err := tx.Where(tag).FirstOrCreate(&tag).Error; err != nil {
+			return err
+		}
+		tags = append(tags, tag)
+	}
+	return tx.Model(t).Association("Tags").Replace(tags)
+}
+
+// loadAssociations loads the author, the assignee and the tags of the to-do item
+func (t *ToDo) loadAssociations() error {
+	var author UserSummary
+	if err := database.GlobalDB.Where("id = ?", t.AuthorID).Take(&author).Error; err != nil {
+		return err
+	}
+	t.Author = &author
+	t.Assignee = nil
+	if t.AssigneeID != nil {
+		var assignee UserSummary
+		if err := database.GlobalDB.Where("id = ?", *t.AssigneeID).Take(&assignee).Error; err != nil {
+			return err
+		}
+		t.Assignee = &assignee
+	}
+	t.Tags = []Tag{}
+	return database.GlobalDB.Model(t).Association("Tags").Find(&t.Tags)
+}
+
+// toDoIDs returns the IDs of to-do items
+func toDoIDs(todos []ToDo) []uint {
+	ids := make([]uint, len(todos))
+	for i := range todos {
+		ids[i] = todos[i].ID
+	}
+	return ids
+}
+
+// normalizeTag trims and lowercases a tag name
+func normalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -1,21 +1,144 @@
 package models
 
 import (
+	"errors"
 	"math/rand"
+	"net/http"
+	neturl "net/url"
+	"strings"
 	"time"
 
 	"github.com/zerodot618/go-huang/database"
 	"gorm.io/gorm"
 )
 
+// DefaultRedirectType is the HTTP status used when a short URL does not specify one.
+// 302 is used so browsers do not cache the redirect and every visit is counted.
+const DefaultRedirectType = http.StatusFound
+
+// ErrInvalidRedirectType is returned when a redirect type is not one of 301, 302, 307 or 308
+var ErrInvalidRedirectType = errors.New("redirect type must be one of 301, 302, 307 or 308")
+
+// ErrNoDestination is returned when a short URL has neither a long URL nor any destinations
+var ErrNoDestination = errors.New("long_url or destinations is required")
+
 // URL is a struct that stores the information for a URL
 type URL struct {
 	gorm.Model
-	LongURL      string     `json:"long_url" gorm:"unique"`
-	ShortURL     string     `json:"short_url" gorm:"unique"`
-	AccessCount  uint       `json:"access_count"`
-	LastAccessed *time.Time `json:"last_accessed"`
-	AccessPlace  string     `json:"access_place"`
+	LongURL      string           `json:"long_url" gorm:"unique" binding:"omitempty,url"`
+	ShortURL     string           `json:"short_url" gorm:"unique"`
+	RedirectType int              `json:"redirect_type" gorm:"not null;default:302" binding:"omitempty,oneof=301 302 307 308"`
+	ForwardQuery bool             `json:"forward_query" gorm:"not null;default:false"`
+	Destinations []URLDestination `json:"destinations" gorm:"foreignKey:URLID" binding:"omitempty,dive"`
+	AccessCount  uint             `json:"access_count"`
+	LastAccessed *time.Time       `json:"last_accessed"`
+	AccessPlace  string           `json:"access_place"`
+	// CreatedBy is the user who created the short URL, only this user can update it.
+	// It is 0 for the short URLs created anonymously, which cannot be updated
+	CreatedBy uint `json:"created_by" gorm:"index"`
+}
+
+// URLDestination is one of several weighted targets of a short URL.
+// It is used for A/B testing, the destination is picked at random in proportion to its weight.
+type URLDestination struct {
+	gorm.Model
+	URLID       uint   `json:"-" gorm:"index;not null"`
+	LongURL     string `json:"long_url" gorm:"not null" binding:"required,url"`
+	Weight      uint   `json:"weight" gorm:"not null;default:1"`
+	AccessCount uint   `json:"access_count"`
+}
+
+// IsValidRedirectType reports whether status is a redirect type supported by short URLs
+func IsValidRedirectType(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// Prepare is a method that fills the defaults of a URL and validates it before saving
+// It returns an error if the redirect type is not supported or there is no destination
+func (u *URL) Prepare() error {
+	if u.RedirectType == 0 {
+		u.RedirectType = DefaultRedirectType
+	}
+	if !IsValidRedirectType(u.RedirectType) {
+		return ErrInvalidRedirectType
+	}
+	for i := range u.Destinations {
+		if u.Destinations[i].LongURL == "" {
+			return ErrNoDestination
+		}
+		if u.Destinations[i].Weight == 0 {
+			u.Destinations[i].Weight = 1
+		}
+	}
+	// The long URL stays the primary destination, fall back to the first weighted one
+	if u.LongURL == "" && len(u.Destinations) > 0 {
+		u.LongURL = u.Destinations[0].LongURL
+	}
+	if u.LongURL == "" {
+		return ErrNoDestination
+	}
+	return nil
+}
+
+// PickDestination is a method used to choose where a visit of the short URL is sent to
+// It returns the chosen destination, or nil when the long URL should be used
+func (u *URL) PickDestination() *URLDestination {
+	var total uint
+	for _, d := range u.Destinations {
+		total += d.Weight
+	}
+	if total == 0 {
+		return nil
+	}
+	n := uint(rand.Int63n(int64(total)))
+	for i := range u.Destinations {
+		if n < u.Destinations[i].Weight {
+			return &u.Destinations[i]
+		}
+		n -= u.Destinations[i].Weight
+	}
+	return nil
+}
+
+// MergeQuery adds the query parameters of the short URL request to the long URL.
+// Parameters already present in the long URL are kept, so UTM tags set on the link win.
+// The new parameters are appended, the query of the long URL is left as it is written,
+// since some links, like signed ones, break when it is re-encoded or reordered.
+func MergeQuery(longURL string, query neturl.Values) (string, error) {
+	if len(query) == 0 {
+		return longURL, nil
+	}
+	target, err := neturl.Parse(longURL)
+	if err != nil {
+		return "", err
+	}
+	present := target.Query()
+	added := neturl.Values{}
+	for key, vals := range query {
+		if _, ok := present[key]; ok {
+			continue
+		}
+		added[key] = vals
+	}
+	if len(added) == 0 {
+		return longURL, nil
+	}
+	base, fragment, hasFragment := strings.Cut(longURL, "#")
+	switch {
+	case !strings.Contains(base, "?"):
+		base += "?"
+	case !strings.HasSuffix(base, "?") && !strings.HasSuffix(base, "&"):
+		base += "&"
+	}
+	base += added.Encode()
+	if hasFragment {
+		base += "#" + fragment
+	}
+	return base, nil
 }
 
 // GenerateShortURL is a method used to generate a random short URL
@@ -31,16 +154,16 @@ func (u *URL) GenerateShortURL() {
 }
 
 // CreateURL is a method used to create a new URL in the database
-// It takes in a pointer to a URL struct as a parameter and returns nothing
-func CreateURL(url *URL) {
-	database.GlobalDB.Create(&url)
+// It takes in a pointer to a URL struct as a parameter and returns an error
+func CreateURL(url *URL) error {
+	return database.GlobalDB.Create(&url).Error
 }
 
 // GetURLByShortURL is a method used to get a URL from the database by its short URL
 // It takes a string as a parameter and returns a URL struct and an error
 func GetURLByShortURL(shortURL string) (URL, error) {
 	var url URL
-	if err := database.GlobalDB.Where("short_url = ?", shortURL).First(&url).Error; err != nil {
+	if err := database.GlobalDB.Preload("Destinations").Where("short_url = ?", shortURL).First(&url).Error; err != nil {
 		return url, err
 	}
 	return url, nil
@@ -48,6 +171,46 @@ func GetURLByShortURL(shortURL string) (URL, error) {
 
 // UpdateURL is a method used to update a URL in the database
 func UpdateURL(url *URL) error {
-	result := database.GlobalDB.Save(url)
+	result := database.GlobalDB.Omit("Destinations").Save(url)
 	return result.Error
 }
+
+// RecordVisit is a method used to count a visit of a short URL and remember when and from where it came
+// The count is incremented in the database, so concurrent visits are all counted
+func RecordVisit(url *URL, place string) error {
+	now := time.Now()
+	url.AccessCount++
+	url.LastAccessed = &now
+	url.AccessPlace = place
+	return database.GlobalDB.Model(url).UpdateColumns(map[string]interface{}{
+		"access_count":  gorm.Expr("access_count + ?", 1),
+		"last_accessed": now,
+		"access_place":  place,
+	}).Error
+}
+
+// ReplaceDestinations is a method used to replace all the weighted destinations of a URL
+func ReplaceDestinations(url *URL, destinations []URLDestination) error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("url_id = ?", url.ID).Delete(&URLDestination{}).Error; err != nil {
+			return err
+		}
+		for i := range destinations {
+			destinations[i].ID = 0
+			destinations[i].URLID = url.ID
+		}
+		if len(destinations) > 0 {
+			if err := tx.Create(&destinations).Error; err != nil {
+				return err
+			}
+		}
+		url.Destinations = destinations
+		return nil
+	})
+}
+
+// IncrementDestinationCount is a method used to count a visit sent to a weighted destination
+func IncrementDestinationCount(destination *URLDestination) error {
+	destination.AccessCount++
+	return database.GlobalDB.Model(destination).UpdateColumn("access_count", gorm.Expr("access_count + ?", 1)).Error
+}
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	neturl "net/url"
+	"testing"
+)
+
+func TestIsValidRedirectType(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusMovedPermanently, true},
+		{http.StatusFound, true},
+		{http.StatusTemporaryRedirect, true},
+		{http.StatusPermanentRedirect, true},
+		{0, false},
+		{http.StatusOK, false},
+		{http.StatusSeeOther, false},
+		{http.StatusNotModified, false},
+	}
+	for _, tt := range tests {
+		if got := IsValidRedirectType(tt.status); got != tt.want {
+			t.Errorf("IsValidRedirectType(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestURLPrepare(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          URL
+		wantErr      error
+		wantRedirect int
+		wantLongURL  string
+		wantWeights  []uint
+	}{
+		{
+			name:         "default redirect type",
+			url:          URL{LongURL: "https://example.com"},
+			wantRedirect: http.StatusFound,
+			wantLongURL:  "https://example.com",
+		},
+		{
+			name:         "redirect type kept",
+			url:          URL{LongURL: "https://example.com", RedirectType: http.StatusMovedPermanently},
+			wantRedirect: http.StatusMovedPermanently,
+			wantLongURL:  "https://example.com",
+		},
+		{
+			name:    "invalid redirect type",
+			url:     URL{LongURL: "https://example.com", RedirectType: http.StatusOK},
+			wantErr: ErrInvalidRedirectType,
+		},
+		{
+			name:    "no destination",
+			url:     URL{},
+			wantErr: ErrNoDestination,
+		},
+		{
+			name:    "destination without URL",
+			url:     URL{LongURL: "https://example.com", Destinations: []URLDestination{{Weight: 1}}},
+			wantErr: ErrNoDestination,
+		},
+		{
+			name: "long URL from the first destination and default weight",
+			url: URL{Destinations: []URLDestination{
+				{LongURL: "https://a.example.com"},
+				{LongURL: "https://b.example.com", Weight: 3},
+			}},
+			wantRedirect: http.StatusFound,
+			wantLongURL:  "https://a.example.com",
+			wantWeights:  []uint{1, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := tt.url
+			err := u.Prepare()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Prepare() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if u.RedirectType != tt.wantRedirect {
+				t.Errorf("RedirectType = %d, want %d", u.RedirectType, tt.wantRedirect)
+			}
+			if u.LongURL != tt.wantLongURL {
+				t.Errorf("LongURL = %q, want %q", u.LongURL, tt.wantLongURL)
+			}
+			for i, w := range tt.wantWeights {
+				if u.Destinations[i].Weight != w {
+					t.Errorf("Destinations[%d].Weight = %d, want %d", i, u.Destinations[i].Weight, w)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		longURL string
+		query   neturl.Values
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "no query",
+			longURL: "https://example.com/page?a=1",
+			want:    "https://example.com/page?a=1",
+		},
+		{
+			name:    "query added",
+			longURL: "https://example.com/page",
+			query:   neturl.Values{"ref": {"mail"}},
+			want:    "https://example.com/page?ref=mail",
+		},
+		{
+			name:    "long URL parameters win",
+			longURL: "https://example.com/page?utm_source=link",
+			query:   neturl.Values{"utm_source": {"visitor"}, "id": {"7"}},
+			want:    "https://example.com/page?utm_source=link&id=7",
+		},
+		{
+			name:    "long URL query kept as written",
+			longURL: "https://example.com/page?b=2&a=%7e&sig=x+y",
+			query:   neturl.Values{"c": {"3"}},
+			want:    "https://example.com/page?b=2&a=%7e&sig=x+y&c=3",
+		},
+		{
+			name:    "every parameter already present",
+			longURL: "https://example.com/page?b=2&a=1",
+			query:   neturl.Values{"a": {"9"}},
+			want:    "https://example.com/page?b=2&a=1",
+		},
+		{
+			name:    "empty long URL query",
+			longURL: "https://example.com/page?",
+			query:   neturl.Values{"x": {"1"}},
+			want:    "https://example.com/page?x=1",
+		},
+		{
+			name:    "repeated parameters",
+			longURL: "https://example.com/",
+			query:   neturl.Values{"tag": {"a", "b"}},
+			want:    "https://example.com/?tag=a&tag=b",
+		},
+		{
+			name:    "fragment kept",
+			longURL: "https://example.com/page#top",
+			query:   neturl.Values{"x": {"1"}},
+			want:    "https://example.com/page?x=1#top",
+		},
+		{
+			name:    "invalid long URL",
+			longURL: "://bad",
+			query:   neturl.Values{"x": {"1"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeQuery(tt.longURL, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MergeQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []uint
+	}{
+		{"no destination", nil},
+		{"single", []uint{1}},
+		{"even", []uint{1, 1}},
+		{"weighted", []uint{1, 3}},
+		{"three", []uint{2, 5, 3}},
+		{"zero weight never picked", []uint{0, 4}},
+	}
+	const picks = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := URL{LongURL: "https://example.com"}
+			var total uint
+			for i, w := range tt.weights {
+				u.Destinations = append(u.Destinations, URLDestination{LongURL: "https://example.com/" + string(rune('a'+i)), Weight: w})
+				total += w
+			}
+			counts := make([]int, len(u.Destinations))
+			for i := 0; i < picks; i++ {
+				d := u.PickDestination()
+				if total == 0 {
+					if d != nil {
+						t.Fatalf("PickDestination() = %v, want nil", d)
+					}
+					continue
+				}
+				if d == nil {
+					t.Fatal("PickDestination() = nil, want a destination")
+				}
+				counts[d.LongURL[len(d.LongURL)-1]-'a']++
+			}
+			for i, w := range tt.weights {
+				want := float64(picks) * float64(w) / float64(total)
+				got := float64(counts[i])
+				// Within 5% of the picks of the expected share
+				if got < want-0.05*picks || got > want+0.05*picks {
+					t.Errorf("destination %d picked %d times, want about %.0f", i, counts[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/logging"
+	"gorm.io/gorm"
+)
+
+// AccountDeletionGracePeriod is how long a deleted account can be restored by logging in, before it is purged
+const AccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+// accountPurgeInterval is how often RunAccountPurge looks for accounts to purge
+const accountPurgeInterval = time.Hour
+
+// ErrNoPendingEmail is returned when an email change is confirmed but none was requested
+var ErrNoPendingEmail = errors.New("no pending email change")
+
+// User statuses, used to filter the users
+const (
+	UserStatusActive          = "active"
+	UserStatusDisabled        = "disabled"
+	UserStatusPendingDeletion = "pending_deletion"
+)
+
+// UserFilter filters the users searched by admins
+type UserFilter struct {
+	Query  string `form:"q" binding:"omitempty,max=100"`
+	Status string `form:"status" binding:"omitempty,oneof=active disabled pending_deletion"`
+	Role   string `form:"role" binding:"omitempty,oneof=user admin"`
+}
+
+// IsDisabled reports whether an admin disabled the user
+func (user *User) IsDisabled() bool {
+	return user.DisabledAt != nil
+}
+
+// UpdateName changes the name of the user
+func (user *User) UpdateName(name string) error {
+	user.Name = name
+	return database.GlobalDB.Model(user).UpdateColumn("name", name).Error
+}
+
+// StartEmailChange records the new address of an email change
+func (user *User) StartEmailChange(email string) error {
+	user.PendingEmail = email
+	return database.GlobalDB.Model(user).UpdateColumn("pending_email", email).Error
+}
+
+// ConfirmEmailChange replaces the email of the user with the pending one, which is now verified
+// It fails with a duplicate key error if another account took the address in the meantime
+func (user *User) ConfirmEmailChange() error {
+	if user.PendingEmail == "" {
+		return ErrNoPendingEmail
+	}
+	now := time.Now()
+	err := database.GlobalDB.Model(user).UpdateColumns(map[string]interface{}{
+		"email":             user.PendingEmail,
+		"pending_email":     "",
+		"email_verified_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+	user.EmailVerifiedAt = &now
+	return nil
+}
+
+// SetDisabled disables or enables the user, disabling also revokes every session
+func (user *User) SetDisabled(disabled bool) error {
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+	err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).UpdateColumn("disabled_at", disabledAt).Error; err != nil {
+			return err
+		}
+		if !disabled {
+			return nil
+		}
+		return tx.Model(&Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return err
+	}
+	user.DisabledAt = disabledAt
+	return nil
+}
+
+// RequestDeletion schedules the deletion of the account and revokes every session
+// Logging in again during AccountDeletionGracePeriod cancels the deletion
+func (user *User) RequestDeletion() error {
+	now := time.Now()
+	err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).UpdateColumn("deletion_requested_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+	user.DeletionRequestedAt = &now
+	return nil
+}
+
+// CancelDeletion cancels the scheduled deletion of the account
+func (user *User) CancelDeletion() error {
+	if user.DeletionRequestedAt == nil {
+		return nil
+	}
+	user.DeletionRequestedAt = nil
+	return database.GlobalDB.Model(user).UpdateColumn("deletion_requested_at", nil).Error
+}
+
+// DeletionScheduledAt returns when the account will be purged, nil if no deletion was requested
+func (user *User) DeletionScheduledAt() *time.Time {
+	if user.DeletionRequestedAt == nil {
+		return nil
+	}
+	at := user.DeletionRequestedAt.Add(AccountDeletionGracePeriod)
+	return &at
+}
+
+// SearchUsers returns a page of the users matching a filter, by ID
+// The query matches the name or the email
+func SearchUsers(filter UserFilter, pagination Pagination) (*Page[User], error) {
+	query := database.GlobalDB.Model(&User{}).Order("id")
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		query = query.Where("name LIKE ? OR email LIKE ?", pattern, pattern)
+	}
+	switch filter.Status {
+	case UserStatusActive:
+		query = query.Where("disabled_at IS NULL AND deletion_requested_at IS NULL")
+	case UserStatusDisabled:
+		query = query.Where("disabled_at IS NOT NULL")
+	case UserStatusPendingDeletion:
+		query = query.Where("deletion_requested_at IS NOT NULL")
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	page, err := paginate[User](query, pagination)
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Items {
+		page.Items[i].Password = ""
+	}
+	return page, nil
+}
+
+// PurgeDeletedUsers deletes for good the accounts whose deletion was requested before a time,
+// with their tokens, recovery codes, identities, API keys, sessions, to-do items, tags and list memberships
+// It returns the number of purged accounts
+func PurgeDeletedUsers(before time.Time) (int, error) {
+	var ids []uint
+	err := database.GlobalDB.Model(&User{}).
+		Where("deletion_requested_at IS NOT NULL AND deletion_requested_at < ?", before).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+			for _, model := range []interface{}{&UserToken{}, &RecoveryCode{}, &ExternalIdentity{}, &APIKey{}, &Session{}} {
+				if err := tx.Unscoped().Where("user_id = ?", id).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			if err := purgeUserTodoLists(tx, id); err != nil {
+				return err
+			}
+			if err := purgeUserToDos(tx, id); err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&User{}, id).Error
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// RunAccountPurge purges the accounts past their deletion grace period every hour, until the context is cancelled
+func RunAccountPurge(ctx context.Context) {
+	ticker := time.NewTicker(accountPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := PurgeDeletedUsers(time.Now().Add(-AccountDeletionGracePeriod))
+		if err != nil {
+			logging.Default.Error("could not purge deleted accounts", "error", err)
+		} else if purged > 0 {
+			logging.Default.Info("deleted accounts purged", "count", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"github.com/zerodot618/go-huang/totp"
+	"gorm.io/gorm"
+)
+
+// RecoveryCodeCount is the number of recovery codes given to a user when 2FA is enabled
+const RecoveryCodeCount = 10
+
+// recoveryEncoding is the lower case base32 alphabet of the recovery codes
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the user lost their authenticator
+// Only the SHA-256 hash of the code is stored, like the one-time user tokens
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"size:64;uniqueIndex;not null"`
+	UsedAt   *time.Time
+}
+
+// Second factors accepted by VerifySecondFactor
+const (
+	SecondFactorTOTP     = "totp"
+	SecondFactorRecovery = "recovery_code"
+)
+
+// StartTOTPEnrollment stores a new TOTP secret for the user, 2FA stays disabled until it is confirmed
+func (user *User) StartTOTPEnrollment(secret string) error {
+	user.TOTPSecret = secret
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	return database.GlobalDB.Model(user).UpdateColumns(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error
+}
+
+// CheckTOTP checks a TOTP code against the secret of the user
+// A code is only accepted once: the matched time step is recorded with a conditional update,
+// so the same code cannot be replayed, even by concurrent requests
+func (user *User) CheckTOTP(code string) (bool, error) {
+	if user.TOTPSecret == "" {
+		return false, nil
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	result := database.GlobalDB.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		UpdateColumn("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+// EnableTOTP enables 2FA for the user and returns new recovery codes
+func (user *User) EnableTOTP() ([]string, error) {
+	var codes []string
+	err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).UpdateColumn("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	return codes, nil
+}
+
+// DisableTOTP disables 2FA for the user and deletes their secret and recovery codes
+func (user *User) DisableTOTP() error {
+	err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).UpdateColumns(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user and returns the new ones
+func (user *User) RegenerateRecoveryCodes() ([]string, error) {
+	var codes []string
+	err := database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// UseRecoveryCode marks a recovery code of the user as used
+// It returns false if the code is unknown or was already used
+func (user *User) UseRecoveryCode(code string) (bool, error) {
+	result := database.GlobalDB.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// VerifySecondFactor checks a TOTP code or a recovery code of a user with 2FA enabled
+// It returns which factor matched, or an empty string if none did
+func (user *User) VerifySecondFactor(code string) (string, error) {
+	if !user.TOTPEnabled {
+		return "", nil
+	}
+	code = strings.TrimSpace(code)
+	if len(strings.ReplaceAll(code, " ", "")) == totp.Digits {
+		ok, err := user.CheckTOTP(code)
+		if err != nil || !ok {
+			return "", err
+		}
+		return SecondFactorTOTP, nil
+	}
+	ok, err := user.UseRecoveryCode(code)
+	if err != nil || !ok {
+		return "", err
+	}
+	return SecondFactorRecovery, nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of a user and creates new ones
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodeCount)
+	records := make([]RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		// 16 characters shown as two groups, like xxxxxxxx-xxxxxxxx
+		plain := recoveryEncoding.EncodeToString(raw)
+		codes[i] = plain[:8] + "-" + plain[8:]
+		records[i] = RecoveryCode{UserID: userID, CodeHash: hashToken(plain)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode removes the separators and the case of a recovery code typed by a user
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/zerodot618/go-huang/database"
 
 	"golang.org/x/crypto/bcrypt"
@@ -14,7 +16,72 @@ type User struct {
 	ID       uint   `gorm:"primaryKey"`
 	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email" gorm:"unique"`
-	Password string `json:"password" binding:"required"`
+	Password string `json:"password"`
+	// FailedLogins counts the consecutive failed logins, LockedUntil is set once there are too many
+	FailedLogins int        `json:"-" gorm:"not null;default:0"`
+	LockedUntil  *time.Time `json:"-"`
+	// EmailVerifiedAt is set once the user opened the link of the verification email
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// Role grants access to the admin routes, it can only be changed in the database
+	Role string `json:"role" gorm:"size:16;not null;default:user"`
+	// TOTPSecret is set at enrollment, TOTPEnabled once the user confirmed it with a code
+	// TOTPLastStep is the time step of the last accepted code, so a code cannot be replayed
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled" gorm:"not null;default:false"`
+	TOTPLastStep int64  `json:"-" gorm:"not null;default:0"`
+	// PendingEmail is the new address of an email change, until the link sent to it is opened
+	PendingEmail string `json:"pending_email,omitempty"`
+	// DisabledAt is set by admins, a disabled user cannot log in and their tokens are refused
+	DisabledAt *time.Time `json:"disabled_at"`
+	// DeletionRequestedAt is set when the user deletes their account, it is purged after a grace period
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at"`
+}
+
+// UserSummary is the public part of a user, shown with the records they own
+type UserSummary struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// TableName makes UserSummary read the users table
+func (UserSummary) TableName() string {
+	return "users"
+}
+
+// Roles of the users
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// IsAdmin reports whether the user has the admin role
+func (user *User) IsAdmin() bool {
+	return user.Role == RoleAdmin
+}
+
+// Login lockout policy: after FreeLoginAttempts consecutive failures the account is locked,
+// for LockoutBase at first and twice as long after every further failure, up to LockoutMax
+const (
+	FreeLoginAttempts = 5
+	LockoutBase       = 30 * time.Second
+	LockoutMax        = time.Hour
+)
+
+// MigrateUsers migrates the users table
+// Users created before email verification existed are marked as verified
+func MigrateUsers() error {
+	migrator := database.GlobalDB.Migrator()
+	backfill := migrator.HasTable(&User{}) && !migrator.HasColumn(&User{}, "EmailVerifiedAt")
+	if err := database.GlobalDB.AutoMigrate(&User{}); err != nil {
+		return err
+	}
+	if !backfill {
+		return nil
+	}
+	return database.GlobalDB.Model(&User{}).
+		Where("email_verified_at IS NULL").
+		UpdateColumn("email_verified_at", gorm.Expr("created_at")).Error
 }
 
 // CreateUserRecord creates a user record in the database
@@ -50,3 +117,91 @@ func (user *User) CheckPassword(providedPassword string) error {
 	}
 	return nil
 }
+
+// dummyPasswordHash is a bcrypt hash with the cost of HashPassword, compared when there is no user to check
+var dummyPasswordHash = []byte("$2a$14$OI/uX2ItQm6MkO/k7Qvxc.tscxm/8LIBe16WfyNoaVHV1kqioPJ2q")
+
+// CheckDummyPassword compares a password to a dummy hash and always fails
+// A login with an unknown email calls it so that it takes as long as a wrong password, and does not reveal the email
+func CheckDummyPassword(providedPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(providedPassword))
+}
+
+// LockedFor returns how long the account stays locked after failed logins, zero if it is not locked
+func (user *User) LockedFor(now time.Time) time.Duration {
+	if user.LockedUntil == nil || !user.LockedUntil.After(now) {
+		return 0
+	}
+	return user.LockedUntil.Sub(now)
+}
+
+// RecordFailedLogin counts a failed login and locks the account once there are too many
+// The lock grows exponentially with every further failure
+func (user *User) RecordFailedLogin() error {
+	return database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).UpdateColumn("failed_logins", gorm.Expr("failed_logins + ?", 1)).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(user).Select("failed_logins").Take(user).Error; err != nil {
+			return err
+		}
+		if user.FailedLogins < FreeLoginAttempts {
+			return nil
+		}
+		lockedUntil := time.Now().Add(lockoutDuration(user.FailedLogins - FreeLoginAttempts))
+		user.LockedUntil = &lockedUntil
+		return tx.Model(user).UpdateColumn("locked_until", lockedUntil).Error
+	})
+}
+
+// ResetFailedLogins clears the failed logins after a successful login
+func (user *User) ResetFailedLogins() error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	return database.GlobalDB.Model(user).UpdateColumns(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+}
+
+// lockoutDuration returns the lock applied after the n-th failure past the free attempts
+func lockoutDuration(n int) time.Duration {
+	if n >= 30 {
+		return LockoutMax
+	}
+	d := LockoutBase << uint(n)
+	if d > LockoutMax || d <= 0 {
+		return LockoutMax
+	}
+	return d
+}
+
+// IsEmailVerified reports whether the user verified their email address
+func (user *User) IsEmailVerified() bool {
+	return user.EmailVerifiedAt != nil
+}
+
+// MarkEmailVerified records that the user verified their email address
+func (user *User) MarkEmailVerified() error {
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	return database.GlobalDB.Model(user).UpdateColumn("email_verified_at", now).Error
+}
+
+// UpdatePassword hashes and stores a new password, and unlocks the account
+func (user *User) UpdatePassword(password string) error {
+	if err := user.HashPassword(password); err != nil {
+		return err
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	return database.GlobalDB.Model(user).UpdateColumns(map[string]interface{}{
+		"password":      user.Password,
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/zerodot618/go-huang/database"
+	"gorm.io/gorm"
+)
+
+// Purposes of the one-time user tokens
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailChange       = "email_change"
+)
+
+// Lifetimes of the one-time user tokens
+const (
+	EmailVerificationTokenTTL = 24 * time.Hour
+	PasswordResetTokenTTL     = time.Hour
+	EmailChangeTokenTTL       = 24 * time.Hour
+)
+
+// ErrInvalidToken is returned when a one-time token is unknown, expired or already used
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// UserToken is a one-time token sent to a user by email
+// Only the SHA-256 hash of the token is stored, so a database leak does not leak usable tokens
+type UserToken struct {
+	gorm.Model
+	UserID    uint      `gorm:"index;not null"`
+	Purpose   string    `gorm:"size:32;index;not null"`
+	TokenHash string    `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// CreateUserToken creates a one-time token for a user and returns its plain value
+// The previous unused tokens of the user for the same purpose are revoked
+func CreateUserToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	plain, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	token := UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(plain),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	err = database.GlobalDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).Delete(&UserToken{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&token).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// ConsumeUserToken marks a one-time token as used and returns it
+// It returns ErrInvalidToken if the token is unknown, expired, used or issued for another purpose.
+// The token is consumed with a single conditional update, so it cannot be used twice concurrently.
+func ConsumeUserToken(plain, purpose string) (*UserToken, error) {
+	now := time.Now()
+	hash := hashToken(plain)
+	result := database.GlobalDB.Model(&UserToken{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, ErrInvalidToken
+	}
+	var token UserToken
+	if err := database.GlobalDB.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// newToken returns a random URL-safe token of 256 bits
+func newToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
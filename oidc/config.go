@@ -0,0 +1,72 @@
+package oidc
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// Registry holds the configured OpenID Connect providers by name
+type Registry struct {
+	providers map[string]*Provider
+}
+
+// Default is the registry used by the controllers, it has no provider until main loads them
+var Default = NewRegistry()
+
+// NewRegistry returns a registry of providers
+func NewRegistry(providers ...*Provider) *Registry {
+	r := &Registry{providers: map[string]*Provider{}}
+	for _, p := range providers {
+		r.providers[p.Name] = p
+	}
+	return r
+}
+
+// Get returns a provider by name
+func (r *Registry) Get(name string) (*Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Names returns the sorted names of the providers
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRegistryFromEnv loads the providers listed in OIDC_PROVIDERS, a comma separated list of names
+// Each provider NAME is configured by the OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID, OIDC_NAME_CLIENT_SECRET,
+// OIDC_NAME_SCOPES and OIDC_NAME_REDIRECT_URL variables.
+// The redirect URL defaults to the callback route under APP_URL.
+func NewRegistryFromEnv() *Registry {
+	base := strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8088"
+	}
+	var providers []*Provider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config := Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(strings.ReplaceAll(os.Getenv(prefix+"SCOPES"), ",", " ")),
+		}
+		if config.RedirectURL == "" {
+			config.RedirectURL = base + "/api/public/oidc/" + name + "/callback"
+		}
+		providers = append(providers, NewProvider(config))
+	}
+	return NewRegistry(providers...)
+}
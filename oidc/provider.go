@@ -0,0 +1,373 @@
+// Package oidc implements the relying party side of OpenID Connect:
+// the authorization code flow with PKCE, provider discovery and ID token validation with the provider JWKS
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/zerodot618/go-huang/jwk"
+)
+
+// Validation and caching settings
+const (
+	// Leeway is the clock skew accepted when checking the times of an ID token
+	Leeway = time.Minute
+	// discoveryTTL and keysTTL are how long the discovery document and the keys are cached
+	discoveryTTL = time.Hour
+	keysTTL      = time.Hour
+	// keysRefreshInterval limits the refreshes of the keys caused by tokens with an unknown kid
+	keysRefreshInterval = time.Minute
+	// maxResponseSize bounds the responses read from a provider
+	maxResponseSize = 1 << 20
+)
+
+// signingMethods are the algorithms accepted for ID tokens, symmetric algorithms and none are never accepted
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Errors returned by the providers
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+	ErrUnknownKey     = errors.New("oidc: unknown signing key")
+)
+
+// Config is the configuration of a provider
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Discovery is the part of the provider metadata used by the relying party
+// See https://openid.net/specs/openid-connect-discovery-1_0.html
+type Discovery struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	IDTokenSigningAlgs       []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// TokenResponse is the response of the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// IDTokenClaims are the claims of an ID token used to find or create the local user
+type IDTokenClaims struct {
+	Email         string   `json:"email"`
+	EmailVerified jsonBool `json:"email_verified"`
+	Name          string   `json:"name"`
+	Nonce         string   `json:"nonce"`
+	AuthorizedBy  string   `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// Provider is an OpenID Connect provider
+// The discovery document and the keys are fetched lazily and cached
+type Provider struct {
+	Config
+	Client *http.Client
+
+	mu           sync.Mutex
+	discovery    *Discovery
+	discoveredAt time.Time
+	keys         []jwk.Key
+	keysAt       time.Time
+}
+
+// NewProvider returns a provider with the default scopes when none are configured
+func NewProvider(config Config) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	hasOpenID := false
+	for _, scope := range config.Scopes {
+		hasOpenID = hasOpenID || scope == "openid"
+	}
+	if !hasOpenID {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &Provider{Config: config, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Discover returns the discovery document of the provider
+// The document must be served for the configured issuer, as required by the specification
+func (p *Provider) Discover(ctx context.Context) (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.discovery, nil
+	}
+	var d Discovery
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	p.discovery = &d
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint to redirect the user to
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", CodeChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange trades an authorization code and its PKCE verifier for tokens
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*TokenResponse, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	useBasic := p.ClientSecret != "" && supportsBasicAuth(d.TokenEndpointAuthMethods)
+	if !useBasic {
+		form.Set("client_id", p.ClientID)
+		if p.ClientSecret != "" {
+			form.Set("client_secret", p.ClientSecret)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		// The credentials are form encoded before being used for basic auth, RFC 6749 section 2.3.1
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &e)
+		return nil, fmt.Errorf("oidc: token request failed with status %d: %s %s", resp.StatusCode, e.Error, e.Description)
+	}
+	var tokens TokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("oidc: invalid token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response has no ID token")
+	}
+	return &tokens, nil
+}
+
+// VerifyIDToken validates an ID token and returns its claims
+// It checks the signature with the provider keys, the algorithm, the issuer, the audience,
+// the expiry and issue times with Leeway, and the nonce sent in the authorization request
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDTokenClaims, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(allowedMethods(d.IDTokenSigningAlgs)),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithLeeway(Leeway),
+		jwt.WithIssuedAt(),
+	)
+	var claims IDTokenClaims
+	_, err = parser.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid, token.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	if claims.ExpiresAt == nil || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing exp or sub", ErrInvalidIDToken)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	// With several audiences, the token must have been issued to this client
+	if (len(claims.Audience) > 1 || claims.AuthorizedBy != "") && claims.AuthorizedBy != p.ClientID {
+		return nil, fmt.Errorf("%w: azp mismatch", ErrInvalidIDToken)
+	}
+	return &claims, nil
+}
+
+// key returns the public key of the provider identified by kid
+// The keys are fetched again when the kid is unknown, to follow the key rotations of the provider
+func (p *Provider) key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys == nil || time.Since(p.keysAt) > keysTTL {
+		if err := p.fetchKeys(ctx, d.JWKSURI); err != nil {
+			return nil, err
+		}
+	}
+	if k, ok := findKey(p.keys, kid, alg); ok {
+		return k.PublicKey()
+	}
+	if time.Since(p.keysAt) < keysRefreshInterval {
+		return nil, ErrUnknownKey
+	}
+	if err := p.fetchKeys(ctx, d.JWKSURI); err != nil {
+		return nil, err
+	}
+	if k, ok := findKey(p.keys, kid, alg); ok {
+		return k.PublicKey()
+	}
+	return nil, ErrUnknownKey
+}
+
+// fetchKeys fetches the key set of the provider, p.mu must be held
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) error {
+	var set jwk.Set
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return err
+	}
+	p.keys = set.Keys
+	p.keysAt = time.Now()
+	return nil
+}
+
+// getJSON fetches a JSON document of the provider
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc: could not fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: could not fetch %s: status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("oidc: invalid document at %s: %w", url, err)
+	}
+	return nil
+}
+
+// findKey finds a signing key by kid, or the only signing key when the token has no kid
+func findKey(keys []jwk.Key, kid, alg string) (jwk.Key, bool) {
+	var candidates []jwk.Key
+	for _, k := range keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.Alg != "" && k.Alg != alg {
+			continue
+		}
+		if kid != "" && k.Kid == kid {
+			return k, true
+		}
+		candidates = append(candidates, k)
+	}
+	if kid == "" && len(candidates) == 1 {
+		return candidates[0], true
+	}
+	return jwk.Key{}, false
+}
+
+// allowedMethods returns the signing methods supported by both sides
+func allowedMethods(advertised []string) []string {
+	if len(advertised) == 0 {
+		return []string{"RS256"}
+	}
+	var methods []string
+	for _, alg := range advertised {
+		for _, m := range signingMethods {
+			if alg == m {
+				methods = append(methods, alg)
+			}
+		}
+	}
+	return methods
+}
+
+// supportsBasicAuth reports whether the token endpoint accepts client_secret_basic, the default method
+func supportsBasicAuth(methods []string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if m == "client_secret_basic" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonBool is a boolean claim that some providers send as a string
+type jsonBool bool
+
+// UnmarshalJSON accepts true, false, "true" and "false"
+func (b *jsonBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null", "":
+		*b = false
+	default:
+		return fmt.Errorf("oidc: invalid boolean %s", data)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Package poll runs the background jobs that poll the database for due work,
+// like the reminder and the scan schedulers, every interval until the service stops.
+package poll
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Default settings of the pollers
+const (
+	DefaultInterval  = 30 * time.Second
+	DefaultBatchSize = 100
+)
+
+// IntervalFromEnv returns the interval of an environment variable in the time.ParseDuration format,
+// DefaultInterval if it is not set
+func IntervalFromEnv(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return DefaultInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return interval, nil
+}
+
+// Run calls tick every interval, until the context is cancelled
+// tick returns true when more work is waiting, like after a full batch, it is then called again at once
+func Run(ctx context.Context, interval time.Duration, tick func(ctx context.Context) bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if tick(ctx) && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package poll
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIntervalFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultInterval, false},
+		{"5s", 5 * time.Second, false},
+		{"1m30s", 90 * time.Second, false},
+		{"0s", 0, true},
+		{"-1s", 0, true},
+		{"30", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_POLL_INTERVAL", tt.value)
+		got, err := IntervalFromEnv("TEST_POLL_INTERVAL")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("IntervalFromEnv(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("IntervalFromEnv(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRunCallsAgainWhileMoreIsWaiting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The interval is never reached, the ticks only follow each other while more is waiting
+		Run(ctx, time.Hour, func(context.Context) bool {
+			calls++
+			if calls == 3 {
+				cancel()
+			}
+			return true
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return once the context was cancelled")
+	}
+	if calls != 3 {
+		t.Errorf("tick called %d times, want 3", calls)
+	}
+}
+
+func TestRunWaitsForTheInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(ctx, 20*time.Millisecond, func(context.Context) bool {
+			calls <- struct{}{}
+			return false
+		})
+	}()
+	start := time.Now()
+	<-calls
+	<-calls
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("second tick after %s, want it to wait for the interval", elapsed)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,85 @@
+package port
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AllowList is the set of targets a scan may probe, IP ranges and host names
+// An IP address is allowed if an allowed range contains it. A host name is only allowed if it is listed,
+// it is not resolved: the address it resolves to when dialing is not checked
+type AllowList struct {
+	prefixes []netip.Prefix
+	names    map[string]bool
+}
+
+// ParseAllowList parses a comma-separated list of CIDR ranges, IP addresses and host names
+// An empty list allows nothing
+func ParseAllowList(s string) (*AllowList, error) {
+	a := &AllowList{names: map[string]bool{}}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q", entry)
+			}
+			a.prefixes = append(a.prefixes, unmapPrefix(prefix.Masked()))
+			continue
+		}
+		if addr, err := netip.ParseAddr(strings.Trim(entry, "[]")); err == nil {
+			addr = addr.Unmap()
+			a.prefixes = append(a.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		a.names[strings.ToLower(entry)] = true
+	}
+	return a, nil
+}
+
+// unmapPrefix returns the IPv4 range of an IPv4-mapped IPv6 range like ::ffff:10.0.0.0/104,
+// since the hosts are unmapped before they are checked
+func unmapPrefix(prefix netip.Prefix) netip.Prefix {
+	if !prefix.Addr().Is4In6() || prefix.Bits() < 96 {
+		return prefix
+	}
+	return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+}
+
+// Empty reports whether the list allows nothing
+func (a *AllowList) Empty() bool {
+	return a == nil || len(a.prefixes) == 0 && len(a.names) == 0
+}
+
+// Allowed reports whether a host, as expanded by ExpandTargets, is allowed
+// IPv4-mapped IPv6 addresses are checked as IPv4 addresses, and zoned addresses like fe80::1%eth0 without their zone
+func (a *AllowList) Allowed(host string) bool {
+	if a == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return a.names[strings.ToLower(host)]
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range a.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error naming the first host that is not allowed
+func (a *AllowList) Check(hosts []string) error {
+	for _, host := range hosts {
+		if !a.Allowed(host) {
+			return fmt.Errorf("target %q is not allowed", host)
+		}
+	}
+	return nil
+}
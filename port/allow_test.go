@@ -0,0 +1,93 @@
+package port
+
+import "testing"
+
+func TestAllowListAllowed(t *testing.T) {
+	list, err := ParseAllowList("10.0.0.0/24, 192.168.1.10, [2001:db8::1], fe80::/10, Scanme.Example.com, ::ffff:172.16.5.5, ::ffff:172.17.0.0/112")
+	if err != nil {
+		t.Fatalf("ParseAllowList() error = %v", err)
+	}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"10.0.0.0", true},
+		{"10.0.0.255", true},
+		{"10.0.1.1", false},
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		// IPv4-mapped addresses are checked as IPv4 addresses, both in the list and as hosts
+		{"::ffff:10.0.0.7", true},
+		{"::ffff:10.0.1.7", false},
+		{"172.16.5.5", true},
+		{"172.17.0.9", true},
+		{"172.17.200.9", true},
+		{"172.18.0.9", false},
+		// The zone of a link-local address does not change the address
+		{"fe80::1", true},
+		{"fe80::1%eth0", true},
+		{"fe80::1%2", true},
+		{"fec0::1%eth0", false},
+		{"scanme.example.com", true},
+		{"SCANME.example.COM", true},
+		{"other.example.com", false},
+		{"10.0.0.7.nip.io", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := list.Allowed(tt.host); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestParseAllowList(t *testing.T) {
+	tests := []struct {
+		s         string
+		wantEmpty bool
+		wantErr   bool
+	}{
+		{s: "", wantEmpty: true},
+		{s: " , ", wantEmpty: true},
+		{s: "10.0.0.0/8"},
+		{s: "localhost"},
+		{s: "10.0.0.0/33", wantErr: true},
+		{s: "example.com/24", wantErr: true},
+	}
+	for _, tt := range tests {
+		list, err := ParseAllowList(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAllowList(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && list.Empty() != tt.wantEmpty {
+			t.Errorf("ParseAllowList(%q).Empty() = %v, want %v", tt.s, list.Empty(), tt.wantEmpty)
+		}
+	}
+
+	var none *AllowList
+	if !none.Empty() || none.Allowed("10.0.0.1") {
+		t.Error("a nil list must be empty and allow nothing")
+	}
+	empty, _ := ParseAllowList("")
+	if empty.Allowed("10.0.0.1") || empty.Allowed("localhost") {
+		t.Error("an empty list must allow nothing")
+	}
+}
+
+func TestAllowListCheck(t *testing.T) {
+	list, _ := ParseAllowList("10.0.0.0/30")
+	hosts, err := ExpandTargets([]string{"10.0.0.0/30"})
+	if err != nil {
+		t.Fatalf("ExpandTargets() error = %v", err)
+	}
+	if err := list.Check(hosts); err != nil {
+		t.Errorf("Check(%v) error = %v, want nil", hosts, err)
+	}
+	err = list.Check([]string{"10.0.0.1", "10.0.0.9", "10.0.0.10"})
+	if err == nil || err.Error() != `target "10.0.0.9" is not allowed` {
+		t.Errorf("Check() error = %v, want the first host that is not allowed", err)
+	}
+}
@@ -0,0 +1,223 @@
+package port
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxBanner bounds the length of the banners and versions in characters, the size of their columns
+const maxBanner = 255
+
+// maxGreetingWait bounds the wait for the greeting, most services waiting for the client
+const maxGreetingWait = time.Second
+
+// tlsPorts are the ports expecting a TLS handshake first, they are probed with TLS before HTTP
+var tlsPorts = map[int]bool{443: true, 465: true, 636: true, 990: true, 993: true, 995: true, 2376: true, 5061: true, 6443: true, 8443: true}
+
+// detectService identifies the service of an open TCP port
+// It reads the greeting of the services talking first (SSH, FTP, SMTP, POP3, IMAP, MySQL, VNC),
+// then sends an HTTP HEAD request and a TLS ClientHello, reading the certificate subject and the negotiated ALPN.
+// Every probe opens its own connection and is bounded by the timeout
+func detectService(ctx context.Context, result *ScanResult, hostname string, port int, timeout time.Duration) {
+	if greeting := readGreeting(ctx, hostname, port, timeout); greeting != nil {
+		result.Banner = sanitizeBanner(greeting)
+		if service, version := matchGreeting(greeting, port); service != "" {
+			result.Service, result.Version = service, sanitizeBanner([]byte(version))
+		}
+		return
+	}
+	probes := []func(context.Context, *ScanResult, string, int, time.Duration) bool{probeHTTP, probeTLS}
+	if tlsPorts[port] {
+		probes[0], probes[1] = probes[1], probes[0]
+	}
+	for _, probe := range probes {
+		if ctx.Err() != nil || probe(ctx, result, hostname, port, timeout) {
+			return
+		}
+	}
+}
+
+// readGreeting returns what the service sends once connected, nil if it waits for the client
+func readGreeting(ctx context.Context, hostname string, port int, timeout time.Duration) []byte {
+	conn, err := dial(ctx, hostname, port, timeout)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return readSome(conn, min(timeout, maxGreetingWait))
+}
+
+// probeHTTP sends an HTTP HEAD request and reads the Server header of the response
+// A 400 response is reported but the TLS probe still runs, it is how many HTTPS servers answer plain HTTP
+func probeHTTP(ctx context.Context, result *ScanResult, hostname string, port int, timeout time.Duration) bool {
+	conn, err := dial(ctx, hostname, port, timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	resp, ok := headRequest(conn, hostname, timeout)
+	if !ok {
+		return false
+	}
+	result.Service, result.Version = "http", sanitizeBanner([]byte(resp.Header.Get("Server")))
+	result.Banner = sanitizeBanner([]byte(resp.Proto + " " + resp.Status))
+	return resp.StatusCode != http.StatusBadRequest
+}
+
+// probeTLS performs a TLS handshake and reads the certificate subject and the negotiated ALPN,
+// then identifies the service inside the TLS connection. The service is prefixed with ssl/
+func probeTLS(ctx context.Context, result *ScanResult, hostname string, port int, timeout time.Duration) bool {
+	dialer := tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		Config: &tls.Config{
+			// The certificate is reported, not verified
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		},
+	}
+	if net.ParseIP(hostname) == nil {
+		dialer.Config.ServerName = hostname
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", address(hostname, port))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	tlsConn := conn.(*tls.Conn)
+	state := tlsConn.ConnectionState()
+	info := []string{tls.VersionName(state.Version)}
+	if len(state.PeerCertificates) > 0 {
+		info = append(info, "subject="+state.PeerCertificates[0].Subject.String())
+	}
+	if state.NegotiatedProtocol != "" {
+		info = append(info, "alpn="+state.NegotiatedProtocol)
+	}
+	service, version := serviceOr(result.Service, "unknown"), ""
+	switch state.NegotiatedProtocol {
+	case "h2":
+		service = "http"
+	case "http/1.1":
+		if resp, ok := headRequest(tlsConn, hostname, timeout); ok {
+			service, version = "http", resp.Header.Get("Server")
+			info = append(info, resp.Proto+" "+resp.Status)
+		}
+	default:
+		if greeting := readSome(tlsConn, timeout); greeting != nil {
+			if name, v := matchGreeting(greeting, port); name != "" {
+				service, version = name, v
+			}
+			info = append(info, sanitizeBanner(greeting))
+		}
+	}
+	result.Service, result.Version = "ssl/"+service, sanitizeBanner([]byte(version))
+	result.Banner = sanitizeBanner([]byte(strings.Join(info, "; ")))
+	return true
+}
+
+// headRequest sends an HTTP HEAD request on a connection and returns the response, without its body
+// It returns false if the response is not HTTP
+func headRequest(conn net.Conn, hostname string, timeout time.Duration) (*http.Response, bool) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	request := "HEAD / HTTP/1.1\r\nHost: " + hostname + "\r\nUser-Agent: scan_port\r\nConnection: close\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return nil, false
+	}
+	resp.Body.Close()
+	return resp, true
+}
+
+// matchGreeting identifies a service and its version from its greeting
+// It returns an empty service if the greeting is unknown
+func matchGreeting(greeting []byte, port int) (string, string) {
+	line := string(greeting)
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	switch {
+	case strings.HasPrefix(line, "SSH-"):
+		// SSH-2.0-OpenSSH_9.6p1 Ubuntu-3
+		parts := strings.SplitN(line, "-", 3)
+		if len(parts) == 3 {
+			return "ssh", parts[2]
+		}
+		return "ssh", ""
+	case strings.HasPrefix(line, "220"):
+		text := strings.TrimSpace(strings.TrimLeft(line[3:], " -"))
+		upper := strings.ToUpper(text)
+		if strings.Contains(upper, "FTP") || port == 21 || port == 990 {
+			return "ftp", text
+		}
+		if strings.Contains(upper, "SMTP") || port == 25 || port == 465 || port == 587 {
+			return "smtp", text
+		}
+		return "", ""
+	case strings.HasPrefix(line, "+OK"):
+		return "pop3", strings.TrimSpace(line[3:])
+	case strings.HasPrefix(line, "* OK"):
+		return "imap", strings.TrimSpace(line[4:])
+	case strings.HasPrefix(line, "RFB "):
+		return "vnc", line
+	case len(greeting) > 5 && greeting[4] == 0x0a:
+		// MySQL handshake: 3 bytes of length, the sequence number, the protocol version 10 and the server version
+		if end := bytes.IndexByte(greeting[5:], 0); end > 0 {
+			return "mysql", string(greeting[5 : 5+end])
+		}
+	}
+	return "", ""
+}
+
+// dial opens a TCP connection bounded by the timeout
+func dial(ctx context.Context, hostname string, port int, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	return dialer.DialContext(ctx, "tcp", address(hostname, port))
+}
+
+// readSome reads what a connection sends before the timeout, nil if nothing
+func readSome(conn net.Conn, timeout time.Duration) []byte {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1024)
+	n, _ := conn.Read(buf)
+	if n == 0 {
+		return nil
+	}
+	return buf[:n]
+}
+
+// sanitizeBanner makes a banner or a version printable valid UTF-8 on one line, bounded by maxBanner characters
+func sanitizeBanner(banner []byte) string {
+	text := strings.Map(func(r rune) rune {
+		switch {
+		case r == '\r' || r == '\n' || r == '\t':
+			return ' '
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			return '.'
+		}
+		return r
+	}, strings.ToValidUTF8(string(banner), string(utf8.RuneError)))
+	text = strings.TrimSpace(text)
+	if runes := []rune(text); len(runes) > maxBanner {
+		text = string(runes[:maxBanner])
+	}
+	return text
+}
+
+// serviceOr returns a service name, or a fallback if it is empty
+func serviceOr(service, fallback string) string {
+	if service == "" {
+		return fallback
+	}
+	return service
+}
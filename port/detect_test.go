@@ -0,0 +1,207 @@
+package port
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// detectTimeout is the timeout of the probes of the detection tests
+const detectTimeout = 500 * time.Millisecond
+
+// listenTCP starts a TCP server on a free loopback port, serving every connection with handle
+// It returns the port of the server
+func listenTCP(t *testing.T, handle func(net.Conn)) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// serverPort returns the port of an httptest server
+func serverPort(t *testing.T, server *httptest.Server) int {
+	t.Helper()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("server address: %v", err)
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// detect runs the service detection on a loopback port, its result starts with the well-known service of wellKnown
+func detect(port int, wellKnown string) ScanResult {
+	result := ScanResult{Host: "127.0.0.1", Port: strconv.Itoa(port) + "/tcp", State: StateOpen, Service: ServiceName(wellKnown)}
+	detectService(context.Background(), &result, "127.0.0.1", port, detectTimeout)
+	return result
+}
+
+func TestDetectBanner(t *testing.T) {
+	port := listenTCP(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6p1 Ubuntu-3\r\n"))
+		time.Sleep(detectTimeout)
+	})
+	result := detect(port, "")
+	if result.Service != "ssh" || result.Version != "OpenSSH_9.6p1 Ubuntu-3" {
+		t.Errorf("detect() = %q %q, want ssh OpenSSH_9.6p1 Ubuntu-3", result.Service, result.Version)
+	}
+	if result.Banner != "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3" {
+		t.Errorf("Banner = %q", result.Banner)
+	}
+}
+
+func TestDetectHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "test-server/1.0")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	result := detect(serverPort(t, server), "")
+	if result.Service != "http" || result.Version != "test-server/1.0" {
+		t.Errorf("detect() = %q %q, want http test-server/1.0", result.Service, result.Version)
+	}
+	if result.Banner != "HTTP/1.1 200 OK" {
+		t.Errorf("Banner = %q, want HTTP/1.1 200 OK", result.Banner)
+	}
+}
+
+func TestDetectTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "test-tls/2.0")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	// The plain HTTP probe gets a 400 response, the TLS probe runs next
+	result := detect(serverPort(t, server), "")
+	if result.Service != "ssl/http" || result.Version != "test-tls/2.0" {
+		t.Errorf("detect() = %q %q, want ssl/http test-tls/2.0", result.Service, result.Version)
+	}
+	for _, want := range []string{"TLS 1.3", "subject=O=Acme Co", "alpn=http/1.1", "HTTP/1.1 200 OK"} {
+		if !strings.Contains(result.Banner, want) {
+			t.Errorf("Banner = %q, want it to contain %q", result.Banner, want)
+		}
+	}
+}
+
+func TestDetectFallbackToServicesTable(t *testing.T) {
+	// The server reads the probes and never answers
+	port := listenTCP(t, func(conn net.Conn) {
+		conn.SetReadDeadline(time.Now().Add(4 * detectTimeout))
+		buf := make([]byte, 1024)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+		}
+	})
+	result := detect(port, "22/tcp")
+	if result.Service != "ssh" || result.Version != "" || result.Banner != "" {
+		t.Errorf("detect() = %q %q %q, want the well-known ssh service", result.Service, result.Version, result.Banner)
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	tests := []struct {
+		port string
+		want string
+	}{
+		{"22/tcp", "ssh"},
+		{"53/udp", "domain"},
+		{"443/tcp", "https"},
+		{"8443/tcp", "https-alt"},
+		{"22/udp", ""},
+		{"65000/tcp", ""},
+	}
+	for _, tt := range tests {
+		if got := ServiceName(tt.port); got != tt.want {
+			t.Errorf("ServiceName(%q) = %q, want %q", tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestMatchGreeting(t *testing.T) {
+	tests := []struct {
+		name        string
+		greeting    string
+		port        int
+		wantService string
+		wantVersion string
+	}{
+		{"ssh", "SSH-2.0-dropbear_2022.83\r\n", 2222, "ssh", "dropbear_2022.83"},
+		{"ftp", "220 (vsFTPd 3.0.5)\r\n", 21, "ftp", "(vsFTPd 3.0.5)"},
+		{"smtp", "220 mail.example.com ESMTP Postfix\r\n", 2525, "smtp", "mail.example.com ESMTP Postfix"},
+		{"unknown 220", "220 ready\r\n", 4000, "", ""},
+		{"pop3", "+OK Dovecot ready.\r\n", 110, "pop3", "Dovecot ready."},
+		{"imap", "* OK [CAPABILITY IMAP4rev1] Dovecot ready.\r\n", 143, "imap", "[CAPABILITY IMAP4rev1] Dovecot ready."},
+		{"vnc", "RFB 003.008\n", 5900, "vnc", "RFB 003.008"},
+		{"mysql", "\x4a\x00\x00\x00\x0a8.0.36\x00\x08\x00\x00\x00", 3306, "mysql", "8.0.36"},
+		{"unknown", "hello\r\n", 4000, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, version := matchGreeting([]byte(tt.greeting), tt.port)
+			if service != tt.wantService || version != tt.wantVersion {
+				t.Errorf("matchGreeting() = %q %q, want %q %q", service, version, tt.wantService, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestScannerDetection(t *testing.T) {
+	port := listenTCP(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+		time.Sleep(detectTimeout)
+	})
+	scanner := New(WithTimeout(detectTimeout), WithDetection(true))
+	results, err := scanner.Scan(context.Background(), []string{"127.0.0.1"}, []int{port})
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(results) != 1 || results[0].State != StateOpen || results[0].Service != "ssh" {
+		t.Errorf("Scan() = %+v, want an open ssh port", results)
+	}
+}
+
+func TestSanitizeBanner(t *testing.T) {
+	long := strings.Repeat("é", maxBanner+10)
+	tests := []struct {
+		name   string
+		banner string
+		want   string
+	}{
+		{"printable", "SSH-2.0-OpenSSH_9.6\r\n", "SSH-2.0-OpenSSH_9.6"},
+		{"control bytes", "220 \x00ready\x1b", "220 .ready."},
+		{"invalid UTF-8", "nginx/\xff\xfe1.25", "nginx/.1.25"},
+		{"multi-byte runes cut by characters", long, strings.Repeat("é", maxBanner)},
+	}
+	for _, tt := range tests {
+		got := sanitizeBanner([]byte(tt.banner))
+		if got != tt.want {
+			t.Errorf("%s: sanitizeBanner() = %q, want %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) || utf8.RuneCountInString(got) > maxBanner {
+			t.Errorf("%s: sanitizeBanner() = %q, not valid UTF-8 of at most %d characters", tt.name, got, maxBanner)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Package port scans the TCP and UDP ports of hosts.
+// A Scanner probes every port of every host with a bounded number of workers, until its context is cancelled.
+// Scan returns the sorted results once the scan is done, Stream sends them as the probes are done:
+//
+//	scanner := port.New(port.WithTimeout(time.Second), port.WithDetection(true))
+//	for result := range scanner.Stream(ctx, hosts, ports) {
+//		...
+//	}
+package port
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// States of the scanned ports
+const (
+	StateOpen     = "open"
+	StateClosed   = "closed"
+	StateFiltered = "filtered"
+	// StateOpenFiltered is a UDP port that did not reply, see scanUDP
+	StateOpenFiltered = "open|filtered"
+)
+
+// Reasons of the states, as reported by nmap
+const (
+	ReasonSynAck      = "syn-ack"
+	ReasonConnRefused = "conn-refused"
+	ReasonTimeout     = "timeout"
+	ReasonUnreachable = "host-unreach"
+	ReasonError       = "error"
+	ReasonUDPResponse = "udp-response"
+	ReasonPortUnreach = "port-unreach"
+	ReasonNoResponse  = "no-response"
+)
+
+// Default settings of the Scanner
+const (
+	DefaultTimeout = 2 * time.Second
+	DefaultWorkers = 100
+)
+
+// ScanResult is the state of a port of a host, the port is formatted like 80/tcp
+// Reason tells why the port is in its state. Duration is the time the probe took, without the service detection.
+// Service is the well-known service of the port, or the service detected on it with its Version and Banner
+type ScanResult struct {
+	Host      string
+	Port      string
+	State     string
+	Reason    string
+	StartedAt time.Time
+	Duration  time.Duration
+	Service   string
+	Version   string
+	Banner    string
+}
+
+// PortNumber returns the number of the port
+func (r ScanResult) PortNumber() int {
+	number, _, _ := strings.Cut(r.Port, "/")
+	n, _ := strconv.Atoi(number)
+	return n
+}
+
+// Protocol returns the protocol of the port, tcp or udp
+func (r ScanResult) Protocol() string {
+	_, protocol, _ := strings.Cut(r.Port, "/")
+	return protocol
+}
+
+// Scanner scans ports concurrently
+// It is built by New with options, or as a struct literal, the zero fields taking the default settings
+type Scanner struct {
+	// Protocols are the protocols probed on every port, tcp or udp
+	Protocols []string
+	// Timeout bounds every dial
+	Timeout time.Duration
+	// Workers is the number of probes run at once
+	Workers int
+	// Detect identifies the services of the open TCP ports, see detectService
+	Detect bool
+	// Progress is called by Scan after every probe with the number of probes done, the total and the result,
+	// from one goroutine at a time
+	Progress func(done, total int, result ScanResult)
+}
+
+// Option is a setting of a Scanner
+type Option func(*Scanner)
+
+// WithProtocols sets the protocols probed on every port, tcp by default
+func WithProtocols(protocols ...string) Option {
+	return func(s *Scanner) { s.Protocols = protocols }
+}
+
+// WithTimeout sets the timeout of every dial, DefaultTimeout by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Scanner) { s.Timeout = timeout }
+}
+
+// WithWorkers sets the number of probes run at once, DefaultWorkers by default
+func WithWorkers(workers int) Option {
+	return func(s *Scanner) { s.Workers = workers }
+}
+
+// WithDetection identifies the services of the open TCP ports
+func WithDetection(detect bool) Option {
+	return func(s *Scanner) { s.Detect = detect }
+}
+
+// WithProgress sets the function called by Scan after every probe
+func WithProgress(progress func(done, total int, result ScanResult)) Option {
+	return func(s *Scanner) { s.Progress = progress }
+}
+
+// New returns a Scanner with options
+func New(opts ...Option) *Scanner {
+	s := &Scanner{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// probe is a port to scan
+type probe struct {
+	protocol string
+	host     string
+	port     int
+}
+
+// Total returns the number of probes of a scan of the ports of hosts
+func (s *Scanner) Total(hosts []string, ports []int) int {
+	return len(hosts) * len(s.protocols()) * len(ports)
+}
+
+// Stream probes the ports of the hosts and sends the results on the returned channel as the probes are done,
+// in no particular order. The channel is closed once every port is probed or the context is cancelled,
+// the probes interrupted by the cancellation have no result. The caller must drain the channel
+func (s *Scanner) Stream(ctx context.Context, hosts []string, ports []int) <-chan ScanResult {
+	protocols := s.protocols()
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if total := s.Total(hosts, ports); workers > total {
+		workers = total
+	}
+
+	probes := make(chan probe)
+	go func() {
+		defer close(probes)
+		for _, host := range hosts {
+			for _, protocol := range protocols {
+				for _, p := range ports {
+					select {
+					case probes <- probe{protocol: protocol, host: host, port: p}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	results := make(chan ScanResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pr := range probes {
+				result := ScanPort(ctx, pr.protocol, pr.host, pr.port, timeout)
+				if s.Detect && pr.protocol == "tcp" && result.State == StateOpen {
+					detectService(ctx, &result, pr.host, pr.port, timeout)
+				}
+				if ctx.Err() != nil {
+					continue
+				}
+				results <- result
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// Scan probes the ports of the hosts and returns the results sorted by host, protocol and port
+// If the context is cancelled, it returns the results of the probes done so far with the error of the context
+func (s *Scanner) Scan(ctx context.Context, hosts []string, ports []int) ([]ScanResult, error) {
+	total := s.Total(hosts, ports)
+	var results []ScanResult
+	for result := range s.Stream(ctx, hosts, ports) {
+		results = append(results, result)
+		if s.Progress != nil {
+			s.Progress(len(results), total, result)
+		}
+	}
+	SortResults(results, hosts, s.protocols())
+	return results, ctx.Err()
+}
+
+// SortResults sorts results by host and protocol, in the order they were scanned in, and by port
+func SortResults(results []ScanResult, hosts, protocols []string) {
+	hostIndex := make(map[string]int, len(hosts))
+	for i, host := range hosts {
+		hostIndex[host] = i
+	}
+	protocolIndex := make(map[string]int, len(protocols))
+	for i, protocol := range protocols {
+		protocolIndex[protocol] = i
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Host != b.Host {
+			return hostIndex[a.Host] < hostIndex[b.Host]
+		}
+		if a.Protocol() != b.Protocol() {
+			return protocolIndex[a.Protocol()] < protocolIndex[b.Protocol()]
+		}
+		return a.PortNumber() < b.PortNumber()
+	})
+}
+
+// protocols returns the protocols of the scanner, tcp by default
+func (s *Scanner) protocols() []string {
+	if len(s.Protocols) == 0 {
+		return []string{"tcp"}
+	}
+	return s.Protocols
+}
+
+// ScanPort probes a port of a host and reports its well-known service
+// A TCP port is open if it accepts the connection, closed if it refuses it and filtered if the dial times out.
+// See scanUDP for the UDP ports
+func ScanPort(ctx context.Context, protocol, hostname string, port int, timeout time.Duration) ScanResult {
+	name := strconv.Itoa(port) + "/" + protocol
+	result := ScanResult{Host: hostname, Port: name, Service: ServiceName(name), StartedAt: time.Now()}
+	if protocol == "udp" {
+		result.State, result.Reason = scanUDP(ctx, hostname, port, timeout)
+	} else {
+		result.State, result.Reason = scanTCP(ctx, protocol, hostname, port, timeout)
+	}
+	result.Duration = time.Since(result.StartedAt)
+	return result
+}
+
+// scanTCP probes a TCP port and returns its state and the reason
+func scanTCP(ctx context.Context, protocol, hostname string, port int, timeout time.Duration) (string, string) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, protocol, address(hostname, port))
+	if err == nil {
+		conn.Close()
+		return StateOpen, ReasonSynAck
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return StateClosed, ReasonConnRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return StateFiltered, ReasonTimeout
+	case errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH):
+		return StateFiltered, ReasonUnreachable
+	default:
+		return StateFiltered, ReasonError
+	}
+}
+
+// InitialScan scans the well-known UDP and TCP ports of a host, from 1 to 1024
+func InitialScan(hostname string) []ScanResult {
+	return scanRange(hostname, 1024)
+}
+
+// WideScan scans the well-known and registered UDP and TCP ports of a host, from 1 to 49152
+func WideScan(hostname string) []ScanResult {
+	return scanRange(hostname, 49152)
+}
+
+// scanRange scans the UDP and TCP ports of a host from 1 to last with the default settings
+func scanRange(hostname string, last int) []ScanResult {
+	ports := make([]int, last)
+	for i := range ports {
+		ports[i] = i + 1
+	}
+	results, _ := New(WithProtocols("udp", "tcp")).Scan(context.Background(), []string{hostname}, ports)
+	return results
+}
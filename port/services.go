@@ -0,0 +1,37 @@
+package port
+
+import (
+	"bufio"
+	_ "embed"
+	"strings"
+)
+
+// servicesTable lists the well-known services by port and protocol
+//
+//go:embed services.txt
+var servicesTable string
+
+// services maps ports like 80/tcp to the name of their well-known service
+var services = parseServices(servicesTable)
+
+// ServiceName returns the well-known service of a port like 80/tcp, empty if it has none
+func ServiceName(port string) string {
+	return services[port]
+}
+
+// parseServices parses a services table, a service and a port by line, # starting a comment
+func parseServices(table string) map[string]string {
+	names := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(table))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if _, ok := names[fields[1]]; !ok {
+			names[fields[1]] = fields[0]
+		}
+	}
+	return names
+}
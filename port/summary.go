@@ -0,0 +1,37 @@
+package port
+
+import "time"
+
+// Summary describes a scan run
+type Summary struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Hosts is the number of scanned hosts, Probes the number of probed ports
+	Hosts  int
+	Probes int
+	// States counts the ports by state
+	States map[string]int
+	// Interrupted is set when the scan was cancelled before probing every port
+	Interrupted bool
+}
+
+// Summarize describes a scan run from its results
+func Summarize(hosts []string, results []ScanResult, startedAt, finishedAt time.Time, interrupted bool) Summary {
+	summary := Summary{
+		StartedAt:   startedAt,
+		FinishedAt:  finishedAt,
+		Hosts:       len(hosts),
+		Probes:      len(results),
+		States:      map[string]int{},
+		Interrupted: interrupted,
+	}
+	for _, result := range results {
+		summary.States[result.State]++
+	}
+	return summary
+}
+
+// Elapsed returns the duration of the run
+func (s Summary) Elapsed() time.Duration {
+	return s.FinishedAt.Sub(s.StartedAt)
+}
@@ -0,0 +1,117 @@
+package port
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxTargets bounds the addresses a scan expands its targets to, a /16 network
+const MaxTargets = 1 << 16
+
+// ParsePorts parses a list of ports and ranges like 22,80,8000-8100
+// The ports are returned sorted, without duplicates
+func ParsePorts(s string) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		low, high, isRange := strings.Cut(part, "-")
+		first, err := parsePort(low)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parsePort(high); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		for p := first; p <= last; p++ {
+			seen[p] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("no ports in %q", s)
+	}
+	ports := make([]int, 0, len(seen))
+	for p := range seen {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// parsePort parses a port number, from 1 to 65535
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return p, nil
+}
+
+// ExpandTargets expands host names, IP addresses and CIDR ranges like 10.0.0.0/24 to the hosts to scan
+// The network and broadcast addresses of the IPv4 ranges are left out. Host names are resolved when dialing
+func ExpandTargets(targets []string) ([]string, error) {
+	var hosts []string
+	seen := map[string]bool{}
+	add := func(host string) error {
+		if seen[host] {
+			return nil
+		}
+		if len(hosts) == MaxTargets {
+			return fmt.Errorf("more than %d targets", MaxTargets)
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+		return nil
+	}
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		if !strings.Contains(target, "/") {
+			if err := add(strings.Trim(target, "[]")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", target)
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().BitLen()-prefix.Bits() > 16 {
+			return nil, fmt.Errorf("CIDR range %q has more than %d addresses", target, MaxTargets)
+		}
+		// The network and broadcast addresses are not hosts, except in the /31 and /32 ranges
+		skipEnds := prefix.Addr().Is4() && prefix.Bits() < 31
+		for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+			if skipEnds && (addr == prefix.Addr() || !prefix.Contains(addr.Next())) {
+				continue
+			}
+			if err := add(addr.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	return hosts, nil
+}
+
+// address joins a host and a port, with brackets around IPv6 addresses
+func address(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
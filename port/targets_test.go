@@ -0,0 +1,112 @@
+package port
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []int
+		wantErr bool
+	}{
+		{s: "22", want: []int{22}},
+		{s: "22,80,8000-8002", want: []int{22, 80, 8000, 8001, 8002}},
+		{s: "443,80,22", want: []int{22, 80, 443}},
+		{s: "80,79-81,80", want: []int{79, 80, 81}},
+		{s: " 22 , 80 - 81 ", want: []int{22, 80, 81}},
+		{s: "22,,80,", want: []int{22, 80}},
+		{s: "1,65535", want: []int{1, 65535}},
+		{s: "5-5", want: []int{5}},
+		{s: "", wantErr: true},
+		{s: ",", wantErr: true},
+		{s: "0", wantErr: true},
+		{s: "65536", wantErr: true},
+		{s: "-1", wantErr: true},
+		{s: "http", wantErr: true},
+		{s: "10-5", wantErr: true},
+		{s: "1-", wantErr: true},
+		{s: "1-2-3", wantErr: true},
+		{s: "1-65536", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePorts(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePorts(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePorts(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+
+	all, err := ParsePorts("1-65535")
+	if err != nil || len(all) != 65535 || all[0] != 1 || all[len(all)-1] != 65535 {
+		t.Errorf("ParsePorts(1-65535) = %d ports, %v, want every port", len(all), err)
+	}
+}
+
+func TestExpandTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "host name", targets: []string{"scanme.example.com"}, want: []string{"scanme.example.com"}},
+		{name: "addresses", targets: []string{"10.0.0.1", " 10.0.0.2 ", "[::1]", "::2"}, want: []string{"10.0.0.1", "10.0.0.2", "::1", "::2"}},
+		{name: "network and broadcast skipped", targets: []string{"10.0.0.0/30"}, want: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "range masked", targets: []string{"10.0.0.6/29"}, want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}},
+		{name: "point-to-point range", targets: []string{"10.0.0.0/31"}, want: []string{"10.0.0.0", "10.0.0.1"}},
+		{name: "single address range", targets: []string{"10.0.0.7/32"}, want: []string{"10.0.0.7"}},
+		{name: "ipv6 range keeps its ends", targets: []string{"2001:db8::/126"}, want: []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{name: "duplicates", targets: []string{"10.0.0.1", "10.0.0.0/30", "10.0.0.2", ""}, want: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "no target", targets: []string{" ", ""}, wantErr: true},
+		{name: "invalid range", targets: []string{"10.0.0.0/33"}, wantErr: true},
+		{name: "range of a host name", targets: []string{"example.com/24"}, wantErr: true},
+		{name: "range larger than a /16", targets: []string{"10.0.0.0/15"}, wantErr: true},
+		{name: "ipv6 range larger than a /112", targets: []string{"2001:db8::/111"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ExpandTargets(tt.targets)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ExpandTargets(%q) error = %v, wantErr %v", tt.name, tt.targets, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ExpandTargets(%q) = %v, want %v", tt.name, tt.targets, got, tt.want)
+		}
+	}
+}
+
+func TestExpandTargetsLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		want    int
+		wantErr bool
+	}{
+		// A /16 has 65534 hosts without its network and broadcast addresses, two more fit in the limit
+		{name: "ipv4 /16", targets: []string{"10.1.0.0/16"}, want: MaxTargets - 2},
+		{name: "ipv4 /16 and two hosts", targets: []string{"10.1.0.0/16", "10.2.0.1", "10.2.0.2"}, want: MaxTargets},
+		{name: "ipv4 /16 and three hosts", targets: []string{"10.1.0.0/16", "10.2.0.1", "10.2.0.2", "10.2.0.3"}, wantErr: true},
+		{name: "ipv6 /112", targets: []string{"2001:db8::/112"}, want: MaxTargets},
+		{name: "ipv6 /112 and a host", targets: []string{"2001:db8::/112", "10.0.0.1"}, wantErr: true},
+		{name: "two /17", targets: []string{"10.1.0.0/17", "10.1.128.0/17", "10.2.0.0/24"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ExpandTargets(tt.targets)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if len(got) != tt.want {
+			t.Errorf("%s: %d targets, want %d", tt.name, len(got), tt.want)
+		}
+		if tt.wantErr && err.Error() != fmt.Sprintf("more than %d targets", MaxTargets) {
+			t.Errorf("%s: error = %v, want the limit error", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package port
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// udpPayloads are the probes of the UDP services, which only reply to a valid request
+// The other ports receive an empty datagram
+var udpPayloads = map[int][]byte{
+	// DNS: query of the A record of the root, recursion desired
+	53: {
+		0x13, 0x37, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x01, 0x00, 0x01,
+	},
+	// NTP: version 3 client request
+	123: append([]byte{0x1b}, make([]byte, 47)...),
+	// NetBIOS: wildcard node status request
+	137: {
+		0x13, 0x37, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x20, 0x43, 0x4b, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41,
+		0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41,
+		0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x41, 0x00, 0x00, 0x21,
+		0x00, 0x01,
+	},
+	// SNMP: version 1 get-request of sysDescr.0 with the public community
+	161: {
+		0x30, 0x26, 0x02, 0x01, 0x00, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69,
+		0x63, 0xa0, 0x19, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+		0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01,
+		0x01, 0x00, 0x05, 0x00,
+	},
+	// SSDP: discovery of every device
+	1900: []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n"),
+	// mDNS: query of the services, like a DNS query
+	5353: {
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x09, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x73, 0x07, 0x5f,
+		0x64, 0x6e, 0x73, 0x2d, 0x73, 0x64, 0x04, 0x5f, 0x75, 0x64, 0x70, 0x05,
+		0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x00, 0x00, 0x0c, 0x00, 0x01,
+	},
+}
+
+// udpAttempts is the number of datagrams sent before a silent port is reported open|filtered,
+// every attempt waits for a part of the timeout
+const udpAttempts = 2
+
+// scanUDP probes a UDP port, which has no handshake
+// A port is open if it replies to the probe of its service, closed if the host answers with an ICMP port unreachable,
+// and open|filtered if nothing comes back: the service ignored the probe, or a firewall dropped it
+// It returns the state of the port and the reason
+func scanUDP(ctx context.Context, hostname string, port int, timeout time.Duration) (string, string) {
+	// A connected socket receives the ICMP errors of the remote port as ECONNREFUSED
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address(hostname, port))
+	if err != nil {
+		return StateFiltered, ReasonError
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	payload := udpPayloads[port]
+	buf := make([]byte, 1500)
+	for i := 0; i < udpAttempts; i++ {
+		if _, err := conn.Write(payload); err != nil {
+			return udpState(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout / udpAttempts))
+		_, err := conn.Read(buf)
+		if err == nil {
+			return StateOpen, ReasonUDPResponse
+		}
+		if state, reason := udpState(err); state != StateOpenFiltered || ctx.Err() != nil {
+			return state, reason
+		}
+	}
+	return StateOpenFiltered, ReasonNoResponse
+}
+
+// udpState returns the state of a UDP port and the reason from the error of a probe
+func udpState(err error) (string, string) {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return StateClosed, ReasonPortUnreach
+	}
+	return StateOpenFiltered, ReasonNoResponse
+}
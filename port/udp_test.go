@@ -0,0 +1,119 @@
+package port
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenUDP starts a UDP listener on a free loopback port, replying to every datagram if echo is set
+// It returns the port of the listener
+func listenUDP(t *testing.T, echo bool) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if echo {
+				conn.WriteTo(append([]byte("echo:"), buf[:n]...), addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// closedUDPPort returns a loopback UDP port nothing listens on
+func closedUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+	return port
+}
+
+func TestScanUDP(t *testing.T) {
+	const timeout = 400 * time.Millisecond
+	tests := []struct {
+		name       string
+		port       func(t *testing.T) int
+		wantState  string
+		wantReason string
+	}{
+		{
+			name:       "open",
+			port:       func(t *testing.T) int { return listenUDP(t, true) },
+			wantState:  StateOpen,
+			wantReason: ReasonUDPResponse,
+		},
+		{
+			name:       "closed",
+			port:       closedUDPPort,
+			wantState:  StateClosed,
+			wantReason: ReasonPortUnreach,
+		},
+		{
+			name:       "open|filtered",
+			port:       func(t *testing.T) int { return listenUDP(t, false) },
+			wantState:  StateOpenFiltered,
+			wantReason: ReasonNoResponse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := tt.port(t)
+			result := ScanPort(context.Background(), "udp", "127.0.0.1", port, timeout)
+			if result.State != tt.wantState || result.Reason != tt.wantReason {
+				t.Errorf("ScanPort(udp %d) = %s (%s), want %s (%s)", port, result.State, result.Reason, tt.wantState, tt.wantReason)
+			}
+			if result.Protocol() != "udp" || result.PortNumber() != port {
+				t.Errorf("ScanPort(udp %d) port = %s", port, result.Port)
+			}
+			if result.Duration > timeout+time.Second {
+				t.Errorf("ScanPort(udp %d) took %s, timeout %s", port, result.Duration, timeout)
+			}
+		})
+	}
+}
+
+func TestScanUDPCancelled(t *testing.T) {
+	port := listenUDP(t, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	started := time.Now()
+	state, _ := scanUDP(ctx, "127.0.0.1", port, 10*time.Second)
+	if state != StateOpenFiltered {
+		t.Errorf("scanUDP() = %s, want %s", state, StateOpenFiltered)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("scanUDP() took %s after the context was cancelled", elapsed)
+	}
+}
+
+func TestScannerUDP(t *testing.T) {
+	open := listenUDP(t, true)
+	closed := closedUDPPort(t)
+	scanner := New(WithProtocols("udp"), WithTimeout(400*time.Millisecond))
+	results, err := scanner.Scan(context.Background(), []string{"127.0.0.1"}, []int{open, closed})
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	states := map[int]string{}
+	for _, r := range results {
+		states[r.PortNumber()] = r.State
+	}
+	if states[open] != StateOpen || states[closed] != StateClosed {
+		t.Errorf("Scan() states = %v, want %d open and %d closed", states, open, closed)
+	}
+}
@@ -0,0 +1,78 @@
+// Package ratelimit implements token bucket rate limiting with pluggable stores.
+// The in-memory store suits a single instance, the Redis store shares the
+// buckets between every instance of the service.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zerodot618/go-huang/lifecycle"
+)
+
+// Limit describes a token bucket: Burst requests at once, refilled at Burst per Period
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// PerMinute returns a limit of n requests per minute
+func PerMinute(n int) Limit {
+	return Limit{Burst: n, Period: time.Minute}
+}
+
+// rate returns the number of tokens added per millisecond
+func (l Limit) rate() float64 {
+	return float64(l.Burst) / float64(l.Period.Milliseconds())
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool          // Whether the request may proceed
+	Limit      int           // Size of the bucket
+	Remaining  int           // Tokens left after this request
+	Reset      time.Duration // Time until the bucket is full again
+	RetryAfter time.Duration // Time until the next token, zero when allowed
+}
+
+// Store keeps the state of the token buckets
+type Store interface {
+	// Take consumes one token from the bucket of key
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// NewStore returns a Redis store if REDIS_URL is set, or an in-memory store otherwise
+// The Redis connection is closed on shutdown
+func NewStore() (Store, error) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		return NewMemoryStore(), nil
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	lifecycle.OnShutdown("ratelimit redis", func(ctx context.Context) error {
+		return client.Close()
+	})
+	return NewRedisStore(client, "ratelimit:"), nil
+}
+
+// result builds the Result of a bucket holding tokens after the request
+func result(limit Limit, allowed bool, tokens float64) Result {
+	rate := limit.rate()
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Burst)-tokens)/rate) * time.Millisecond,
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration(math.Ceil((1-tokens)/rate)) * time.Millisecond
+	}
+	return res
+}
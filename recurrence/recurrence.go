@@ -0,0 +1,268 @@
+// Package recurrence implements the subset of the iCalendar recurrence rules (RFC 5545) used by the to-do items:
+// daily, weekly on chosen weekdays and monthly on a day of the month, every INTERVAL periods, until an end time.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is how often a rule repeats
+type Frequency string
+
+// Frequencies of the supported rules
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// MaxInterval is the largest supported INTERVAL
+const MaxInterval = 1000
+
+// maxSkippedPeriods bounds the search of a monthly occurrence, e.g. the 31st every 12 months from February never comes
+const maxSkippedPeriods = 48
+
+// untilLayouts are the formats of UNTIL, a UTC time or a date
+var untilLayouts = []string{"20060102T150405Z", "20060102"}
+
+// weekdays are the BYDAY codes, indexed by time.Weekday
+var weekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// ErrInvalidRule is returned for a malformed or unsupported rule
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+// Rule is a recurrence rule
+// Weekdays is only used by weekly rules, MonthDay by monthly rules: 1 to 31 from the start of the month,
+// -1 to -31 from its end, 0 for the day of the previous occurrence. Months without the day are skipped
+type Rule struct {
+	Frequency Frequency
+	Interval  int
+	Weekdays  []time.Weekday
+	MonthDay  int
+	Until     *time.Time
+}
+
+// Parse parses a rule like FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;UNTIL=20261231T000000Z
+// The RRULE: prefix is optional
+func Parse(s string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return rule, ErrInvalidRule
+	}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(name)
+		if !ok || value == "" || seen[name] {
+			return rule, fmt.Errorf("%w: %q", ErrInvalidRule, part)
+		}
+		seen[name] = true
+		var err error
+		switch name {
+		case "FREQ":
+			rule.Frequency = Frequency(strings.ToUpper(value))
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(value)
+		case "BYDAY":
+			rule.Weekdays, err = parseWeekdays(value)
+		case "BYMONTHDAY":
+			rule.MonthDay, err = strconv.Atoi(value)
+		case "UNTIL":
+			rule.Until, err = parseUntil(value)
+		default:
+			return rule, fmt.Errorf("%w: %s is not supported", ErrInvalidRule, name)
+		}
+		if err != nil {
+			return rule, fmt.Errorf("%w: %q", ErrInvalidRule, part)
+		}
+	}
+	return rule, rule.Validate()
+}
+
+// Validate checks that the rule is supported
+func (r Rule) Validate() error {
+	switch r.Frequency {
+	case Daily, Weekly, Monthly:
+	default:
+		return fmt.Errorf("%w: FREQ must be DAILY, WEEKLY or MONTHLY", ErrInvalidRule)
+	}
+	if r.Interval < 1 || r.Interval > MaxInterval {
+		return fmt.Errorf("%w: INTERVAL must be between 1 and %d", ErrInvalidRule, MaxInterval)
+	}
+	if len(r.Weekdays) > 0 && r.Frequency != Weekly {
+		return fmt.Errorf("%w: BYDAY is only supported by WEEKLY rules", ErrInvalidRule)
+	}
+	if r.MonthDay != 0 && r.Frequency != Monthly {
+		return fmt.Errorf("%w: BYMONTHDAY is only supported by MONTHLY rules", ErrInvalidRule)
+	}
+	if r.MonthDay < -31 || r.MonthDay > 31 {
+		return fmt.Errorf("%w: BYMONTHDAY must be between -31 and 31", ErrInvalidRule)
+	}
+	for _, day := range r.Weekdays {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("%w: invalid weekday", ErrInvalidRule)
+		}
+	}
+	return nil
+}
+
+// String formats the rule in its canonical form, which Parse reads back
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Frequency)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.Weekdays) > 0 {
+		codes := make([]string, 0, len(r.Weekdays))
+		for _, day := range sortedWeekdays(r.Weekdays) {
+			codes = append(codes, weekdays[day])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.MonthDay != 0 {
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(r.MonthDay))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayouts[0]))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next returns the occurrence following prev, at the same time of day
+// It returns false once the rule is over
+func (r Rule) Next(prev time.Time) (time.Time, bool) {
+	var next time.Time
+	switch r.Frequency {
+	case Daily:
+		next = prev.AddDate(0, 0, r.interval())
+	case Weekly:
+		next = r.nextWeekly(prev)
+	case Monthly:
+		var ok bool
+		if next, ok = r.nextMonthly(prev); !ok {
+			return time.Time{}, false
+		}
+	default:
+		return time.Time{}, false
+	}
+	if r.Until != nil && next.After(*r.Until) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// nextWeekly returns the next chosen weekday of the week of prev,
+// or the first one of the week INTERVAL weeks later. Weeks start on Monday
+func (r Rule) nextWeekly(prev time.Time) time.Time {
+	if len(r.Weekdays) == 0 {
+		return prev.AddDate(0, 0, 7*r.interval())
+	}
+	days := sortedWeekdays(r.Weekdays)
+	offset := mondayOffset(prev.Weekday())
+	for _, day := range days {
+		if mondayOffset(day) > offset {
+			return prev.AddDate(0, 0, mondayOffset(day)-offset)
+		}
+	}
+	monday := prev.AddDate(0, 0, -offset+7*r.interval())
+	return monday.AddDate(0, 0, mondayOffset(days[0]))
+}
+
+// nextMonthly returns the occurrence on the day of the month following prev,
+// every INTERVAL months from the month of prev, skipping the months without that day
+func (r Rule) nextMonthly(prev time.Time) (time.Time, bool) {
+	year, month, day := prev.Date()
+	hour, minute, sec := prev.Clock()
+	// The day of the month of prev is kept when the rule has none
+	target := r.MonthDay
+	if target == 0 {
+		target = day
+	}
+	// The month of prev is tried first, the day of the rule can come later in it
+	for i := 0; i <= maxSkippedPeriods; i++ {
+		first := time.Date(year, month+time.Month(i*r.interval()), 1, hour, minute, sec, prev.Nanosecond(), prev.Location())
+		if candidate, ok := dayOfMonth(first, target); ok && candidate.After(prev) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// interval returns the interval of the rule, at least 1
+func (r Rule) interval() int {
+	if r.Interval < 1 {
+		return 1
+	}
+	return r.Interval
+}
+
+// dayOfMonth returns a day of the month of first, counted from its end if negative
+// It returns false if the month has no such day
+func dayOfMonth(first time.Time, day int) (time.Time, bool) {
+	days := first.AddDate(0, 1, -1).Day()
+	if day < 0 {
+		day = days + day + 1
+	}
+	if day < 1 || day > days {
+		return time.Time{}, false
+	}
+	return first.AddDate(0, 0, day-1), true
+}
+
+// mondayOffset returns the number of days between Monday and a weekday
+func mondayOffset(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+// sortedWeekdays returns the distinct weekdays from Monday to Sunday
+func sortedWeekdays(days []time.Weekday) []time.Weekday {
+	seen := map[time.Weekday]bool{}
+	sorted := make([]time.Weekday, 0, len(days))
+	for _, day := range days {
+		if !seen[day] {
+			seen[day] = true
+			sorted = append(sorted, day)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return mondayOffset(sorted[i]) < mondayOffset(sorted[j]) })
+	return sorted
+}
+
+// ParseWeekday parses a BYDAY code like MO
+func ParseWeekday(code string) (time.Weekday, bool) {
+	for i, c := range weekdays {
+		if strings.EqualFold(c, code) {
+			return time.Weekday(i), true
+		}
+	}
+	return 0, false
+}
+
+// parseWeekdays parses a list of BYDAY codes like MO,WE,FR
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, code := range strings.Split(value, ",") {
+		day, ok := ParseWeekday(code)
+		if !ok {
+			return nil, ErrInvalidRule
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// parseUntil parses the end time of a rule
+func parseUntil(value string) (*time.Time, error) {
+	for _, layout := range untilLayouts {
+		if until, err := time.Parse(layout, value); err == nil {
+			return &until, nil
+		}
+	}
+	return nil, ErrInvalidRule
+}
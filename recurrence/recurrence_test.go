@@ -0,0 +1,246 @@
+package recurrence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// occurrences returns the n occurrences of a rule following start, formatted with their weekday and zone
+func occurrences(t *testing.T, rule Rule, start time.Time, n int) []string {
+	t.Helper()
+	var got []string
+	prev := start
+	for i := 0; i < n; i++ {
+		next, ok := rule.Next(prev)
+		if !ok {
+			break
+		}
+		if !next.After(prev) {
+			t.Fatalf("Next(%s) = %s, not after it", prev, next)
+		}
+		got = append(got, next.Format("2006-01-02 Mon 15:04 MST"))
+		prev = next
+	}
+	return got
+}
+
+func TestParse(t *testing.T) {
+	until := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec    string
+		want    Rule
+		wantErr bool
+	}{
+		{spec: "FREQ=DAILY", want: Rule{Frequency: Daily, Interval: 1}},
+		{spec: "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TH,MO", want: Rule{Frequency: Weekly, Interval: 2, Weekdays: []time.Weekday{time.Thursday, time.Monday}}},
+		{spec: "freq=monthly;bymonthday=-1", want: Rule{Frequency: Monthly, Interval: 1, MonthDay: -1}},
+		{spec: "FREQ=MONTHLY;BYMONTHDAY=31;UNTIL=20261231T000000Z", want: Rule{Frequency: Monthly, Interval: 1, MonthDay: 31, Until: &until}},
+		{spec: "FREQ=DAILY;UNTIL=20261231", want: Rule{Frequency: Daily, Interval: 1, Until: &until}},
+		{spec: "", wantErr: true},
+		{spec: "FREQ=YEARLY", wantErr: true},
+		{spec: "FREQ=DAILY;INTERVAL=0", wantErr: true},
+		{spec: "FREQ=DAILY;INTERVAL=1001", wantErr: true},
+		{spec: "FREQ=DAILY;FREQ=WEEKLY", wantErr: true},
+		{spec: "FREQ=DAILY;BYDAY=MO", wantErr: true},
+		{spec: "FREQ=WEEKLY;BYDAY=XX", wantErr: true},
+		{spec: "FREQ=WEEKLY;BYMONTHDAY=1", wantErr: true},
+		{spec: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: true},
+		{spec: "FREQ=MONTHLY;BYMONTHDAY=-32", wantErr: true},
+		{spec: "FREQ=DAILY;COUNT=3", wantErr: true},
+		{spec: "FREQ=DAILY;UNTIL=tomorrow", wantErr: true},
+		{spec: "FREQ=DAILY;INTERVAL=", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("Parse(%q) error = %v, want ErrInvalidRule", tt.spec, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+		// The canonical form is read back to the same rule
+		again, err := Parse(got.String())
+		if err != nil || again.String() != got.String() {
+			t.Errorf("Parse(%q) = %+v, %v, want it to read back %q", got.String(), again, err, got.String())
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	until := time.Date(2026, 12, 31, 23, 0, 0, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{Frequency: Daily, Interval: 1}, "FREQ=DAILY"},
+		{Rule{Frequency: Weekly, Interval: 2, Weekdays: []time.Weekday{time.Sunday, time.Monday, time.Monday}}, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU"},
+		{Rule{Frequency: Monthly, Interval: 1, MonthDay: -1, Until: &until}, "FREQ=MONTHLY;BYMONTHDAY=-1;UNTIL=20261231T220000Z"},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	// The first four occurrences, fewer for the rules that end
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		want  []string
+	}{
+		{
+			name:  "daily every other day",
+			rule:  "FREQ=DAILY;INTERVAL=2",
+			start: at(2026, time.February, 27, 9),
+			want:  []string{"2026-03-01 Sun 09:00 UTC", "2026-03-03 Tue 09:00 UTC", "2026-03-05 Thu 09:00 UTC", "2026-03-07 Sat 09:00 UTC"},
+		},
+		{
+			name:  "weekly on the weekday of the start",
+			rule:  "FREQ=WEEKLY;INTERVAL=3",
+			start: at(2026, time.October, 19, 9),
+			want:  []string{"2026-11-09 Mon 09:00 UTC", "2026-11-30 Mon 09:00 UTC", "2026-12-21 Mon 09:00 UTC", "2027-01-11 Mon 09:00 UTC"},
+		},
+		{
+			name:  "weekly on two weekdays",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,TH",
+			start: at(2026, time.October, 22, 9),
+			want:  []string{"2026-10-26 Mon 09:00 UTC", "2026-10-29 Thu 09:00 UTC", "2026-11-02 Mon 09:00 UTC", "2026-11-05 Thu 09:00 UTC"},
+		},
+		{
+			name:  "every other week on two weekdays",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+			start: at(2026, time.October, 19, 9),
+			want:  []string{"2026-10-22 Thu 09:00 UTC", "2026-11-02 Mon 09:00 UTC", "2026-11-05 Thu 09:00 UTC", "2026-11-16 Mon 09:00 UTC"},
+		},
+		{
+			// Weeks start on Monday: the Sunday of the week of the start comes before the next interval
+			name:  "every other week on sunday from a weekday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=SU,WE",
+			start: at(2026, time.October, 19, 9),
+			want:  []string{"2026-10-21 Wed 09:00 UTC", "2026-10-25 Sun 09:00 UTC", "2026-11-04 Wed 09:00 UTC", "2026-11-08 Sun 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the 31st skips the shorter months",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=31",
+			start: at(2026, time.January, 31, 9),
+			want:  []string{"2026-03-31 Tue 09:00 UTC", "2026-05-31 Sun 09:00 UTC", "2026-07-31 Fri 09:00 UTC", "2026-08-31 Mon 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the day of the start",
+			rule:  "FREQ=MONTHLY",
+			start: at(2026, time.January, 30, 9),
+			want:  []string{"2026-03-30 Mon 09:00 UTC", "2026-04-30 Thu 09:00 UTC", "2026-05-30 Sat 09:00 UTC", "2026-06-30 Tue 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the last day",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1",
+			start: at(2027, time.December, 31, 9),
+			want:  []string{"2028-01-31 Mon 09:00 UTC", "2028-02-29 Tue 09:00 UTC", "2028-03-31 Fri 09:00 UTC", "2028-04-30 Sun 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the third day from the end",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-3",
+			start: at(2026, time.January, 1, 9),
+			want:  []string{"2026-01-29 Thu 09:00 UTC", "2026-02-26 Thu 09:00 UTC", "2026-03-29 Sun 09:00 UTC", "2026-04-28 Tue 09:00 UTC"},
+		},
+		{
+			name:  "monthly later in the month of the start",
+			rule:  "FREQ=MONTHLY;INTERVAL=3;BYMONTHDAY=15",
+			start: at(2026, time.January, 10, 9),
+			want:  []string{"2026-01-15 Thu 09:00 UTC", "2026-04-15 Wed 09:00 UTC", "2026-07-15 Wed 09:00 UTC", "2026-10-15 Thu 09:00 UTC"},
+		},
+		{
+			name:  "every two months on the 31st skips to the next month with it",
+			rule:  "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=31",
+			start: at(2026, time.May, 31, 9),
+			want:  []string{"2026-07-31 Fri 09:00 UTC", "2027-01-31 Sun 09:00 UTC", "2027-03-31 Wed 09:00 UTC", "2027-05-31 Mon 09:00 UTC"},
+		},
+		{
+			name:  "a day that never comes",
+			rule:  "FREQ=MONTHLY;INTERVAL=12;BYMONTHDAY=30",
+			start: at(2026, time.February, 1, 9),
+			want:  nil,
+		},
+		{
+			name:  "until a date",
+			rule:  "FREQ=DAILY;UNTIL=20261021",
+			start: at(2026, time.October, 18, 0),
+			want:  []string{"2026-10-19 Mon 00:00 UTC", "2026-10-20 Tue 00:00 UTC", "2026-10-21 Wed 00:00 UTC"},
+		},
+		{
+			name:  "until a time before the time of day",
+			rule:  "FREQ=DAILY;UNTIL=20261021T080000Z",
+			start: at(2026, time.October, 19, 9),
+			want:  []string{"2026-10-20 Tue 09:00 UTC"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.rule, err)
+			}
+			if got := occurrences(t, rule, tt.start, 4); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("occurrences = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextKeepsTheTimeOfDayAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		want  []string
+	}{
+		{
+			name:  "daily over the spring change",
+			rule:  "FREQ=DAILY",
+			start: time.Date(2026, time.March, 7, 9, 0, 0, 0, newYork),
+			want:  []string{"2026-03-08 Sun 09:00 EDT", "2026-03-09 Mon 09:00 EDT"},
+		},
+		{
+			name:  "weekly over the autumn change",
+			rule:  "FREQ=WEEKLY;BYDAY=SA,SU",
+			start: time.Date(2026, time.October, 31, 9, 0, 0, 0, newYork),
+			want:  []string{"2026-11-01 Sun 09:00 EST", "2026-11-07 Sat 09:00 EST"},
+		},
+		{
+			name:  "monthly over the spring change",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1",
+			start: time.Date(2026, time.February, 28, 23, 30, 0, 0, newYork),
+			want:  []string{"2026-03-31 Tue 23:30 EDT", "2026-04-30 Thu 23:30 EDT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.rule, err)
+			}
+			if got := occurrences(t, rule, tt.start, 2); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("occurrences = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Package reminder delivers the reminders of the to-do items before their due time.
+// A Scheduler polls the reminders persisted by the models and hands them to a Notifier:
+// EmailNotifier sends them through a mail.Mailer, WebhookNotifier posts them to a URL, LogNotifier only logs them.
+package reminder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/mail"
+	"github.com/zerodot618/go-huang/webhook"
+)
+
+// SignatureHeader is the header of the webhook requests holding the HMAC-SHA256 of the body, hex encoded
+const SignatureHeader = "X-Reminder-Signature"
+
+// Notification is a reminder of a to-do item due soon
+type Notification struct {
+	ReminderID uint      `json:"reminder_id"`
+	ToDoID     uint      `json:"todo_id"`
+	Title      string    `json:"title"`
+	DueAt      time.Time `json:"due_at"`
+	UserID     uint      `json:"user_id"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+}
+
+// Notifier delivers the reminders
+// A reminder can be delivered more than once if an instance stops before recording the delivery,
+// the notifiers pass the reminder ID on so the receivers can drop the duplicates
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NewNotifierFromEnv returns the notifier chosen by REMINDER_NOTIFIER: email (the default), webhook or log
+// The webhook is configured by REMINDER_WEBHOOK_URL and REMINDER_WEBHOOK_SECRET
+func NewNotifierFromEnv() (Notifier, error) {
+	switch kind := os.Getenv("REMINDER_NOTIFIER"); kind {
+	case "", "email":
+		return EmailNotifier{}, nil
+	case "webhook":
+		url := os.Getenv("REMINDER_WEBHOOK_URL")
+		if url == "" {
+			return nil, errors.New("REMINDER_WEBHOOK_URL is required by the webhook notifier")
+		}
+		return &WebhookNotifier{URL: url, Secret: os.Getenv("REMINDER_WEBHOOK_SECRET")}, nil
+	case "log":
+		return LogNotifier{}, nil
+	default:
+		return nil, fmt.Errorf("invalid REMINDER_NOTIFIER %q", kind)
+	}
+}
+
+// EmailNotifier is a Notifier sending the reminders by email
+type EmailNotifier struct {
+	// Mailer sends the emails, mail.Default if nil
+	Mailer mail.Mailer
+}
+
+// Notify sends the reminder to the recipient
+func (e EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	mailer := e.Mailer
+	if mailer == nil {
+		mailer = mail.Default
+	}
+	return mailer.Send(ctx, mail.ReminderEmail(n.Email, n.Name, n.Title, n.DueAt))
+}
+
+// WebhookNotifier is a Notifier posting the reminders to a URL as JSON
+// The body is signed with the secret in SignatureHeader, and the Idempotency-Key header identifies the reminder
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	// Client sends the requests, a client with a 10 seconds timeout if nil
+	Client *http.Client
+}
+
+// Notify posts the reminder, any response but a 2xx is an error
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	endpoint := webhook.Endpoint{URL: w.URL, Secret: w.Secret, SignatureHeader: SignatureHeader, Client: w.Client}
+	return endpoint.Post(ctx, "reminder-"+strconv.FormatUint(uint64(n.ReminderID), 10), n)
+}
+
+// LogNotifier is a Notifier that writes the reminders to the log instead of delivering them
+type LogNotifier struct{}
+
+// Notify logs the reminder
+func (LogNotifier) Notify(ctx context.Context, n Notification) error {
+	logging.FromContext(ctx).Info("reminder not sent, logged only",
+		"reminder_id", n.ReminderID,
+		"todo_id", n.ToDoID,
+		"user_id", n.UserID,
+		"due_at", n.DueAt,
+	)
+	return nil
+}
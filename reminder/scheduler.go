@@ -0,0 +1,157 @@
+package reminder
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/metrics"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/poll"
+	"gorm.io/gorm"
+)
+
+// Default settings of the Scheduler
+const (
+	DefaultLease = 5 * time.Minute
+	// notifyTimeout bounds the delivery of one reminder
+	notifyTimeout = 30 * time.Second
+)
+
+// Scheduler delivers the due reminders every interval
+// The reminders are persisted: a restart neither loses them nor delivers again those recorded as sent.
+// Every instance can run a Scheduler, a reminder is claimed by one of them for the lease.
+// The lease is renewed before each delivery and must be longer than the delivery of one reminder;
+// a batch holds at most Lease / notifyTimeout reminders, so the claims do not expire while it is delivered
+type Scheduler struct {
+	Notifier  Notifier
+	Interval  time.Duration
+	BatchSize int
+	Lease     time.Duration
+	// Owner identifies the instance in the claims of the reminders
+	Owner string
+}
+
+// NewSchedulerFromEnv returns a Scheduler using the notifier of NewNotifierFromEnv,
+// polling every REMINDER_POLL_INTERVAL (time.ParseDuration format, 30s by default)
+func NewSchedulerFromEnv() (*Scheduler, error) {
+	notifier, err := NewNotifierFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	s := NewScheduler(notifier)
+	if s.Interval, err = poll.IntervalFromEnv("REMINDER_POLL_INTERVAL"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewScheduler returns a Scheduler with the default settings and an owner unique to the instance
+func NewScheduler(notifier Notifier) *Scheduler {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return &Scheduler{
+		Notifier:  notifier,
+		Interval:  poll.DefaultInterval,
+		BatchSize: poll.DefaultBatchSize,
+		Lease:     DefaultLease,
+		Owner:     fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix)),
+	}
+}
+
+// Run delivers the due reminders every interval, until the context is cancelled
+// A full batch is followed at once by the next one
+func (s *Scheduler) Run(ctx context.Context) {
+	poll.Run(ctx, s.Interval, func(ctx context.Context) bool {
+		delivered, err := s.Tick(ctx)
+		if err != nil {
+			logging.Default.Error("could not deliver reminders", "error", err)
+			return false
+		}
+		return delivered == s.batchSize()
+	})
+}
+
+// Tick claims a batch of due reminders and delivers them, it returns the number of claimed reminders
+// A failed delivery is retried later, see models.Reminder.MarkFailed
+func (s *Scheduler) Tick(ctx context.Context) (int, error) {
+	reminders, err := models.ClaimDueReminders(s.Owner, time.Now(), s.Lease, s.batchSize())
+	if err != nil {
+		return 0, err
+	}
+	for i := range reminders {
+		if ctx.Err() != nil {
+			// The unprocessed reminders are claimed again once their lease is over
+			return i, nil
+		}
+		err := s.deliver(ctx, &reminders[i])
+		if errors.Is(err, models.ErrReminderLeaseLost) {
+			// Another instance claimed the reminder once the lease expired, it delivers it
+			logging.Default.Warn("reminder lease lost", "reminder_id", reminders[i].ID)
+			continue
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(reminders), nil
+}
+
+// batchSize returns the number of reminders claimed at once,
+// at most the number of deliveries that fit in a lease
+func (s *Scheduler) batchSize() int {
+	size := s.BatchSize
+	if fit := int(s.Lease / notifyTimeout); fit < size {
+		size = fit
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// deliver renews the claim of a reminder, delivers it and records the result
+// It only returns the errors of the database and models.ErrReminderLeaseLost,
+// a failed delivery is recorded on the reminder
+func (s *Scheduler) deliver(ctx context.Context, reminder *models.Reminder) error {
+	if err := reminder.ExtendLease(s.Owner, time.Now(), s.Lease); err != nil {
+		return err
+	}
+	target, err := reminder.Target()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		metrics.Reminders.WithLabelValues("cancelled").Inc()
+		return reminder.MarkCancelled(s.Owner)
+	}
+	if err != nil {
+		return err
+	}
+	if target.ToDo.Status == models.ToDoStatusDone || target.ToDo.DueAt == nil {
+		metrics.Reminders.WithLabelValues("cancelled").Inc()
+		return reminder.MarkCancelled(s.Owner)
+	}
+	// The delivery ends before the renewed lease, even with a lease shorter than notifyTimeout
+	notifyCtx, cancel := context.WithTimeout(ctx, min(notifyTimeout, s.Lease))
+	defer cancel()
+	err = s.Notifier.Notify(notifyCtx, Notification{
+		ReminderID: reminder.ID,
+		ToDoID:     target.ToDo.ID,
+		Title:      target.ToDo.Title,
+		DueAt:      *target.ToDo.DueAt,
+		UserID:     target.Recipient.ID,
+		Name:       target.Recipient.Name,
+		Email:      target.Recipient.Email,
+	})
+	if err != nil {
+		logging.Default.Warn("could not deliver reminder", "reminder_id", reminder.ID, "attempt", reminder.Attempts+1, "error", err)
+		metrics.Reminders.WithLabelValues("failed").Inc()
+		return reminder.MarkFailed(s.Owner, time.Now(), err)
+	}
+	metrics.Reminders.WithLabelValues("sent").Inc()
+	return reminder.MarkSent(s.Owner, time.Now())
+}
@@ -0,0 +1,31 @@
+package reminder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zerodot618/go-huang/poll"
+)
+
+func TestSchedulerBatchSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		lease     time.Duration
+		want      int
+	}{
+		{"defaults", poll.DefaultBatchSize, DefaultLease, int(DefaultLease / notifyTimeout)},
+		{"small batch", 3, DefaultLease, 3},
+		{"long lease", poll.DefaultBatchSize, time.Hour, poll.DefaultBatchSize},
+		{"lease shorter than a delivery", poll.DefaultBatchSize, 10 * time.Second, 1},
+	}
+	for _, tt := range tests {
+		s := &Scheduler{BatchSize: tt.batchSize, Lease: tt.lease}
+		if got := s.batchSize(); got != tt.want {
+			t.Errorf("%s: batchSize() = %d, want %d", tt.name, got, tt.want)
+		}
+		if got := s.batchSize(); time.Duration(got-1)*notifyTimeout >= tt.lease {
+			t.Errorf("%s: %d deliveries do not fit in a lease of %s", tt.name, got, tt.lease)
+		}
+	}
+}
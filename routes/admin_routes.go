@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/middlewares"
+)
+
+func setupAdminRoutes(router *gin.RouterGroup) {
+	var adminController controllers.AdminController
+
+	// Create a new group for the admin routes, only reachable by users with the admin role
+	admin := router.Group("/admin").Use(middlewares.Authz(), middlewares.RequireAdmin())
+	{
+		// Add the user management routes
+		admin.GET("/users", adminController.ListUsers)
+		admin.POST("/users/:id/disable", adminController.DisableUser)
+		admin.POST("/users/:id/enable", adminController.EnableUser)
+		admin.POST("/users/:id/impersonate", adminController.Impersonate)
+		admin.GET("/audit-logs", adminController.ListAuditLogs)
+		// Add the 2FA reset route
+		admin.POST("/users/:id/2fa/reset", adminController.ResetTOTP)
+		// Add the route logging a user out of every device
+		admin.POST("/users/:id/sessions/terminate", adminController.TerminateSessions)
+	}
+}
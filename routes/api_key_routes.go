@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/middlewares"
+)
+
+func setupAPIKeyRoutes(router *gin.RouterGroup) {
+	var apiKeyController controllers.APIKeyController
+
+	// The API keys are managed with user tokens only, an API key cannot create other keys
+	protected := router.Group("/protected/api-keys").Use(middlewares.Authz(), middlewares.NoImpersonation())
+	{
+		protected.POST("", apiKeyController.CreateAPIKey)
+		protected.GET("", apiKeyController.ListAPIKeys)
+		protected.DELETE("/:id", apiKeyController.RevokeAPIKey)
+	}
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/controllers"
+)
+
+func setupHealthRoutes(router *gin.Engine) {
+	var healthController controllers.HealthController
+
+	// Probes are served at the root, outside of the /api group
+	router.GET("/healthz", healthController.Liveness)
+	router.GET("/readyz", healthController.Readiness)
+}
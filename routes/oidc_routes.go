@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/middlewares"
+	"github.com/zerodot618/go-huang/ratelimit"
+)
+
+func setupOIDCRoutes(router *gin.RouterGroup, limiter ratelimit.Store) {
+	var oidcController controllers.OIDCController
+
+	// Create a new group for the public routes of the OpenID Connect logins
+	public := router.Group("/public/oidc")
+	{
+		public.GET("/providers", oidcController.Providers)
+		public.GET("/:provider/login",
+			middlewares.RateLimit(limiter, "login_ip", loginIPLimit, middlewares.ByIP),
+			oidcController.Login)
+		public.GET("/:provider/callback",
+			middlewares.RateLimit(limiter, "login_ip", loginIPLimit, middlewares.ByIP),
+			oidcController.Callback)
+	}
+
+	// Create a new group for the linked identities of the current user
+	protected := router.Group("/protected").Use(middlewares.Authz())
+	{
+		protected.POST("/oidc/:provider/link", middlewares.NoImpersonation(), oidcController.Link)
+		protected.GET("/identities", oidcController.Identities)
+		protected.DELETE("/identities/:id", middlewares.NoImpersonation(), oidcController.Unlink)
+	}
+}
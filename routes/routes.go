@@ -1,7 +1,16 @@
 package routes
 
 import (
+	"os"
+	"strings"
+
 	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/metrics"
+	"github.com/zerodot618/go-huang/middlewares"
+	"github.com/zerodot618/go-huang/ratelimit"
+	"github.com/zerodot618/go-huang/validation"
 
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -9,23 +18,65 @@ import (
 
 // setupRouter sets up the router and adds the routes.
 func SetupRouter() *gin.Engine {
+	// Register the custom validation rules and their translations
+	validation.Setup()
+	// Create the store of the rate limits, shared between instances when REDIS_URL is set
+	limiter, err := ratelimit.NewStore()
+	if err != nil {
+		logging.Default.Error("could not create rate limit store, using memory", "error", err)
+		limiter = ratelimit.NewMemoryStore()
+	}
 	// Create a new router
-	r := gin.Default()
+	r := gin.New()
+	// The client IP of the rate limits and sessions is only read from the proxy headers set by TRUSTED_PROXIES
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		logging.Default.Error("invalid TRUSTED_PROXIES, trusting no proxy", "error", err)
+		r.SetTrustedProxies(nil)
+	}
+	// Assign request IDs and render every error with the same JSON envelope
+	r.Use(middlewares.RequestID(), middlewares.Logger(), middlewares.Metrics(), middlewares.Recovery(), middlewares.ErrorHandler())
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(middlewares.NoRoute)
+	r.NoMethod(middlewares.NoMethod)
 	// Add a welcome route
 	r.GET("/", func(c *gin.Context) {
 		c.String(200, "Welcome To This Website")
 	})
+	// Liveness and readiness probes
+	setupHealthRoutes(r)
+	// Public keys of the tokens, for the services verifying them
+	r.GET("/.well-known/jwks.json", controllers.JWKSController{}.JWKS)
+	// Prometheus metrics route
+	r.GET("/metrics", metrics.Handler())
 	// docs route
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	// Create a new group for the API
 	api := r.Group("/api")
 	{
 		// Add the routes for the user
-		setupUserRoutes(api)
+		setupUserRoutes(api, limiter)
+		setupOIDCRoutes(api, limiter)
+		setupAPIKeyRoutes(api)
+		setupSessionRoutes(api)
 		setupBookRoutes(api)
+		setupToDoRoutes(api)
+		setupTodoListRoutes(api)
 		setupShortenerRoutes(api)
 		setupFileRoutes(api)
+		setupAdminRoutes(api)
+		setupScanRoutes(api)
 	}
 	// Return the router
 	return r
 }
+
+// trustedProxies returns the comma separated IP addresses and CIDR ranges of TRUSTED_PROXIES, none by default
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
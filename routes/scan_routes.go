@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/middlewares"
+)
+
+func setupScanRoutes(router *gin.RouterGroup) {
+	var scanController controllers.ScanController
+	var scanProfileController controllers.ScanProfileController
+
+	// Create a new group for the port scans, only reachable by users with the admin role
+	scans := router.Group("/scans").Use(middlewares.Authz(), middlewares.RequireAdmin())
+	{
+		scans.POST("", scanController.StartScan)
+		scans.GET("", scanController.ListScans)
+		scans.GET("/:id", scanController.GetScan)
+		scans.POST("/:id/cancel", scanController.CancelScan)
+		scans.GET("/:id/changes", scanController.ScanChanges)
+		// Stream the results of a scan as server-sent events
+		scans.GET("/:id/events", scanController.ScanEvents)
+	}
+
+	// Create a new group for the scan profiles, run on their schedule or on demand
+	profiles := router.Group("/scan-profiles").Use(middlewares.Authz(), middlewares.RequireAdmin())
+	{
+		profiles.GET("", scanProfileController.ListScanProfiles)
+		profiles.POST("", scanProfileController.CreateScanProfile)
+		profiles.GET("/:id", scanProfileController.GetScanProfile)
+		profiles.PUT("/:id", scanProfileController.UpdateScanProfile)
+		profiles.DELETE("/:id", scanProfileController.DeleteScanProfile)
+		profiles.POST("/:id/run", scanProfileController.RunScanProfile)
+	}
+}
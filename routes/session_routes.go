@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/middlewares"
+)
+
+func setupSessionRoutes(router *gin.RouterGroup) {
+	var sessionController controllers.SessionController
+
+	// Create a new group for the sessions of the current user
+	protected := router.Group("/protected/sessions").Use(middlewares.Authz())
+	{
+		protected.GET("", sessionController.ListSessions)
+		// An admin impersonating the user cannot revoke the sessions of the user
+		protected.DELETE("/:id", middlewares.NoImpersonation(), sessionController.RevokeSession)
+	}
+}
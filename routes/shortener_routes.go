@@ -3,6 +3,7 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/middlewares"
 )
 
 func setupShortenerRoutes(router *gin.RouterGroup) {
@@ -10,8 +11,9 @@ func setupShortenerRoutes(router *gin.RouterGroup) {
 
 	shortenerRoutes := router.Group("/shortener")
 	{
-		shortenerRoutes.POST("", shortenerController.CreateShortURL)
+		shortenerRoutes.POST("", middlewares.OptionalAuthz(), shortenerController.CreateShortURL)
 		shortenerRoutes.GET("/:short_url", shortenerController.RedirectShortURL)
+		shortenerRoutes.PATCH("/:short_url", middlewares.Authz(), shortenerController.UpdateShortURL)
 		shortenerRoutes.GET("/:short_url/stats", shortenerController.GetURLStatistics)
 	}
 }
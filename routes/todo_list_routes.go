@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/auth"
+	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/middlewares"
+)
+
+func setupTodoListRoutes(router *gin.RouterGroup) {
+	var todoListController controllers.TodoListController
+
+	// Create a new group for the shared to-do lists, also reachable with an API key
+	lists := router.Group("/todo-lists")
+	{
+		lists.POST("", middlewares.Authz(auth.ScopeTodosWrite), todoListController.CreateList)
+		lists.GET("", middlewares.Authz(auth.ScopeTodosRead), todoListController.ListLists)
+		lists.GET("/:id", middlewares.Authz(auth.ScopeTodosRead), todoListController.GetList)
+		// Add the acceptance of an invitation, opened from the link of the invitation email
+		lists.GET("/invitations/accept", middlewares.Authz(), todoListController.AcceptInvitation)
+		lists.POST("/invitations/accept", middlewares.Authz(), todoListController.AcceptInvitation)
+		lists.PUT("/:id", middlewares.Authz(auth.ScopeTodosWrite), todoListController.UpdateList)
+		lists.DELETE("/:id", middlewares.Authz(auth.ScopeTodosWrite), todoListController.DeleteList)
+		lists.GET("/:id/activity", middlewares.Authz(auth.ScopeTodosRead), todoListController.ListActivity)
+		lists.GET("/:id/members", middlewares.Authz(auth.ScopeTodosRead), todoListController.ListMembers)
+		lists.POST("/:id/members", middlewares.Authz(auth.ScopeTodosWrite), todoListController.InviteMember)
+		lists.PUT("/:id/members/:user_id", middlewares.Authz(auth.ScopeTodosWrite), todoListController.ChangeMemberRole)
+		lists.DELETE("/:id/members/:user_id", middlewares.Authz(auth.ScopeTodosWrite), todoListController.RemoveMember)
+	}
+}
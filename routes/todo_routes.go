@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/auth"
+	"github.com/zerodot618/go-huang/controllers"
+	"github.com/zerodot618/go-huang/middlewares"
+)
+
+func setupToDoRoutes(router *gin.RouterGroup) {
+	var todoController controllers.ToDoController
+
+	// Create a new group for the to-do items of the current user, also reachable with an API key
+	todos := router.Group("/todos")
+	{
+		todos.POST("", middlewares.Authz(auth.ScopeTodosWrite), todoController.CreateToDo)
+		todos.GET("", middlewares.Authz(auth.ScopeTodosRead), todoController.ListToDos)
+		todos.GET("/overdue", middlewares.Authz(auth.ScopeTodosRead), todoController.ListOverdueToDos)
+		todos.GET("/tags", middlewares.Authz(auth.ScopeTodosRead), todoController.ListTags)
+		todos.POST("/bulk", middlewares.Authz(auth.ScopeTodosWrite), todoController.BulkToDos)
+		todos.GET("/:id", middlewares.Authz(auth.ScopeTodosRead), todoController.GetToDo)
+		todos.PUT("/:id", middlewares.Authz(auth.ScopeTodosWrite), todoController.UpdateToDo)
+		todos.PUT("/:id/assignee", middlewares.Authz(auth.ScopeTodosWrite), todoController.AssignToDo)
+		todos.DELETE("/:id", middlewares.Authz(auth.ScopeTodosWrite), todoController.DeleteToDo)
+	}
+}
@@ -2,26 +2,89 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/zerodot618/go-huang/auth"
 	"github.com/zerodot618/go-huang/controllers"
 	"github.com/zerodot618/go-huang/middlewares"
+	"github.com/zerodot618/go-huang/ratelimit"
 )
 
-func setupUserRoutes(router *gin.RouterGroup) {
+// Rate limits of the public routes, per client IP and per account
+var (
+	loginIPLimit      = ratelimit.PerMinute(20)
+	loginAccountLimit = ratelimit.PerMinute(5)
+	signupIPLimit     = ratelimit.PerMinute(5)
+	mailIPLimit       = ratelimit.PerMinute(5)
+	mailAccountLimit  = ratelimit.PerMinute(2)
+	codeAccountLimit  = ratelimit.PerMinute(5)
+)
+
+func setupUserRoutes(router *gin.RouterGroup, limiter ratelimit.Store) {
 	var userController controllers.UserController
 
 	// Create a new group for the public routes
 	public := router.Group("/public")
 	{
 		// Add the login route
-		public.POST("/login", userController.Login)
+		public.POST("/login",
+			middlewares.RateLimit(limiter, "login_ip", loginIPLimit, middlewares.ByIP),
+			middlewares.RateLimit(limiter, "login_account", loginAccountLimit, middlewares.ByJSONField("email")),
+			userController.Login)
+		// Add the second step of a login with 2FA, rate limited like the password step
+		public.POST("/login/mfa",
+			middlewares.RateLimit(limiter, "login_ip", loginIPLimit, middlewares.ByIP),
+			middlewares.RateLimit(limiter, "login_mfa_token", loginAccountLimit, middlewares.ByJSONField("mfa_token")),
+			userController.LoginMFA)
+		// Add the token refresh, rate limited like the logins
+		public.POST("/token/refresh",
+			middlewares.RateLimit(limiter, "refresh_ip", loginIPLimit, middlewares.ByIP),
+			userController.Refresh)
 		// Add the signup route
-		public.POST("/signup", userController.Signup)
+		public.POST("/signup",
+			middlewares.RateLimit(limiter, "signup_ip", signupIPLimit, middlewares.ByIP),
+			userController.Signup)
+		// Add the email verification routes
+		public.GET("/email/verify", userController.VerifyEmail)
+		public.POST("/email/verify", userController.VerifyEmail)
+		public.POST("/email/resend",
+			middlewares.RateLimit(limiter, "mail_ip", mailIPLimit, middlewares.ByIP),
+			middlewares.RateLimit(limiter, "mail_account", mailAccountLimit, middlewares.ByJSONField("email")),
+			userController.ResendVerification)
+		// Add the password recovery routes
+		public.POST("/password/forgot",
+			middlewares.RateLimit(limiter, "mail_ip", mailIPLimit, middlewares.ByIP),
+			middlewares.RateLimit(limiter, "mail_account", mailAccountLimit, middlewares.ByJSONField("email")),
+			userController.ForgotPassword)
+		public.GET("/password/reset", userController.ResetPasswordForm)
+		public.POST("/password/reset",
+			middlewares.RateLimit(limiter, "reset_ip", loginIPLimit, middlewares.ByIP),
+			userController.ResetPassword)
+		// Add the confirmation of an email change, opened from the link sent to the new address
+		public.GET("/email/change/confirm", userController.ConfirmEmailChange)
+		public.POST("/email/change/confirm", userController.ConfirmEmailChange)
 	}
 
-	// Add the signup route
-	protected := router.Group("/protected").Use(middlewares.Authz())
+	// Add the profile route, also reachable with an API key
+	router.GET("/protected/profile", middlewares.Authz(auth.ScopeProfileRead), userController.Profile)
+
+	// Add the profile update route, also reachable by an admin impersonating the user
+	router.PATCH("/protected/profile", middlewares.Authz(), userController.UpdateProfile)
+
+	// Create a new group for the account routes, only reachable with a user token of the user themself
+	protected := router.Group("/protected").Use(middlewares.Authz(), middlewares.NoImpersonation())
 	{
-		// Add the profile route
-		protected.GET("/profile", userController.Profile)
+		// Add the password, email and account deletion routes, rate limited per account since they check the password
+		passwordLimit := middlewares.RateLimit(limiter, "password_account", codeAccountLimit, middlewares.ByUser)
+		protected.POST("/password", passwordLimit, userController.ChangePassword)
+		protected.POST("/email",
+			passwordLimit,
+			middlewares.RateLimit(limiter, "mail_account", mailAccountLimit, middlewares.ByUser),
+			userController.ChangeEmail)
+		protected.DELETE("/account", passwordLimit, userController.DeleteAccount)
+		// Add the 2FA routes, rate limited per account since they check codes
+		twoFactorLimit := middlewares.RateLimit(limiter, "2fa_account", codeAccountLimit, middlewares.ByUser)
+		protected.POST("/2fa/enroll", twoFactorLimit, userController.EnrollTOTP)
+		protected.POST("/2fa/confirm", twoFactorLimit, userController.ConfirmTOTP)
+		protected.POST("/2fa/disable", twoFactorLimit, userController.DisableTOTP)
+		protected.POST("/2fa/recovery-codes", twoFactorLimit, userController.RegenerateRecoveryCodes)
 	}
 }
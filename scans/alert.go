@@ -0,0 +1,87 @@
+package scans
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/webhook"
+)
+
+// SignatureHeader is the header of the webhook requests holding the HMAC-SHA256 of the body, hex encoded
+const SignatureHeader = "X-Scan-Signature"
+
+// Alert is the exposure change of the hosts of a profile between two of its jobs
+type Alert struct {
+	ProfileID     uint                `json:"profile_id"`
+	Profile       string              `json:"profile"`
+	JobID         uint                `json:"job_id"`
+	PreviousJobID uint                `json:"previous_job_id"`
+	FinishedAt    time.Time           `json:"finished_at"`
+	Changes       []models.ScanChange `json:"changes"`
+}
+
+// Alerter delivers the alerts
+type Alerter interface {
+	Alert(ctx context.Context, a Alert) error
+}
+
+// NewAlerterFromEnv returns the alerter chosen by SCAN_ALERTER: log (the default) or webhook
+// The webhook is configured by SCAN_ALERT_WEBHOOK_URL and SCAN_ALERT_WEBHOOK_SECRET
+func NewAlerterFromEnv() (Alerter, error) {
+	switch kind := os.Getenv("SCAN_ALERTER"); kind {
+	case "", "log":
+		return LogAlerter{}, nil
+	case "webhook":
+		url := os.Getenv("SCAN_ALERT_WEBHOOK_URL")
+		if url == "" {
+			return nil, errors.New("SCAN_ALERT_WEBHOOK_URL is required by the webhook alerter")
+		}
+		return &WebhookAlerter{URL: url, Secret: os.Getenv("SCAN_ALERT_WEBHOOK_SECRET")}, nil
+	default:
+		return nil, fmt.Errorf("invalid SCAN_ALERTER %q", kind)
+	}
+}
+
+// WebhookAlerter is an Alerter posting the alerts to a URL as JSON
+// The body is signed with the secret in SignatureHeader, and the Idempotency-Key header identifies the job
+type WebhookAlerter struct {
+	URL    string
+	Secret string
+	// Client sends the requests, a client with a 10 seconds timeout if nil
+	Client *http.Client
+}
+
+// Alert posts the alert, any response but a 2xx is an error
+func (w *WebhookAlerter) Alert(ctx context.Context, a Alert) error {
+	endpoint := webhook.Endpoint{URL: w.URL, Secret: w.Secret, SignatureHeader: SignatureHeader, Client: w.Client}
+	return endpoint.Post(ctx, "scan-"+strconv.FormatUint(uint64(a.JobID), 10), a)
+}
+
+// LogAlerter is an Alerter writing the alerts to the log, a line by change
+type LogAlerter struct{}
+
+// Alert logs the changes
+func (LogAlerter) Alert(ctx context.Context, a Alert) error {
+	logger := logging.FromContext(ctx)
+	for _, c := range a.Changes {
+		logger.Warn("scan exposure changed",
+			"profile", a.Profile,
+			"job_id", a.JobID,
+			"previous_job_id", a.PreviousJobID,
+			"host", c.Host,
+			"port", fmt.Sprintf("%d/%s", c.Port, c.Protocol),
+			"change", c.Change,
+			"field", c.Field,
+			"before", c.Before,
+			"after", c.After,
+		)
+	}
+	return nil
+}
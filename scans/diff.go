@@ -0,0 +1,144 @@
+package scans
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/metrics"
+	"github.com/zerodot618/go-huang/models"
+)
+
+// alertTimeout bounds the delivery of an alert
+const alertTimeout = 30 * time.Second
+
+// volatileBanner matches the parts of the banners that change on every connection, see normalizeBanner
+var volatileBanner = []*regexp.Regexp{
+	// Week days and dates, like "Mon, 19 Oct 2026", "Oct 19 2026" or "2026-10-19T"
+	regexp.MustCompile(`(?i)\b(?:mon|tue|wed|thu|fri|sat|sun)[a-z]*\b,?`),
+	regexp.MustCompile(`(?i)\b(?:\d{1,2}\s+)?(?:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.?\s+(?:\d{1,2},?\s+)?\d{2,4}\b`),
+	regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}T?`),
+	// Times with their zone, like "10:00:00 +0000" or "10:00:00.123Z"
+	regexp.MustCompile(`\b\d{1,2}:\d{2}(?::\d{2})?(?:\.\d+)?(?:\s*(?:[+-]\d{4}|Z|[A-Z]{3})\b)?`),
+	// Session IDs and challenges, like the hex tokens of FTP servers or the APOP challenge of POP3 greetings
+	regexp.MustCompile(`\b[0-9a-fA-F]{16,}\b`),
+	regexp.MustCompile(`<[^<>\s]+@[^<>\s]+>`),
+}
+
+// normalizeBanner replaces the dates, times and session tokens of a banner, so that greetings like
+// "220 mail.example.com ESMTP Postfix Mon, 19 Oct 2026 10:00:00 +0000" are the same on every run
+func normalizeBanner(banner string) string {
+	for _, pattern := range volatileBanner {
+		banner = pattern.ReplaceAllString(banner, "*")
+	}
+	return banner
+}
+
+// Diff returns the changes between the open ports found by two jobs: the ports opened or closed since the previous job,
+// and the service, version and banner of the ports still open that changed. The changes are sorted by host and port.
+// Banners are compared without their dates, times and session tokens, see normalizeBanner
+func Diff(previous, current []models.ScanJobResult) []models.ScanChange {
+	key := func(r models.ScanJobResult) string {
+		return fmt.Sprintf("%s %d/%s", r.Host, r.Port, r.Protocol)
+	}
+	before := make(map[string]models.ScanJobResult, len(previous))
+	for _, r := range previous {
+		before[key(r)] = r
+	}
+	var changes []models.ScanChange
+	add := func(r models.ScanJobResult, change, field, from, to string) {
+		changes = append(changes, models.ScanChange{
+			Host: r.Host, Port: r.Port, Protocol: r.Protocol, Change: change, Field: field, Before: from, After: to,
+		})
+	}
+	for _, r := range current {
+		old, ok := before[key(r)]
+		if !ok {
+			add(r, models.ScanChangeOpened, "", "", r.State)
+			continue
+		}
+		delete(before, key(r))
+		for _, f := range []struct {
+			name, from, to string
+			same           bool
+		}{
+			{"state", old.State, r.State, old.State == r.State},
+			{"service", old.Service, r.Service, old.Service == r.Service},
+			{"version", old.Version, r.Version, old.Version == r.Version},
+			{"banner", old.Banner, r.Banner, normalizeBanner(old.Banner) == normalizeBanner(r.Banner)},
+		} {
+			if !f.same {
+				add(r, models.ScanChangeChanged, f.name, f.from, f.to)
+			}
+		}
+	}
+	for _, r := range before {
+		add(r, models.ScanChangeClosed, "", r.State, "")
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Protocol < b.Protocol
+	})
+	return changes
+}
+
+// compare records the changes between a done job of a profile and the previous done job of the profile,
+// and alerts about them. The first job of a profile has nothing to be compared with
+func (m *Manager) compare(job *models.ScanJob) error {
+	previous, err := models.PreviousScanJob(*job.ProfileID, job.ID)
+	if err != nil || previous == nil {
+		return err
+	}
+	before, err := models.ListScanJobResults(previous.ID)
+	if err != nil {
+		return err
+	}
+	after, err := models.ListScanJobResults(job.ID)
+	if err != nil {
+		return err
+	}
+	changes := Diff(before, after)
+	for i := range changes {
+		changes[i].JobID, changes[i].PreviousJobID, changes[i].ProfileID = job.ID, previous.ID, *job.ProfileID
+	}
+	if err := models.RecordScanChanges(job.ID, changes); err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	profile, err := models.GetScanProfile(*job.ProfileID)
+	if err != nil {
+		return err
+	}
+	alert := Alert{
+		ProfileID:     profile.ID,
+		Profile:       profile.Name,
+		JobID:         job.ID,
+		PreviousJobID: previous.ID,
+		FinishedAt:    *job.FinishedAt,
+		Changes:       changes,
+	}
+	alerter := m.Alerter
+	if alerter == nil {
+		alerter = LogAlerter{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	if err := alerter.Alert(ctx, alert); err != nil {
+		metrics.ScanAlerts.WithLabelValues("failed").Inc()
+		logging.Default.Error("could not send scan alert", "job_id", job.ID, "profile", profile.Name, "changes", len(changes), "error", err)
+		return nil
+	}
+	metrics.ScanAlerts.WithLabelValues("sent").Inc()
+	return nil
+}
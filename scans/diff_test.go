@@ -0,0 +1,65 @@
+package scans
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zerodot618/go-huang/models"
+)
+
+func TestNormalizeBanner(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		same   bool
+	}{
+		{"smtp greeting time", "220 mx.example.com ESMTP Postfix Mon, 19 Oct 2026 10:00:00 +0000", "220 mx.example.com ESMTP Postfix Tue, 20 Oct 2026 11:30:05 +0000", true},
+		{"exchange greeting", "220 mail.example.com Microsoft ESMTP MAIL Service ready at Mon, 19 Oct 2026 10:00:00 +0200", "220 mail.example.com Microsoft ESMTP MAIL Service ready at Wed, 21 Oct 2026 09:12:44 +0200", true},
+		{"ctime date", "220 ftp.example.com FTP server ready Oct 19 10:00:00 2026", "220 ftp.example.com FTP server ready Oct 20 08:01:02 2026", true},
+		{"iso timestamp", "+OK ready 2026-10-19T10:00:00.123Z", "+OK ready 2026-10-20T23:59:59.999Z", true},
+		{"apop challenge", "+OK POP3 server ready <1896.697170952@mx.example.com>", "+OK POP3 server ready <2041.697171888@mx.example.com>", true},
+		{"session token", "220 FTP ready, session 9f86d081884c7d659a2feaa0c55ad015", "220 FTP ready, session 2c26b46b68ffc68ff99b453c1d304134", true},
+		{"ssh version", "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1", "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu0.4", false},
+		{"smtp software", "220 mx.example.com ESMTP Postfix Mon, 19 Oct 2026 10:00:00 +0000", "220 mx.example.com ESMTP Exim 4.96 Mon, 19 Oct 2026 10:00:00 +0000", false},
+		{"server name", "HTTP/1.1 200 OK Server: nginx/1.24.0", "HTTP/1.1 200 OK Server: Apache/2.4.58", false},
+		{"esmtp kept", "220 mx.example.com ESMTP", "220 mx.example.com SMTP", false},
+	}
+	for _, tt := range tests {
+		if same := normalizeBanner(tt.before) == normalizeBanner(tt.after); same != tt.same {
+			t.Errorf("%s: %q and %q the same = %v, want %v (%q, %q)", tt.name, tt.before, tt.after, same, tt.same,
+				normalizeBanner(tt.before), normalizeBanner(tt.after))
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	result := func(host string, port int, version, banner string) models.ScanJobResult {
+		return models.ScanJobResult{Host: host, Port: port, Protocol: "tcp", State: "open", Service: "smtp", Version: version, Banner: banner}
+	}
+	previous := []models.ScanJobResult{
+		result("10.0.0.1", 25, "Postfix", "220 mx ESMTP Postfix Mon, 19 Oct 2026 10:00:00 +0000"),
+		result("10.0.0.1", 80, "", ""),
+		result("10.0.0.2", 22, "OpenSSH_8.9", "SSH-2.0-OpenSSH_8.9"),
+	}
+	current := []models.ScanJobResult{
+		result("10.0.0.2", 22, "OpenSSH_9.6", "SSH-2.0-OpenSSH_9.6"),
+		result("10.0.0.1", 25, "Postfix", "220 mx ESMTP Postfix Tue, 20 Oct 2026 10:05:00 +0000"),
+		result("10.0.0.1", 443, "", ""),
+	}
+	change := func(host string, port int, kind, field, before, after string) models.ScanChange {
+		return models.ScanChange{Host: host, Port: port, Protocol: "tcp", Change: kind, Field: field, Before: before, After: after}
+	}
+	want := []models.ScanChange{
+		change("10.0.0.1", 80, models.ScanChangeClosed, "", "open", ""),
+		change("10.0.0.1", 443, models.ScanChangeOpened, "", "", "open"),
+		change("10.0.0.2", 22, models.ScanChangeChanged, "version", "OpenSSH_8.9", "OpenSSH_9.6"),
+		change("10.0.0.2", 22, models.ScanChangeChanged, "banner", "SSH-2.0-OpenSSH_8.9", "SSH-2.0-OpenSSH_9.6"),
+	}
+	if got := Diff(previous, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+	if got := Diff(current, current); len(got) != 0 {
+		t.Errorf("Diff() of a job with itself = %+v, want no change", got)
+	}
+}
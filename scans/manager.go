@@ -0,0 +1,468 @@
+// Package scans runs the port scans requested by the admins in the background and streams their results.
+// A Manager validates the targets of a scan against an allow-list, so the service cannot be used to scan
+// arbitrary networks, runs a bounded number of jobs at once and records their open ports.
+// The subscribers of a job running on the instance receive its results as the ports are probed.
+// A Scheduler runs the named profiles on their cron schedule, and the open ports of every job of a profile
+// are compared with the previous one: the changes are recorded and sent to an Alerter.
+package scans
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/metrics"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/port"
+)
+
+// Default settings of the Manager
+const (
+	DefaultMaxJobs   = 2
+	DefaultMaxProbes = 1 << 16
+	// MaxTimeout bounds the timeout of the dials of a job
+	MaxTimeout = 30 * time.Second
+	// touchInterval is the interval between the progress updates of a job in the database,
+	// a job left untouched for staleAfter belonged to a stopped instance
+	touchInterval = 5 * time.Second
+	staleAfter    = 12 * touchInterval
+	// progressInterval is the interval between the progress events sent to the subscribers
+	progressInterval = time.Second
+	// subscriberBuffer is the number of events buffered by subscriber, a slower subscriber is dropped
+	subscriberBuffer = 64
+)
+
+// Types of the events sent to the subscribers
+const (
+	EventResult   = "result"
+	EventProgress = "progress"
+	EventDone     = "done"
+)
+
+// Errors of Prepare
+var (
+	ErrDisabled   = errors.New("scans are disabled, no target is allowed")
+	ErrNotAllowed = errors.New("target not allowed")
+	ErrInvalid    = errors.New("invalid scan")
+)
+
+// Causes of the cancellation of the jobs
+var (
+	errCancelled = errors.New("cancelled")
+	errShutdown  = errors.New("server shutting down")
+)
+
+// Default is the manager of the scan jobs of the instance
+var Default = NewManager(nil)
+
+// Request is a scan asked for by an admin
+type Request struct {
+	// Targets are host names, IP addresses and CIDR ranges
+	Targets []string
+	// Ports are port ranges like 22,80,8000-8100
+	Ports string
+	// Protocols are tcp and udp, tcp if empty
+	Protocols []string
+	Detect    bool
+	// Timeout is the timeout of every dial, the one of the Manager if zero
+	Timeout time.Duration
+}
+
+// Plan is a validated Request
+type Plan struct {
+	Request
+	Hosts  []string
+	Ports  []int
+	Probes int
+	// ProfileID is the profile the job is run for, if any
+	ProfileID *uint
+}
+
+// Event is sent to the subscribers of a job
+// Data is a models.ScanJobResult, a Progress or the finished models.ScanJob
+type Event struct {
+	Type string
+	Data interface{}
+}
+
+// Progress is the number of probed ports of a job
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Manager runs the scan jobs of the instance
+type Manager struct {
+	// Allowed are the targets the jobs may probe, nothing if empty
+	Allowed *port.AllowList
+	// MaxJobs is the number of jobs run at once, the others are queued
+	MaxJobs int
+	// MaxProbes is the number of ports a job may probe, hosts times protocols times ports
+	MaxProbes int
+	// Timeout and Workers are the settings of the scanner of every job, the defaults of port.Scanner if zero
+	Timeout time.Duration
+	Workers int
+	// Alerter is told about the changes found by the jobs of the profiles, they are only logged if nil
+	Alerter Alerter
+
+	once   sync.Once
+	slots  chan struct{}
+	mu     sync.Mutex
+	jobs   map[uint]*run
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// run is a job of the instance
+type run struct {
+	cancel context.CancelCauseFunc
+	// results are the results recorded so far, replayed to the new subscribers
+	results     []models.ScanJobResult
+	progress    Progress
+	subscribers map[chan Event]struct{}
+}
+
+// NewManagerFromEnv returns a Manager configured by the environment:
+// SCAN_ALLOWED_TARGETS is the comma-separated allow-list of CIDR ranges, IP addresses and host names, empty disables scans.
+// SCAN_MAX_JOBS and SCAN_MAX_PROBES are the limits of the jobs, SCAN_TIMEOUT the timeout of every dial (time.ParseDuration format)
+func NewManagerFromEnv() (*Manager, error) {
+	allowed, err := port.ParseAllowList(os.Getenv("SCAN_ALLOWED_TARGETS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCAN_ALLOWED_TARGETS: %w", err)
+	}
+	m := NewManager(allowed)
+	for name, value := range map[string]*int{"SCAN_MAX_JOBS": &m.MaxJobs, "SCAN_MAX_PROBES": &m.MaxProbes} {
+		if s := os.Getenv(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*value = n
+		}
+	}
+	if s := os.Getenv("SCAN_TIMEOUT"); s != "" {
+		timeout, err := time.ParseDuration(s)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid SCAN_TIMEOUT %q", s)
+		}
+		m.Timeout = timeout
+	}
+	return m, nil
+}
+
+// NewManager returns a Manager with the default settings, allowing the targets of an allow-list
+func NewManager(allowed *port.AllowList) *Manager {
+	return &Manager{Allowed: allowed, MaxJobs: DefaultMaxJobs, MaxProbes: DefaultMaxProbes}
+}
+
+// Prepare validates a request, it returns an error wrapping ErrDisabled, ErrNotAllowed or ErrInvalid
+func (m *Manager) Prepare(req Request) (*Plan, error) {
+	if m.Allowed.Empty() {
+		return nil, ErrDisabled
+	}
+	plan := &Plan{Request: req}
+	if len(plan.Protocols) == 0 {
+		plan.Protocols = []string{"tcp"}
+	}
+	if plan.Timeout < 0 || plan.Timeout > MaxTimeout {
+		return nil, fmt.Errorf("%w: the timeout must be at most %s", ErrInvalid, MaxTimeout)
+	}
+	seen := map[string]bool{}
+	for _, protocol := range plan.Protocols {
+		if protocol != "tcp" && protocol != "udp" || seen[protocol] {
+			return nil, fmt.Errorf("%w: protocols must be tcp or udp, once", ErrInvalid)
+		}
+		seen[protocol] = true
+	}
+	hosts, err := port.ExpandTargets(req.Targets)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := m.Allowed.Check(hosts); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotAllowed, err)
+	}
+	ports, err := port.ParsePorts(req.Ports)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	plan.Hosts, plan.Ports = hosts, ports
+	plan.Probes = len(hosts) * len(plan.Protocols) * len(ports)
+	if plan.Probes > m.MaxProbes {
+		return nil, fmt.Errorf("%w: %d probes, more than %d", ErrInvalid, plan.Probes, m.MaxProbes)
+	}
+	return plan, nil
+}
+
+// Start records a job of a prepared plan for a user and runs it in the background, once a slot is free
+func (m *Manager) Start(userID uint, plan *Plan) (*models.ScanJob, error) {
+	m.once.Do(func() {
+		m.slots = make(chan struct{}, max(m.MaxJobs, 1))
+		m.jobs = map[uint]*run{}
+	})
+	job := &models.ScanJob{
+		CreatedByID: userID,
+		ProfileID:   plan.ProfileID,
+		Targets:     strings.Join(plan.Targets, ","),
+		Ports:       plan.Request.Ports,
+		Protocols:   strings.Join(plan.Protocols, ","),
+		Detect:      plan.Detect,
+		TimeoutMS:   int(plan.Timeout.Milliseconds()),
+		Hosts:       len(plan.Hosts),
+		Probes:      plan.Probes,
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errShutdown
+	}
+	if err := models.CreateScanJob(job); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	r := &run{cancel: cancel, progress: Progress{Total: plan.Probes}, subscribers: map[chan Event]struct{}{}}
+	m.jobs[job.ID] = r
+	m.wg.Add(1)
+	go m.execute(ctx, job, plan, r)
+	return job, nil
+}
+
+// Cancel cancels a job of the instance, it returns false if the job does not run on the instance
+func (m *Manager) Cancel(id uint) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.jobs[id]
+	if ok {
+		r.cancel(errCancelled)
+	}
+	return ok
+}
+
+// Subscribe returns the results recorded so far and the progress of a job of the instance,
+// and a channel receiving its next events. The channel is closed after the done event,
+// or earlier if the subscriber does not keep up. Unsubscribe must be called once the events are not read anymore.
+// It returns false if the job does not run on the instance
+func (m *Manager) Subscribe(id uint) (results []models.ScanJobResult, progress Progress, events <-chan Event, unsubscribe func(), ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.jobs[id]
+	if !ok {
+		return nil, Progress{}, nil, nil, false
+	}
+	ch := make(chan Event, subscriberBuffer)
+	r.subscribers[ch] = struct{}{}
+	unsubscribe = func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := r.subscribers[ch]; ok {
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+	return append([]models.ScanJobResult(nil), r.results...), r.progress, ch, unsubscribe, true
+}
+
+// Shutdown cancels the jobs of the instance and waits for them to be recorded as cancelled
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	for _, r := range m.jobs {
+		r.cancel(errShutdown)
+	}
+	m.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FailStale fails the jobs of the stopped instances, which will never finish
+func FailStale() error {
+	n, err := models.FailStaleScanJobs(time.Now().Add(-staleAfter))
+	if n > 0 {
+		logging.Default.Warn("failed interrupted scan jobs", "count", n)
+	}
+	return err
+}
+
+// execute runs a job once a slot is free and records its results and its end
+func (m *Manager) execute(ctx context.Context, job *models.ScanJob, plan *Plan, r *run) {
+	defer m.wg.Done()
+	touch := time.NewTicker(touchInterval)
+	defer touch.Stop()
+	for queued := true; queued; {
+		select {
+		case m.slots <- struct{}{}:
+			queued = false
+		case <-touch.C:
+			m.touch(job.ID, 0)
+		case <-ctx.Done():
+			m.finish(job.ID, r, models.ScanCancelled, context.Cause(ctx).Error())
+			return
+		}
+	}
+	defer func() { <-m.slots }()
+
+	if err := models.StartScanJob(job.ID, len(plan.Hosts), plan.Probes, time.Now()); err != nil {
+		m.finish(job.ID, r, models.ScanFailed, err.Error())
+		return
+	}
+	logging.Default.Info("scan job started", "job_id", job.ID, "hosts", len(plan.Hosts), "probes", plan.Probes)
+	timeout := plan.Timeout
+	if timeout == 0 {
+		timeout = m.Timeout
+	}
+	scanner := port.New(
+		port.WithProtocols(plan.Protocols...),
+		port.WithTimeout(timeout),
+		port.WithWorkers(m.Workers),
+		port.WithDetection(plan.Detect),
+	)
+	progress := time.NewTicker(progressInterval)
+	defer progress.Stop()
+	var failure error
+	results := scanner.Stream(ctx, plan.Hosts, plan.Ports)
+	for streaming := true; streaming; {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				streaming = false
+				break
+			}
+			if err := m.record(job.ID, r, result); err != nil && failure == nil {
+				// The scan is stopped, the results left are drained
+				failure = err
+				r.cancel(err)
+			}
+		case <-progress.C:
+			m.mu.Lock()
+			m.broadcast(r, Event{Type: EventProgress, Data: r.progress})
+			m.mu.Unlock()
+		case <-touch.C:
+			m.touch(job.ID, m.done(r))
+		}
+	}
+	switch {
+	case failure != nil:
+		m.finish(job.ID, r, models.ScanFailed, failure.Error())
+	case ctx.Err() != nil:
+		m.finish(job.ID, r, models.ScanCancelled, context.Cause(ctx).Error())
+	default:
+		m.finish(job.ID, r, models.ScanDone, "")
+	}
+}
+
+// record counts a result of a job, and records and sends it if the port is open or may be open
+func (m *Manager) record(jobID uint, r *run, result port.ScanResult) error {
+	open := result.State == port.StateOpen || result.State == port.StateOpenFiltered
+	var row models.ScanJobResult
+	if open {
+		row = models.ScanJobResult{
+			JobID:      jobID,
+			Host:       result.Host,
+			Port:       result.PortNumber(),
+			Protocol:   result.Protocol(),
+			State:      result.State,
+			Reason:     result.Reason,
+			Service:    truncate(result.Service, 64),
+			Version:    truncate(result.Version, 255),
+			Banner:     truncate(result.Banner, 255),
+			StartedAt:  result.StartedAt,
+			DurationMS: float64(result.Duration.Microseconds()) / 1000,
+		}
+		if err := models.AddScanJobResult(&row); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.progress.Done++
+	if open {
+		r.results = append(r.results, row)
+		m.broadcast(r, Event{Type: EventResult, Data: row})
+	}
+	return nil
+}
+
+// finish records the end of a job, sends the done event and closes the channels of the subscribers
+func (m *Manager) finish(id uint, r *run, status, cause string) {
+	done := m.done(r)
+	m.mu.Lock()
+	open := len(r.results)
+	m.mu.Unlock()
+	if err := models.FinishScanJob(id, status, done, open, cause, time.Now()); err != nil {
+		logging.Default.Error("could not record the end of a scan job", "job_id", id, "status", status, "error", err)
+	}
+	metrics.ScanJobs.WithLabelValues(status).Inc()
+	logging.Default.Info("scan job finished", "job_id", id, "status", status, "done", done, "open", open)
+	job, err := models.GetScanJob(id)
+	if err != nil {
+		logging.Default.Error("could not get a finished scan job", "job_id", id, "error", err)
+	}
+	if job != nil && job.Status == models.ScanDone && job.ProfileID != nil {
+		if err := m.compare(job); err != nil {
+			logging.Default.Error("could not compare a scan job with the previous one", "job_id", id, "error", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job != nil {
+		m.broadcast(r, Event{Type: EventDone, Data: job})
+	}
+	for ch := range r.subscribers {
+		delete(r.subscribers, ch)
+		close(ch)
+	}
+	delete(m.jobs, id)
+	r.cancel(nil)
+}
+
+// broadcast sends an event to the subscribers of a job, the subscribers whose buffer is full are dropped
+// It must be called with the lock held
+func (m *Manager) broadcast(r *run, event Event) {
+	for ch := range r.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// done returns the number of probed ports of a job
+func (m *Manager) done(r *run) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return r.progress.Done
+}
+
+// touch records the progress of a job, a failure is logged
+func (m *Manager) touch(id uint, done int) {
+	if err := models.TouchScanJob(id, done); err != nil {
+		logging.Default.Warn("could not record the progress of a scan job", "job_id", id, "error", err)
+	}
+}
+
+// truncate cuts a string to a number of characters, the size of a VARCHAR column, dropping invalid UTF-8
+func truncate(s string, n int) string {
+	s = strings.ToValidUTF8(s, "")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
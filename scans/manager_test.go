@@ -0,0 +1,31 @@
+package scans
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"short", "ssh", 64, "ssh"},
+		{"ascii", "abcdef", 3, "abc"},
+		{"multi-byte runes", "héllo wörld", 7, "héllo w"},
+		{"rune at the limit", strings.Repeat("日", 300), 255, strings.Repeat("日", 255)},
+		{"invalid UTF-8", "Apache\xff/2.4", 255, "Apache/2.4"},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.n)
+		if got != tt.want {
+			t.Errorf("%s: truncate() = %q, want %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: truncate() = %q, not valid UTF-8", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package scans
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zerodot618/go-huang/cron"
+	"github.com/zerodot618/go-huang/logging"
+	"github.com/zerodot618/go-huang/models"
+	"github.com/zerodot618/go-huang/poll"
+)
+
+// ErrProfileBusy is returned when a profile is run while its previous job is not over
+var ErrProfileBusy = errors.New("the previous scan of the profile is not over")
+
+// ProfileSpec is a profile of the file of SCAN_PROFILES_FILE, a JSON array of them:
+//
+//	[{"name": "dmz", "targets": ["10.0.1.0/24"], "ports": "1-1024", "timeout": "1s", "schedule": "0 * * * *"}]
+type ProfileSpec struct {
+	Name      string   `json:"name"`
+	Targets   []string `json:"targets"`
+	Ports     string   `json:"ports"`
+	Protocols []string `json:"protocols"`
+	Detect    bool     `json:"detect"`
+	// Timeout is in the time.ParseDuration format
+	Timeout  string `json:"timeout"`
+	Schedule string `json:"schedule"`
+	// Enabled is true if missing
+	Enabled *bool `json:"enabled"`
+}
+
+// NextRun returns the next time of a cron schedule after a time, in UTC, or nil if the schedule is empty
+func NextRun(schedule string, after time.Time) (*time.Time, error) {
+	if schedule == "" {
+		return nil, nil
+	}
+	s, err := cron.Parse(schedule)
+	if err != nil {
+		return nil, err
+	}
+	next, ok := s.Next(after.UTC())
+	if !ok {
+		return nil, fmt.Errorf("%w: %q never runs", cron.ErrInvalidSchedule, schedule)
+	}
+	return &next, nil
+}
+
+// ProfileRequest returns the request of the jobs of a profile
+func ProfileRequest(profile *models.ScanProfile) Request {
+	req := Request{
+		Targets: strings.Split(profile.Targets, ","),
+		Ports:   profile.Ports,
+		Detect:  profile.Detect,
+		Timeout: time.Duration(profile.TimeoutMS) * time.Millisecond,
+	}
+	if profile.Protocols != "" {
+		req.Protocols = strings.Split(profile.Protocols, ",")
+	}
+	return req
+}
+
+// RunProfile starts a job of a profile for a user, 0 for the scheduler, and records the run on the profile
+// It returns ErrProfileBusy if the previous job of the profile is not over, the errors of Prepare and Start otherwise
+func (m *Manager) RunProfile(userID uint, profile *models.ScanProfile) (*models.ScanJob, error) {
+	job, err := m.runProfile(userID, profile)
+	var jobID *uint
+	cause := ""
+	if job != nil {
+		jobID = &job.ID
+	}
+	if err != nil {
+		cause = err.Error()
+	}
+	if err := models.RecordScanProfileRun(profile.ID, jobID, cause, time.Now()); err != nil {
+		logging.Default.Error("could not record the run of a scan profile", "profile", profile.Name, "error", err)
+	}
+	return job, err
+}
+
+// runProfile starts a job of a profile unless its previous one is not over
+func (m *Manager) runProfile(userID uint, profile *models.ScanProfile) (*models.ScanJob, error) {
+	active, err := models.ScanProfileActive(profile.ID)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		return nil, ErrProfileBusy
+	}
+	plan, err := m.Prepare(ProfileRequest(profile))
+	if err != nil {
+		return nil, err
+	}
+	plan.ProfileID = &profile.ID
+	return m.Start(userID, plan)
+}
+
+// LoadProfiles reads and validates the profiles of a file, see ProfileSpec
+// The targets are not checked against the allow-list, a profile whose targets are not allowed fails when run
+func LoadProfiles(path string) ([]models.ScanProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []ProfileSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	profiles := make([]models.ScanProfile, 0, len(specs))
+	seen := map[string]bool{}
+	for i, spec := range specs {
+		profile, err := spec.profile()
+		if err == nil && seen[profile.Name] {
+			err = errors.New("duplicate name")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: profile %d %q: %w", path, i, spec.Name, err)
+		}
+		seen[profile.Name] = true
+		profiles = append(profiles, *profile)
+	}
+	return profiles, nil
+}
+
+// profile validates a spec and returns its profile, with its next run
+func (spec ProfileSpec) profile() (*models.ScanProfile, error) {
+	if spec.Name == "" || len(spec.Name) > 64 {
+		return nil, errors.New("the name is required, up to 64 characters")
+	}
+	if len(spec.Targets) == 0 || spec.Ports == "" {
+		return nil, errors.New("the targets and the ports are required")
+	}
+	profile := &models.ScanProfile{
+		Name:      spec.Name,
+		Targets:   strings.Join(spec.Targets, ","),
+		Ports:     spec.Ports,
+		Protocols: strings.Join(spec.Protocols, ","),
+		Detect:    spec.Detect,
+		Schedule:  strings.TrimSpace(spec.Schedule),
+		Enabled:   spec.Enabled == nil || *spec.Enabled,
+	}
+	if spec.Timeout != "" {
+		timeout, err := time.ParseDuration(spec.Timeout)
+		if err != nil || timeout <= 0 || timeout > MaxTimeout {
+			return nil, fmt.Errorf("invalid timeout %q, at most %s", spec.Timeout, MaxTimeout)
+		}
+		profile.TimeoutMS = int(timeout.Milliseconds())
+	}
+	next, err := NextRun(profile.Schedule, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if profile.Enabled {
+		profile.NextRunAt = next
+	}
+	return profile, nil
+}
+
+// SyncProfilesFromEnv loads the profiles of the file of SCAN_PROFILES_FILE, if set, to the database
+func SyncProfilesFromEnv() error {
+	path := os.Getenv("SCAN_PROFILES_FILE")
+	if path == "" {
+		return nil
+	}
+	profiles, err := LoadProfiles(path)
+	if err != nil {
+		return err
+	}
+	return models.SyncFileScanProfiles(profiles)
+}
+
+// Scheduler starts the jobs of the profiles whose schedule is due every interval
+// Every instance can run a Scheduler, a run is claimed by one of them by moving the next run of its profile
+type Scheduler struct {
+	Manager   *Manager
+	Interval  time.Duration
+	BatchSize int
+}
+
+// NewSchedulerFromEnv returns a Scheduler of a manager polling every SCAN_POLL_INTERVAL
+// (time.ParseDuration format, 30s by default)
+func NewSchedulerFromEnv(m *Manager) (*Scheduler, error) {
+	interval, err := poll.IntervalFromEnv("SCAN_POLL_INTERVAL")
+	if err != nil {
+		return nil, err
+	}
+	return &Scheduler{Manager: m, Interval: interval, BatchSize: poll.DefaultBatchSize}, nil
+}
+
+// Run starts the due jobs every interval, until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	poll.Run(ctx, s.Interval, func(context.Context) bool {
+		if err := s.Tick(time.Now()); err != nil {
+			logging.Default.Error("could not run scheduled scans", "error", err)
+		}
+		return false
+	})
+}
+
+// Tick starts the jobs of the profiles due at a time
+// A run whose job cannot be started, because the previous one is not over or the targets are not allowed,
+// is skipped: the error is recorded on the profile, which runs again at its next time
+func (s *Scheduler) Tick(now time.Time) error {
+	profiles, err := models.DueScanProfiles(now, s.BatchSize)
+	if err != nil {
+		return err
+	}
+	for i := range profiles {
+		profile := &profiles[i]
+		next, err := NextRun(profile.Schedule, now)
+		if err != nil {
+			// The schedule was valid when saved, the profile is not run again until fixed
+			logging.Default.Error("invalid scan profile schedule", "profile", profile.Name, "schedule", profile.Schedule, "error", err)
+		}
+		claimed, err := models.ClaimScanProfile(profile, next)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		job, err := s.Manager.RunProfile(0, profile)
+		if err != nil {
+			logging.Default.Warn("scheduled scan skipped", "profile", profile.Name, "error", err)
+			continue
+		}
+		logging.Default.Info("scheduled scan started", "profile", profile.Name, "job_id", job.ID)
+	}
+	return nil
+}
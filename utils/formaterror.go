@@ -1,38 +1,369 @@
 package utils
 
-import "strings"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 
-// errorMessages is a map of strings to strings used to store error message
-var errorMessages = make(map[string]string)
+	"github.com/go-sql-driver/mysql"
+	"github.com/zerodot618/go-huang/apperror"
+	"gorm.io/gorm"
+)
 
-// err is a variable used to store the errors
-var err error
+// ErrorKind is the category of a database error
+type ErrorKind string
 
-// FormatError takes a string as an argument and returns a map of strings to strings
-// containing error messages.
-//
-// It checks if the string contains certain keywords and adds the corresponding
-// error message to the map. If the map is empty, it adds an "Incorrect Details"
-// error message.
-func FormatError(errString string) map[string]string {
-	if strings.Contains(errString, "name") {
-		errorMessages["Taken_name"] = "Name Already Taken"
+// The categories ClassifyError sorts database errors into
+const (
+	KindUnknown      ErrorKind = "unknown"
+	KindDuplicateKey ErrorKind = "duplicate_key"
+	KindForeignKey   ErrorKind = "foreign_key_violation"
+	KindNotNull      ErrorKind = "not_null_violation"
+	KindNotFound     ErrorKind = "not_found"
+	KindDeadlock     ErrorKind = "deadlock"
+	KindTimeout      ErrorKind = "timeout"
+)
+
+// DBError is the classification of an error returned by GORM or a database driver.
+// A new DBError is built on every call, so it is safe to use from concurrent requests.
+type DBError struct {
+	Kind       ErrorKind         // Category of the error
+	Driver     string            // Driver that returned the error: mysql, postgres, sqlite or gorm
+	Code       string            // Driver specific error code, e.g. 1062 or 23505
+	Constraint string            // Name of the violated constraint or key, if known
+	Field      string            // Column guessed from the constraint name, if known
+	Messages   map[string]string // Human readable messages keyed like "Taken_email"
+	Err        error             // Original error
+}
+
+// Error implements the error interface
+func (e *DBError) Error() string {
+	return fmt.Sprintf("%s (%s %s): %v", e.Kind, e.Driver, e.Code, e.Err)
+}
+
+// Unwrap returns the original error
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// AppError converts the classification into the API error returned to clients
+func (e *DBError) AppError(message string) *apperror.Error {
+	switch e.Kind {
+	case KindDuplicateKey:
+		appErr := apperror.Wrap(e, apperror.CodeConflict, message)
+		if e.Field != "" {
+			appErr.WithField(e.Field, string(e.Kind), e.Messages["Taken_"+e.Field])
+		}
+		return appErr
+	case KindForeignKey:
+		return apperror.Wrap(e, apperror.CodeConflict, message)
+	case KindNotNull:
+		appErr := apperror.Wrap(e, apperror.CodeValidation, message)
+		if e.Field != "" {
+			appErr.WithField(e.Field, "required", e.Messages["Required_"+e.Field])
+		}
+		return appErr
+	case KindNotFound:
+		return apperror.Wrap(e, apperror.CodeNotFound, message)
+	case KindDeadlock, KindTimeout:
+		return apperror.Wrap(e, apperror.CodeUnavailable, message)
+	}
+	return apperror.Internal(e, message)
+}
+
+// MySQL error numbers, see https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	mysqlDuplicateEntry     = 1062
+	mysqlRowIsReferenced    = 1451
+	mysqlNoReferencedRow    = 1452
+	mysqlRowIsReferenced2   = 1216
+	mysqlNoReferencedRow2   = 1217
+	mysqlBadNull            = 1048
+	mysqlLockWaitTimeout    = 1205
+	mysqlLockDeadlock       = 1213
+	mysqlQueryTimeout       = 3024
+	mysqlQueryInterrupted   = 1317
+	mysqlFieldDoesntDefault = 1364
+)
+
+// PostgreSQL SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgNotNullViolation     = "23502"
+	pgDeadlockDetected     = "40P01"
+	pgSerializationFailure = "40001"
+	pgQueryCanceled        = "57014"
+	pgLockNotAvailable     = "55P03"
+)
+
+// SQLite result codes, see https://www.sqlite.org/rescode.html
+const (
+	sqliteBusy                  = 5
+	sqliteLocked                = 6
+	sqliteConstraintForeignKey  = 787
+	sqliteConstraintNotNull     = 1299
+	sqliteConstraintPrimaryKey  = 1555
+	sqliteConstraintUnique      = 2067
+	sqliteConstraintRowID       = 2579
+	sqlitePrimaryResultCodeMask = 0xff
+)
+
+// sqlStateError is implemented by the PostgreSQL drivers (pgconn.PgError and pq.Error)
+type sqlStateError interface {
+	SQLState() string
+}
+
+// sqliteCodeError is implemented by the pure Go SQLite driver (modernc.org/sqlite)
+type sqliteCodeError interface {
+	Code() int
+}
+
+var (
+	// mysqlKeyPattern matches "Duplicate entry 'a@b.c' for key 'users.email'"
+	mysqlKeyPattern = regexp.MustCompile(`for key '([^']+)'`)
+	// mysqlColumnPattern matches "Column 'email' cannot be null"
+	mysqlColumnPattern = regexp.MustCompile(`[Cc]olumn '([^']+)'`)
+	// mysqlFieldPattern matches "Field 'email' doesn't have a default value"
+	mysqlFieldPattern = regexp.MustCompile(`[Ff]ield '([^']+)'`)
+	// pgConstraintPattern matches `violates unique constraint "users_email_key"`,
+	// and the MySQL "CONSTRAINT `fk_to_dos_author`" once its backquotes are replaced
+	pgConstraintPattern = regexp.MustCompile(`(?i)constraint "([^"]+)"`)
+	// pgColumnPattern matches `null value in column "email"`
+	pgColumnPattern = regexp.MustCompile(`column "([^"]+)"`)
+	// sqliteConstraintPattern matches "UNIQUE constraint failed: users.email"
+	sqliteConstraintPattern = regexp.MustCompile(`constraint failed: ([^\s,]+)`)
+)
+
+// ClassifyError sorts an error returned by GORM or a database driver into an ErrorKind
+// It recognises MySQL, PostgreSQL and SQLite errors by their error code and
+// returns nil if err is nil
+func ClassifyError(err error) *DBError {
+	if err == nil {
+		return nil
+	}
+	result := &DBError{Kind: KindUnknown, Driver: "gorm", Err: err}
+
+	var mysqlErr *mysql.MySQLError
+	var stateErr sqlStateError
+	var sqliteErr sqliteCodeError
+	switch {
+	case errors.As(err, &mysqlErr):
+		classifyMySQL(result, mysqlErr)
+	case errors.As(err, &stateErr):
+		classifyPostgres(result, stateErr.SQLState(), err.Error())
+	case errors.As(err, &sqliteErr):
+		classifySQLite(result, sqliteErr.Code(), err.Error())
+	case sqliteExtendedCode(err) != 0:
+		classifySQLite(result, sqliteExtendedCode(err), err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, sql.ErrNoRows):
+		result.Kind = KindNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		result.Kind = KindDuplicateKey
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		result.Kind = KindForeignKey
+	case errors.Is(err, context.DeadlineExceeded):
+		result.Kind = KindTimeout
+	}
+	result.Messages = formatMessages(result)
+	return result
+}
+
+// FormatError classifies err and returns its human readable messages
+// It returns a new map on every call, keyed like "Taken_email" or "No_record"
+func FormatError(err error) map[string]string {
+	if err == nil {
+		return map[string]string{}
+	}
+	return ClassifyError(err).Messages
+}
+
+// classifyMySQL fills the classification of a MySQL error
+func classifyMySQL(result *DBError, mysqlErr *mysql.MySQLError) {
+	result.Driver = "mysql"
+	result.Code = strconv.Itoa(int(mysqlErr.Number))
+	switch mysqlErr.Number {
+	case mysqlDuplicateEntry:
+		result.Kind = KindDuplicateKey
+		result.Constraint = firstSubmatch(mysqlKeyPattern, mysqlErr.Message)
+		result.Field = fieldFromConstraint(result.Constraint)
+	case mysqlRowIsReferenced, mysqlNoReferencedRow, mysqlRowIsReferenced2, mysqlNoReferencedRow2:
+		result.Kind = KindForeignKey
+		result.Constraint = firstSubmatch(pgConstraintPattern, strings.ReplaceAll(mysqlErr.Message, "`", `"`))
+	case mysqlBadNull, mysqlFieldDoesntDefault:
+		result.Kind = KindNotNull
+		result.Field = firstSubmatch(mysqlColumnPattern, mysqlErr.Message)
+		if result.Field == "" {
+			result.Field = firstSubmatch(mysqlFieldPattern, mysqlErr.Message)
+		}
+	case mysqlLockDeadlock:
+		result.Kind = KindDeadlock
+	case mysqlLockWaitTimeout, mysqlQueryTimeout, mysqlQueryInterrupted:
+		result.Kind = KindTimeout
 	}
-	if strings.Contains(errString, "email") {
-		errorMessages["Taken_email"] = "Email Already Taken"
+}
+
+// classifyPostgres fills the classification of a PostgreSQL error
+func classifyPostgres(result *DBError, state, message string) {
+	result.Driver = "postgres"
+	result.Code = state
+	switch state {
+	case pgUniqueViolation:
+		result.Kind = KindDuplicateKey
+		result.Constraint = firstSubmatch(pgConstraintPattern, message)
+		result.Field = fieldFromConstraint(result.Constraint)
+	case pgForeignKeyViolation:
+		result.Kind = KindForeignKey
+		result.Constraint = firstSubmatch(pgConstraintPattern, message)
+	case pgNotNullViolation:
+		result.Kind = KindNotNull
+		result.Field = firstSubmatch(pgColumnPattern, message)
+	case pgDeadlockDetected, pgSerializationFailure:
+		result.Kind = KindDeadlock
+	case pgQueryCanceled, pgLockNotAvailable:
+		result.Kind = KindTimeout
+	}
+}
+
+// classifySQLite fills the classification of a SQLite error from its extended result code
+func classifySQLite(result *DBError, code int, message string) {
+	result.Driver = "sqlite"
+	result.Code = strconv.Itoa(code)
+	switch code {
+	case sqliteConstraintUnique, sqliteConstraintPrimaryKey, sqliteConstraintRowID:
+		result.Kind = KindDuplicateKey
+		result.Constraint = firstSubmatch(sqliteConstraintPattern, message)
+		result.Field = fieldFromConstraint(result.Constraint)
+	case sqliteConstraintForeignKey:
+		result.Kind = KindForeignKey
+	case sqliteConstraintNotNull:
+		result.Kind = KindNotNull
+		result.Field = fieldFromConstraint(firstSubmatch(sqliteConstraintPattern, message))
+	}
+	switch code & sqlitePrimaryResultCodeMask {
+	case sqliteBusy:
+		result.Kind = KindTimeout
+	case sqliteLocked:
+		result.Kind = KindDeadlock
+	}
+}
+
+// sqliteExtendedCode reads the extended result code of a github.com/mattn/go-sqlite3 error.
+// That driver needs cgo, so its sqlite3.Error type is matched by shape instead of imported.
+func sqliteExtendedCode(err error) int {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.ValueOf(err)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct || !strings.HasSuffix(v.Type().PkgPath(), "go-sqlite3") {
+			continue
+		}
+		if f := v.FieldByName("ExtendedCode"); f.IsValid() && f.CanInt() {
+			return int(f.Int())
+		}
+	}
+	return 0
+}
+
+// formatMessages builds the human readable messages of a classification
+func formatMessages(result *DBError) map[string]string {
+	messages := make(map[string]string)
+	field := result.Field
+	switch result.Kind {
+	case KindDuplicateKey:
+		if field == "" {
+			messages["Taken"] = "Already Taken"
+			break
+		}
+		messages["Taken_"+field] = title(field) + " Already Taken"
+	case KindForeignKey:
+		messages["Invalid_reference"] = "Referenced Record Does Not Exist Or Is Still In Use"
+	case KindNotNull:
+		if field == "" {
+			messages["Required"] = "Required Field Missing"
+			break
+		}
+		messages["Required_"+field] = title(field) + " Is Required"
+	case KindNotFound:
+		messages["No_record"] = "No Record Found"
+	case KindDeadlock, KindTimeout:
+		messages["Try_again"] = "Database Busy, Try Again"
+	default:
+		messages["Incorrect_details"] = "Incorrect Details"
+	}
+	return messages
+}
+
+// constraintFields maps the composite unique indexes of the models to the field reported as taken
+var constraintFields = map[string]string{
+	"idx_todo_author_title_occurrence": "title",
+	"idx_tag_user_name":                "name",
+	"idx_list_member":                  "user_id",
+	"idx_list_invitation":              "email",
+	"idx_identity_subject":             "subject",
+}
+
+// multiWordTables are the tables whose names contain "_", longest match first,
+// so that their prefix can be told from the column in index names like idx_to_dos_title
+var multiWordTables = []string{
+	"todo_list_invitations", "todo_list_members", "oidc_auth_requests", "external_identities", "url_destinations", "scan_job_results",
+	"todo_activities", "recovery_codes", "scan_profiles", "signing_keys", "scan_changes", "user_tokens",
+	"todo_lists", "audit_logs", "scan_jobs", "api_keys", "to_dos",
+}
+
+// fieldFromConstraint guesses the column of a constraint or key name,
+// e.g. "users.email", "idx_users_email", "uni_users_email" and "users_email_key" give "email"
+// The table qualifier of MySQL 8 key names, like "to_dos.idx_to_dos_title", is removed first
+func fieldFromConstraint(constraint string) string {
+	name := constraint
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	if field, ok := constraintFields[name]; ok {
+		return field
 	}
-	if strings.Contains(errString, "hashedPassword") {
-		errorMessages["Incorrect_password"] = "Incorrect Password"
+	trimmed := strings.TrimSuffix(name, "_key")
+	for _, prefix := range []string{"idx_", "uni_"} {
+		if strings.HasPrefix(trimmed, prefix) {
+			trimmed = strings.TrimPrefix(trimmed, prefix)
+			break
+		}
 	}
-	if strings.Contains(errString, "record not found") {
-		errorMessages["No_record"] = "No Record Found"
+	// A bare column name, like the "email" of "users.email"
+	if trimmed == name {
+		return name
 	}
-	if len(errorMessages) > 0 {
-		return errorMessages
+	// Drop the table name from index names like idx_users_email or users_email_key
+	for _, table := range multiWordTables {
+		if strings.HasPrefix(trimmed, table+"_") {
+			return strings.TrimPrefix(trimmed, table+"_")
+		}
 	}
-	if len(errorMessages) == 0 {
-		errorMessages["Incorrect_details"] = "Incorrect Details"
-		return errorMessages
+	if i := strings.Index(trimmed, "_"); i >= 0 {
+		return trimmed[i+1:]
+	}
+	return trimmed
+}
+
+// firstSubmatch returns the first capture group of pattern in s, or an empty string
+func firstSubmatch(pattern *regexp.Regexp, s string) string {
+	if m := pattern.FindStringSubmatch(s); len(m) > 1 {
+		return m[1]
+	}
+	return ""
+}
+
+// title upper-cases the first letter of s and turns underscores into spaces
+func title(s string) string {
+	s = strings.ReplaceAll(s, "_", " ")
+	if s == "" {
+		return s
 	}
-	return nil
+	return strings.ToUpper(s[:1]) + s[1:]
 }
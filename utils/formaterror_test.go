@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// pgError has the shape of the PostgreSQL driver errors
+type pgError struct {
+	state   string
+	message string
+}
+
+func (e *pgError) Error() string    { return e.message }
+func (e *pgError) SQLState() string { return e.state }
+
+// sqliteError has the shape of the modernc.org/sqlite errors
+type sqliteError struct {
+	code    int
+	message string
+}
+
+func (e *sqliteError) Error() string { return e.message }
+func (e *sqliteError) Code() int     { return e.code }
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		kind       ErrorKind
+		driver     string
+		code       string
+		constraint string
+		field      string
+		messages   map[string]string
+	}{
+		{
+			name:       "mysql duplicate entry",
+			err:        &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users.email'"},
+			kind:       KindDuplicateKey,
+			driver:     "mysql",
+			code:       "1062",
+			constraint: "users.email",
+			field:      "email",
+			messages:   map[string]string{"Taken_email": "Email Already Taken"},
+		},
+		{
+			name:       "mysql duplicate entry of a gorm index",
+			err:        &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'idx_users_user_name'"},
+			kind:       KindDuplicateKey,
+			driver:     "mysql",
+			code:       "1062",
+			constraint: "idx_users_user_name",
+			field:      "user_name",
+			messages:   map[string]string{"Taken_user_name": "User name Already Taken"},
+		},
+		{
+			name:       "mysql 8 duplicate entry of a composite index",
+			err:        &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '3-Buy milk-0' for key 'to_dos.idx_todo_author_title_occurrence'"},
+			kind:       KindDuplicateKey,
+			driver:     "mysql",
+			code:       "1062",
+			constraint: "to_dos.idx_todo_author_title_occurrence",
+			field:      "title",
+			messages:   map[string]string{"Taken_title": "Title Already Taken"},
+		},
+		{
+			name:       "mysql 8 duplicate entry of a table with an underscore",
+			err:        &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'api_keys.idx_api_keys_key_hash'"},
+			kind:       KindDuplicateKey,
+			driver:     "mysql",
+			code:       "1062",
+			constraint: "api_keys.idx_api_keys_key_hash",
+			field:      "key_hash",
+			messages:   map[string]string{"Taken_key_hash": "Key hash Already Taken"},
+		},
+		{
+			name: "mysql no referenced row",
+			err: &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails " +
+				"(`db`.`to_dos`, CONSTRAINT `fk_to_dos_author` FOREIGN KEY (`author_id`) REFERENCES `users` (`id`))"},
+			kind:       KindForeignKey,
+			driver:     "mysql",
+			code:       "1452",
+			constraint: "fk_to_dos_author",
+			messages:   map[string]string{"Invalid_reference": "Referenced Record Does Not Exist Or Is Still In Use"},
+		},
+		{
+			name:     "mysql column cannot be null",
+			err:      &mysql.MySQLError{Number: 1048, Message: "Column 'email' cannot be null"},
+			kind:     KindNotNull,
+			driver:   "mysql",
+			code:     "1048",
+			field:    "email",
+			messages: map[string]string{"Required_email": "Email Is Required"},
+		},
+		{
+			name:     "mysql deadlock",
+			err:      &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+			kind:     KindDeadlock,
+			driver:   "mysql",
+			code:     "1213",
+			messages: map[string]string{"Try_again": "Database Busy, Try Again"},
+		},
+		{
+			name:     "mysql unknown number",
+			err:      &mysql.MySQLError{Number: 1146, Message: "Table 'db.x' doesn't exist"},
+			kind:     KindUnknown,
+			driver:   "mysql",
+			code:     "1146",
+			messages: map[string]string{"Incorrect_details": "Incorrect Details"},
+		},
+		{
+			name:       "sqlstate unique violation",
+			err:        &pgError{state: "23505", message: `duplicate key value violates unique constraint "users_email_key"`},
+			kind:       KindDuplicateKey,
+			driver:     "postgres",
+			code:       "23505",
+			constraint: "users_email_key",
+			field:      "email",
+			messages:   map[string]string{"Taken_email": "Email Already Taken"},
+		},
+		{
+			name:       "sqlstate foreign key violation",
+			err:        &pgError{state: "23503", message: `insert or update on table "to_dos" violates foreign key constraint "fk_to_dos_author"`},
+			kind:       KindForeignKey,
+			driver:     "postgres",
+			code:       "23503",
+			constraint: "fk_to_dos_author",
+			messages:   map[string]string{"Invalid_reference": "Referenced Record Does Not Exist Or Is Still In Use"},
+		},
+		{
+			name:     "sqlstate not null violation",
+			err:      &pgError{state: "23502", message: `null value in column "title" violates not-null constraint`},
+			kind:     KindNotNull,
+			driver:   "postgres",
+			code:     "23502",
+			field:    "title",
+			messages: map[string]string{"Required_title": "Title Is Required"},
+		},
+		{
+			name:       "code unique constraint",
+			err:        &sqliteError{code: 2067, message: "UNIQUE constraint failed: users.email"},
+			kind:       KindDuplicateKey,
+			driver:     "sqlite",
+			code:       "2067",
+			constraint: "users.email",
+			field:      "email",
+			messages:   map[string]string{"Taken_email": "Email Already Taken"},
+		},
+		{
+			name:     "code foreign key constraint",
+			err:      &sqliteError{code: 787, message: "FOREIGN KEY constraint failed"},
+			kind:     KindForeignKey,
+			driver:   "sqlite",
+			code:     "787",
+			messages: map[string]string{"Invalid_reference": "Referenced Record Does Not Exist Or Is Still In Use"},
+		},
+		{
+			name:     "code busy",
+			err:      &sqliteError{code: 261, message: "database is locked"},
+			kind:     KindTimeout,
+			driver:   "sqlite",
+			code:     "261",
+			messages: map[string]string{"Try_again": "Database Busy, Try Again"},
+		},
+		{
+			name:       "wrapped mysql error",
+			err:        fmt.Errorf("create user: %w", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users.email'"}),
+			kind:       KindDuplicateKey,
+			driver:     "mysql",
+			code:       "1062",
+			constraint: "users.email",
+			field:      "email",
+			messages:   map[string]string{"Taken_email": "Email Already Taken"},
+		},
+		{
+			name:       "wrapped sqlstate error",
+			err:        fmt.Errorf("save: %w", fmt.Errorf("tx: %w", &pgError{state: "23505", message: `violates unique constraint "users_email_key"`})),
+			kind:       KindDuplicateKey,
+			driver:     "postgres",
+			code:       "23505",
+			constraint: "users_email_key",
+			field:      "email",
+			messages:   map[string]string{"Taken_email": "Email Already Taken"},
+		},
+		{
+			name:     "record not found",
+			err:      gorm.ErrRecordNotFound,
+			kind:     KindNotFound,
+			driver:   "gorm",
+			messages: map[string]string{"No_record": "No Record Found"},
+		},
+		{
+			name:     "wrapped record not found",
+			err:      fmt.Errorf("get user: %w", gorm.ErrRecordNotFound),
+			kind:     KindNotFound,
+			driver:   "gorm",
+			messages: map[string]string{"No_record": "No Record Found"},
+		},
+		{
+			name:     "no rows",
+			err:      sql.ErrNoRows,
+			kind:     KindNotFound,
+			driver:   "gorm",
+			messages: map[string]string{"No_record": "No Record Found"},
+		},
+		{
+			name:     "gorm duplicated key",
+			err:      gorm.ErrDuplicatedKey,
+			kind:     KindDuplicateKey,
+			driver:   "gorm",
+			messages: map[string]string{"Taken": "Already Taken"},
+		},
+		{
+			name:     "deadline exceeded",
+			err:      context.DeadlineExceeded,
+			kind:     KindTimeout,
+			driver:   "gorm",
+			messages: map[string]string{"Try_again": "Database Busy, Try Again"},
+		},
+		{
+			name:     "unknown error",
+			err:      errors.New("boom"),
+			kind:     KindUnknown,
+			driver:   "gorm",
+			messages: map[string]string{"Incorrect_details": "Incorrect Details"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyError(tt.err)
+			if got.Kind != tt.kind {
+				t.Errorf("Kind = %q, want %q", got.Kind, tt.kind)
+			}
+			if got.Driver != tt.driver {
+				t.Errorf("Driver = %q, want %q", got.Driver, tt.driver)
+			}
+			if got.Code != tt.code {
+				t.Errorf("Code = %q, want %q", got.Code, tt.code)
+			}
+			if got.Constraint != tt.constraint {
+				t.Errorf("Constraint = %q, want %q", got.Constraint, tt.constraint)
+			}
+			if got.Field != tt.field {
+				t.Errorf("Field = %q, want %q", got.Field, tt.field)
+			}
+			if len(got.Messages) != len(tt.messages) {
+				t.Errorf("Messages = %v, want %v", got.Messages, tt.messages)
+			}
+			for key, want := range tt.messages {
+				if got.Messages[key] != want {
+					t.Errorf("Messages[%q] = %q, want %q", key, got.Messages[key], want)
+				}
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("errors.Is(ClassifyError(err), err) = false, want true")
+			}
+		})
+	}
+}
+
+func TestFieldFromConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"", ""},
+		{"email", "email"},
+		{"users.email", "email"},
+		{"users.user_name", "user_name"},
+		{"idx_users_email", "email"},
+		{"idx_users_user_name", "user_name"},
+		{"uni_users_email", "email"},
+		{"users_email_key", "email"},
+		{"idx_to_dos_title", "title"},
+		{"to_dos.idx_to_dos_title", "title"},
+		{"idx_user_tokens_token_hash", "token_hash"},
+		{"user_tokens.idx_user_tokens_token_hash", "token_hash"},
+		{"idx_todo_author_title_occurrence", "title"},
+		{"to_dos.idx_todo_author_title_occurrence", "title"},
+		{"tags.idx_tag_user_name", "name"},
+		{"todo_list_members.idx_list_member", "user_id"},
+		{"idx_todo_list_members_role", "role"},
+		{"idx_todo_lists_name", "name"},
+		{"todo_list_invitations.idx_list_invitation", "email"},
+		{"idx_todo_list_invitations_token_hash", "token_hash"},
+	}
+	for _, tt := range tests {
+		if got := fieldFromConstraint(tt.constraint); got != tt.want {
+			t.Errorf("fieldFromConstraint(%q) = %q, want %q", tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyErrorNil(t *testing.T) {
+	if got := ClassifyError(nil); got != nil {
+		t.Errorf("ClassifyError(nil) = %v, want nil", got)
+	}
+	if got := FormatError(nil); len(got) != 0 {
+		t.Errorf("FormatError(nil) = %v, want empty", got)
+	}
+}
+
+func TestClassifyErrorReturnsNewResult(t *testing.T) {
+	err := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users.email'"}
+	first := ClassifyError(err)
+	second := ClassifyError(err)
+	if first == second {
+		t.Fatal("ClassifyError returned the same result twice")
+	}
+	// Changing a result must not leak into the others
+	first.Messages["Taken_email"] = "changed"
+	first.Field = "changed"
+	if second.Messages["Taken_email"] != "Email Already Taken" || second.Field != "email" {
+		t.Errorf("second result changed with the first: %+v", second)
+	}
+	if third := FormatError(err); third["Taken_email"] != "Email Already Taken" {
+		t.Errorf("FormatError() = %v after changing a previous result", third)
+	}
+	other := ClassifyError(gorm.ErrRecordNotFound)
+	if _, ok := other.Messages["Taken_email"]; ok || other.Kind != KindNotFound {
+		t.Errorf("ClassifyError(ErrRecordNotFound) = %+v, leaks a previous result", other)
+	}
+}
+
+func TestDBErrorAppError(t *testing.T) {
+	dup := ClassifyError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users.email'"})
+	appErr := dup.AppError("Could Not Create User")
+	if appErr.Status() != 409 {
+		t.Errorf("duplicate key status = %d, want 409", appErr.Status())
+	}
+	if len(appErr.Fields) != 1 || appErr.Fields[0].Field != "email" {
+		t.Errorf("duplicate key fields = %+v, want email", appErr.Fields)
+	}
+	if got := ClassifyError(gorm.ErrRecordNotFound).AppError("Not Found").Status(); got != 404 {
+		t.Errorf("not found status = %d, want 404", got)
+	}
+	if got := ClassifyError(errors.New("boom")).AppError("Failed").Status(); got != 500 {
+		t.Errorf("unknown status = %d, want 500", got)
+	}
+}
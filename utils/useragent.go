@@ -0,0 +1,53 @@
+package utils
+
+import "strings"
+
+// userAgentBrowsers and userAgentSystems are matched in order, the first match wins
+// Edge and Opera come before Chrome and Chrome before Safari, since their user agents contain the later names
+var (
+	userAgentBrowsers = [][2]string{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"Go-http-client/", "Go"},
+		{"python-requests/", "Python"},
+		{"PostmanRuntime/", "Postman"},
+	}
+	userAgentSystems = [][2]string{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DeviceName returns a short description of the device of a user agent, like "Chrome on Windows"
+// It is only meant to help users recognize their sessions, not to detect anything reliably
+func DeviceName(userAgent string) string {
+	browser := matchUserAgent(userAgent, userAgentBrowsers)
+	system := matchUserAgent(userAgent, userAgentSystems)
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return "Unknown device"
+}
+
+func matchUserAgent(userAgent string, names [][2]string) string {
+	for _, name := range names {
+		if strings.Contains(userAgent, name[0]) {
+			return name[1]
+		}
+	}
+	return ""
+}
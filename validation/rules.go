@@ -0,0 +1,118 @@
+package validation
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/zerodot618/go-huang/auth"
+)
+
+// MinPasswordLength is the minimum length of a strong password
+const MinPasswordLength = 8
+
+// slugPattern matches lower case words separated by single dashes, e.g. "my-book-2"
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// rules are the custom validation rules, keyed by their tag
+var rules = map[string]validator.Func{
+	"password": strongPassword,
+	"slug":     slug,
+	"isbn":     isbn,
+	"scope":    scope,
+}
+
+// customMessages are the translations of the custom rules, keyed by locale and tag
+var customMessages = map[string]map[string]string{
+	"en": {
+		"password": "{0} must be at least 8 characters and contain an upper case letter, a lower case letter and a digit",
+		"slug":     "{0} must only contain lower case letters, digits and single dashes",
+		"scope":    "{0} must be a known scope",
+	},
+	"zh": {
+		"password": "{0}长度至少为8个字符，且必须包含大写字母、小写字母和数字",
+		"slug":     "{0}只能包含小写字母、数字和单个连字符",
+		"scope":    "{0}必须是已知的权限范围",
+	},
+}
+
+// strongPassword checks that a password is long enough and mixes upper case, lower case and digits
+func strongPassword(fl validator.FieldLevel) bool {
+	password := fl.Field().String()
+	if len([]rune(password)) < MinPasswordLength {
+		return false
+	}
+	var upper, lower, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
+
+// slug checks that a value is a URL friendly slug
+func slug(fl validator.FieldLevel) bool {
+	return slugPattern.MatchString(fl.Field().String())
+}
+
+// scope checks that a value is a scope that can be granted to an API key
+func scope(fl validator.FieldLevel) bool {
+	return auth.IsValidScope(fl.Field().String())
+}
+
+// isbn checks that a value is a valid ISBN-10 or ISBN-13, hyphens and spaces are allowed.
+// It replaces the built-in isbn rule of the validator, which rejects hyphenated ISBNs.
+func isbn(fl validator.FieldLevel) bool {
+	value := strings.NewReplacer("-", "", " ", "").Replace(fl.Field().String())
+	switch len(value) {
+	case 10:
+		return isbn10(value)
+	case 13:
+		return isbn13(value)
+	}
+	return false
+}
+
+// isbn10 checks the check digit of a normalized ISBN-10
+func isbn10(value string) bool {
+	sum := 0
+	for i, r := range value {
+		var digit int
+		switch {
+		case r >= '0' && r <= '9':
+			digit = int(r - '0')
+		case (r == 'X' || r == 'x') && i == 9:
+			digit = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * digit
+	}
+	return sum%11 == 0
+}
+
+// isbn13 checks the check digit of a normalized ISBN-13
+func isbn13(value string) bool {
+	if !strings.HasPrefix(value, "978") && !strings.HasPrefix(value, "979") {
+		return false
+	}
+	sum := 0
+	for i, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+		digit := int(r - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
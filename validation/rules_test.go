@@ -0,0 +1,90 @@
+package validation
+
+import "testing"
+
+func TestPasswordRule(t *testing.T) {
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"Passw0rd", true},
+		{"Pässw0rdß", true},
+		{"Sh0rt", false},
+		{"Pass0rd", false},
+		{"password1", false},
+		{"PASSWORD1", false},
+		{"Password", false},
+		{"", false},
+		// Eight characters of which some take several bytes
+		{"Pässw0rd", true},
+		{"Päs0rd", false},
+	}
+	for _, tt := range tests {
+		payload := struct {
+			Password string `json:"password" binding:"password"`
+		}{tt.password}
+		if got := Struct(payload) == nil; got != tt.want {
+			t.Errorf("password %q valid = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestSlugRule(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"my-book-2", true},
+		{"book", true},
+		{"2024", true},
+		{"My-Book", false},
+		{"my--book", false},
+		{"-book", false},
+		{"book-", false},
+		{"my_book", false},
+		{"my book", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		payload := struct {
+			Slug string `json:"slug" binding:"slug"`
+		}{tt.slug}
+		if got := Struct(payload) == nil; got != tt.want {
+			t.Errorf("slug %q valid = %v, want %v", tt.slug, got, tt.want)
+		}
+	}
+}
+
+func TestISBNRule(t *testing.T) {
+	tests := []struct {
+		name string
+		isbn string
+		want bool
+	}{
+		{"isbn-10", "0306406152", true},
+		{"hyphenated isbn-10", "0-306-40615-2", true},
+		{"isbn-10 with spaces", "0 306 40615 2", true},
+		{"isbn-10 with X check digit", "080442957X", true},
+		{"isbn-10 with lower case x", "080442957x", true},
+		{"isbn-10 with wrong check digit", "0-306-40615-3", false},
+		{"isbn-10 with X before the check digit", "08044295X7", false},
+		{"isbn-13", "9780306406157", true},
+		{"hyphenated isbn-13", "978-0-306-40615-7", true},
+		{"isbn-13 with 979 prefix", "979-10-90636-07-1", true},
+		{"isbn-13 with wrong check digit", "978-0-306-40615-8", false},
+		{"isbn-13 with unknown prefix", "9770306406155", false},
+		{"isbn-13 with X", "978030640615X", false},
+		{"too short", "030640615", false},
+		{"too long", "97803064061570", false},
+		{"letters", "abcdefghij", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		payload := struct {
+			ISBN string `json:"isbn" binding:"isbn"`
+		}{tt.isbn}
+		if got := Struct(payload) == nil; got != tt.want {
+			t.Errorf("%s: isbn %q valid = %v, want %v", tt.name, tt.isbn, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package validation is the request validation layer of the API.
+// It configures the go-playground/validator engine used by gin binding with the
+// custom rules of the API, and translates validation errors into structured
+// field errors in English or Chinese based on the Accept-Language header.
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	zh_translations "github.com/go-playground/validator/v10/translations/zh"
+	"github.com/zerodot618/go-huang/apperror"
+)
+
+// DefaultLocale is the locale used when Accept-Language has no supported language
+const DefaultLocale = "en"
+
+var (
+	once     sync.Once
+	validate *validator.Validate
+	uni      *ut.UniversalTranslator
+)
+
+// invalidInputs is the localized message of validation errors
+var invalidInputs = map[string]string{
+	"en": "Invalid Inputs",
+	"zh": "输入无效",
+}
+
+// Setup registers the custom rules and translations on the validator used by gin binding
+// It is safe to call several times, the setup only runs once
+func Setup() {
+	once.Do(func() {
+		engine, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			engine = validator.New()
+			engine.SetTagName("binding")
+		}
+		validate = engine
+		// Report the JSON name of fields instead of the Go struct field name
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+		for tag, rule := range rules {
+			if err := validate.RegisterValidation(tag, rule); err != nil {
+				panic(err)
+			}
+		}
+
+		enLocale := en.New()
+		uni = ut.New(enLocale, enLocale, zh.New())
+		enTrans, _ := uni.GetTranslator("en")
+		zhTrans, _ := uni.GetTranslator("zh")
+		if err := en_translations.RegisterDefaultTranslations(validate, enTrans); err != nil {
+			panic(err)
+		}
+		if err := zh_translations.RegisterDefaultTranslations(validate, zhTrans); err != nil {
+			panic(err)
+		}
+		registerTranslations(validate, enTrans, customMessages["en"])
+		registerTranslations(validate, zhTrans, customMessages["zh"])
+	})
+}
+
+// Struct validates a struct with the same rules as gin binding
+func Struct(obj interface{}) error {
+	Setup()
+	return validate.Struct(obj)
+}
+
+// Partial validates only the given fields of a struct
+func Partial(obj interface{}, fields ...string) error {
+	Setup()
+	return validate.StructPartial(obj, fields...)
+}
+
+// Locale returns the supported locale that best matches an Accept-Language header
+func Locale(acceptLanguage string) string {
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		lang := strings.SplitN(tag, "-", 2)[0]
+		if _, ok := invalidInputs[lang]; ok {
+			return lang
+		}
+	}
+	return DefaultLocale
+}
+
+// AppError translates validation errors into a validation_failed error in the locale
+// matching acceptLanguage. It returns nil if err does not contain validation errors.
+func AppError(err error, acceptLanguage string) *apperror.Error {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+	Setup()
+	locale := Locale(acceptLanguage)
+	trans, _ := uni.GetTranslator(locale)
+	fields := make([]apperror.FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		fields = append(fields, apperror.FieldError{
+			Field:   fieldPath(fe),
+			Code:    fe.Tag(),
+			Message: fe.Translate(trans),
+		})
+	}
+	appErr := apperror.Validation(fields...)
+	appErr.Message = invalidInputs[locale]
+	appErr.Err = err
+	return appErr
+}
+
+// fieldPath returns the JSON path of a field without the top level struct name
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return fe.Field()
+}
+
+// registerTranslations adds the messages of the custom rules to a translator
+func registerTranslations(v *validator.Validate, trans ut.Translator, messages map[string]string) {
+	for tag, message := range messages {
+		message := message
+		tag := tag
+		err := v.RegisterTranslation(tag, trans,
+			func(ut ut.Translator) error {
+				return ut.Add(tag, message, true)
+			},
+			func(ut ut.Translator, fe validator.FieldError) string {
+				t, err := ut.T(tag, fe.Field())
+				if err != nil {
+					return fe.Error()
+				}
+				return t
+			},
+		)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
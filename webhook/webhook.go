@@ -0,0 +1,68 @@
+// Package webhook posts signed JSON events to the URLs of the receivers.
+// It is shared by the reminder notifier and the scan alerter: the body is signed with HMAC-SHA256
+// and the Idempotency-Key header lets the receivers drop the events delivered twice.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Timeout is the timeout of the requests when no client is set
+const Timeout = 10 * time.Second
+
+// Endpoint is a URL receiving events as JSON
+type Endpoint struct {
+	URL string
+	// Secret signs the bodies, they are not signed if empty
+	Secret string
+	// SignatureHeader is the header holding the signature, e.g. X-Reminder-Signature
+	SignatureHeader string
+	// Client sends the requests, a client with a 10 seconds timeout if nil
+	Client *http.Client
+}
+
+// Sign returns the signature of a body with a secret, "sha256=" followed by the hex encoded HMAC-SHA256
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post posts an event, with the key identifying it in the Idempotency-Key header
+// Any response but a 2xx is an error
+func (e Endpoint) Post(ctx context.Context, idempotencyKey string, event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	if e.Secret != "" {
+		req.Header.Set(e.SignatureHeader, Sign(e.Secret, body))
+	}
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: Timeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
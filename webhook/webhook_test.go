@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEndpointPost(t *testing.T) {
+	tests := []struct {
+		name          string
+		secret        string
+		status        int
+		wantSignature bool
+		wantErr       bool
+	}{
+		{"signed", "s3cret", http.StatusNoContent, true, false},
+		{"unsigned", "", http.StatusOK, false, false},
+		{"refused", "s3cret", http.StatusInternalServerError, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			e := Endpoint{URL: server.URL, Secret: tt.secret, SignatureHeader: "X-Test-Signature"}
+			err := e.Post(context.Background(), "test-7", map[string]int{"id": 7})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Post() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(body) != `{"id":7}` {
+				t.Errorf("body = %s, want the JSON of the event", body)
+			}
+			if h := got.Header.Get("Idempotency-Key"); h != "test-7" {
+				t.Errorf("Idempotency-Key = %q, want %q", h, "test-7")
+			}
+			if h := got.Header.Get("Content-Type"); h != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", h)
+			}
+			signature := got.Header.Get("X-Test-Signature")
+			if tt.wantSignature && signature != Sign(tt.secret, body) {
+				t.Errorf("signature = %q, want %q", signature, Sign(tt.secret, body))
+			}
+			if !tt.wantSignature && signature != "" {
+				t.Errorf("signature = %q, want none without a secret", signature)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 test case 2 of RFC 4231
+	got := Sign("Jefe", []byte("what do ya want for nothing?"))
+	want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+}